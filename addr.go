@@ -0,0 +1,88 @@
+package fastrpc
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/UladzimirTrehubenka/fastrpc/internal/netutil"
+)
+
+// Address schemes supported by Dial and Listen.
+const (
+	schemeTCP  = "tcp://"
+	schemeUnix = "unix://"
+)
+
+// splitAddr returns network and address for the given addr.
+//
+// The following addr forms are supported:
+//
+//   - host:port and tcp://host:port for TCP;
+//   - unix:///path/to/socket for unix socket at /path/to/socket;
+//   - unix://@name for unix socket in Linux abstract namespace.
+func splitAddr(addr string) (string, string, error) {
+	switch {
+	case strings.HasPrefix(addr, schemeUnix):
+		path := addr[len(schemeUnix):]
+		if path == "" {
+			return "", "", fmt.Errorf("missing unix socket path in %q", addr)
+		}
+		return "unix", path, nil
+	case strings.HasPrefix(addr, schemeTCP):
+		return "tcp", addr[len(schemeTCP):], nil
+	case strings.Contains(addr, "://"):
+		return "", "", fmt.Errorf("unsupported scheme in %q; supported schemes: %s, %s", addr, schemeTCP, schemeUnix)
+	default:
+		return "tcp", addr, nil
+	}
+}
+
+// addrHost returns host from the given TCP addr.
+//
+// Returns empty string for non-TCP addrs.
+func addrHost(addr string) string {
+	network, address, err := splitAddr(addr)
+	if err != nil || network != "tcp" {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return address
+	}
+	return host
+}
+
+// Listen announces on the given addr.
+//
+// See Client.Addr for supported addr forms. Stale unix socket file
+// without a listener is removed before listening.
+func Listen(addr string) (net.Listener, error) {
+	network, address, err := splitAddr(addr)
+	if err != nil {
+		return nil, err
+	}
+	if network == "unix" {
+		netutil.RemoveStaleUnixSocket(address, DefaultDialTimeout)
+	}
+	ln, err := net.Listen(network, address)
+	if err != nil {
+		return nil, err
+	}
+	if ul, ok := ln.(*net.UnixListener); ok {
+		// The socket file is removed by Close.
+		ul.SetUnlinkOnClose(true)
+	}
+	return ln, nil
+}
+
+// ListenAndServe serves rpc requests on the given addr.
+//
+// See Client.Addr for supported addr forms.
+func (s *Server) ListenAndServe(addr string) error {
+	ln, err := Listen(addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(ln)
+}
@@ -0,0 +1,137 @@
+package fastrpc
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/UladzimirTrehubenka/fastrpc/tlv"
+)
+
+func TestSplitAddr(t *testing.T) {
+	f := func(addr, expectedNetwork, expectedAddress string) {
+		t.Helper()
+		network, address, err := splitAddr(addr)
+		if err != nil {
+			t.Fatalf("unexpected error for %q: %s", addr, err)
+		}
+		if network != expectedNetwork || address != expectedAddress {
+			t.Fatalf("unexpected result for %q: %q, %q. Expecting %q, %q", addr, network, address, expectedNetwork, expectedAddress)
+		}
+	}
+	f("localhost:1234", "tcp", "localhost:1234")
+	f("tcp://localhost:1234", "tcp", "localhost:1234")
+	f("unix:///var/run/foo.sock", "unix", "/var/run/foo.sock")
+	f("unix://@foo", "unix", "@foo")
+
+	for _, addr := range []string{"unix://", "udp://localhost:1234"} {
+		if _, _, err := splitAddr(addr); err == nil {
+			t.Fatalf("expecting non-nil error for %q", addr)
+		}
+	}
+
+	if host := addrHost("tcp://example.org:1234"); host != "example.org" {
+		t.Fatalf("unexpected host: %q. Expecting %q", host, "example.org")
+	}
+	if host := addrHost("unix:///var/run/foo.sock"); host != "" {
+		t.Fatalf("unexpected host for unix addr: %q", host)
+	}
+}
+
+func testServeAddr(t *testing.T, addr string) {
+	t.Helper()
+	ln, err := Listen(addr)
+	if err != nil {
+		t.Fatalf("cannot listen on %q: %s", addr, err)
+	}
+	s := &Server{
+		NewHandlerCtx: newTestHandlerCtx,
+		Handler: func(ctxv HandlerCtx) HandlerCtx {
+			ctx := ctxv.(*tlv.RequestCtx)
+			uid := -1
+			if cred, err := ctx.PeerCredentials(); err == nil {
+				uid = cred.UID
+			}
+			fmt.Fprintf(ctx, "%s %d", ctx.RemoteIP(), uid)
+			return ctx
+		},
+	}
+	serverDone := make(chan error, 1)
+	go func() {
+		serverDone <- s.Serve(ln)
+	}()
+
+	if _, port, err := net.SplitHostPort(ln.Addr().String()); err == nil {
+		addr = "tcp://127.0.0.1:" + port
+	}
+	c := &Client{
+		NewResponse: newTestResponse,
+		Addr:        addr,
+	}
+	var req tlv.Request
+	var resp tlv.Response
+	if err := c.DoDeadline(&req, &resp, time.Now().Add(time.Second)); err != nil {
+		t.Fatalf("unexpected error for %q: %s", addr, err)
+	}
+	expectedUID := -1
+	if ln.Addr().Network() == "unix" && runtime.GOOS == "linux" {
+		expectedUID = os.Getuid()
+	}
+	expected := "127.0.0.1 " + strconv.Itoa(expectedUID)
+	if string(resp.Value()) != expected {
+		t.Fatalf("unexpected response for %q: %q. Expecting %q", addr, resp.Value(), expected)
+	}
+	c.Close()
+
+	if err := ln.Close(); err != nil {
+		t.Fatalf("cannot close listener: %s", err)
+	}
+	select {
+	case err := <-serverDone:
+		if err != nil {
+			t.Fatalf("unexpected server error: %s", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("timeout")
+	}
+}
+
+func TestServeUnixSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fastrpc-unix")
+	if err != nil {
+		t.Fatalf("cannot create temporary dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "sock")
+
+	testServeAddr(t, "unix://"+path)
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("the socket file must be removed after closing the listener; stat error: %v", err)
+	}
+
+	// Stale socket file must be removed before listening.
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatalf("cannot listen: %s", err)
+	}
+	ln.(*net.UnixListener).SetUnlinkOnClose(false)
+	ln.Close()
+	testServeAddr(t, "unix://"+path)
+}
+
+func TestServeUnixSocketAbstract(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("abstract unix sockets are supported only on linux")
+	}
+	testServeAddr(t, fmt.Sprintf("unix://@fastrpc-test-%d", os.Getpid()))
+}
+
+func TestServeTCPScheme(t *testing.T) {
+	testServeAddr(t, "tcp://127.0.0.1:0")
+}
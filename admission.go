@@ -0,0 +1,154 @@
+package fastrpc
+
+import (
+	"errors"
+	"math"
+	"net"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// DefaultCoDelTarget is the default acceptable queue delay for CoDel.
+	DefaultCoDelTarget = 5 * time.Millisecond
+
+	// DefaultCoDelInterval is the default CoDel interval.
+	DefaultCoDelInterval = 100 * time.Millisecond
+)
+
+// ErrOverloaded is passed to RejectHandlerCtx.RejectError for requests
+// rejected due to server overload.
+var ErrOverloaded = errors.New("server overloaded")
+
+// AdmissionController decides whether the Server accepts requests.
+type AdmissionController interface {
+	// Admit is called for each request read by the Server before passing
+	// the request to Server.Handler.
+	//
+	// The request is rejected via RejectHandlerCtx.RejectError
+	// with the returned error if it is non-nil.
+	Admit(conn net.Conn, ctx HandlerCtx) error
+}
+
+// AdmissionFunc is an adapter allowing ordinary functions
+// as AdmissionController.
+type AdmissionFunc func(conn net.Conn, ctx HandlerCtx) error
+
+// Admit implements AdmissionController.
+func (f AdmissionFunc) Admit(conn net.Conn, ctx HandlerCtx) error {
+	return f(conn, ctx)
+}
+
+// CoDel drops requests waiting too long before Server.Handler picks them up.
+//
+// The queue delay is measured from the moment the request header is read
+// from the connection until Server.Handler is called. So it includes
+// the time the request waits in the Server read buffer behind other
+// requests and the time it waits for a handler goroutine or a worker.
+//
+// The server is considered overloaded after the minimum queue delay
+// exceeded Target during the whole last Interval. Requests waiting longer
+// than Target are dropped while the server is overloaded. This keeps
+// queues short during overload while tolerating short bursts.
+//
+// CoDel cannot be shared among Servers.
+type CoDel struct {
+	// Target is the acceptable queue delay.
+	//
+	// DefaultCoDelTarget is used by default.
+	Target time.Duration
+
+	// Interval is the interval for tracking the minimum queue delay.
+	//
+	// DefaultCoDelInterval is used by default.
+	Interval time.Duration
+
+	mu            sync.Mutex
+	intervalStart time.Time
+	minDelay      time.Duration
+	overloaded    bool
+}
+
+// drop returns true if the request with the given queue delay
+// must be dropped.
+func (cd *CoDel) drop(delay time.Duration, now time.Time) bool {
+	target := cd.Target
+	if target <= 0 {
+		target = DefaultCoDelTarget
+	}
+	interval := cd.Interval
+	if interval <= 0 {
+		interval = DefaultCoDelInterval
+	}
+
+	cd.mu.Lock()
+	if elapsed := now.Sub(cd.intervalStart); elapsed >= interval {
+		// Intervals without requests aren't overloaded, so the state
+		// is based only on the interval just ended.
+		cd.overloaded = !cd.intervalStart.IsZero() && elapsed < 2*interval && cd.minDelay > target
+		cd.intervalStart = now
+		cd.minDelay = delay
+	} else if delay < cd.minDelay {
+		cd.minDelay = delay
+	}
+	overloaded := cd.overloaded
+	cd.mu.Unlock()
+
+	return overloaded && delay > target
+}
+
+// CPUAdmission rejects requests with ErrOverloaded while the process
+// CPU usage exceeds MaxUsage.
+//
+// CPU usage is measured only on systems supporting getrusage.
+// Requests are always admitted on other systems.
+type CPUAdmission struct {
+	// MaxUsage is the maximum CPU usage in the range (0..1], where 1 means
+	// all the GOMAXPROCS CPU cores are busy.
+	MaxUsage float64
+
+	// SampleInterval is the interval for measuring CPU usage.
+	//
+	// 250ms is used by default.
+	SampleInterval time.Duration
+
+	mu         sync.Mutex
+	lastSample time.Time
+	lastCPU    time.Duration
+
+	// usage contains math.Float64bits for the last measured CPU usage.
+	usage uint64
+}
+
+// Admit implements AdmissionController.
+func (a *CPUAdmission) Admit(conn net.Conn, ctx HandlerCtx) error {
+	if a.Usage() > a.MaxUsage {
+		return ErrOverloaded
+	}
+	return nil
+}
+
+// Usage returns the last measured CPU usage.
+func (a *CPUAdmission) Usage() float64 {
+	sampleInterval := a.SampleInterval
+	if sampleInterval <= 0 {
+		sampleInterval = 250 * time.Millisecond
+	}
+
+	now := time.Now()
+	a.mu.Lock()
+	if now.Sub(a.lastSample) >= sampleInterval {
+		cpu := processCPUTime()
+		if !a.lastSample.IsZero() {
+			usage := float64(cpu-a.lastCPU) / float64(now.Sub(a.lastSample)) / float64(runtime.GOMAXPROCS(0))
+			atomic.StoreUint64(&a.usage, math.Float64bits(usage))
+		}
+		a.lastSample = now
+		a.lastCPU = cpu
+	}
+	a.mu.Unlock()
+
+	return math.Float64frombits(atomic.LoadUint64(&a.usage))
+}
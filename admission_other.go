@@ -0,0 +1,12 @@
+//go:build !aix && !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !solaris
+// +build !aix,!darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!solaris
+
+package fastrpc
+
+import (
+	"time"
+)
+
+func processCPUTime() time.Duration {
+	return 0
+}
@@ -0,0 +1,223 @@
+package fastrpc
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/UladzimirTrehubenka/fastrpc/tlv"
+)
+
+func TestServerAdmissionController(t *testing.T) {
+	s := &Server{
+		NewHandlerCtx: newTestHandlerCtx,
+		Handler:       testEchoHandler,
+		AdmissionController: AdmissionFunc(func(conn net.Conn, ctxv HandlerCtx) error {
+			ctx := ctxv.(*tlv.RequestCtx)
+			if ctx.Request.Opcode() == 13 {
+				return ErrOverloaded
+			}
+			return nil
+		}),
+	}
+	serverStop, c := newTestServerClientExt(s)
+
+	for i := 0; i < 10; i++ {
+		var req tlv.Request
+		var resp tlv.Response
+		req.SetOpcode(byte(i + 10))
+		req.SwapValue([]byte("foobar"))
+		if err := c.DoDeadline(&req, &resp, time.Now().Add(time.Second)); err != nil {
+			t.Fatalf("unexpected error on iteration %d: %s", i, err)
+		}
+		expected := "foobar"
+		if i == 3 {
+			expected = ErrOverloaded.Error()
+		}
+		if string(resp.Value()) != expected {
+			t.Fatalf("unexpected response on iteration %d: %q. Expecting %q", i, resp.Value(), expected)
+		}
+		if rejected := errors.Is(resp.Err(), tlv.ErrRejected); rejected != (i == 3) {
+			t.Fatalf("unexpected response status on iteration %d: %d", i, resp.Status())
+		}
+	}
+
+	if err := serverStop(); err != nil {
+		t.Fatalf("cannot shutdown server: %s", err)
+	}
+}
+
+func TestServerAdmissionControllerNoRejectHandlerCtx(t *testing.T) {
+	s := &Server{
+		NewHandlerCtx: func() HandlerCtx {
+			return &testNoRejectHandlerCtx{}
+		},
+		Handler: func(ctxv HandlerCtx) HandlerCtx {
+			ctx := ctxv.(*testNoRejectHandlerCtx)
+			ctx.ctx.Write(ctx.ctx.Request.Value())
+			return ctx
+		},
+		AdmissionController: AdmissionFunc(func(conn net.Conn, ctx HandlerCtx) error {
+			return ErrOverloaded
+		}),
+	}
+	serverStop, c := newTestServerClientExt(s)
+
+	// HandlerCtx without RejectError must get ConcurrencyLimitError
+	// for rejected requests.
+	var req tlv.Request
+	var resp tlv.Response
+	req.SwapValue([]byte("foobar"))
+	if err := c.DoDeadline(&req, &resp, time.Now().Add(time.Second)); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if string(resp.Value()) != "concurrency limit exceeded" {
+		t.Fatalf("unexpected response: %q. Expecting %q", resp.Value(), "concurrency limit exceeded")
+	}
+
+	if err := serverStop(); err != nil {
+		t.Fatalf("cannot shutdown server: %s", err)
+	}
+}
+
+// testNoRejectHandlerCtx is HandlerCtx, which doesn't implement
+// RejectHandlerCtx.
+type testNoRejectHandlerCtx struct {
+	ctx tlv.RequestCtx
+}
+
+func (ctx *testNoRejectHandlerCtx) ConcurrencyLimitError(concurrency int) {
+	ctx.ctx.Response.Swap([]byte("concurrency limit exceeded"))
+}
+
+func (ctx *testNoRejectHandlerCtx) Init(conn net.Conn, logger Logger) {
+	ctx.ctx.Init(conn, logger)
+}
+
+func (ctx *testNoRejectHandlerCtx) ReadRequest(br *bufio.Reader) error {
+	return ctx.ctx.ReadRequest(br)
+}
+
+func (ctx *testNoRejectHandlerCtx) WriteResponse(bw *bufio.Writer) error {
+	return ctx.ctx.WriteResponse(bw)
+}
+
+func TestCoDel(t *testing.T) {
+	cd := &CoDel{
+		Target:   5 * time.Millisecond,
+		Interval: 100 * time.Millisecond,
+	}
+	now := time.Now()
+
+	// Requests aren't dropped until the server is overloaded
+	// during the whole interval.
+	if cd.drop(150*time.Millisecond, now) {
+		t.Fatalf("unexpected drop before overload")
+	}
+	if cd.drop(50*time.Millisecond, now.Add(50*time.Millisecond)) {
+		t.Fatalf("unexpected drop before overload")
+	}
+
+	// The minimum delay exceeded the target during the interval,
+	// so requests above the target must be dropped.
+	now = now.Add(100 * time.Millisecond)
+	if !cd.drop(10*time.Millisecond, now) {
+		t.Fatalf("expecting drop for the delay above target during overload")
+	}
+	if cd.drop(time.Millisecond, now) {
+		t.Fatalf("unexpected drop for the delay below target")
+	}
+
+	// The overload is over after the interval with short delays.
+	now = now.Add(100 * time.Millisecond)
+	if cd.drop(10*time.Millisecond, now) {
+		t.Fatalf("unexpected drop after overload")
+	}
+
+	// Intervals without requests aren't overloaded.
+	now = now.Add(100 * time.Millisecond)
+	if !cd.drop(10*time.Millisecond, now) {
+		t.Fatalf("expecting drop for the delay above target during overload")
+	}
+	now = now.Add(time.Second)
+	if cd.drop(10*time.Millisecond, now) {
+		t.Fatalf("unexpected drop after idle interval")
+	}
+}
+
+func TestServerCoDel(t *testing.T) {
+	s := &Server{
+		NewHandlerCtx: newTestHandlerCtx,
+		Handler: func(ctxv HandlerCtx) HandlerCtx {
+			time.Sleep(2 * time.Millisecond)
+			return testEchoHandler(ctxv)
+		},
+		// Requests are queued in the read buffer while the handler
+		// processes the previous request.
+		PipelineRequests: true,
+		CoDel: &CoDel{
+			Target:   5 * time.Millisecond,
+			Interval: 20 * time.Millisecond,
+		},
+	}
+	serverStop, c := newTestServerClientExt(s)
+
+	const requests = 300
+	resultCh := make(chan error, requests)
+	for i := 0; i < requests; i++ {
+		go func() {
+			var req tlv.Request
+			var resp tlv.Response
+			req.SwapValue([]byte("foobar"))
+			if err := c.DoDeadline(&req, &resp, time.Now().Add(10*time.Second)); err != nil {
+				resultCh <- err
+				return
+			}
+			resultCh <- resp.Err()
+		}()
+	}
+
+	served, shed := 0, 0
+	for i := 0; i < requests; i++ {
+		err := <-resultCh
+		switch {
+		case err == nil:
+			served++
+		case errors.Is(err, tlv.ErrRejected):
+			shed++
+		default:
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+	if served == 0 {
+		t.Fatalf("expecting served requests")
+	}
+	if shed == 0 {
+		t.Fatalf("expecting shed requests during overload")
+	}
+	if n := s.Stats().RejectedRequests; n != uint64(shed) {
+		t.Fatalf("unexpected number of rejected requests: %d. Expecting %d", n, shed)
+	}
+
+	if err := serverStop(); err != nil {
+		t.Fatalf("cannot shutdown server: %s", err)
+	}
+}
+
+func TestCPUAdmission(t *testing.T) {
+	a := &CPUAdmission{
+		MaxUsage:       10,
+		SampleInterval: time.Millisecond,
+	}
+	for i := 0; i < 10; i++ {
+		if err := a.Admit(nil, nil); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if usage := a.Usage(); usage < 0 {
+			t.Fatalf("unexpected CPU usage: %f", usage)
+		}
+		time.Sleep(2 * time.Millisecond)
+	}
+}
@@ -0,0 +1,46 @@
+package fastrpc
+
+import (
+	"net"
+
+	"github.com/UladzimirTrehubenka/fastrpc/auth"
+)
+
+// newServerAuthHandshake returns handshake func authenticating the client
+// via a and then calling handshake if it is set.
+//
+// Returns handshake if a is nil.
+func newServerAuthHandshake(a auth.Authenticator, handshake func(conn net.Conn) (net.Conn, error)) func(conn net.Conn) (net.Conn, error) {
+	if a == nil {
+		return handshake
+	}
+	return func(conn net.Conn) (net.Conn, error) {
+		ac, err := auth.ServerHandshake(conn, a)
+		if err != nil {
+			return nil, err
+		}
+		if handshake == nil {
+			return ac, nil
+		}
+		return handshake(ac)
+	}
+}
+
+// newClientAuthHandshake returns handshake func authenticating to the server
+// with creds and then calling handshake if it is set.
+//
+// Returns handshake if creds is nil.
+func newClientAuthHandshake(creds auth.Credentials, handshake func(conn net.Conn) (net.Conn, error)) func(conn net.Conn) (net.Conn, error) {
+	if creds == nil {
+		return handshake
+	}
+	return func(conn net.Conn) (net.Conn, error) {
+		if err := auth.ClientHandshake(conn, creds); err != nil {
+			return nil, err
+		}
+		if handshake == nil {
+			return conn, nil
+		}
+		return handshake(conn)
+	}
+}
@@ -0,0 +1,117 @@
+package auth
+
+import (
+	"errors"
+	"net"
+)
+
+// Principal is the authenticated identity of the client.
+type Principal struct {
+	// Name identifies the client, e.g. HMAC key ID or token subject.
+	Name string
+
+	// Method is the authentication method used by the client.
+	Method string
+
+	// Attributes contains additional attributes of the client,
+	// e.g. token claims.
+	Attributes map[string]string
+}
+
+// Response is the client response to the server challenge.
+type Response struct {
+	// Method is the authentication method, e.g. MethodHMAC or MethodToken.
+	//
+	// The method cannot exceed 255 bytes.
+	Method string
+
+	// Identity is the claimed client identity, e.g. HMAC key ID.
+	//
+	// The identity cannot exceed 255 bytes.
+	Identity string
+
+	// Proof proves the claimed identity, e.g. HMAC of the challenge
+	// or bearer token.
+	//
+	// The proof cannot exceed 65535 bytes.
+	Proof []byte
+}
+
+// Authenticator authenticates clients on the server.
+type Authenticator interface {
+	// Authenticate must verify the client response to the given challenge
+	// and return the authenticated Principal.
+	//
+	// The error isn't sent to the client.
+	Authenticate(challenge []byte, resp *Response) (*Principal, error)
+}
+
+// Credentials provides client credentials.
+type Credentials interface {
+	// Respond must return the response to the given server challenge.
+	//
+	// Respond is called for each new connection, so the credentials
+	// may be refreshed between connections.
+	Respond(challenge []byte) (*Response, error)
+}
+
+var (
+	// ErrAuthFailed is returned by the client if the server rejected
+	// its credentials.
+	ErrAuthFailed = errors.New("authentication failed")
+
+	// ErrUnsupportedMethod may be returned by Authenticator
+	// for unsupported authentication methods.
+	ErrUnsupportedMethod = errors.New("unsupported authentication method")
+)
+
+// Methods is Authenticator dispatching authentication to Authenticators
+// by Response.Method.
+type Methods map[string]Authenticator
+
+// Authenticate implements Authenticator.
+func (m Methods) Authenticate(challenge []byte, resp *Response) (*Principal, error) {
+	a := m[resp.Method]
+	if a == nil {
+		return nil, ErrUnsupportedMethod
+	}
+	return a.Authenticate(challenge, resp)
+}
+
+// Conn is an authenticated connection.
+type Conn struct {
+	net.Conn
+
+	principal *Principal
+}
+
+// Principal returns the authenticated principal for the connection.
+func (c *Conn) Principal() *Principal {
+	return c.principal
+}
+
+// NetConn returns the underlying connection.
+func (c *Conn) NetConn() net.Conn {
+	return c.Conn
+}
+
+// PrincipalFromConn returns the authenticated Principal for the given
+// server connection.
+//
+// Connections wrapped after authentication are unwrapped via NetConn method.
+// Returns nil if the connection isn't authenticated.
+func PrincipalFromConn(conn net.Conn) *Principal {
+	for conn != nil {
+		if c, ok := conn.(*Conn); ok {
+			return c.principal
+		}
+		uc, ok := conn.(interface {
+			NetConn() net.Conn
+		})
+		if !ok {
+			break
+		}
+		conn = uc.NetConn()
+	}
+	return nil
+}
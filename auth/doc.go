@@ -0,0 +1,11 @@
+// Package auth provides connection authentication for fastrpc.
+//
+// The client is authenticated once per connection during the handshake.
+// The server sends a random challenge, the client responds with
+// the credentials and the server either accepts the connection
+// with the authenticated Principal or rejects it.
+//
+// Set fastrpc.Server.Authenticator and fastrpc.Client.Credentials
+// for enabling authentication. The authenticated Principal is available
+// in handlers via tlv.RequestCtx.Principal.
+package auth
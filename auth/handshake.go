@@ -0,0 +1,176 @@
+package auth
+
+import (
+	"crypto/rand"
+	"fmt"
+	"io"
+	"net"
+)
+
+const (
+	protocolVersion = 1
+	challengeSize   = 32
+
+	statusOK     = 0
+	statusDenied = 1
+
+	maxShortFieldSize = 255
+	maxProofSize      = 65535
+)
+
+// ServerHandshake authenticates the client connected via conn.
+//
+// Returns Conn with the authenticated Principal on success.
+// The client is notified about the failed authentication.
+//
+// The caller is responsible for conn deadlines.
+func ServerHandshake(conn net.Conn, a Authenticator) (*Conn, error) {
+	var challenge [1 + challengeSize]byte
+	challenge[0] = protocolVersion
+	if _, err := io.ReadFull(rand.Reader, challenge[1:]); err != nil {
+		return nil, fmt.Errorf("cannot generate challenge: %w", err)
+	}
+	if _, err := conn.Write(challenge[:]); err != nil {
+		return nil, fmt.Errorf("cannot write challenge: %w", err)
+	}
+
+	resp, err := readResponse(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	p, err := a.Authenticate(challenge[1:], resp)
+	if err == nil && p == nil {
+		err = fmt.Errorf("BUG: Authenticator returned nil Principal without error")
+	}
+	if err != nil {
+		// Do not send error details to the client.
+		writeResult(conn, statusDenied, "access denied")
+		return nil, fmt.Errorf("cannot authenticate %q via %q: %w", resp.Identity, resp.Method, err)
+	}
+	if err := writeResult(conn, statusOK, ""); err != nil {
+		return nil, err
+	}
+	return &Conn{
+		Conn:      conn,
+		principal: p,
+	}, nil
+}
+
+// ClientHandshake authenticates to the server connected via conn
+// using the given credentials.
+//
+// Returns an error wrapping ErrAuthFailed if the server rejected
+// the credentials.
+//
+// The caller is responsible for conn deadlines.
+func ClientHandshake(conn net.Conn, c Credentials) error {
+	var challenge [1 + challengeSize]byte
+	if _, err := io.ReadFull(conn, challenge[:]); err != nil {
+		return fmt.Errorf("cannot read challenge: %w", err)
+	}
+	if challenge[0] != protocolVersion {
+		return fmt.Errorf("unsupported authentication protocol version: %d", challenge[0])
+	}
+
+	resp, err := c.Respond(challenge[1:])
+	if err != nil {
+		return fmt.Errorf("cannot obtain credentials: %w", err)
+	}
+	if err := writeResponse(conn, resp); err != nil {
+		return err
+	}
+
+	status, msg, err := readResult(conn)
+	if err != nil {
+		return err
+	}
+	if status != statusOK {
+		return fmt.Errorf("%w: %s", ErrAuthFailed, msg)
+	}
+	return nil
+}
+
+func writeResponse(conn net.Conn, resp *Response) error {
+	if len(resp.Method) > maxShortFieldSize {
+		return fmt.Errorf("too long authentication method: %d bytes. Max %d bytes", len(resp.Method), maxShortFieldSize)
+	}
+	if len(resp.Identity) > maxShortFieldSize {
+		return fmt.Errorf("too long identity: %d bytes. Max %d bytes", len(resp.Identity), maxShortFieldSize)
+	}
+	if len(resp.Proof) > maxProofSize {
+		return fmt.Errorf("too long proof: %d bytes. Max %d bytes", len(resp.Proof), maxProofSize)
+	}
+	b := make([]byte, 0, 4+len(resp.Method)+len(resp.Identity)+len(resp.Proof))
+	b = append(b, byte(len(resp.Method)))
+	b = append(b, resp.Method...)
+	b = append(b, byte(len(resp.Identity)))
+	b = append(b, resp.Identity...)
+	b = append(b, byte(len(resp.Proof)), byte(len(resp.Proof)>>8))
+	b = append(b, resp.Proof...)
+	if _, err := conn.Write(b); err != nil {
+		return fmt.Errorf("cannot write authentication response: %w", err)
+	}
+	return nil
+}
+
+func readResponse(conn net.Conn) (*Response, error) {
+	method, err := readShortField(conn)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read authentication method: %w", err)
+	}
+	identity, err := readShortField(conn)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read identity: %w", err)
+	}
+	var sizeBuf [2]byte
+	if _, err := io.ReadFull(conn, sizeBuf[:]); err != nil {
+		return nil, fmt.Errorf("cannot read proof size: %w", err)
+	}
+	proof := make([]byte, int(sizeBuf[0])|int(sizeBuf[1])<<8)
+	if _, err := io.ReadFull(conn, proof); err != nil {
+		return nil, fmt.Errorf("cannot read proof: %w", err)
+	}
+	return &Response{
+		Method:   string(method),
+		Identity: string(identity),
+		Proof:    proof,
+	}, nil
+}
+
+func writeResult(conn net.Conn, status byte, msg string) error {
+	if len(msg) > maxShortFieldSize {
+		msg = msg[:maxShortFieldSize]
+	}
+	b := make([]byte, 0, 2+len(msg))
+	b = append(b, status, byte(len(msg)))
+	b = append(b, msg...)
+	if _, err := conn.Write(b); err != nil {
+		return fmt.Errorf("cannot write authentication result: %w", err)
+	}
+	return nil
+}
+
+func readResult(conn net.Conn) (byte, string, error) {
+	var status [1]byte
+	if _, err := io.ReadFull(conn, status[:]); err != nil {
+		return 0, "", fmt.Errorf("cannot read authentication result: %w", err)
+	}
+	msg, err := readShortField(conn)
+	if err != nil {
+		return 0, "", fmt.Errorf("cannot read authentication result: %w", err)
+	}
+	return status[0], string(msg), nil
+}
+
+func readShortField(conn net.Conn) ([]byte, error) {
+	var size [1]byte
+	if _, err := io.ReadFull(conn, size[:]); err != nil {
+		return nil, err
+	}
+	b := make([]byte, size[0])
+	if _, err := io.ReadFull(conn, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
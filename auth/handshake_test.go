@@ -0,0 +1,129 @@
+package auth
+
+import (
+	"errors"
+	"net"
+	"testing"
+)
+
+type testHandshakeResult struct {
+	conn *Conn
+	err  error
+}
+
+func testHandshake(t *testing.T, a Authenticator, c Credentials) (*Conn, error, error) {
+	t.Helper()
+	serverConn, clientConn := net.Pipe()
+	defer serverConn.Close()
+	defer clientConn.Close()
+
+	ch := make(chan testHandshakeResult, 1)
+	go func() {
+		conn, err := ServerHandshake(serverConn, a)
+		if err != nil {
+			// Unblock the client if the result cannot be written.
+			serverConn.Close()
+		}
+		ch <- testHandshakeResult{conn, err}
+	}()
+	clientErr := ClientHandshake(clientConn, c)
+	r := <-ch
+	return r.conn, r.err, clientErr
+}
+
+func TestHandshakeHMAC(t *testing.T) {
+	a := &HMACAuthenticator{
+		Secrets: map[string][]byte{
+			"key1": []byte("secret1"),
+		},
+	}
+
+	conn, serverErr, clientErr := testHandshake(t, a, &HMACCredentials{KeyID: "key1", Secret: []byte("secret1")})
+	if serverErr != nil || clientErr != nil {
+		t.Fatalf("unexpected errors: server: %v, client: %v", serverErr, clientErr)
+	}
+	p := PrincipalFromConn(conn)
+	if p == nil || p.Name != "key1" || p.Method != MethodHMAC {
+		t.Fatalf("unexpected principal: %+v", p)
+	}
+
+	for _, c := range []*HMACCredentials{
+		{KeyID: "key1", Secret: []byte("wrong")},
+		{KeyID: "key2", Secret: []byte("secret1")},
+	} {
+		_, serverErr, clientErr = testHandshake(t, a, c)
+		if serverErr == nil {
+			t.Fatalf("expecting non-nil server error for %+v", c)
+		}
+		if !errors.Is(clientErr, ErrAuthFailed) {
+			t.Fatalf("unexpected client error for %+v: %v. Expecting %v", c, clientErr, ErrAuthFailed)
+		}
+	}
+}
+
+func TestHandshakeToken(t *testing.T) {
+	a := &TokenAuthenticator{
+		Verify: func(token string) (*Principal, error) {
+			if token != "valid-token" {
+				return nil, errors.New("invalid token")
+			}
+			return &Principal{
+				Name:       "alice",
+				Attributes: map[string]string{"role": "admin"},
+			}, nil
+		},
+	}
+
+	conn, serverErr, clientErr := testHandshake(t, a, StaticToken("valid-token"))
+	if serverErr != nil || clientErr != nil {
+		t.Fatalf("unexpected errors: server: %v, client: %v", serverErr, clientErr)
+	}
+	p := conn.Principal()
+	if p.Name != "alice" || p.Method != MethodToken || p.Attributes["role"] != "admin" {
+		t.Fatalf("unexpected principal: %+v", p)
+	}
+
+	_, serverErr, clientErr = testHandshake(t, a, StaticToken("invalid-token"))
+	if serverErr == nil || !errors.Is(clientErr, ErrAuthFailed) {
+		t.Fatalf("unexpected errors: server: %v, client: %v", serverErr, clientErr)
+	}
+}
+
+func TestHandshakeMethods(t *testing.T) {
+	a := Methods{
+		MethodHMAC: &HMACAuthenticator{
+			Secrets: map[string][]byte{"key": []byte("secret")},
+		},
+	}
+	_, serverErr, clientErr := testHandshake(t, a, &HMACCredentials{KeyID: "key", Secret: []byte("secret")})
+	if serverErr != nil || clientErr != nil {
+		t.Fatalf("unexpected errors: server: %v, client: %v", serverErr, clientErr)
+	}
+	_, serverErr, clientErr = testHandshake(t, a, StaticToken("token"))
+	if !errors.Is(serverErr, ErrUnsupportedMethod) || !errors.Is(clientErr, ErrAuthFailed) {
+		t.Fatalf("unexpected errors: server: %v, client: %v", serverErr, clientErr)
+	}
+}
+
+type testWrappedConn struct {
+	net.Conn
+}
+
+func (c *testWrappedConn) NetConn() net.Conn {
+	return c.Conn
+}
+
+func TestPrincipalFromConn(t *testing.T) {
+	serverConn, clientConn := net.Pipe()
+	defer serverConn.Close()
+	defer clientConn.Close()
+
+	if p := PrincipalFromConn(serverConn); p != nil {
+		t.Fatalf("unexpected principal for unauthenticated conn: %+v", p)
+	}
+	p := &Principal{Name: "foo"}
+	conn := &testWrappedConn{&Conn{Conn: serverConn, principal: p}}
+	if pp := PrincipalFromConn(conn); pp != p {
+		t.Fatalf("unexpected principal for wrapped conn: %+v. Expecting %+v", pp, p)
+	}
+}
@@ -0,0 +1,68 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+)
+
+// MethodHMAC is the authentication method for HMACCredentials.
+const MethodHMAC = "hmac-sha256"
+
+var errInvalidHMAC = errors.New("invalid HMAC")
+
+// HMACCredentials authenticates the client via HMAC-SHA256
+// of the server challenge with the shared secret.
+//
+// The secret is never sent over the connection.
+type HMACCredentials struct {
+	// KeyID identifies the secret on the server.
+	KeyID string
+
+	// Secret is the shared secret.
+	Secret []byte
+}
+
+// Respond implements Credentials.
+func (c *HMACCredentials) Respond(challenge []byte) (*Response, error) {
+	return &Response{
+		Method:   MethodHMAC,
+		Identity: c.KeyID,
+		Proof:    hmacSum(c.Secret, challenge, c.KeyID),
+	}, nil
+}
+
+// HMACAuthenticator authenticates clients with HMACCredentials.
+type HMACAuthenticator struct {
+	// Secrets maps key IDs to shared secrets.
+	//
+	// Secrets mustn't be modified after the server is started.
+	Secrets map[string][]byte
+}
+
+// Authenticate implements Authenticator.
+//
+// The returned Principal.Name is the key ID.
+func (a *HMACAuthenticator) Authenticate(challenge []byte, resp *Response) (*Principal, error) {
+	if resp.Method != MethodHMAC {
+		return nil, ErrUnsupportedMethod
+	}
+	secret, ok := a.Secrets[resp.Identity]
+	if !ok {
+		return nil, errInvalidHMAC
+	}
+	if !hmac.Equal(resp.Proof, hmacSum(secret, challenge, resp.Identity)) {
+		return nil, errInvalidHMAC
+	}
+	return &Principal{
+		Name:   resp.Identity,
+		Method: MethodHMAC,
+	}, nil
+}
+
+func hmacSum(secret, challenge []byte, keyID string) []byte {
+	h := hmac.New(sha256.New, secret)
+	h.Write(challenge)
+	h.Write([]byte(keyID))
+	return h.Sum(nil)
+}
@@ -0,0 +1,113 @@
+package auth
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// MethodToken is the authentication method for TokenCredentials.
+const MethodToken = "token"
+
+// DefaultTokenRefreshBefore is the default duration before token
+// expiration when TokenCredentials fetches a new token.
+const DefaultTokenRefreshBefore = 30 * time.Second
+
+// TokenCredentials authenticates the client via bearer token.
+//
+// The token is sent as is, so it must be used only over TLS connections.
+type TokenCredentials struct {
+	// Fetch must return a new token and its expiration time.
+	//
+	// Zero expiration time means the token never expires.
+	Fetch func() (token string, expiresAt time.Time, err error)
+
+	// RefreshBefore is the duration before token expiration when
+	// a new token is fetched.
+	//
+	// DefaultTokenRefreshBefore is used by default.
+	RefreshBefore time.Duration
+
+	mu        sync.Mutex
+	token     string
+	expiresAt time.Time
+	fetched   bool
+}
+
+// StaticToken returns TokenCredentials for the given non-expiring token.
+func StaticToken(token string) *TokenCredentials {
+	return &TokenCredentials{
+		Fetch: func() (string, time.Time, error) {
+			return token, time.Time{}, nil
+		},
+	}
+}
+
+// Respond implements Credentials.
+func (c *TokenCredentials) Respond(challenge []byte) (*Response, error) {
+	token, err := c.Token()
+	if err != nil {
+		return nil, err
+	}
+	return &Response{
+		Method: MethodToken,
+		Proof:  []byte(token),
+	}, nil
+}
+
+// Token returns the current token, fetching a new one if the current
+// token is about to expire.
+func (c *TokenCredentials) Token() (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	refreshBefore := c.RefreshBefore
+	if refreshBefore <= 0 {
+		refreshBefore = DefaultTokenRefreshBefore
+	}
+	if c.fetched && (c.expiresAt.IsZero() || time.Until(c.expiresAt) > refreshBefore) {
+		return c.token, nil
+	}
+	token, expiresAt, err := c.Fetch()
+	if err != nil {
+		return "", err
+	}
+	c.token = token
+	c.expiresAt = expiresAt
+	c.fetched = true
+	return token, nil
+}
+
+// Invalidate forces fetching a new token on the next Respond call,
+// e.g. after the token has been revoked.
+func (c *TokenCredentials) Invalidate() {
+	c.mu.Lock()
+	c.fetched = false
+	c.mu.Unlock()
+}
+
+var errEmptyToken = errors.New("empty token")
+
+// TokenAuthenticator authenticates clients with TokenCredentials.
+type TokenAuthenticator struct {
+	// Verify must verify the token and return the Principal for it.
+	Verify func(token string) (*Principal, error)
+}
+
+// Authenticate implements Authenticator.
+func (a *TokenAuthenticator) Authenticate(challenge []byte, resp *Response) (*Principal, error) {
+	if resp.Method != MethodToken {
+		return nil, ErrUnsupportedMethod
+	}
+	if len(resp.Proof) == 0 {
+		return nil, errEmptyToken
+	}
+	p, err := a.Verify(string(resp.Proof))
+	if err != nil {
+		return nil, err
+	}
+	if p != nil && p.Method == "" {
+		p.Method = MethodToken
+	}
+	return p, nil
+}
@@ -0,0 +1,65 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestTokenCredentialsRefresh(t *testing.T) {
+	var fetches int
+	var fetchErr error
+	expiresAt := time.Now().Add(time.Hour)
+	c := &TokenCredentials{
+		Fetch: func() (string, time.Time, error) {
+			if fetchErr != nil {
+				return "", time.Time{}, fetchErr
+			}
+			fetches++
+			return fmt.Sprintf("token%d", fetches), expiresAt, nil
+		},
+	}
+
+	checkToken := func(expected string) {
+		t.Helper()
+		resp, err := c.Respond(nil)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if resp.Method != MethodToken || string(resp.Proof) != expected {
+			t.Fatalf("unexpected response: %+v. Expecting token %q", resp, expected)
+		}
+	}
+
+	checkToken("token1")
+	checkToken("token1")
+
+	// The token must be refetched after invalidation.
+	c.Invalidate()
+	checkToken("token2")
+
+	// The token must be refetched before expiration.
+	expiresAt = time.Now().Add(DefaultTokenRefreshBefore / 2)
+	c.Invalidate()
+	checkToken("token3")
+	checkToken("token4")
+
+	fetchErr = errors.New("fetch error")
+	if _, err := c.Respond(nil); err != fetchErr {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, fetchErr)
+	}
+}
+
+func TestStaticToken(t *testing.T) {
+	c := StaticToken("foobar")
+	for i := 0; i < 3; i++ {
+		token, err := c.Token()
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if token != "foobar" {
+			t.Fatalf("unexpected token: %q. Expecting %q", token, "foobar")
+		}
+	}
+}
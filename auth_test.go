@@ -0,0 +1,123 @@
+package fastrpc
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/UladzimirTrehubenka/fastrpc/auth"
+	"github.com/UladzimirTrehubenka/fastrpc/tlv"
+)
+
+type testCountingCredentials struct {
+	auth.Credentials
+	n uint32
+}
+
+func (c *testCountingCredentials) Respond(challenge []byte) (*auth.Response, error) {
+	atomic.AddUint32(&c.n, 1)
+	return c.Credentials.Respond(challenge)
+}
+
+func newTestAuthServer() *Server {
+	return &Server{
+		NewHandlerCtx: newTestHandlerCtx,
+		Handler: func(ctxv HandlerCtx) HandlerCtx {
+			ctx := ctxv.(*tlv.RequestCtx)
+			p := ctx.Principal()
+			if p == nil {
+				ctx.Write([]byte("anonymous"))
+				return ctx
+			}
+			ctx.Write([]byte(p.Name))
+			return ctx
+		},
+		Authenticator: &auth.HMACAuthenticator{
+			Secrets: map[string][]byte{
+				"client1": []byte("secret1"),
+			},
+		},
+	}
+}
+
+func TestServerClientAuthenticator(t *testing.T) {
+	s := newTestAuthServer()
+	serverStop, ln := newTestServerExt(s)
+
+	creds := &testCountingCredentials{
+		Credentials: &auth.HMACCredentials{KeyID: "client1", Secret: []byte("secret1")},
+	}
+	c := newTestClient(ln)
+	c.Credentials = creds
+
+	doRequest := func() error {
+		var req tlv.Request
+		var resp tlv.Response
+		if err := c.DoDeadline(&req, &resp, time.Now().Add(time.Second)); err != nil {
+			return err
+		}
+		if string(resp.Value()) != "client1" {
+			t.Fatalf("unexpected response: %q. Expecting %q", resp.Value(), "client1")
+		}
+		return nil
+	}
+	for i := 0; i < 2; i++ {
+		if err := doRequest(); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+	if n := atomic.LoadUint32(&creds.n); n != 1 {
+		t.Fatalf("unexpected number of authentications: %d. Expecting 1", n)
+	}
+
+	// The client must authenticate again after reconnecting.
+	c.Conn().Close()
+	deadline := time.Now().Add(time.Second)
+	for doRequest() != nil {
+		if time.Now().After(deadline) {
+			t.Fatalf("cannot reconnect to the server")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := atomic.LoadUint32(&creds.n); n != 2 {
+		t.Fatalf("unexpected number of authentications: %d. Expecting 2", n)
+	}
+
+	if err := serverStop(); err != nil {
+		t.Fatalf("cannot shutdown server: %s", err)
+	}
+}
+
+func TestServerClientAuthenticatorInvalidCredentials(t *testing.T) {
+	s := newTestAuthServer()
+	serverStop, ln := newTestServerExt(s)
+
+	c := newTestClient(ln)
+	c.Credentials = &auth.HMACCredentials{KeyID: "client1", Secret: []byte("wrong")}
+
+	var req tlv.Request
+	var resp tlv.Response
+	if err := c.DoDeadline(&req, &resp, time.Now().Add(200*time.Millisecond)); err == nil {
+		t.Fatalf("expecting non-nil error")
+	}
+	var err error
+	deadline := time.Now().Add(time.Second)
+	for {
+		err = c.getError(nil)
+		if errors.Is(err, auth.ErrAuthFailed) || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !errors.Is(err, auth.ErrAuthFailed) {
+		t.Fatalf("unexpected client error: %v. Expecting %v", err, auth.ErrAuthFailed)
+	}
+	if n := s.Stats().HandshakeErrors; n == 0 {
+		t.Fatalf("expecting non-zero server handshake errors")
+	}
+
+	if err := serverStop(); err != nil {
+		t.Fatalf("cannot shutdown server: %s", err)
+	}
+}
@@ -0,0 +1,33 @@
+package fastrpc
+
+import (
+	"errors"
+	"net"
+)
+
+// ErrPermissionDenied is passed to
+// PermissionDeniedHandlerCtx.PermissionDeniedError for requests denied
+// by Server.Authorizer.
+var ErrPermissionDenied = errors.New("permission denied")
+
+// Authorizer decides whether requests are allowed to reach Server.Handler.
+//
+// See authz package for policy-based Authorizer.
+type Authorizer interface {
+	// Authorize is called for each request read by the Server before
+	// passing the request to Server.Handler.
+	//
+	// The request is rejected via
+	// PermissionDeniedHandlerCtx.PermissionDeniedError with the returned
+	// error if it is non-nil. ErrPermissionDenied should be returned
+	// for denied requests.
+	Authorize(conn net.Conn, ctx HandlerCtx) error
+}
+
+// AuthorizerFunc is an adapter allowing ordinary functions as Authorizer.
+type AuthorizerFunc func(conn net.Conn, ctx HandlerCtx) error
+
+// Authorize implements Authorizer.
+func (f AuthorizerFunc) Authorize(conn net.Conn, ctx HandlerCtx) error {
+	return f(conn, ctx)
+}
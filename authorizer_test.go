@@ -0,0 +1,54 @@
+package fastrpc
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/UladzimirTrehubenka/fastrpc/tlv"
+)
+
+func TestServerAuthorizer(t *testing.T) {
+	s := &Server{
+		NewHandlerCtx: newTestHandlerCtx,
+		Handler:       testEchoHandler,
+		Authorizer: AuthorizerFunc(func(conn net.Conn, ctxv HandlerCtx) error {
+			ctx := ctxv.(*tlv.RequestCtx)
+			if ctx.Request.Opcode() != 1 {
+				return ErrPermissionDenied
+			}
+			return nil
+		}),
+	}
+	serverStop, ln := newTestServerExt(s)
+	c := newTestClient(ln)
+
+	f := func(opcode byte, expected string) {
+		t.Helper()
+		var req tlv.Request
+		var resp tlv.Response
+		req.SetOpcode(opcode)
+		req.SwapValue([]byte("foobar"))
+		if err := c.DoDeadline(&req, &resp, time.Now().Add(time.Second)); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if string(resp.Value()) != expected {
+			t.Fatalf("unexpected response for opcode %d: %q. Expecting %q", opcode, resp.Value(), expected)
+		}
+		if denied := errors.Is(resp.Err(), tlv.ErrPermissionDenied); denied != (opcode != 1) {
+			t.Fatalf("unexpected response status for opcode %d: %d", opcode, resp.Status())
+		}
+	}
+	f(1, "foobar")
+	f(2, ErrPermissionDenied.Error())
+
+	stats := s.Stats()
+	if stats.DeniedRequests != 1 || stats.RejectedRequests != 1 {
+		t.Fatalf("unexpected stats: denied=%d, rejected=%d. Expecting 1 and 1", stats.DeniedRequests, stats.RejectedRequests)
+	}
+
+	if err := serverStop(); err != nil {
+		t.Fatalf("cannot shutdown server: %s", err)
+	}
+}
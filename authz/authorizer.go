@@ -0,0 +1,69 @@
+package authz
+
+import (
+	"net"
+
+	"github.com/UladzimirTrehubenka/fastrpc"
+	"github.com/UladzimirTrehubenka/fastrpc/auth"
+	"github.com/UladzimirTrehubenka/fastrpc/rpclog"
+	"github.com/UladzimirTrehubenka/fastrpc/tlv"
+)
+
+// Authorizer implements fastrpc.Authorizer for tlv requests.
+//
+// Requests not allowed by the policy are rejected
+// with fastrpc.ErrPermissionDenied.
+type Authorizer struct {
+	// Policy provides the policy for authorization decisions.
+	//
+	// Use *Policy for static policy or *PolicyFile for the policy
+	// reloaded from file.
+	Policy PolicySource
+
+	// AuditLogger is used for logging authorization decisions.
+	//
+	// Denied requests are logged with warning level.
+	// Allowed requests are logged with info level if AuditAllowed is set.
+	//
+	// tlv.RequestCtx.Logger is used by default, so the log entries
+	// contain connection ID and request nonce.
+	AuditLogger rpclog.Logger
+
+	// AuditAllowed enables logging of allowed requests.
+	AuditAllowed bool
+}
+
+// Authorize implements fastrpc.Authorizer.
+func (a *Authorizer) Authorize(conn net.Conn, ctxv fastrpc.HandlerCtx) error {
+	ctx, ok := ctxv.(*tlv.RequestCtx)
+	if !ok {
+		panic("BUG: authz.Authorizer may be used only with *tlv.RequestCtx")
+	}
+	opcode := ctx.Request.Opcode()
+	p := auth.PrincipalFromConn(conn)
+	allowed := a.Policy.Policy().Allowed(opcode, p)
+	if allowed && !a.AuditAllowed {
+		return nil
+	}
+
+	logger := a.AuditLogger
+	if logger == nil {
+		logger = ctx.Logger()
+	}
+	var name, method string
+	if p != nil {
+		name, method = p.Name, p.Method
+	}
+	keyvals := []interface{}{
+		"opcode", opcode,
+		"principal", name,
+		"auth_method", method,
+		"remote_addr", ctx.RemoteAddr().String(),
+	}
+	if allowed {
+		logger.Info("authz: request allowed", keyvals...)
+		return nil
+	}
+	logger.Warn("authz: request denied", keyvals...)
+	return fastrpc.ErrPermissionDenied
+}
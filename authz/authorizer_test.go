@@ -0,0 +1,116 @@
+package authz
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/UladzimirTrehubenka/fastrpc"
+	"github.com/UladzimirTrehubenka/fastrpc/auth"
+	"github.com/UladzimirTrehubenka/fastrpc/rpclog"
+	"github.com/UladzimirTrehubenka/fastrpc/tlv"
+	"github.com/valyala/fasthttp/fasthttputil"
+)
+
+type testAuditLog struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (tl *testAuditLog) Printf(format string, args ...interface{}) {
+	tl.mu.Lock()
+	tl.lines = append(tl.lines, fmt.Sprintf(format, args...))
+	tl.mu.Unlock()
+}
+
+func TestAuthorizer(t *testing.T) {
+	p, err := ParsePolicy([]byte(`{"rules": [{"opcodes": [1], "principals": ["alice"]}]}`))
+	if err != nil {
+		t.Fatalf("cannot parse policy: %s", err)
+	}
+	var audit testAuditLog
+	s := &fastrpc.Server{
+		NewHandlerCtx: func() fastrpc.HandlerCtx {
+			return &tlv.RequestCtx{}
+		},
+		Handler: func(ctxv fastrpc.HandlerCtx) fastrpc.HandlerCtx {
+			ctx := ctxv.(*tlv.RequestCtx)
+			ctx.Write([]byte("ok"))
+			return ctx
+		},
+		Authenticator: &auth.TokenAuthenticator{
+			Verify: func(token string) (*auth.Principal, error) {
+				return &auth.Principal{Name: token}, nil
+			},
+		},
+		Authorizer: &Authorizer{
+			Policy:      p,
+			AuditLogger: rpclog.FromPrintfLogger(&audit, rpclog.LevelInfo),
+		},
+	}
+	ln := fasthttputil.NewInmemoryListener()
+	serverDone := make(chan error, 1)
+	go func() {
+		serverDone <- s.Serve(ln)
+	}()
+
+	newClient := func(name string) *fastrpc.Client {
+		return &fastrpc.Client{
+			NewResponse: func() fastrpc.ResponseReader {
+				return &tlv.Response{}
+			},
+			Dial: func(addr string) (net.Conn, error) {
+				return ln.Dial()
+			},
+			Credentials: auth.StaticToken(name),
+		}
+	}
+	f := func(c *fastrpc.Client, opcode byte, expected string) {
+		t.Helper()
+		var req tlv.Request
+		var resp tlv.Response
+		req.SetOpcode(opcode)
+		if err := c.DoDeadline(&req, &resp, time.Now().Add(time.Second)); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if string(resp.Value()) != expected {
+			t.Fatalf("unexpected response for opcode %d: %q. Expecting %q", opcode, resp.Value(), expected)
+		}
+		if denied := resp.Status() == tlv.StatusPermissionDenied; denied != (expected != "ok") {
+			t.Fatalf("unexpected response status for opcode %d: %d", opcode, resp.Status())
+		}
+	}
+
+	alice := newClient("alice")
+	bob := newClient("bob")
+	f(alice, 1, "ok")
+	f(alice, 2, fastrpc.ErrPermissionDenied.Error())
+	f(bob, 1, fastrpc.ErrPermissionDenied.Error())
+
+	if n := s.Stats().DeniedRequests; n != 2 {
+		t.Fatalf("unexpected number of denied requests: %d. Expecting 2", n)
+	}
+	audit.mu.Lock()
+	lines := append([]string(nil), audit.lines...)
+	audit.mu.Unlock()
+	if len(lines) != 2 {
+		t.Fatalf("unexpected number of audit log lines: %d. Expecting 2; lines: %q", len(lines), lines)
+	}
+	for _, s := range []string{"WARN authz: request denied", "opcode=1", "principal=bob", "auth_method=token"} {
+		if !strings.Contains(lines[1], s) {
+			t.Fatalf("missing %q in audit log line %q", s, lines[1])
+		}
+	}
+
+	if err := ln.Close(); err != nil {
+		t.Fatalf("cannot close listener: %s", err)
+	}
+	select {
+	case <-serverDone:
+	case <-time.After(time.Second):
+		t.Fatalf("timeout")
+	}
+}
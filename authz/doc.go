@@ -0,0 +1,20 @@
+// Package authz provides policy-based authorization of tlv requests
+// for fastrpc.Server.
+//
+// The policy maps tlv opcodes to principals and roles allowed to send
+// them. Principals are authenticated via fastrpc.Server.Authenticator.
+// The policy may be loaded from JSON file and reloaded on changes:
+//
+//	{
+//	  "roles": {
+//	    "reader": ["svc-frontend", "svc-reports"]
+//	  },
+//	  "rules": [
+//	    {"opcodes": [1, 2], "roles": ["reader"]},
+//	    {"opcodes": [3], "principals": ["svc-admin"]},
+//	    {"principals": ["svc-root"]}
+//	  ]
+//	}
+//
+// Requests not allowed by any rule are denied.
+package authz
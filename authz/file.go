@@ -0,0 +1,119 @@
+package authz
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultPolicyCheckInterval is the default interval between checks
+// for policy file modification in PolicyFile.
+const DefaultPolicyCheckInterval = 10 * time.Second
+
+// PolicySource provides the current policy.
+type PolicySource interface {
+	// Policy must return the current policy.
+	Policy() *Policy
+}
+
+// PolicyFile loads the policy from JSON file and reloads it when
+// the file is modified, so the policy may be updated without restarting
+// the server.
+type PolicyFile struct {
+	path          string
+	checkInterval time.Duration
+
+	// OnReload is called after each reload attempt of the modified file
+	// with nil or the reload error.
+	//
+	// The previously loaded policy is kept on error.
+	OnReload func(err error)
+
+	policy atomic.Value
+
+	// nextCheck is the time in unix nanoseconds for the next
+	// modification check.
+	nextCheck int64
+
+	mu      sync.Mutex
+	modTime time.Time
+}
+
+// NewPolicyFile loads the policy from the given file.
+//
+// The file is checked for modification at most once per checkInterval.
+// DefaultPolicyCheckInterval is used if checkInterval isn't positive.
+func NewPolicyFile(path string, checkInterval time.Duration) (*PolicyFile, error) {
+	if checkInterval <= 0 {
+		checkInterval = DefaultPolicyCheckInterval
+	}
+	pf := &PolicyFile{
+		path:          path,
+		checkInterval: checkInterval,
+	}
+	if err := pf.Reload(); err != nil {
+		return nil, err
+	}
+	return pf, nil
+}
+
+// Reload unconditionally reloads the policy from the file.
+//
+// The previously loaded policy is kept on error.
+func (pf *PolicyFile) Reload() error {
+	pf.mu.Lock()
+	defer pf.mu.Unlock()
+	return pf.reload()
+}
+
+func (pf *PolicyFile) reload() error {
+	fi, err := os.Stat(pf.path)
+	if err != nil {
+		return fmt.Errorf("cannot stat policy file: %w", err)
+	}
+	data, err := ioutil.ReadFile(pf.path)
+	if err != nil {
+		return fmt.Errorf("cannot read policy file: %w", err)
+	}
+	p, err := ParsePolicy(data)
+	if err != nil {
+		return fmt.Errorf("cannot load policy from %q: %w", pf.path, err)
+	}
+	pf.policy.Store(p)
+	pf.modTime = fi.ModTime()
+	atomic.StoreInt64(&pf.nextCheck, time.Now().Add(pf.checkInterval).UnixNano())
+	return nil
+}
+
+// Policy returns the current policy.
+//
+// The policy is reloaded if the file has been modified.
+func (pf *PolicyFile) Policy() *Policy {
+	now := time.Now().UnixNano()
+	next := atomic.LoadInt64(&pf.nextCheck)
+	if now >= next && atomic.CompareAndSwapInt64(&pf.nextCheck, next, now+int64(pf.checkInterval)) {
+		pf.checkModified()
+	}
+	return pf.policy.Load().(*Policy)
+}
+
+func (pf *PolicyFile) checkModified() {
+	pf.mu.Lock()
+	defer pf.mu.Unlock()
+
+	fi, err := os.Stat(pf.path)
+	if err != nil || fi.ModTime().Equal(pf.modTime) {
+		return
+	}
+	err = pf.reload()
+	if err != nil {
+		// Do not check the broken file until it is modified again.
+		pf.modTime = fi.ModTime()
+	}
+	if pf.OnReload != nil {
+		pf.OnReload(err)
+	}
+}
@@ -0,0 +1,68 @@
+package authz
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/UladzimirTrehubenka/fastrpc/auth"
+)
+
+func TestPolicyFileReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fastrpc-authz")
+	if err != nil {
+		t.Fatalf("cannot create temporary dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "policy.json")
+
+	writePolicy := func(data string, modTime time.Time) {
+		t.Helper()
+		if err := ioutil.WriteFile(path, []byte(data), 0600); err != nil {
+			t.Fatalf("cannot write policy: %s", err)
+		}
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatalf("cannot change modification time: %s", err)
+		}
+	}
+
+	if _, err := NewPolicyFile(path, 0); err == nil {
+		t.Fatalf("expecting non-nil error for missing file")
+	}
+
+	now := time.Now()
+	writePolicy(`{"rules": [{"opcodes": [1], "principals": ["alice"]}]}`, now.Add(-2*time.Minute))
+	pf, err := NewPolicyFile(path, time.Nanosecond)
+	if err != nil {
+		t.Fatalf("cannot load policy: %s", err)
+	}
+	var reloadErrs []error
+	pf.OnReload = func(err error) {
+		reloadErrs = append(reloadErrs, err)
+	}
+	alice := &auth.Principal{Name: "alice"}
+	if !pf.Policy().Allowed(1, alice) {
+		t.Fatalf("opcode 1 must be allowed for alice")
+	}
+
+	writePolicy(`{"rules": [{"opcodes": [2], "principals": ["alice"]}]}`, now.Add(-time.Minute))
+	time.Sleep(time.Millisecond)
+	if pf.Policy().Allowed(1, alice) || !pf.Policy().Allowed(2, alice) {
+		t.Fatalf("the policy must be reloaded")
+	}
+
+	// Broken policy must not replace the loaded policy.
+	writePolicy(`{"rules": [`, now)
+	time.Sleep(time.Millisecond)
+	if !pf.Policy().Allowed(2, alice) {
+		t.Fatalf("the broken policy must be ignored")
+	}
+	if len(reloadErrs) != 2 || reloadErrs[0] != nil || reloadErrs[1] == nil {
+		t.Fatalf("unexpected reload errors: %v", reloadErrs)
+	}
+	if err := pf.Reload(); err == nil {
+		t.Fatalf("expecting non-nil error when reloading broken policy")
+	}
+}
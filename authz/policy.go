@@ -0,0 +1,198 @@
+package authz
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/UladzimirTrehubenka/fastrpc/auth"
+)
+
+// AnyPrincipal may be used in Rule.Principals for allowing
+// any authenticated principal.
+const AnyPrincipal = "*"
+
+// RolesAttribute is the auth.Principal attribute with comma-separated
+// roles of the principal, e.g. obtained from token claims.
+const RolesAttribute = "roles"
+
+// Policy maps tlv opcodes to principals and roles allowed to send them.
+//
+// Policy mustn't be modified after the first Allowed call.
+// Invalid policy denies all the requests, so use ParsePolicy
+// or Policy.Validate for detecting errors.
+type Policy struct {
+	// Roles maps role names to principal names.
+	Roles map[string][]string `json:"roles,omitempty"`
+
+	// Rules contains rules allowing requests.
+	//
+	// Requests not allowed by any rule are denied.
+	Rules []Rule `json:"rules"`
+
+	compileOnce sync.Once
+	compiled    *compiledPolicy
+}
+
+// Rule allows the given opcodes for the given principals and roles.
+type Rule struct {
+	// Opcodes contains allowed opcodes.
+	//
+	// All the opcodes are allowed if empty.
+	Opcodes []int `json:"opcodes,omitempty"`
+
+	// Principals contains names of allowed principals.
+	//
+	// AnyPrincipal allows any authenticated principal.
+	Principals []string `json:"principals,omitempty"`
+
+	// Roles contains allowed roles.
+	Roles []string `json:"roles,omitempty"`
+}
+
+type compiledPolicy struct {
+	// principalRoles maps principal names to their roles from Policy.Roles.
+	principalRoles map[string][]string
+
+	// opcodes contains allowed principals and roles per opcode.
+	opcodes [256]opcodeACL
+}
+
+type opcodeACL struct {
+	any        bool
+	principals map[string]struct{}
+	roles      map[string]struct{}
+}
+
+// ParsePolicy parses JSON-encoded policy.
+func ParsePolicy(data []byte) (*Policy, error) {
+	var p Policy
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.DisallowUnknownFields()
+	if err := d.Decode(&p); err != nil {
+		return nil, fmt.Errorf("cannot parse policy: %w", err)
+	}
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// Validate returns an error if p is invalid.
+func (p *Policy) Validate() error {
+	_, err := p.newCompiledPolicy()
+	return err
+}
+
+func (p *Policy) getCompiled() *compiledPolicy {
+	p.compileOnce.Do(func() {
+		if cp, err := p.newCompiledPolicy(); err == nil {
+			p.compiled = cp
+		}
+	})
+	return p.compiled
+}
+
+func (p *Policy) newCompiledPolicy() (*compiledPolicy, error) {
+	cp := &compiledPolicy{
+		principalRoles: make(map[string][]string),
+	}
+	for role, principals := range p.Roles {
+		for _, name := range principals {
+			cp.principalRoles[name] = append(cp.principalRoles[name], role)
+		}
+	}
+	for i := range p.Rules {
+		r := &p.Rules[i]
+		if len(r.Principals) == 0 && len(r.Roles) == 0 {
+			return nil, fmt.Errorf("rule #%d must contain principals or roles", i)
+		}
+		for _, opcode := range r.Opcodes {
+			if opcode < 0 || opcode > 255 {
+				return nil, fmt.Errorf("rule #%d contains invalid opcode %d; it must be in the range [0..255]", i, opcode)
+			}
+		}
+		if len(r.Opcodes) == 0 {
+			for opcode := range cp.opcodes {
+				cp.opcodes[opcode].add(r)
+			}
+			continue
+		}
+		for _, opcode := range r.Opcodes {
+			cp.opcodes[opcode].add(r)
+		}
+	}
+	return cp, nil
+}
+
+func (acl *opcodeACL) add(r *Rule) {
+	for _, name := range r.Principals {
+		if name == AnyPrincipal {
+			acl.any = true
+			continue
+		}
+		if acl.principals == nil {
+			acl.principals = make(map[string]struct{})
+		}
+		acl.principals[name] = struct{}{}
+	}
+	for _, role := range r.Roles {
+		if acl.roles == nil {
+			acl.roles = make(map[string]struct{})
+		}
+		acl.roles[role] = struct{}{}
+	}
+}
+
+// Allowed returns true if the principal is allowed to send requests
+// with the given opcode.
+//
+// Requests from unauthenticated clients, i.e. with nil principal,
+// are always denied.
+//
+// Principal roles are obtained from Policy.Roles and from
+// the RolesAttribute of the principal.
+func (p *Policy) Allowed(opcode byte, principal *auth.Principal) bool {
+	if principal == nil {
+		return false
+	}
+	cp := p.getCompiled()
+	if cp == nil {
+		return false
+	}
+	acl := &cp.opcodes[opcode]
+	if acl.any {
+		return true
+	}
+	if _, ok := acl.principals[principal.Name]; ok {
+		return true
+	}
+	if len(acl.roles) == 0 {
+		return false
+	}
+	for _, role := range cp.principalRoles[principal.Name] {
+		if _, ok := acl.roles[role]; ok {
+			return true
+		}
+	}
+	roles := principal.Attributes[RolesAttribute]
+	for roles != "" {
+		var role string
+		if n := strings.IndexByte(roles, ','); n >= 0 {
+			role, roles = roles[:n], roles[n+1:]
+		} else {
+			role, roles = roles, ""
+		}
+		if _, ok := acl.roles[strings.TrimSpace(role)]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Policy returns p, so Policy may be used as PolicySource.
+func (p *Policy) Policy() *Policy {
+	return p
+}
@@ -0,0 +1,84 @@
+package authz
+
+import (
+	"testing"
+
+	"github.com/UladzimirTrehubenka/fastrpc/auth"
+)
+
+const testPolicy = `{
+	"roles": {
+		"reader": ["alice", "bob"]
+	},
+	"rules": [
+		{"opcodes": [1, 2], "roles": ["reader"]},
+		{"opcodes": [3], "principals": ["bob"]},
+		{"opcodes": [4], "principals": ["*"]},
+		{"opcodes": [5], "roles": ["writer"]},
+		{"principals": ["root"]}
+	]
+}`
+
+func TestPolicyAllowed(t *testing.T) {
+	p, err := ParsePolicy([]byte(testPolicy))
+	if err != nil {
+		t.Fatalf("cannot parse policy: %s", err)
+	}
+
+	f := func(opcode byte, principal *auth.Principal, expected bool) {
+		t.Helper()
+		if allowed := p.Allowed(opcode, principal); allowed != expected {
+			t.Fatalf("unexpected decision for opcode %d and principal %+v: %v. Expecting %v", opcode, principal, allowed, expected)
+		}
+	}
+	alice := &auth.Principal{Name: "alice"}
+	bob := &auth.Principal{Name: "bob"}
+	carol := &auth.Principal{Name: "carol"}
+	dave := &auth.Principal{
+		Name:       "dave",
+		Attributes: map[string]string{RolesAttribute: "auditor, writer"},
+	}
+	root := &auth.Principal{Name: "root"}
+
+	f(1, alice, true)
+	f(2, bob, true)
+	f(1, carol, false)
+	f(3, alice, false)
+	f(3, bob, true)
+	f(4, carol, true)
+	f(4, nil, false)
+	f(5, dave, true)
+	f(5, alice, false)
+	f(6, alice, false)
+	f(6, root, true)
+	f(255, root, true)
+}
+
+func TestParsePolicyError(t *testing.T) {
+	f := func(data string) {
+		t.Helper()
+		if _, err := ParsePolicy([]byte(data)); err == nil {
+			t.Fatalf("expecting non-nil error for policy %s", data)
+		}
+	}
+	f(``)
+	f(`{"rules": [`)
+	f(`{"rules": [{"opcodes": [256], "principals": ["alice"]}]}`)
+	f(`{"rules": [{"opcodes": [-1], "principals": ["alice"]}]}`)
+	f(`{"rules": [{"opcodes": [1]}]}`)
+	f(`{"unknown": 1}`)
+}
+
+func TestPolicyInvalidDeniesAll(t *testing.T) {
+	p := &Policy{
+		Rules: []Rule{
+			{Opcodes: []int{1000}, Principals: []string{"*"}},
+		},
+	}
+	if err := p.Validate(); err == nil {
+		t.Fatalf("expecting non-nil error")
+	}
+	if p.Allowed(1, &auth.Principal{Name: "alice"}) {
+		t.Fatalf("invalid policy must deny all the requests")
+	}
+}
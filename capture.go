@@ -0,0 +1,116 @@
+package fastrpc
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"sync/atomic"
+	"time"
+
+	"github.com/UladzimirTrehubenka/fastrpc/capture"
+)
+
+// frameRecorder writes frames sent and received over a connection
+// to capture.Writer.
+//
+// Frames are read by the connection reader and written by the connection
+// writer, so the read and the write parts may be used concurrently.
+type frameRecorder struct {
+	w      *capture.Writer
+	connID uint64
+	logger Logger
+
+	// readBuf contains bytes read from the connection starting
+	// from readPos position.
+	readBuf []byte
+	readPos int64
+	readN   int64
+
+	// writeBuf and bw are used for serializing written frames.
+	writeBuf bytes.Buffer
+	bw       *bufio.Writer
+
+	// errLogged is set after the first capture error is logged.
+	errLogged uint32
+}
+
+func newFrameRecorder(w *capture.Writer, connID uint64, logger Logger) *frameRecorder {
+	if w == nil {
+		return nil
+	}
+	fr := &frameRecorder{
+		w:      w,
+		connID: connID,
+		logger: logger,
+	}
+	fr.bw = bufio.NewWriter(&fr.writeBuf)
+	return fr
+}
+
+// reader returns reader, which remembers bytes read from r.
+func (fr *frameRecorder) reader(r io.Reader) io.Reader {
+	return &recordingReader{
+		r:  r,
+		fr: fr,
+	}
+}
+
+type recordingReader struct {
+	r  io.Reader
+	fr *frameRecorder
+}
+
+func (rr *recordingReader) Read(p []byte) (int, error) {
+	n, err := rr.r.Read(p)
+	rr.fr.readBuf = append(rr.fr.readBuf, p[:n]...)
+	rr.fr.readN += int64(n)
+	return n, err
+}
+
+// beginRead marks the start of the frame at the current br position.
+//
+// br must read from the reader returned by fr.reader.
+func (fr *frameRecorder) beginRead(br *bufio.Reader) {
+	pos := fr.readN - int64(br.Buffered())
+	n := copy(fr.readBuf, fr.readBuf[pos-fr.readPos:])
+	fr.readBuf = fr.readBuf[:n]
+	fr.readPos = pos
+}
+
+// endRead records the frame read from br since beginRead call.
+func (fr *frameRecorder) endRead(br *bufio.Reader, typ capture.Type, nonce uint32) {
+	pos := fr.readN - int64(br.Buffered())
+	fr.record(typ, nonce, fr.readBuf[:pos-fr.readPos])
+}
+
+// writeFrame writes the frame serialized by writeFrame to bw
+// and records it.
+func (fr *frameRecorder) writeFrame(bw *bufio.Writer, typ capture.Type, nonce uint32, writeFrame func(bw *bufio.Writer) error) error {
+	fr.writeBuf.Reset()
+	if err := writeFrame(fr.bw); err != nil {
+		fr.bw.Reset(&fr.writeBuf)
+		return err
+	}
+	if err := fr.bw.Flush(); err != nil {
+		return err
+	}
+	data := fr.writeBuf.Bytes()
+	if _, err := bw.Write(data); err != nil {
+		return err
+	}
+	fr.record(typ, nonce, data)
+	return nil
+}
+
+func (fr *frameRecorder) record(typ capture.Type, nonce uint32, data []byte) {
+	err := fr.w.Write(&capture.Record{
+		Type:   typ,
+		Time:   time.Now(),
+		ConnID: fr.connID,
+		Nonce:  nonce,
+		Data:   data,
+	})
+	if err != nil && atomic.CompareAndSwapUint32(&fr.errLogged, 0, 1) {
+		fr.logger.Error("fastrpc: cannot capture frame", "error", err)
+	}
+}
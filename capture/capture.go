@@ -0,0 +1,238 @@
+package capture
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Magic is the header of capture files.
+const Magic = "FRPCCAP1"
+
+// MaxDataSize is the maximum size of Record.Data.
+const MaxDataSize = 64 * 1024 * 1024
+
+// ErrInvalidFormat is returned when the capture has invalid format.
+var ErrInvalidFormat = errors.New("capture: invalid format")
+
+// Type is the type of the captured frame.
+type Type byte
+
+const (
+	// TypeRequest is the type of request frames.
+	TypeRequest Type = 1
+
+	// TypeResponse is the type of response frames.
+	TypeResponse Type = 2
+)
+
+// String returns the name of t.
+func (t Type) String() string {
+	switch t {
+	case TypeRequest:
+		return "request"
+	case TypeResponse:
+		return "response"
+	default:
+		return fmt.Sprintf("Type(%d)", byte(t))
+	}
+}
+
+// Record is the captured frame.
+type Record struct {
+	// Type is the frame type.
+	Type Type
+
+	// Time is the time the frame is sent or received at.
+	Time time.Time
+
+	// ConnID is the ID of the connection the frame is sent over.
+	ConnID uint64
+
+	// Nonce is the request ID.
+	Nonce uint32
+
+	// Data is the frame without the nonce.
+	Data []byte
+}
+
+const recordHeaderSize = 1 + 8 + 8 + 4 + 4
+
+// Writer writes records to capture.
+//
+// It is safe calling Writer methods from concurrently running goroutines.
+type Writer struct {
+	mu  sync.Mutex
+	bw  *bufio.Writer
+	c   io.Closer
+	err error
+
+	header [recordHeaderSize]byte
+}
+
+// NewWriter writes Magic to w and returns Writer for writing records to w.
+//
+// Records are buffered, so call Flush or Close when done.
+func NewWriter(w io.Writer) (*Writer, error) {
+	bw := bufio.NewWriterSize(w, 64*1024)
+	if _, err := bw.WriteString(Magic); err != nil {
+		return nil, fmt.Errorf("capture: cannot write header: %w", err)
+	}
+	cw := &Writer{
+		bw: bw,
+	}
+	if c, ok := w.(io.Closer); ok {
+		cw.c = c
+	}
+	return cw, nil
+}
+
+// Create creates capture file at the given path.
+func Create(path string) (*Writer, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("capture: %w", err)
+	}
+	w, err := NewWriter(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+// Write writes r to the capture.
+//
+// Writer stops writing after the first error and returns it
+// on subsequent calls.
+func (w *Writer) Write(r *Record) error {
+	if len(r.Data) > MaxDataSize {
+		return fmt.Errorf("capture: too big data size %d; mustn't exceed %d", len(r.Data), MaxDataSize)
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.err != nil {
+		return w.err
+	}
+	h := w.header[:]
+	h[0] = byte(r.Type)
+	binary.LittleEndian.PutUint64(h[1:], uint64(r.Time.UnixNano()))
+	binary.LittleEndian.PutUint64(h[9:], r.ConnID)
+	binary.LittleEndian.PutUint32(h[17:], r.Nonce)
+	binary.LittleEndian.PutUint32(h[21:], uint32(len(r.Data)))
+	if _, err := w.bw.Write(h); err != nil {
+		return w.setError(err)
+	}
+	if _, err := w.bw.Write(r.Data); err != nil {
+		return w.setError(err)
+	}
+	return nil
+}
+
+// Flush writes buffered records to the underlying writer.
+func (w *Writer) Flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.err != nil {
+		return w.err
+	}
+	if err := w.bw.Flush(); err != nil {
+		return w.setError(err)
+	}
+	return nil
+}
+
+// Close flushes buffered records and closes the underlying writer
+// if it implements io.Closer.
+func (w *Writer) Close() error {
+	err := w.Flush()
+	if w.c != nil {
+		if cerr := w.c.Close(); err == nil && cerr != nil {
+			err = fmt.Errorf("capture: %w", cerr)
+		}
+	}
+	return err
+}
+
+// setError remembers err as the Writer error.
+//
+// mu must be locked.
+func (w *Writer) setError(err error) error {
+	w.err = fmt.Errorf("capture: cannot write record: %w", err)
+	return w.err
+}
+
+// Reader reads records from capture.
+type Reader struct {
+	br *bufio.Reader
+
+	header [recordHeaderSize]byte
+}
+
+// NewReader checks Magic at the start of r and returns Reader
+// for reading records from r.
+func NewReader(r io.Reader) (*Reader, error) {
+	br := bufio.NewReaderSize(r, 64*1024)
+	var magic [len(Magic)]byte
+	if _, err := io.ReadFull(br, magic[:]); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, ErrInvalidFormat
+		}
+		return nil, fmt.Errorf("capture: cannot read header: %w", err)
+	}
+	if string(magic[:]) != Magic {
+		return nil, ErrInvalidFormat
+	}
+	return &Reader{
+		br: br,
+	}, nil
+}
+
+// Next reads the next record into r.
+//
+// r.Data buffer is re-used. io.EOF is returned at the end of capture.
+func (cr *Reader) Next(r *Record) error {
+	h := cr.header[:]
+	if n, err := io.ReadFull(cr.br, h); err != nil {
+		if err == io.EOF && n == 0 {
+			return io.EOF
+		}
+		if err == io.ErrUnexpectedEOF {
+			return fmt.Errorf("%w: truncated record header", ErrInvalidFormat)
+		}
+		return fmt.Errorf("capture: cannot read record header: %w", err)
+	}
+
+	r.Type = Type(h[0])
+	if r.Type != TypeRequest && r.Type != TypeResponse {
+		return fmt.Errorf("%w: unknown record type %d", ErrInvalidFormat, h[0])
+	}
+	r.Time = time.Unix(0, int64(binary.LittleEndian.Uint64(h[1:])))
+	r.ConnID = binary.LittleEndian.Uint64(h[9:])
+	r.Nonce = binary.LittleEndian.Uint32(h[17:])
+	size := binary.LittleEndian.Uint32(h[21:])
+	if size > MaxDataSize {
+		return fmt.Errorf("%w: too big data size %d", ErrInvalidFormat, size)
+	}
+
+	if n := int(size); cap(r.Data) >= n {
+		r.Data = r.Data[:n]
+	} else {
+		r.Data = make([]byte, n)
+	}
+	if _, err := io.ReadFull(cr.br, r.Data); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return fmt.Errorf("%w: truncated record data", ErrInvalidFormat)
+		}
+		return fmt.Errorf("capture: cannot read record data: %w", err)
+	}
+	return nil
+}
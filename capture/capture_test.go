@@ -0,0 +1,211 @@
+package capture
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWriterReader(t *testing.T) {
+	records := []Record{
+		{
+			Type:   TypeRequest,
+			Time:   time.Unix(0, 1600000000123456789),
+			ConnID: 1,
+			Nonce:  1,
+			Data:   []byte("request"),
+		},
+		{
+			Type:   TypeRequest,
+			Time:   time.Unix(0, 1600000000223456789),
+			ConnID: 2,
+			Nonce:  0,
+		},
+		{
+			Type:   TypeResponse,
+			Time:   time.Unix(0, 1600000000323456789),
+			ConnID: 1,
+			Nonce:  1,
+			Data:   []byte("response"),
+		},
+	}
+
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	for i := range records {
+		if err := w.Write(&records[i]); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !bytes.HasPrefix(buf.Bytes(), []byte(Magic)) {
+		t.Fatalf("the capture must start with %q", Magic)
+	}
+
+	r, err := NewReader(&buf)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	var rec Record
+	for i := range records {
+		if err := r.Next(&rec); err != nil {
+			t.Fatalf("unexpected error on record %d: %s", i, err)
+		}
+		testCheckRecord(t, &rec, &records[i])
+	}
+	if err := r.Next(&rec); err != io.EOF {
+		t.Fatalf("unexpected error: %v; expecting io.EOF", err)
+	}
+}
+
+func TestCreate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fastrpc-capture")
+	if err != nil {
+		t.Fatalf("cannot create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "test.cap")
+	w, err := Create(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected := Record{
+		Type:   TypeRequest,
+		Time:   time.Now(),
+		ConnID: 42,
+		Nonce:  123,
+		Data:   []byte("foobar"),
+	}
+	if err := w.Write(&expected); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer f.Close()
+	r, err := NewReader(f)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	var rec Record
+	if err := r.Next(&rec); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	testCheckRecord(t, &rec, &expected)
+}
+
+func TestReaderInvalidFormat(t *testing.T) {
+	for _, s := range []string{
+		"",
+		"FRPC",
+		"FRPCCAP0",
+	} {
+		if _, err := NewReader(bytes.NewBufferString(s)); err != ErrInvalidFormat {
+			t.Fatalf("unexpected error for %q: %v; expecting %v", s, err, ErrInvalidFormat)
+		}
+	}
+
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := w.Write(&Record{Type: TypeRequest, Data: []byte("foobar")}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	data := buf.Bytes()
+
+	// truncated record
+	for _, n := range []int{len(Magic) + 1, len(data) - 1} {
+		r, err := NewReader(bytes.NewReader(data[:n]))
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		var rec Record
+		if err := r.Next(&rec); !errors.Is(err, ErrInvalidFormat) {
+			t.Fatalf("unexpected error for truncated record: %v; expecting %v", err, ErrInvalidFormat)
+		}
+	}
+
+	// unknown record type
+	corrupted := append([]byte{}, data...)
+	corrupted[len(Magic)] = 42
+	r, err := NewReader(bytes.NewReader(corrupted))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	var rec Record
+	if err := r.Next(&rec); !errors.Is(err, ErrInvalidFormat) {
+		t.Fatalf("unexpected error for unknown record type: %v; expecting %v", err, ErrInvalidFormat)
+	}
+}
+
+func TestWriterError(t *testing.T) {
+	w, err := NewWriter(&testFailingWriter{})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := w.Flush(); err == nil {
+		t.Fatalf("expecting non-nil error")
+	}
+	// The error must be returned on subsequent calls.
+	if err := w.Write(&Record{Type: TypeRequest}); err == nil {
+		t.Fatalf("expecting non-nil error")
+	}
+}
+
+func TestTypeString(t *testing.T) {
+	if s := TypeRequest.String(); s != "request" {
+		t.Fatalf("unexpected string: %q; expecting %q", s, "request")
+	}
+	if s := TypeResponse.String(); s != "response" {
+		t.Fatalf("unexpected string: %q; expecting %q", s, "response")
+	}
+	if s := Type(42).String(); s != "Type(42)" {
+		t.Fatalf("unexpected string: %q; expecting %q", s, "Type(42)")
+	}
+}
+
+type testFailingWriter struct{}
+
+func (w *testFailingWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("write error")
+}
+
+func testCheckRecord(t *testing.T, rec, expected *Record) {
+	t.Helper()
+	if rec.Type != expected.Type {
+		t.Fatalf("unexpected type: %s; expecting %s", rec.Type, expected.Type)
+	}
+	if !rec.Time.Equal(expected.Time) {
+		t.Fatalf("unexpected time: %s; expecting %s", rec.Time, expected.Time)
+	}
+	if rec.ConnID != expected.ConnID {
+		t.Fatalf("unexpected connection ID: %d; expecting %d", rec.ConnID, expected.ConnID)
+	}
+	if rec.Nonce != expected.Nonce {
+		t.Fatalf("unexpected nonce: %d; expecting %d", rec.Nonce, expected.Nonce)
+	}
+	if !bytes.Equal(rec.Data, expected.Data) {
+		t.Fatalf("unexpected data: %q; expecting %q", rec.Data, expected.Data)
+	}
+}
@@ -0,0 +1,21 @@
+// Package capture reads and writes captures of rpc traffic.
+//
+// Captures are written by fastrpc.Server and fastrpc.Client
+// with Capture set and may be replayed with replay package
+// or cmd/fastrpc-replay tool.
+//
+// The capture file starts with 8-byte Magic followed by records.
+// Each record has the following format with integers in little endian:
+//
+//	type     1 byte: 1 for request, 2 for response
+//	time     8 bytes: unix timestamp in nanoseconds
+//	conn_id  8 bytes: ID of the connection the frame is sent over
+//	nonce    4 bytes: request ID; 0 for requests without response
+//	size     4 bytes: the size of data
+//	data     size bytes: the frame as written by fastrpc.RequestWriter
+//	         or fastrpc.HandlerCtx.WriteResponse without the nonce
+//
+// Connection IDs are unique within the Server or the Client, which wrote
+// the capture, so a response is matched to the request by connection ID
+// and nonce.
+package capture
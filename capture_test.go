@@ -0,0 +1,106 @@
+package fastrpc
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/UladzimirTrehubenka/fastrpc/capture"
+	"github.com/UladzimirTrehubenka/fastrpc/tlv"
+)
+
+func TestServerClientCapture(t *testing.T) {
+	var serverBuf, clientBuf bytes.Buffer
+	serverCapture, err := capture.NewWriter(&serverBuf)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	clientCapture, err := capture.NewWriter(&clientBuf)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	s := &Server{
+		NewHandlerCtx: newTestHandlerCtx,
+		Handler:       testEchoHandler,
+		Capture:       serverCapture,
+	}
+	serverStop, ln := newTestServerExt(s)
+	c := newTestClient(ln)
+	c.Capture = clientCapture
+
+	const n = 10
+	startTime := time.Now()
+	for i := 0; i < n; i++ {
+		if err := testDoEcho(c, fmt.Sprintf("value %d", i)); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+	c.Close()
+	if err := serverStop(); err != nil {
+		t.Fatalf("cannot shutdown server: %s", err)
+	}
+
+	if err := serverCapture.Close(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := clientCapture.Close(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	for _, buf := range []*bytes.Buffer{&serverBuf, &clientBuf} {
+		r, err := capture.NewReader(buf)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		requests := make(map[uint32]string)
+		responses := 0
+		var rec capture.Record
+		for {
+			if err := r.Next(&rec); err != nil {
+				if err == io.EOF {
+					break
+				}
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if rec.ConnID != 1 {
+				t.Fatalf("unexpected connection ID: %d; expecting 1", rec.ConnID)
+			}
+			if rec.Time.Before(startTime) || rec.Time.After(time.Now()) {
+				t.Fatalf("unexpected record time: %s", rec.Time)
+			}
+
+			br := bufio.NewReader(bytes.NewReader(rec.Data))
+			switch rec.Type {
+			case capture.TypeRequest:
+				var req tlv.Request
+				if err := req.ReadRequest(br); err != nil {
+					t.Fatalf("cannot parse captured request: %s", err)
+				}
+				requests[rec.Nonce] = string(req.Value())
+			case capture.TypeResponse:
+				var resp tlv.Response
+				if err := resp.ReadResponse(br); err != nil {
+					t.Fatalf("cannot parse captured response: %s", err)
+				}
+				v, ok := requests[rec.Nonce]
+				if !ok {
+					t.Fatalf("missing request for the response with nonce %d", rec.Nonce)
+				}
+				if string(resp.Value()) != v {
+					t.Fatalf("unexpected captured response %q; expecting %q", resp.Value(), v)
+				}
+				responses++
+			}
+			if br.Buffered() != 0 {
+				t.Fatalf("captured %s contains %d extra bytes", rec.Type, br.Buffered())
+			}
+		}
+		if len(requests) != n || responses != n {
+			t.Fatalf("unexpected number of captured requests and responses: %d, %d; expecting %d", len(requests), responses, n)
+		}
+	}
+}
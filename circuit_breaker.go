@@ -0,0 +1,276 @@
+package fastrpc
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultCircuitErrorRateThreshold is the default share of failed
+	// requests opening the circuit.
+	DefaultCircuitErrorRateThreshold = 0.5
+
+	// DefaultCircuitMinRequests is the default minimum number of requests
+	// in the window before the circuit may be opened.
+	DefaultCircuitMinRequests = 20
+
+	// DefaultCircuitWindow is the default duration of the window
+	// for counting failed requests.
+	DefaultCircuitWindow = 10 * time.Second
+
+	// DefaultCircuitOpenTimeout is the default duration the circuit stays
+	// open before probe requests are allowed.
+	DefaultCircuitOpenTimeout = 5 * time.Second
+
+	// DefaultCircuitHalfOpenProbes is the default number of successful
+	// probe requests closing the circuit.
+	DefaultCircuitHalfOpenProbes = 3
+)
+
+// ErrCircuitOpen is returned from Client calls while the circuit is open.
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// CircuitState is the state of CircuitBreaker.
+type CircuitState int32
+
+const (
+	// CircuitClosed means requests are sent to the server.
+	CircuitClosed CircuitState = iota
+
+	// CircuitOpen means requests fail immediately with ErrCircuitOpen.
+	CircuitOpen
+
+	// CircuitHalfOpen means a limited number of probe requests is sent
+	// to the server in order to determine whether it is healthy again.
+	CircuitHalfOpen
+)
+
+// String returns human-readable state name.
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+// CircuitBreaker stops sending requests to unhealthy server.
+//
+// The circuit opens when the share of failed requests in the window
+// exceeds ErrorRateThreshold. Requests fail immediately with ErrCircuitOpen
+// while the circuit is open. The circuit becomes half-open after
+// OpenTimeout, so up to HalfOpenProbes probe requests are sent
+// to the server. The circuit closes if all the probes succeed,
+// otherwise it opens again.
+//
+// CircuitBreaker cannot be shared among Clients.
+type CircuitBreaker struct {
+	// ErrorRateThreshold is the share of failed requests in the window
+	// opening the circuit.
+	//
+	// DefaultCircuitErrorRateThreshold is used by default.
+	ErrorRateThreshold float64
+
+	// LatencyThreshold is the maximum request latency.
+	// Requests exceeding the latency are counted as failed.
+	//
+	// By default request latency isn't limited.
+	LatencyThreshold time.Duration
+
+	// MinRequests is the minimum number of requests in the window
+	// before the circuit may be opened.
+	//
+	// DefaultCircuitMinRequests is used by default.
+	MinRequests int
+
+	// Window is the duration of the window for counting failed requests.
+	//
+	// DefaultCircuitWindow is used by default.
+	Window time.Duration
+
+	// OpenTimeout is the duration the circuit stays open before
+	// becoming half-open.
+	//
+	// DefaultCircuitOpenTimeout is used by default.
+	OpenTimeout time.Duration
+
+	// HalfOpenProbes is the number of successful probe requests
+	// required for closing the half-open circuit.
+	//
+	// DefaultCircuitHalfOpenProbes is used by default.
+	HalfOpenProbes int
+
+	// OnStateChange is called on each circuit state change.
+	OnStateChange func(from, to CircuitState)
+
+	mu sync.Mutex
+
+	state       CircuitState
+	generation  uint64
+	windowStart time.Time
+	requests    int
+	failures    int
+	openedAt    time.Time
+
+	pendingProbes    int
+	successfulProbes int
+}
+
+// State returns the current circuit state.
+func (cb *CircuitBreaker) State() CircuitState {
+	cb.mu.Lock()
+	from, to := cb.updateState(time.Now())
+	cb.mu.Unlock()
+
+	cb.notify(from, to)
+	return to
+}
+
+// allow returns true if the request may be sent to the server.
+//
+// report must be called with the returned generation for each allowed
+// request.
+func (cb *CircuitBreaker) allow() (uint64, bool) {
+	cb.mu.Lock()
+	from, to := cb.updateState(time.Now())
+	gen := cb.generation
+	ok := true
+	switch to {
+	case CircuitOpen:
+		ok = false
+	case CircuitHalfOpen:
+		if cb.pendingProbes+cb.successfulProbes >= cb.halfOpenProbes() {
+			ok = false
+		} else {
+			cb.pendingProbes++
+		}
+	}
+	cb.mu.Unlock()
+
+	cb.notify(from, to)
+	return gen, ok
+}
+
+// report registers the result of the request allowed by allow.
+//
+// Results for requests allowed before the last state change are ignored.
+func (cb *CircuitBreaker) report(gen uint64, err error, latency time.Duration) {
+	ignored := err == ErrPendingRequestsOverflow || err == ErrClientClosed
+	failed := err != nil || (cb.LatencyThreshold > 0 && latency > cb.LatencyThreshold)
+
+	cb.mu.Lock()
+	now := time.Now()
+	from, to := cb.updateState(now)
+	if gen != cb.generation {
+		cb.mu.Unlock()
+		cb.notify(from, to)
+		return
+	}
+	switch cb.state {
+	case CircuitClosed:
+		if !ignored {
+			cb.requests++
+			if failed {
+				cb.failures++
+			}
+			if cb.requests >= cb.minRequests() && float64(cb.failures) > cb.errorRateThreshold()*float64(cb.requests) {
+				to = cb.setState(CircuitOpen, now)
+			}
+		}
+	case CircuitHalfOpen:
+		cb.pendingProbes--
+		if !ignored {
+			if failed {
+				to = cb.setState(CircuitOpen, now)
+			} else {
+				cb.successfulProbes++
+				if cb.successfulProbes >= cb.halfOpenProbes() {
+					to = cb.setState(CircuitClosed, now)
+				}
+			}
+		}
+	}
+	cb.mu.Unlock()
+
+	cb.notify(from, to)
+}
+
+// updateState resets the expired window and switches the open circuit
+// to half-open after OpenTimeout.
+//
+// It returns the state before and after the update.
+func (cb *CircuitBreaker) updateState(now time.Time) (CircuitState, CircuitState) {
+	from := cb.state
+	switch cb.state {
+	case CircuitClosed:
+		if now.Sub(cb.windowStart) > cb.window() {
+			cb.windowStart = now
+			cb.requests = 0
+			cb.failures = 0
+		}
+	case CircuitOpen:
+		if now.Sub(cb.openedAt) >= cb.openTimeout() {
+			cb.setState(CircuitHalfOpen, now)
+		}
+	}
+	return from, cb.state
+}
+
+func (cb *CircuitBreaker) setState(state CircuitState, now time.Time) CircuitState {
+	cb.state = state
+	cb.generation++
+	cb.windowStart = now
+	cb.requests = 0
+	cb.failures = 0
+	cb.openedAt = now
+	cb.pendingProbes = 0
+	cb.successfulProbes = 0
+	return state
+}
+
+func (cb *CircuitBreaker) notify(from, to CircuitState) {
+	if from != to && cb.OnStateChange != nil {
+		cb.OnStateChange(from, to)
+	}
+}
+
+func (cb *CircuitBreaker) errorRateThreshold() float64 {
+	if cb.ErrorRateThreshold <= 0 {
+		return DefaultCircuitErrorRateThreshold
+	}
+	return cb.ErrorRateThreshold
+}
+
+func (cb *CircuitBreaker) minRequests() int {
+	if cb.MinRequests <= 0 {
+		return DefaultCircuitMinRequests
+	}
+	return cb.MinRequests
+}
+
+func (cb *CircuitBreaker) window() time.Duration {
+	if cb.Window <= 0 {
+		return DefaultCircuitWindow
+	}
+	return cb.Window
+}
+
+func (cb *CircuitBreaker) openTimeout() time.Duration {
+	if cb.OpenTimeout <= 0 {
+		return DefaultCircuitOpenTimeout
+	}
+	return cb.OpenTimeout
+}
+
+func (cb *CircuitBreaker) halfOpenProbes() int {
+	if cb.HalfOpenProbes <= 0 {
+		return DefaultCircuitHalfOpenProbes
+	}
+	return cb.HalfOpenProbes
+}
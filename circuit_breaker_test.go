@@ -0,0 +1,168 @@
+package fastrpc
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/UladzimirTrehubenka/fastrpc/tlv"
+)
+
+func TestCircuitBreakerStates(t *testing.T) {
+	var transitions []string
+	cb := &CircuitBreaker{
+		MinRequests:    4,
+		OpenTimeout:    50 * time.Millisecond,
+		HalfOpenProbes: 2,
+		OnStateChange: func(from, to CircuitState) {
+			transitions = append(transitions, fmt.Sprintf("%s->%s", from, to))
+		},
+	}
+	errFailed := errors.New("failed")
+
+	testCircuitBreakerReport(t, cb, nil)
+	for i := 0; i < 3; i++ {
+		testCircuitBreakerReport(t, cb, errFailed)
+	}
+	if state := cb.State(); state != CircuitOpen {
+		t.Fatalf("unexpected state: %s. Expecting %s", state, CircuitOpen)
+	}
+	if _, ok := cb.allow(); ok {
+		t.Fatalf("open circuit mustn't allow requests")
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if state := cb.State(); state != CircuitHalfOpen {
+		t.Fatalf("unexpected state: %s. Expecting %s", state, CircuitHalfOpen)
+	}
+
+	// Only HalfOpenProbes probes are allowed.
+	gen1, ok1 := cb.allow()
+	gen2, ok2 := cb.allow()
+	if !ok1 || !ok2 {
+		t.Fatalf("half-open circuit must allow probes")
+	}
+	if _, ok := cb.allow(); ok {
+		t.Fatalf("half-open circuit mustn't allow more than %d probes", cb.HalfOpenProbes)
+	}
+	cb.report(gen1, nil, 0)
+	cb.report(gen2, nil, 0)
+	if state := cb.State(); state != CircuitClosed {
+		t.Fatalf("unexpected state: %s. Expecting %s", state, CircuitClosed)
+	}
+
+	expected := "[closed->open open->half-open half-open->closed]"
+	if s := fmt.Sprintf("%s", transitions); s != expected {
+		t.Fatalf("unexpected transitions: %s. Expecting %s", s, expected)
+	}
+}
+
+func TestCircuitBreakerLatencyThreshold(t *testing.T) {
+	cb := &CircuitBreaker{
+		MinRequests:      2,
+		LatencyThreshold: time.Millisecond,
+	}
+	for i := 0; i < 2; i++ {
+		gen, ok := cb.allow()
+		if !ok {
+			t.Fatalf("closed circuit must allow requests")
+		}
+		cb.report(gen, nil, time.Second)
+	}
+	if state := cb.State(); state != CircuitOpen {
+		t.Fatalf("unexpected state: %s. Expecting %s", state, CircuitOpen)
+	}
+}
+
+func TestClientCircuitBreaker(t *testing.T) {
+	c := &Client{
+		NewResponse: newTestResponse,
+		Dial: func(addr string) (net.Conn, error) {
+			return nil, fmt.Errorf("no server")
+		},
+		CircuitBreaker: &CircuitBreaker{
+			MinRequests: 5,
+		},
+	}
+	defer c.Close()
+
+	for i := 0; i < 5; i++ {
+		var req tlv.Request
+		var resp tlv.Response
+		err := c.DoDeadline(&req, &resp, time.Now().Add(20*time.Millisecond))
+		if err == nil || err == ErrCircuitOpen {
+			t.Fatalf("unexpected error on iteration %d: %v", i, err)
+		}
+	}
+
+	var req tlv.Request
+	var resp tlv.Response
+	if err := c.DoDeadline(&req, &resp, time.Now().Add(time.Second)); err != ErrCircuitOpen {
+		t.Fatalf("unexpected error: %v. Expecting %s", err, ErrCircuitOpen)
+	}
+	if c.SendNowait(&req, nil) {
+		t.Fatalf("SendNowait must fail while the circuit is open")
+	}
+}
+
+func TestClientCircuitBreakerOverflow(t *testing.T) {
+	stopCh := make(chan struct{})
+	h := func(ctx HandlerCtx) HandlerCtx {
+		<-stopCh
+		return ctx
+	}
+	serverStop, c := newTestServerClient(h)
+	c.MaxPendingRequests = 1
+	c.CircuitBreaker = &CircuitBreaker{
+		MinRequests: 5,
+	}
+
+	// Overflows mustn't be counted as failures even if the Client
+	// substitutes them with the last connection error.
+	c.setLastError(errors.New("connection error"))
+
+	resultCh := make(chan error, 1)
+	go func() {
+		var req tlv.Request
+		var resp tlv.Response
+		resultCh <- c.DoDeadline(&req, &resp, time.Now().Add(time.Second))
+	}()
+	for i := 0; i < 100 && c.PendingRequests() == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	for i := 0; i < 10; i++ {
+		var req tlv.Request
+		var resp tlv.Response
+		if err := c.DoDeadline(&req, &resp, time.Now().Add(time.Second)); err == nil || err == ErrCircuitOpen {
+			t.Fatalf("unexpected error on iteration %d: %v", i, err)
+		}
+	}
+	if state := c.CircuitBreaker.State(); state != CircuitClosed {
+		t.Fatalf("unexpected circuit state: %s. Expecting %s", state, CircuitClosed)
+	}
+
+	close(stopCh)
+	select {
+	case err := <-resultCh:
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("timeout")
+	}
+
+	if err := serverStop(); err != nil {
+		t.Fatalf("cannot shutdown server: %s", err)
+	}
+}
+
+func testCircuitBreakerReport(t *testing.T, cb *CircuitBreaker, err error) {
+	gen, ok := cb.allow()
+	if !ok {
+		t.Fatalf("closed circuit must allow requests")
+	}
+	cb.report(gen, err, 0)
+}
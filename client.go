@@ -2,6 +2,7 @@ package fastrpc
 
 import (
 	"bufio"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
@@ -10,7 +11,8 @@ import (
 	"sync/atomic"
 	"time"
 
-	"github.com/valyala/fasthttp"
+	"github.com/UladzimirTrehubenka/fastrpc/auth"
+	"github.com/UladzimirTrehubenka/fastrpc/capture"
 )
 
 // RequestWriter is an interface for writing rpc request to buffered writer.
@@ -25,32 +27,85 @@ type ResponseReader interface {
 	ReadResponse(br *bufio.Reader) error
 }
 
-// Client sends rpc requests to the Server over a single connection.
+// Client sends rpc requests to the Server.
 //
-// Use multiple clients for establishing multiple connections to the server
+// Requests are sent over a single connection by default.
+// Set Client.Connections for spreading requests among multiple connections
 // if a single connection processing consumes 100% of a single CPU core
-// on either multi-core client or server.
+// on either multi-core client or server, or if the network link is lossy.
 type Client struct {
 	// NewResponse must return new response object.
 	NewResponse func() ResponseReader
 
 	// Addr is the Server address to connect to.
+	//
+	// The following forms are supported by the default Dial:
+	//
+	//   - host:port and tcp://host:port for TCP;
+	//   - unix:///path/to/socket for unix socket at /path/to/socket;
+	//   - unix://@name for unix socket in Linux abstract namespace.
 	Addr string
 
 	// Dial is a custom function used for connecting to the Server.
 	//
-	// fasthttp.Dial is used by default.
+	// Dial is used by default.
 	Dial func(addr string) (net.Conn, error)
 
+	// TLSConfig enables TLS for connections to the Server if set.
+	//
+	// Set TLSConfig.Certificates or TLSConfig.GetClientCertificate
+	// for mutual TLS. TLSConfig.ServerName defaults to the host from TCP Addr.
+	//
+	// TLS handshake is performed before Handshake
+	// and is limited by HandshakeTimeout.
+	TLSConfig *tls.Config
+
+	// Credentials are used for authenticating to the Server
+	// with Server.Authenticator if set.
+	//
+	// Credentials.Respond is called for each new connection,
+	// so refreshed credentials are used after reconnecting.
+	//
+	// Authentication is performed after TLS handshake and before
+	// Handshake. It is limited by HandshakeTimeout.
+	Credentials auth.Credentials
+
+	// Capture records all the requests and responses sent and received
+	// over connections if set.
+	//
+	// Frames are recorded with the connection IDs used in log messages.
+	// Capture isn't closed by the Client. See capture package
+	// for the file format.
+	Capture *capture.Writer
+
 	Handshake        func(conn net.Conn) (net.Conn, error)
 	HandshakeTimeout time.Duration
 
+	// Connections is the number of connections to the Server.
+	//
+	// Requests are spread among connections, so a stalled or broken
+	// connection delays only requests sent over it. Responses are matched
+	// to requests by nonce, so they may arrive in any order over
+	// any connection. Connections are established on demand.
+	//
+	// MaxPendingRequests limits pending requests over all the connections.
+	//
+	// A single connection is used by default.
+	Connections int
+
 	// MaxPendingRequests is the maximum number of pending requests
 	// the client may issue until the server responds to them.
 	//
 	// DefaultMaxPendingRequests is used by default.
 	MaxPendingRequests int
 
+	// ConcurrencyLimiter adaptively limits the number of pending requests
+	// below MaxPendingRequests if set.
+	//
+	// ErrPendingRequestsOverflow is returned when the number of pending
+	// requests exceeds ConcurrencyLimiter.Limit().
+	ConcurrencyLimiter ConcurrencyLimiter
+
 	// MaxBatchDelay is the maximum duration before pending requests
 	// are sent to the server.
 	//
@@ -61,11 +116,22 @@ type Client struct {
 
 	// Maximum duration for full response reading (including body).
 	//
-	// This also limits idle connection lifetime duration.
+	// This also limits idle connection lifetime duration
+	// if IdleTimeout isn't set.
 	//
 	// By default response read timeout is unlimited.
 	ReadTimeout time.Duration
 
+	// IdleTimeout is the maximum duration the connection may stay idle,
+	// i.e. without sent requests and pending responses.
+	//
+	// Idle connections are closed and new connections are established
+	// on demand. ReadTimeout limits only waiting for pending responses
+	// if IdleTimeout is set.
+	//
+	// By default ReadTimeout limits idle connections' lifetime.
+	IdleTimeout time.Duration
+
 	// Maximum duration for full request writing (including body).
 	//
 	// By default request write timeout is unlimited.
@@ -85,6 +151,53 @@ type Client struct {
 	// requests is reached.
 	PrioritizeNewRequests bool
 
+	// PingInterval is the interval for sending ping frames to the server
+	// while no requests are sent, so half-open connections are detected
+	// without waiting for request timeouts.
+	//
+	// The server must support ping frames. Ping round-trip time
+	// is available via Stats.
+	//
+	// Pings don't keep idle connections open, i.e. the connection
+	// may be closed by Server.IdleTimeout or Client.IdleTimeout
+	// while pings are sent.
+	//
+	// By default pings aren't sent.
+	PingInterval time.Duration
+
+	// MaxMissedPongs is the number of consecutive pings without pong
+	// after which the connection is closed.
+	//
+	// DefaultMaxMissedPongs is used by default.
+	MaxMissedPongs int
+
+	// CircuitBreaker stops sending requests to unhealthy server
+	// if set.
+	//
+	// Requests fail immediately with ErrCircuitOpen while the circuit
+	// is open.
+	CircuitBreaker *CircuitBreaker
+
+	// OnRequestDone is called after each DoDeadline call with the request,
+	// the returned error and the call duration.
+	//
+	// req may be used only until OnRequestDone returns.
+	OnRequestDone func(req RequestWriter, err error, duration time.Duration)
+
+	// OnFlush is called after each flush of the write buffer
+	// with the number of requests written since the previous flush.
+	OnFlush func(batchSize int)
+
+	// Logger, which is used by the Client.
+	//
+	// Messages with at least rpclog.LevelInfo level are written
+	// to os.Stderr by default.
+	//
+	// Dial errors, disconnects and GOAWAY frames are logged
+	// at rpclog.LevelDebug, since the Client transparently reconnects
+	// and returns these errors from the affected calls.
+	Logger Logger
+
 	OnMessageSent func(conn net.Conn)
 	OnMessageRecv func(conn net.Conn)
 
@@ -93,6 +206,12 @@ type Client struct {
 	lastErrMu sync.Mutex
 	lastErr   error
 
+	// connID is the ID of the last established connection.
+	connID uint64
+
+	// nonce is the last nonce assigned to a request.
+	nonce uint32
+
 	pendingRequests chan *clientWorkItem
 
 	pendingResponses   map[uint32]*clientWorkItem
@@ -100,21 +219,52 @@ type Client struct {
 
 	pendingRequestsCount uint32
 
+	counters clientCounters
+
+	rtt         int64
+	smoothedRTT int64
+
 	stop     chan struct{}
 	stopOnce sync.Once
 	wg       sync.WaitGroup
 
-	conn   net.Conn
+	// conns contains the current connection per connection worker.
+	conns  []net.Conn
 	connMu sync.Mutex
 }
 
+// clientConn contains the state of a single Client connection.
+type clientConn struct {
+	// idx is the index of the connection worker.
+	idx int
+
+	// recorder records frames to Client.Capture if it is set.
+	recorder *frameRecorder
+
+	missedPongs uint32
+
+	// The following fields are protected by Client.pendingResponsesMu.
+	pendingResponses int
+
+	// The following fields are protected by Client.pendingResponsesMu
+	// and are used only if Client.IdleTimeout is set.
+	lastActivity    time.Time
+	readDeadlineGen uint64
+	idle            bool
+}
+
 var (
 	// ErrTimeout is returned from timed out calls.
-	ErrTimeout = fasthttp.ErrTimeout
+	//
+	// It implements net.Error with Timeout() returning true.
+	ErrTimeout error = &timeoutError{}
 
 	// ErrPendingRequestsOverflow is returned when Client cannot send
 	// more requests to the server due to Client.MaxPendingRequests limit.
 	ErrPendingRequestsOverflow = errors.New("pending requests overflowed")
+
+	// ErrClientClosed is returned from calls on the closed Client.
+	ErrClientClosed = errors.New("client closed")
 )
 
 // SendNowait schedules the given request for sending to the server
@@ -132,6 +282,13 @@ var (
 func (c *Client) SendNowait(req RequestWriter, releaseReq func(req RequestWriter)) bool {
 	c.once.Do(c.init)
 
+	if c.isClosed() {
+		return false
+	}
+	if cb := c.CircuitBreaker; cb != nil && cb.State() == CircuitOpen {
+		return false
+	}
+
 	// Do not track 'nowait' request as a pending request, since it
 	// has no response.
 
@@ -143,6 +300,11 @@ func (c *Client) SendNowait(req RequestWriter, releaseReq func(req RequestWriter
 		releaseClientWorkItem(wi)
 		return false
 	}
+	if c.isClosed() {
+		// Close could drain pendingRequests before wi has been enqueued.
+		c.drainPendingRequests()
+		return false
+	}
 	return true
 }
 
@@ -150,15 +312,46 @@ func (c *Client) SendNowait(req RequestWriter, releaseReq func(req RequestWriter
 //
 // ErrTimeout is returned if the server didn't return response until
 // the given deadline.
+//
+// ErrCircuitOpen is returned if Client.CircuitBreaker is open.
 func (c *Client) DoDeadline(req RequestWriter, resp ResponseReader, deadline time.Time) error {
 	c.once.Do(c.init)
 
+	if c.isClosed() {
+		return ErrClientClosed
+	}
+
+	cb := c.CircuitBreaker
+	if cb == nil && c.OnRequestDone == nil {
+		return c.overflowError(c.doDeadline(req, resp, deadline))
+	}
+
+	startTime := time.Now()
+	var err error
+	if cb == nil {
+		err = c.doDeadline(req, resp, deadline)
+	} else if gen, ok := cb.allow(); !ok {
+		err = ErrCircuitOpen
+	} else {
+		err = c.doDeadline(req, resp, deadline)
+		cb.report(gen, err, time.Since(startTime))
+	}
+	err = c.overflowError(err)
+	if c.OnRequestDone != nil {
+		c.OnRequestDone(req, err, time.Since(startTime))
+	}
+	return err
+}
+
+// doDeadline returns ErrPendingRequestsOverflow as is, so CircuitBreaker
+// doesn't count overflows as server failures. Use overflowError
+// for obtaining the error returned to the caller.
+func (c *Client) doDeadline(req RequestWriter, resp ResponseReader, deadline time.Time) error {
 	n := c.incPendingRequests()
 	defer c.decPendingRequests()
 
-	if n >= c.maxPendingRequests() {
-		c.decPendingRequests()
-		return c.getError(ErrPendingRequestsOverflow)
+	if n > c.maxPendingRequests() || (c.ConcurrencyLimiter != nil && n > c.ConcurrencyLimiter.Limit()) {
+		return c.overflow()
 	}
 
 	wi := acquireClientWorkItem()
@@ -169,17 +362,45 @@ func (c *Client) DoDeadline(req RequestWriter, resp ResponseReader, deadline tim
 	wi.deadline = deadline
 
 	if err := c.enqueueWorkItem(wi); err != nil {
-		return c.getError(err)
+		return err
+	}
+	if c.isClosed() {
+		// Close could drain pendingRequests before wi has been enqueued,
+		// so wi would never be completed.
+		c.drainPendingRequests()
 	}
 
 	return <-wi.done
 }
 
+// Conn returns the current connection to the Server or nil
+// if no connections have been established yet.
+//
+// The connection of the first connection worker is returned
+// if Client.Connections is greater than one.
 func (c *Client) Conn() net.Conn {
 	c.connMu.Lock()
 	defer c.connMu.Unlock()
 
-	return c.conn
+	for _, conn := range c.conns {
+		if conn != nil {
+			return conn
+		}
+	}
+	return nil
+}
+
+func (c *Client) setConn(idx int, conn net.Conn) {
+	c.connMu.Lock()
+	c.conns[idx] = conn
+	c.connMu.Unlock()
+}
+
+func (c *Client) connections() int {
+	if c.Connections <= 0 {
+		return 1
+	}
+	return c.Connections
 }
 
 func (c *Client) enqueueWorkItem(wi *clientWorkItem) error {
@@ -188,25 +409,31 @@ func (c *Client) enqueueWorkItem(wi *clientWorkItem) error {
 		return nil
 	default:
 		if !c.PrioritizeNewRequests {
-			return ErrPendingRequestsOverflow
+			return c.overflow()
 		}
 
 		// slow path
 		select {
 		case old := <-c.pendingRequests:
-			c.doneError(old, ErrPendingRequestsOverflow)
+			c.doneError(old, c.overflow())
 			select {
 			case c.pendingRequests <- wi:
 				return nil
 			default:
-				return ErrPendingRequestsOverflow
+				return c.overflow()
 			}
 		default:
-			return ErrPendingRequestsOverflow
+			return c.overflow()
 		}
 	}
 }
 
+// overflow counts ErrPendingRequestsOverflow and returns it.
+func (c *Client) overflow() error {
+	atomic.AddUint64(&c.counters.overflows, 1)
+	return ErrPendingRequestsOverflow
+}
+
 func (c *Client) maxPendingRequests() int {
 	maxPendingRequests := c.MaxPendingRequests
 	if maxPendingRequests <= 0 {
@@ -215,20 +442,49 @@ func (c *Client) maxPendingRequests() int {
 	return maxPendingRequests
 }
 
+// Close closes the connection to the server and stops the Client.
+//
+// Pending requests are completed with ErrClientClosed.
 func (c *Client) Close() {
-	c.connMu.Lock()
-	conn := c.conn
-	c.connMu.Unlock()
+	c.once.Do(c.init)
 
 	c.stopOnce.Do(func() {
-		if conn != nil {
-			conn.Close()
+		c.connMu.Lock()
+		for _, conn := range c.conns {
+			if conn != nil {
+				conn.Close()
+			}
 		}
+		c.connMu.Unlock()
 
 		close(c.stop)
 	})
 
 	c.wg.Wait()
+
+	c.drainPendingRequests()
+}
+
+// drainPendingRequests completes all the queued requests
+// with ErrClientClosed.
+func (c *Client) drainPendingRequests() {
+	for {
+		select {
+		case wi := <-c.pendingRequests:
+			c.doneError(wi, ErrClientClosed)
+		default:
+			return
+		}
+	}
+}
+
+func (c *Client) isClosed() bool {
+	select {
+	case <-c.stop:
+		return true
+	default:
+		return false
+	}
 }
 
 func (c *Client) init() {
@@ -240,11 +496,16 @@ func (c *Client) init() {
 	c.pendingRequests = make(chan *clientWorkItem, n)
 	c.pendingResponses = make(map[uint32]*clientWorkItem, n)
 
+	connections := c.connections()
+	c.conns = make([]net.Conn, connections)
+
 	c.stop = make(chan struct{})
-	c.wg.Add(2)
+	c.wg.Add(1 + connections)
 
 	go c.unblockStaleItems()
-	go c.worker()
+	for i := 0; i < connections; i++ {
+		go c.worker(i)
+	}
 }
 
 func (c *Client) unblockStaleItems() {
@@ -304,6 +565,10 @@ func (c *Client) unblockStaleResponses() bool {
 	for nonce, wi := range c.pendingResponses {
 		if now.After(wi.deadline) {
 			delete(c.pendingResponses, nonce)
+			wi.cc.pendingResponses--
+			if l := c.ConcurrencyLimiter; l != nil {
+				l.OnSample(now.Sub(wi.sentTime), c.PendingRequests(), true)
+			}
 			c.doneError(wi, ErrTimeout)
 			unblocked = true
 		}
@@ -328,13 +593,19 @@ func (c *Client) decPendingRequests() {
 	atomic.AddUint32(&c.pendingRequestsCount, ^uint32(0))
 }
 
-func (c *Client) worker() {
+// worker maintains the connection with the given index.
+func (c *Client) worker(idx int) {
 	defer c.wg.Done()
 
 	dial := c.Dial
 	if dial == nil {
-		dial = fasthttp.Dial
+		dial = Dial
 	}
+	handshake := newHandshake(c.TLSConfig, false, c.Addr, newClientAuthHandshake(c.Credentials, c.Handshake))
+
+	// lost is set after losing the connection, so the next connection
+	// is counted as reconnect.
+	lost := false
 
 	for {
 		var wi *clientWorkItem
@@ -351,6 +622,8 @@ func (c *Client) worker() {
 
 		conn, err := dial(c.Addr)
 		if err != nil {
+			atomic.AddUint64(&c.counters.dialErrors, 1)
+			c.logger().Debug("fastrpc.Client: cannot connect", "addr", c.Addr, "error", err)
 			c.setLastError(fmt.Errorf("cannot connect to %q: %w", c.Addr, err))
 
 			select {
@@ -362,33 +635,62 @@ func (c *Client) worker() {
 			continue
 		}
 
-		c.connMu.Lock()
-		c.conn = conn
-		c.connMu.Unlock()
+		atomic.AddUint64(&c.counters.dials, 1)
+		if lost {
+			atomic.AddUint64(&c.counters.reconnects, 1)
+		}
+		c.setConn(idx, conn)
 
 		laddr := conn.LocalAddr().String()
 		raddr := conn.RemoteAddr().String()
+		connID := atomic.AddUint64(&c.connID, 1)
+		logger := newConnLogger(c.logger(), connID, laddr, raddr)
+		logger.Debug("fastrpc.Client: connected")
 
-		err = c.serveConn(conn)
-
-		if err == nil {
+		cc := &clientConn{
+			idx:      idx,
+			recorder: newFrameRecorder(c.Capture, connID, logger),
+		}
+		err = c.serveConn(cc, conn, handshake, logger)
+
+		idle := isIdleConnError(err)
+		lost = !idle
+		if idle {
+			// Do not report idle connections' closing as an error.
+			logger.Debug("fastrpc.Client: idle connection closed")
+		} else if err == nil {
+			logger.Debug("fastrpc.Client: connection closed by server")
 			c.setLastError(fmt.Errorf("%s<->%s: connection closed by server", laddr, raddr))
 		} else {
+			logger.Debug("fastrpc.Client: error on connection", "error", err)
 			c.setLastError(fmt.Errorf("%s<->%s: %w", laddr, raddr, err))
 		}
 
 		c.pendingResponsesMu.Lock()
 		for nonce, wi := range c.pendingResponses {
-			c.doneError(wi, nil)
+			if wi.cc != cc {
+				// The request is pending on another connection.
+				continue
+			}
 			delete(c.pendingResponses, nonce)
+			if idle {
+				// The server didn't read the request before closing
+				// the idle connection, so it is safe to re-send it.
+				if err := c.enqueueWorkItem(wi); err != nil {
+					c.doneError(wi, err)
+				}
+				continue
+			}
+			c.doneError(wi, nil)
 		}
 		c.pendingResponsesMu.Unlock()
 	}
 }
 
-func (c *Client) serveConn(conn net.Conn) error {
-	realConn, br, bw, err := newBufioConn(conn, c.ReadBufferSize, c.WriteBufferSize, c.Handshake, c.HandshakeTimeout)
+func (c *Client) serveConn(cc *clientConn, conn net.Conn, handshake func(conn net.Conn) (net.Conn, error), logger Logger) error {
+	realConn, br, bw, err := newBufioConn(conn, c.ReadBufferSize, c.WriteBufferSize, handshake, c.HandshakeTimeout)
 	if err != nil {
+		atomic.AddUint64(&c.counters.handshakeErrors, 1)
 		conn.Close()
 
 		select {
@@ -399,19 +701,36 @@ func (c *Client) serveConn(conn net.Conn) error {
 		return err
 	}
 
-	c.connMu.Lock()
-	c.conn = realConn
-	c.connMu.Unlock()
+	c.setConn(cc.idx, realConn)
+
+	var r io.Reader = realConn
+	if cc.recorder != nil {
+		r = cc.recorder.reader(realConn)
+	}
+	br.Reset(&countingReader{
+		r:     r,
+		total: &c.counters.bytesRead,
+	})
+	bw.Reset(&countingWriter{
+		w:     realConn,
+		total: &c.counters.bytesWritten,
+	})
+
+	if c.IdleTimeout > 0 {
+		c.pendingResponsesMu.Lock()
+		cc.lastActivity = time.Now()
+		c.pendingResponsesMu.Unlock()
+	}
 
 	readerDone := make(chan error, 1)
 	go func() {
-		readerDone <- c.connReader(br, realConn)
+		readerDone <- c.connReader(cc, br, realConn, logger)
 	}()
 
 	writerDone := make(chan error, 1)
 	stopWriterCh := make(chan struct{})
 	go func() {
-		writerDone <- c.connWriter(bw, realConn, stopWriterCh)
+		writerDone <- c.connWriter(cc, bw, realConn, stopWriterCh)
 	}()
 
 	select {
@@ -420,14 +739,27 @@ func (c *Client) serveConn(conn net.Conn) error {
 		realConn.Close()
 		<-writerDone
 	case err = <-writerDone:
-		realConn.Close()
-		<-readerDone
+		// The server may close the connection after sending GOAWAY frame,
+		// so give the reader a chance to read the frame.
+		var rerr error
+		t := time.NewTimer(goAwayReadTimeout)
+		select {
+		case rerr = <-readerDone:
+			realConn.Close()
+		case <-t.C:
+			realConn.Close()
+			rerr = <-readerDone
+		}
+		t.Stop()
+		if goAwayErr, ok := rerr.(*GoAwayError); ok {
+			err = goAwayErr
+		}
 	}
 
 	return err
 }
 
-func (c *Client) connWriter(bw *bufio.Writer, conn net.Conn, stopCh <-chan struct{}) error {
+func (c *Client) connWriter(cc *clientConn, bw *bufio.Writer, conn net.Conn, stopCh <-chan struct{}) error {
 	var (
 		wi  *clientWorkItem
 		buf [4]byte
@@ -446,9 +778,22 @@ func (c *Client) connWriter(bw *bufio.Writer, conn net.Conn, stopCh <-chan struc
 		maxBatchDelay = 0
 	}
 
+	var (
+		pingInterval = c.PingInterval
+		pingCh       <-chan time.Time
+		lastSendTime time.Time
+	)
+	if pingInterval > 0 {
+		pingTicker := time.NewTicker(pingInterval)
+		defer pingTicker.Stop()
+		pingCh = pingTicker.C
+		lastSendTime = time.Now()
+	}
+
 	writeTimeout := c.WriteTimeout
+	idleTimeout := c.IdleTimeout
 	var lastWriteDeadline time.Time
-	var nextNonce uint32
+	var batchSize int
 	for {
 		select {
 		case wi = <-c.pendingRequests:
@@ -458,15 +803,25 @@ func (c *Client) connWriter(bw *bufio.Writer, conn net.Conn, stopCh <-chan struc
 			case wi = <-c.pendingRequests:
 			case <-stopCh:
 				return nil
-			case <-flushCh:
-				if err := bw.Flush(); err != nil {
-					return fmt.Errorf("cannot flush requests data to the server: %w", err)
+			case <-pingCh:
+				if time.Since(lastSendTime) < pingInterval {
+					continue
 				}
-
-				if c.OnMessageSent != nil {
-					c.OnMessageSent(conn)
+				if err := c.sendPing(cc, bw); err != nil {
+					return err
 				}
-
+				// The ping is flushed together with buffered requests.
+				if err := c.flush(bw, conn, batchSize); err != nil {
+					return err
+				}
+				batchSize = 0
+				flushCh = nil
+				continue
+			case <-flushCh:
+				if err := c.flush(bw, conn, batchSize); err != nil {
+					return err
+				}
+				batchSize = 0
 				flushCh = nil
 				continue
 			}
@@ -478,13 +833,24 @@ func (c *Client) connWriter(bw *bufio.Writer, conn net.Conn, stopCh <-chan struc
 			continue
 		}
 
+		if idleTimeout > 0 {
+			c.pendingResponsesMu.Lock()
+			if cc.idle {
+				c.pendingResponsesMu.Unlock()
+				// The connection reader is closing the idle connection,
+				// so send the request over a new connection.
+				if err := c.enqueueWorkItem(wi); err != nil {
+					c.doneError(wi, err)
+				}
+				return nil
+			}
+			cc.lastActivity = time.Now()
+			c.pendingResponsesMu.Unlock()
+		}
+
 		nonce := uint32(0)
 		if wi.resp != nil {
-			nextNonce++
-			if nextNonce == 0 {
-				nextNonce = 1
-			}
-			nonce = nextNonce
+			nonce = c.nextNonce()
 		}
 
 		if writeTimeout > 0 {
@@ -498,6 +864,10 @@ func (c *Client) connWriter(bw *bufio.Writer, conn net.Conn, stopCh <-chan struc
 			}
 		}
 
+		if pingInterval > 0 {
+			lastSendTime = time.Now()
+		}
+
 		b := appendUint32(buf[:0], nonce)
 		if _, err := bw.Write(b); err != nil {
 			err = fmt.Errorf("cannot send request ID to the server: %w", err)
@@ -505,11 +875,19 @@ func (c *Client) connWriter(bw *bufio.Writer, conn net.Conn, stopCh <-chan struc
 			return err
 		}
 
-		if err := wi.req.WriteRequest(bw); err != nil {
+		var err error
+		if fr := cc.recorder; fr != nil {
+			err = fr.writeFrame(bw, capture.TypeRequest, nonce, wi.req.WriteRequest)
+		} else {
+			err = wi.req.WriteRequest(bw)
+		}
+		if err != nil {
 			err = fmt.Errorf("cannot send request to the server: %w", err)
 			c.doneError(wi, err)
 			return err
 		}
+		atomic.AddUint64(&c.counters.requestsSent, 1)
+		batchSize++
 
 		if wi.resp == nil {
 			releaseClientWorkItem(wi)
@@ -521,7 +899,20 @@ func (c *Client) connWriter(bw *bufio.Writer, conn net.Conn, stopCh <-chan struc
 				c.doneError(wi, err)
 				return err
 			}
+			if c.ConcurrencyLimiter != nil {
+				wi.sentTime = time.Now()
+			}
+			if idleTimeout > 0 && cc.pendingResponses == 0 {
+				// Switch the reader from idle timeout to read timeout.
+				if err := c.setResponseReadDeadline(cc, conn); err != nil {
+					c.pendingResponsesMu.Unlock()
+					c.doneError(wi, err)
+					return err
+				}
+			}
+			wi.cc = cc
 			c.pendingResponses[nonce] = wi
+			cc.pendingResponses++
 			c.pendingResponsesMu.Unlock()
 		}
 
@@ -537,7 +928,7 @@ func (c *Client) connWriter(bw *bufio.Writer, conn net.Conn, stopCh <-chan struc
 	}
 }
 
-func (c *Client) connReader(br *bufio.Reader, conn net.Conn) error {
+func (c *Client) connReader(cc *clientConn, br *bufio.Reader, conn net.Conn, logger Logger) error {
 	var (
 		buf  [4]byte
 		resp ResponseReader
@@ -546,9 +937,33 @@ func (c *Client) connReader(br *bufio.Reader, conn net.Conn) error {
 	zeroResp := c.NewResponse()
 
 	readTimeout := c.ReadTimeout
+	idleTimeout := c.IdleTimeout
 	var lastReadDeadline time.Time
+	var readDeadlineGen uint64
 	for {
-		if readTimeout > 0 {
+		if idleTimeout > 0 {
+			var err error
+			c.pendingResponsesMu.Lock()
+			if cc.pendingResponses == 0 {
+				err = conn.SetReadDeadline(cc.lastActivity.Add(idleTimeout))
+				cc.readDeadlineGen++
+				lastReadDeadline = zeroTime
+			} else if readTimeout > 0 {
+				// Do not use coarseTimeNow, since it may lag behind
+				// the read deadline set by the writer on switching
+				// from idle timeout to read timeout.
+				t := time.Now()
+				if t.Sub(lastReadDeadline) > (readTimeout >> 2) {
+					err = conn.SetReadDeadline(t.Add(readTimeout))
+					lastReadDeadline = t
+				}
+			}
+			readDeadlineGen = cc.readDeadlineGen
+			c.pendingResponsesMu.Unlock()
+			if err != nil {
+				return fmt.Errorf("cannot update read deadline: %w", err)
+			}
+		} else if readTimeout > 0 {
 			t := coarseTimeNow()
 
 			if t.Sub(lastReadDeadline) > (readTimeout >> 2) {
@@ -559,28 +974,62 @@ func (c *Client) connReader(br *bufio.Reader, conn net.Conn) error {
 			}
 		}
 
-		if _, err := io.ReadFull(br, buf[:]); err != nil {
+		if n, err := io.ReadFull(br, buf[:]); err != nil {
 			if err == io.EOF {
 				return nil
 			}
+			if n == 0 && idleTimeout > 0 && isTimeoutError(err) {
+				idle, retry := c.checkIdle(cc, readDeadlineGen)
+				if idle {
+					return errIdleConn
+				}
+				if retry {
+					continue
+				}
+			}
 			return fmt.Errorf("cannot read response ID: %w", err)
 		}
 
 		nonce := bytes2Uint32(buf)
+		if nonce == controlNonce {
+			typ, payload, err := readControlFrame(br)
+			if err != nil {
+				return err
+			}
+			switch typ {
+			case controlGoAway:
+				return parseGoAway(payload)
+			case controlPong:
+				c.onPong(cc, payload)
+			}
+			// Ignore unknown control frames for forward compatibility.
+			continue
+		}
 
 		c.pendingResponsesMu.Lock()
 		wi := c.pendingResponses[nonce]
-		delete(c.pendingResponses, nonce)
+		if wi != nil {
+			delete(c.pendingResponses, nonce)
+			wi.cc.pendingResponses--
+		}
 		c.pendingResponsesMu.Unlock()
 
 		resp = nil
 		if wi != nil {
 			resp = wi.resp
+		} else {
+			// The request has been timed out or sent without waiting
+			// for the response.
+			logger.Debug("fastrpc.Client: skipping response for unknown request", "nonce", nonce)
 		}
 		if resp == nil {
 			resp = zeroResp
 		}
 
+		fr := cc.recorder
+		if fr != nil {
+			fr.beginRead(br)
+		}
 		if err := resp.ReadResponse(br); err != nil {
 			err = fmt.Errorf("cannot read response with ID %d: %w", nonce, err)
 			if wi != nil {
@@ -588,11 +1037,19 @@ func (c *Client) connReader(br *bufio.Reader, conn net.Conn) error {
 			}
 			return err
 		}
+		if fr != nil {
+			fr.endRead(br, capture.TypeResponse, nonce)
+		}
+
+		atomic.AddUint64(&c.counters.responsesReceived, 1)
 
 		if wi != nil {
 			if wi.resp == nil {
 				panic("BUG: clientWorkItem.resp must be non-nil")
 			}
+			if l := c.ConcurrencyLimiter; l != nil {
+				l.OnSample(time.Since(wi.sentTime), c.PendingRequests(), false)
+			}
 			wi.done <- nil
 		}
 
@@ -602,15 +1059,177 @@ func (c *Client) connReader(br *bufio.Reader, conn net.Conn) error {
 	}
 }
 
+// sendPing writes ping frame to bw.
+//
+// Returns an error if the server didn't answer the last MaxMissedPongs pings.
+func (c *Client) sendPing(cc *clientConn, bw *bufio.Writer) error {
+	maxMissedPongs := c.MaxMissedPongs
+	if maxMissedPongs <= 0 {
+		maxMissedPongs = DefaultMaxMissedPongs
+	}
+	if n := int(atomic.AddUint32(&cc.missedPongs, 1)); n > maxMissedPongs {
+		return fmt.Errorf("the server didn't answer %d pings", n-1)
+	}
+
+	var buf [8]byte
+	payload := appendUint64(buf[:0], uint64(time.Since(pingEpoch)))
+	if err := writeControlFrame(bw, controlPing, payload); err != nil {
+		return fmt.Errorf("cannot send ping to the server: %w", err)
+	}
+	return nil
+}
+
+// flush flushes the batch of batchSize requests to the server.
+func (c *Client) flush(bw *bufio.Writer, conn net.Conn, batchSize int) error {
+	if err := bw.Flush(); err != nil {
+		return fmt.Errorf("cannot flush requests data to the server: %w", err)
+	}
+	atomic.AddUint64(&c.counters.flushes, 1)
+	if c.OnFlush != nil {
+		c.OnFlush(batchSize)
+	}
+	if c.OnMessageSent != nil {
+		c.OnMessageSent(conn)
+	}
+	return nil
+}
+
+func (c *Client) onPong(cc *clientConn, payload []byte) {
+	if len(payload) != 8 {
+		return
+	}
+	atomic.StoreUint32(&cc.missedPongs, 0)
+
+	var buf [8]byte
+	copy(buf[:], payload)
+	rtt := time.Since(pingEpoch) - time.Duration(bytes2Uint64(buf))
+	if rtt < 0 {
+		return
+	}
+	atomic.StoreInt64(&c.rtt, int64(rtt))
+
+	// Connection readers may update smoothedRTT concurrently.
+	for {
+		old := atomic.LoadInt64(&c.smoothedRTT)
+		smoothedRTT := old
+		if smoothedRTT == 0 {
+			smoothedRTT = int64(rtt)
+		} else {
+			smoothedRTT += (int64(rtt) - smoothedRTT) / 8
+		}
+		if atomic.CompareAndSwapInt64(&c.smoothedRTT, old, smoothedRTT) {
+			return
+		}
+	}
+}
+
+// setResponseReadDeadline sets read deadline for reading responses.
+//
+// pendingResponsesMu must be locked.
+func (c *Client) setResponseReadDeadline(cc *clientConn, conn net.Conn) error {
+	deadline := zeroTime
+	if c.ReadTimeout > 0 {
+		deadline = time.Now().Add(c.ReadTimeout)
+	}
+	if err := conn.SetReadDeadline(deadline); err != nil {
+		return fmt.Errorf("cannot update read deadline: %w", err)
+	}
+	cc.readDeadlineGen++
+	return nil
+}
+
+// checkIdle is called by the connection reader on read timeout
+// if IdleTimeout is set.
+//
+// It returns idle=true if the connection must be closed due to idle timeout
+// and retry=true if the timeout is caused by outdated read deadline.
+func (c *Client) checkIdle(cc *clientConn, readDeadlineGen uint64) (idle, retry bool) {
+	c.pendingResponsesMu.Lock()
+	defer c.pendingResponsesMu.Unlock()
+
+	if readDeadlineGen != cc.readDeadlineGen {
+		// The writer updated the read deadline.
+		return false, true
+	}
+	if cc.pendingResponses > 0 {
+		return false, false
+	}
+	if time.Since(cc.lastActivity) < c.IdleTimeout {
+		// Requests without responses have been sent.
+		return false, true
+	}
+	cc.idle = true
+	return true, false
+}
+
+// nextNonce returns the nonce for the next request with response.
+//
+// Nonces are unique among all the connections, so responses are matched
+// to requests regardless of the connection they are received from.
+func (c *Client) nextNonce() uint32 {
+	for {
+		nonce := atomic.AddUint32(&c.nonce, 1)
+		if nonce != 0 && nonce != controlNonce {
+			return nonce
+		}
+	}
+}
+
+type timeoutError struct{}
+
+func (e *timeoutError) Error() string {
+	return "timeout"
+}
+
+// Timeout implements net.Error.
+func (e *timeoutError) Timeout() bool {
+	return true
+}
+
+// Temporary implements net.Error.
+func (e *timeoutError) Temporary() bool {
+	return true
+}
+
+func (c *Client) logger() Logger {
+	if c.Logger != nil {
+		return c.Logger
+	}
+	return defaultLogger
+}
+
 func (c *Client) doneError(wi *clientWorkItem, err error) {
+	if err == ErrTimeout {
+		atomic.AddUint64(&c.counters.timeouts, 1)
+	}
 	if wi.resp != nil {
-		wi.done <- c.getError(err)
+		if err != ErrPendingRequestsOverflow {
+			// ErrPendingRequestsOverflow is substituted by overflowError
+			// after being classified by CircuitBreaker.
+			err = c.getError(err)
+		}
+		wi.done <- err
 	} else {
 		releaseClientWorkItem(wi)
 	}
 }
 
+// overflowError returns the error for the caller if err is
+// ErrPendingRequestsOverflow returned by doDeadline.
+func (c *Client) overflowError(err error) error {
+	if err == ErrPendingRequestsOverflow {
+		return c.getError(err)
+	}
+	return err
+}
+
 func (c *Client) getError(err error) error {
+	if err == ErrClientClosed {
+		// Callers must reliably detect the closed Client,
+		// so the last connection error mustn't hide it.
+		return err
+	}
+
 	c.lastErrMu.Lock()
 	lastErr := c.lastErr
 	c.lastErrMu.Unlock()
@@ -631,7 +1250,13 @@ type clientWorkItem struct {
 	resp       ResponseReader
 	releaseReq func(req RequestWriter)
 	deadline   time.Time
+	sentTime   time.Time
 	done       chan error
+
+	// cc is the connection the request has been sent over.
+	//
+	// It is protected by Client.pendingResponsesMu.
+	cc *clientConn
 }
 
 func acquireClientWorkItem() *clientWorkItem {
@@ -661,6 +1286,7 @@ func releaseClientWorkItem(wi *clientWorkItem) {
 	wi.req = nil
 	wi.resp = nil
 	wi.releaseReq = nil
+	wi.cc = nil
 	clientWorkItemPool.Put(wi)
 }
 
@@ -673,3 +1299,11 @@ func appendUint32(b []byte, n uint32) []byte {
 func bytes2Uint32(b [4]byte) uint32 {
 	return (uint32(b[3]) << 24) | (uint32(b[2]) << 16) | (uint32(b[1]) << 8) | uint32(b[0])
 }
+
+func appendUint64(b []byte, n uint64) []byte {
+	return appendUint32(appendUint32(b, uint32(n)), uint32(n>>32))
+}
+
+func bytes2Uint64(b [8]byte) uint64 {
+	return uint64(bytes2Uint32([4]byte{b[0], b[1], b[2], b[3]})) | (uint64(bytes2Uint32([4]byte{b[4], b[5], b[6], b[7]})) << 32)
+}
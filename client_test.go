@@ -9,6 +9,8 @@ import (
 	"testing"
 	"time"
 
+	"github.com/UladzimirTrehubenka/fastrpc/faultconn"
+	"github.com/UladzimirTrehubenka/fastrpc/rpclog"
 	"github.com/UladzimirTrehubenka/fastrpc/tlv"
 	"github.com/valyala/fasthttp/fasthttputil"
 )
@@ -94,6 +96,73 @@ func TestClientTimeout(t *testing.T) {
 	close(dialCh)
 }
 
+func TestClientCloseAfterDialError(t *testing.T) {
+	c := &Client{
+		NewResponse: newTestResponse,
+		Logger:      rpclog.Discard,
+		Dial: func(addr string) (net.Conn, error) {
+			return nil, fmt.Errorf("no dial")
+		},
+	}
+
+	const iterations = 10
+	resultCh := make(chan error, iterations)
+	for i := 0; i < iterations; i++ {
+		go func() {
+			var req tlv.Request
+			var resp tlv.Response
+			resultCh <- c.DoDeadline(&req, &resp, time.Now().Add(10*time.Second))
+		}()
+	}
+	for i := 0; i < 100 && (c.Stats().DialErrors == 0 || c.PendingRequests() < iterations); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Pending requests must be completed with ErrClientClosed
+	// instead of the last dial error.
+	c.Close()
+	for i := 0; i < iterations; i++ {
+		select {
+		case err := <-resultCh:
+			if err != ErrClientClosed {
+				t.Fatalf("unexpected error on iteration %d: %v. Expecting %s", i, err, ErrClientClosed)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timeout")
+		}
+	}
+}
+
+func TestClientConcurrentClose(t *testing.T) {
+	serverStop, c := newTestServerClient(testEchoHandler)
+
+	// Requests racing with Close mustn't hang.
+	const iterations = 100
+	resultCh := make(chan error, iterations)
+	for i := 0; i < iterations; i++ {
+		go func() {
+			var req tlv.Request
+			var resp tlv.Response
+			resultCh <- c.DoDeadline(&req, &resp, time.Now().Add(10*time.Second))
+		}()
+	}
+	c.Close()
+	for i := 0; i < iterations; i++ {
+		select {
+		case err := <-resultCh:
+			if err != nil && err != ErrClientClosed {
+				t.Fatalf("unexpected error on iteration %d: %s", i, err)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timeout")
+		}
+	}
+
+	if err := serverStop(); err != nil {
+		t.Fatalf("cannot shutdown server: %s", err)
+	}
+}
+
 func TestClientBrokenServerCloseConn(t *testing.T) {
 	testClientBrokenServer(t, func(conn net.Conn) error {
 		err := conn.Close()
@@ -183,6 +252,51 @@ func testClientBrokenServer(t *testing.T, serverConnFunc func(net.Conn) error) {
 	}
 }
 
+func TestClientFaultyServer(t *testing.T) {
+	for _, f := range []*faultconn.Faults{
+		{DropRate: 1},
+		{ResetRate: 1},
+		{GarbageRate: 1},
+		{PartialWriteRate: 1},
+		{StallRate: 1},
+	} {
+		testClientFaultyServer(t, f)
+	}
+}
+
+func testClientFaultyServer(t *testing.T, f *faultconn.Faults) {
+	s := &Server{
+		NewHandlerCtx: newTestHandlerCtx,
+		Handler:       testEchoHandler,
+		Logger:        rpclog.Discard,
+	}
+	ln := fasthttputil.NewInmemoryListener()
+	serverStopCh := make(chan error, 1)
+	go func() {
+		serverStopCh <- s.Serve(f.Listener(ln))
+	}()
+
+	c := newTestClient(ln)
+	c.Logger = rpclog.Discard
+	var req tlv.Request
+	var resp tlv.Response
+	req.SwapValue([]byte("foobar"))
+	if err := c.DoDeadline(&req, &resp, time.Now().Add(50*time.Millisecond)); err == nil {
+		t.Fatalf("expecting error for faults %+v", f)
+	}
+	c.Close()
+
+	ln.Close()
+	select {
+	case err := <-serverStopCh:
+		if err != nil {
+			t.Fatalf("error on the server: %s", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("timeout")
+	}
+}
+
 func newTestResponse() ResponseReader {
 	return &tlv.Response{}
 }
@@ -0,0 +1,84 @@
+// fastrpc-replay re-issues tlv requests from capture file against
+// the server and reports responses different from the captured ones.
+//
+// Usage:
+//
+//	fastrpc-replay -capture=traffic.cap -addr=localhost:8080 -rate=2
+//
+// The exit code is 1 if any response mismatches or any request fails.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+
+	"github.com/UladzimirTrehubenka/fastrpc"
+	"github.com/UladzimirTrehubenka/fastrpc/capture"
+	"github.com/UladzimirTrehubenka/fastrpc/replay"
+)
+
+var (
+	captureFile = flag.String("capture", "", "Path to the capture file")
+	addr        = flag.String("addr", "", "Server address to replay requests against")
+	rate        = flag.Float64("rate", 1, "Replay speed relative to the captured traffic. Requests are replayed without delays if zero")
+	timeout     = flag.Duration("timeout", replay.DefaultTimeout, "Timeout for each request")
+	concurrency = flag.Int("concurrency", replay.DefaultConcurrency, "Maximum number of concurrently replayed requests")
+	connections = flag.Int("connections", 1, "Number of connections to the server")
+	maxPending  = flag.Int("maxPendingRequests", fastrpc.DefaultMaxPendingRequests, "Maximum number of pending requests")
+)
+
+func main() {
+	flag.Parse()
+	if *captureFile == "" || *addr == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	f, err := os.Open(*captureFile)
+	if err != nil {
+		log.Fatalf("cannot open capture: %s", err)
+	}
+	defer f.Close()
+	cr, err := capture.NewReader(f)
+	if err != nil {
+		log.Fatalf("cannot read capture %q: %s", *captureFile, err)
+	}
+
+	c := &fastrpc.Client{
+		Addr:               *addr,
+		Connections:        *connections,
+		MaxPendingRequests: *maxPending,
+	}
+	defer c.Close()
+
+	var stdoutMu sync.Mutex
+	r := &replay.Replayer{
+		Client:      c,
+		Rate:        *rate,
+		Timeout:     *timeout,
+		Concurrency: *concurrency,
+		OnMismatch: func(m *replay.Mismatch) {
+			stdoutMu.Lock()
+			defer stdoutMu.Unlock()
+			if m.Err != nil {
+				fmt.Printf("ERROR conn_id=%d nonce=%d opcode=%d request=%q error=%q\n", m.ConnID, m.Nonce, m.Opcode, m.Request, m.Err)
+				return
+			}
+			fmt.Printf("MISMATCH conn_id=%d nonce=%d opcode=%d request=%q expected=%q actual=%q\n", m.ConnID, m.Nonce, m.Opcode, m.Request, m.Expected, m.Actual)
+		},
+	}
+	res, err := r.Replay(cr)
+	if err != nil {
+		log.Fatalf("cannot replay capture %q: %s", *captureFile, err)
+	}
+
+	fmt.Printf("requests=%d matched=%d mismatched=%d errors=%d unchecked=%d duration=%s\n",
+		res.Requests, res.Matched, res.Mismatched, res.Errors, res.Unchecked, res.Duration)
+	if res.Mismatched > 0 || res.Errors > 0 {
+		c.Close()
+		os.Exit(1)
+	}
+}
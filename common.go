@@ -2,6 +2,7 @@ package fastrpc
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"net"
 	"sync"
@@ -27,6 +28,10 @@ const (
 
 	// DefaultWriteBufferSize is the default size for write buffers.
 	DefaultWriteBufferSize = 64 * 1024
+
+	// DefaultMaxMissedPongs is the default number of consecutive pings
+	// without pong after which Client closes the connection.
+	DefaultMaxMissedPongs = 3
 )
 
 var zeroTime time.Time
@@ -51,7 +56,7 @@ func newBufioConn(conn net.Conn, readBufferSize, writeBufferSize int, handshake
 		conn, err = handshake(conn)
 
 		if err != nil {
-			return nil, nil, nil, fmt.Errorf("error in handshake: %s", err)
+			return nil, nil, nil, fmt.Errorf("error in handshake: %w", err)
 		}
 		if err = conn.SetWriteDeadline(zeroTime); err != nil {
 			return nil, nil, nil, fmt.Errorf("cannot reset write timeout: %s", err)
@@ -106,3 +111,10 @@ func stopFlushTimer(t *time.Timer) {
 }
 
 var flushTimerPool sync.Pool
+
+func isTimeoutError(err error) bool {
+	var te interface {
+		Timeout() bool
+	}
+	return errors.As(err, &te) && te.Timeout()
+}
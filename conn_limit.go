@@ -0,0 +1,133 @@
+package fastrpc
+
+import (
+	"bufio"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/UladzimirTrehubenka/fastrpc/internal/netutil"
+)
+
+// RejectedConns returns the number of connections rejected due to
+// Server.MaxConns, Server.MaxConnsPerIP or Server.Concurrency limits.
+func (s *Server) RejectedConns() uint64 {
+	return atomic.LoadUint64(&s.counters.rejectedConns)
+}
+
+// acquireConn registers the accepted connection in connection limits.
+//
+// Returns non-empty reason if the connection must be rejected.
+// Otherwise releaseConn must be called with the returned ip when
+// the connection is closed.
+func (s *Server) acquireConn(conn net.Conn) (string, string) {
+	if s.MaxConns > 0 {
+		if n := int(atomic.AddInt32(&s.connsCount, 1)); n > s.MaxConns {
+			atomic.AddInt32(&s.connsCount, -1)
+			return "", "too many connections"
+		}
+	}
+
+	var ip string
+	if s.MaxConnsPerIP > 0 {
+		ip = remoteIP(conn)
+		if ip != "" {
+			s.connsPerIPMu.Lock()
+			if s.connsPerIP == nil {
+				s.connsPerIP = make(map[string]int)
+			}
+			n := s.connsPerIP[ip]
+			if n >= s.MaxConnsPerIP {
+				s.connsPerIPMu.Unlock()
+				s.releaseConnsCount()
+				return "", "too many connections from " + ip
+			}
+			s.connsPerIP[ip] = n + 1
+			s.connsPerIPMu.Unlock()
+		}
+	}
+
+	if s.PipelineRequests {
+		// Each connection is served by a single Server.Handler goroutine
+		// in pipeline mode.
+		if n := int(atomic.AddUint32(&s.concurrencyCount, 1)); n > s.concurrency() {
+			atomic.AddUint32(&s.concurrencyCount, ^uint32(0))
+			s.releaseConnPerIP(ip)
+			s.releaseConnsCount()
+			return "", "concurrency limit exceeded"
+		}
+	}
+
+	return ip, ""
+}
+
+// releaseConn releases the connection registered with acquireConn.
+func (s *Server) releaseConn(ip string) {
+	if s.PipelineRequests {
+		atomic.AddUint32(&s.concurrencyCount, ^uint32(0))
+	}
+	s.releaseConnPerIP(ip)
+	s.releaseConnsCount()
+}
+
+func (s *Server) releaseConnsCount() {
+	if s.MaxConns > 0 {
+		atomic.AddInt32(&s.connsCount, -1)
+	}
+}
+
+func (s *Server) releaseConnPerIP(ip string) {
+	if ip == "" {
+		return
+	}
+	s.connsPerIPMu.Lock()
+	n := s.connsPerIP[ip] - 1
+	if n <= 0 {
+		delete(s.connsPerIP, ip)
+	} else {
+		s.connsPerIP[ip] = n
+	}
+	s.connsPerIPMu.Unlock()
+}
+
+// rejectConn closes the connection exceeding connection limits.
+//
+// GOAWAY frame with the given reason is written to the connection
+// before closing it if Server.NotifyRejectedConns is set.
+func (s *Server) rejectConn(conn net.Conn, reason string) {
+	atomic.AddUint64(&s.counters.rejectedConns, 1)
+	s.logger().Warn("fastrpc.Server: rejecting connection", "remote_addr", conn.RemoteAddr().String(), "reason", reason)
+
+	if !s.NotifyRejectedConns || s.Handshake != nil || s.TLSConfig != nil || s.Authenticator != nil {
+		conn.Close()
+		return
+	}
+
+	// Do not block Serve on slow clients.
+	go func() {
+		defer conn.Close()
+		if err := conn.SetWriteDeadline(time.Now().Add(goAwayWriteTimeout)); err != nil {
+			return
+		}
+		bw := bufio.NewWriterSize(conn, 64+len(reason))
+		if err := writeGoAway(bw, GoAwayRejected, reason); err != nil {
+			return
+		}
+		bw.Flush()
+	}()
+}
+
+// remoteIP returns the IP of the remote side of the connection.
+//
+// Unix socket connections share 127.0.0.1 with local TCP connections,
+// like tlv.RequestCtx.RemoteIP. Other connections are unwrapped via NetConn
+// method, so shared memory connections are limited as unix socket
+// connections. Returns empty string for connections without TCP or unix
+// socket underneath, so they aren't limited per IP.
+func remoteIP(conn net.Conn) string {
+	ip := netutil.ConnRemoteIP(conn)
+	if ip == nil {
+		return ""
+	}
+	return ip.String()
+}
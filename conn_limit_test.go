@@ -0,0 +1,230 @@
+package fastrpc
+
+import (
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/UladzimirTrehubenka/fastrpc/tlv"
+	"github.com/valyala/fasthttp/fasthttputil"
+)
+
+func TestServerMaxConns(t *testing.T) {
+	testServerConnLimit(t, &Server{
+		MaxConns: 1,
+	}, "10.0.0.1", "10.0.0.2")
+}
+
+func TestServerMaxConnsPerIP(t *testing.T) {
+	testServerConnLimit(t, &Server{
+		MaxConnsPerIP: 1,
+	}, "10.0.0.1", "10.0.0.1")
+}
+
+func TestServerMaxConnsPerIPUnixSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fastrpc-unix")
+	if err != nil {
+		t.Fatalf("cannot create temporary dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	addr := "unix://" + filepath.Join(dir, "sock")
+
+	ln, err := Listen(addr)
+	if err != nil {
+		t.Fatalf("cannot listen on %q: %s", addr, err)
+	}
+	s := &Server{
+		NewHandlerCtx: newTestHandlerCtx,
+		Handler:       testEchoHandler,
+		MaxConnsPerIP: 1,
+	}
+	serverStop := newTestLoopbackServer(s, ln)
+
+	// Unix socket clients must share a single per-IP limit.
+	c1 := &Client{
+		NewResponse: newTestResponse,
+		Addr:        addr,
+	}
+	if err := testDoEcho(c1, "foo"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	c2 := &Client{
+		NewResponse: newTestResponse,
+		Addr:        addr,
+	}
+	if err := testDoEcho(c2, "bar"); err == nil {
+		t.Fatalf("expecting error for the connection exceeding MaxConnsPerIP")
+	}
+	if n := s.RejectedConns(); n != 1 {
+		t.Fatalf("unexpected number of rejected connections: %d; expecting 1", n)
+	}
+	c1.Close()
+	c2.Close()
+
+	if err := serverStop(); err != nil {
+		t.Fatalf("cannot shutdown server: %s", err)
+	}
+}
+
+func TestServerPipelineConnLimit(t *testing.T) {
+	testServerConnLimit(t, &Server{
+		Concurrency:      1,
+		PipelineRequests: true,
+	}, "10.0.0.1", "10.0.0.2")
+}
+
+func testServerConnLimit(t *testing.T, s *Server, ip1, ip2 string) {
+	s.NewHandlerCtx = newTestHandlerCtx
+	s.Handler = testEchoHandler
+	s.NotifyRejectedConns = true
+	ln := fasthttputil.NewInmemoryListener()
+	ips := make(chan string, 1)
+	serverResultCh := make(chan error, 1)
+	go func() {
+		serverResultCh <- s.Serve(&testAddrListener{
+			Listener: ln,
+			ips:      ips,
+		})
+	}()
+
+	// Remote IPs are assigned to server-side connections in dial order.
+	newClient := func(ip string) *Client {
+		c := newTestClient(ln)
+		c.Dial = func(addr string) (net.Conn, error) {
+			ips <- ip
+			return ln.Dial()
+		}
+		return c
+	}
+
+	// Establish the first connection.
+	c1 := newClient(ip1)
+	if err := testDoEcho(c1, "foo"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	c2 := newClient(ip2)
+	err := testDoEcho(c2, "bar")
+	var goAwayErr *GoAwayError
+	if !errors.As(err, &goAwayErr) {
+		t.Fatalf("expecting GoAwayError; got %v", err)
+	}
+	if goAwayErr.Code != GoAwayRejected {
+		t.Fatalf("unexpected GOAWAY code: %s; expecting %s", goAwayErr.Code, GoAwayRejected)
+	}
+	if n := s.RejectedConns(); n != 1 {
+		t.Fatalf("unexpected number of rejected connections: %d; expecting 1", n)
+	}
+
+	// The first connection must remain usable.
+	if err := testDoEcho(c1, "baz"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// The connection slot must be released after the connection is closed.
+	c1.Close()
+	c3 := newClient(ip2)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		err := testDoEcho(c3, "aaa")
+		if err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("cannot establish connection after closing the first one: %s", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	c2.Close()
+	c3.Close()
+
+	ln.Close()
+	select {
+	case err := <-serverResultCh:
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("timeout")
+	}
+}
+
+func TestServerRejectedConnClosed(t *testing.T) {
+	s := &Server{
+		NewHandlerCtx: newTestHandlerCtx,
+		Handler:       testEchoHandler,
+		MaxConns:      1,
+	}
+	serverStop, ln := newTestServerExt(s)
+
+	c := newTestClient(ln)
+	if err := testDoEcho(c, "foo"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// The rejected connection must be closed by the server.
+	conn, err := ln.Dial()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var buf [1]byte
+	if _, err := conn.Read(buf[:]); err == nil {
+		t.Fatalf("expecting closed connection")
+	} else if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		t.Fatalf("the rejected connection isn't closed")
+	}
+	conn.Close()
+	c.Close()
+
+	if err := serverStop(); err != nil {
+		t.Fatalf("cannot shutdown server: %s", err)
+	}
+}
+
+func testDoEcho(c *Client, v string) error {
+	var req tlv.Request
+	var resp tlv.Response
+	req.SwapValue([]byte(v))
+	if err := c.DoDeadline(&req, &resp, time.Now().Add(5*time.Second)); err != nil {
+		return err
+	}
+	if string(resp.Value()) != v {
+		return errors.New("unexpected response " + string(resp.Value()))
+	}
+	return nil
+}
+
+// testAddrListener assigns remote IPs from ips to accepted connections.
+type testAddrListener struct {
+	net.Listener
+	ips chan string
+}
+
+func (ln *testAddrListener) Accept() (net.Conn, error) {
+	conn, err := ln.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	ip := <-ln.ips
+	return &testAddrConn{
+		Conn: conn,
+		raddr: &net.TCPAddr{
+			IP:   net.ParseIP(ip),
+			Port: 1234,
+		},
+	}, nil
+}
+
+type testAddrConn struct {
+	net.Conn
+	raddr net.Addr
+}
+
+func (c *testAddrConn) RemoteAddr() net.Addr {
+	return c.raddr
+}
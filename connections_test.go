@@ -0,0 +1,192 @@
+package fastrpc
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/UladzimirTrehubenka/fastrpc/faultconn"
+	"github.com/UladzimirTrehubenka/fastrpc/tlv"
+)
+
+func TestClientConnections(t *testing.T) {
+	s := &Server{
+		NewHandlerCtx: newTestHandlerCtx,
+		Handler: func(ctxv HandlerCtx) HandlerCtx {
+			time.Sleep(time.Duration(rand.Intn(10)) * time.Millisecond)
+			return testEchoHandler(ctxv)
+		},
+		Concurrency: 1000,
+	}
+	ln := newTestLoopbackListener(t)
+	serverStop := newTestLoopbackServer(s, ln)
+
+	const connections = 4
+	c := &Client{
+		NewResponse: newTestResponse,
+		Addr:        ln.Addr().String(),
+		Connections: connections,
+	}
+
+	// Responses are sent in random order due to random handler delays,
+	// so they must be matched to requests by nonce across connections.
+	for i := 0; i < 10 && c.Stats().Dials < connections; i++ {
+		if err := testServerClientConcurrentExt(func() error { return testDoEchoN(c, 20) }, 20); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+	if n := c.Stats().Dials; n != connections {
+		t.Fatalf("unexpected number of dials: %d; expecting %d", n, connections)
+	}
+	if n := s.Stats().AcceptedConns; n != connections {
+		t.Fatalf("unexpected number of accepted connections: %d; expecting %d", n, connections)
+	}
+
+	c.Close()
+	if conn := c.Conn(); conn != nil {
+		var buf [1]byte
+		if _, err := conn.Read(buf[:]); err == nil {
+			t.Fatalf("expecting closed connection")
+		}
+	}
+	if err := serverStop(); err != nil {
+		t.Fatalf("cannot shutdown server: %s", err)
+	}
+}
+
+func TestClientConnectionsLossyLink(t *testing.T) {
+	var (
+		lossyAddr   atomic.Value
+		lossyServed uint32
+	)
+	lossyAddr.Store("")
+	s := &Server{
+		NewHandlerCtx: newTestHandlerCtx,
+		Handler: func(ctxv HandlerCtx) HandlerCtx {
+			ctx := ctxv.(*tlv.RequestCtx)
+			if ctx.RemoteAddr().String() == lossyAddr.Load().(string) {
+				atomic.AddUint32(&lossyServed, 1)
+			}
+			return testEchoHandler(ctxv)
+		},
+	}
+	ln := newTestLoopbackListener(t)
+	serverStop := newTestLoopbackServer(s, ln)
+
+	// The first connection loses a part of the written data, while other
+	// connections work as usual.
+	faults := &faultconn.Faults{
+		DropRate: 0.2,
+		Seed:     1,
+	}
+	var dials uint32
+	c := &Client{
+		NewResponse: newTestResponse,
+		Addr:        ln.Addr().String(),
+		Connections: 2,
+		ReadTimeout: 5 * time.Second,
+		Dial: func(addr string) (net.Conn, error) {
+			conn, err := Dial(addr)
+			if err != nil {
+				return nil, err
+			}
+			if atomic.AddUint32(&dials, 1) == 1 {
+				lossyAddr.Store(conn.LocalAddr().String())
+				conn = faults.Conn(conn)
+			}
+			return conn, nil
+		},
+	}
+
+	var (
+		wg     sync.WaitGroup
+		ok     uint32
+		failed uint32
+	)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				var req tlv.Request
+				var resp tlv.Response
+				v := fmt.Sprintf("%d.%d", i, j)
+				req.SwapValue([]byte(v))
+				err := c.DoDeadline(&req, &resp, time.Now().Add(100*time.Millisecond))
+				switch err {
+				case nil:
+					if string(resp.Value()) != v {
+						t.Errorf("unexpected response %q; expecting %q", resp.Value(), v)
+						return
+					}
+					atomic.AddUint32(&ok, 1)
+				case ErrTimeout:
+					atomic.AddUint32(&failed, 1)
+				default:
+					t.Errorf("unexpected error: %s", err)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	// Requests lost on the lossy connection time out, while other
+	// requests over the same connection succeed, so the connection
+	// stays in use.
+	if atomic.LoadUint32(&failed) == 0 {
+		t.Fatalf("expecting timed out requests over the lossy connection")
+	}
+	if atomic.LoadUint32(&ok) <= atomic.LoadUint32(&failed) {
+		t.Fatalf("too many failed requests: %d; succeeded: %d", failed, ok)
+	}
+	if atomic.LoadUint32(&lossyServed) == 0 {
+		t.Fatalf("expecting requests served over the lossy connection")
+	}
+	if n := c.Stats().Dials; n != 2 {
+		t.Fatalf("unexpected number of dials: %d; expecting 2", n)
+	}
+
+	c.Close()
+	if err := serverStop(); err != nil {
+		t.Fatalf("cannot shutdown server: %s", err)
+	}
+}
+
+func testDoEchoN(c *Client, n int) error {
+	for i := 0; i < n; i++ {
+		if err := testDoEcho(c, fmt.Sprintf("value %d", i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func newTestLoopbackListener(t *testing.T) net.Listener {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("cannot listen: %s", err)
+	}
+	return ln
+}
+
+func newTestLoopbackServer(s *Server, ln net.Listener) func() error {
+	serverResultCh := make(chan error, 1)
+	go func() {
+		serverResultCh <- s.Serve(ln)
+	}()
+
+	return func() error {
+		ln.Close()
+		select {
+		case <-serverResultCh:
+		case <-time.After(time.Second):
+			return fmt.Errorf("timeout")
+		}
+		return nil
+	}
+}
@@ -0,0 +1,147 @@
+package fastrpc
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// controlNonce is the request ID reserved for control frames.
+//
+// Client never uses the ID for requests.
+//
+// Control frame has the following format:
+//
+//   - 4 bytes: controlNonce
+//   - 1 byte: frame type
+//   - 4 bytes: payload length
+//   - payload
+const controlNonce = ^uint32(0)
+
+// maxControlPayloadSize is the maximum size of control frame payload.
+const maxControlPayloadSize = 64 * 1024
+
+// goAwayWriteTimeout is the timeout for writing GOAWAY frame
+// to the connection being closed.
+const goAwayWriteTimeout = time.Second
+
+// goAwayReadTimeout is the maximum duration Client waits for GOAWAY frame
+// after failing to write to the connection.
+const goAwayReadTimeout = 100 * time.Millisecond
+
+// Control frame types.
+const (
+	// controlGoAway notifies the client the server closes the connection.
+	//
+	// The payload contains 1-byte GoAwayCode followed by the reason.
+	controlGoAway byte = 1
+
+	// controlPing is sent by the client in order to check whether
+	// the connection is alive.
+	//
+	// The server responds with controlPong frame with the same payload.
+	controlPing byte = 2
+
+	// controlPong is the response to controlPing.
+	controlPong byte = 3
+)
+
+// GoAwayCode is the reason code the Server closes the connection with.
+type GoAwayCode byte
+
+const (
+	// GoAwayRejected means the connection has been rejected due to
+	// connection limits.
+	GoAwayRejected GoAwayCode = 1
+
+	// GoAwayIdle means the connection has been idle for more than
+	// Server.IdleTimeout.
+	GoAwayIdle GoAwayCode = 2
+)
+
+// errIdleConn is returned from connection readers on idle timeout.
+var errIdleConn = errors.New("idle connection")
+
+// String returns human-readable code name.
+func (code GoAwayCode) String() string {
+	switch code {
+	case GoAwayRejected:
+		return "rejected"
+	case GoAwayIdle:
+		return "idle"
+	default:
+		return fmt.Sprintf("unknown(%d)", byte(code))
+	}
+}
+
+// GoAwayError is returned from Client calls if the Server closed
+// the connection after sending GOAWAY frame.
+type GoAwayError struct {
+	// Code is the reason code.
+	Code GoAwayCode
+
+	// Reason is human-readable reason.
+	Reason string
+}
+
+// Error implements error interface.
+func (e *GoAwayError) Error() string {
+	return fmt.Sprintf("server closed the connection (%s): %s", e.Code, e.Reason)
+}
+
+func writeControlFrame(bw *bufio.Writer, typ byte, payload []byte) error {
+	var buf [9]byte
+	b := appendUint32(buf[:0], controlNonce)
+	b = append(b, typ)
+	b = appendUint32(b, uint32(len(payload)))
+	if _, err := bw.Write(b); err != nil {
+		return err
+	}
+	_, err := bw.Write(payload)
+	return err
+}
+
+// readControlFrame reads control frame following controlNonce from br.
+func readControlFrame(br *bufio.Reader) (byte, []byte, error) {
+	var buf [5]byte
+	if _, err := io.ReadFull(br, buf[:]); err != nil {
+		return 0, nil, fmt.Errorf("cannot read control frame header: %w", err)
+	}
+	typ := buf[0]
+	n := bytes2Uint32([4]byte{buf[1], buf[2], buf[3], buf[4]})
+	if n > maxControlPayloadSize {
+		return 0, nil, fmt.Errorf("too big control frame payload: %d bytes; mustn't exceed %d bytes", n, maxControlPayloadSize)
+	}
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(br, payload); err != nil {
+		return 0, nil, fmt.Errorf("cannot read control frame payload: %w", err)
+	}
+	return typ, payload, nil
+}
+
+func writeGoAway(bw *bufio.Writer, code GoAwayCode, reason string) error {
+	payload := make([]byte, 0, 1+len(reason))
+	payload = append(payload, byte(code))
+	payload = append(payload, reason...)
+	return writeControlFrame(bw, controlGoAway, payload)
+}
+
+func parseGoAway(payload []byte) *GoAwayError {
+	if len(payload) == 0 {
+		return &GoAwayError{}
+	}
+	return &GoAwayError{
+		Code:   GoAwayCode(payload[0]),
+		Reason: string(payload[1:]),
+	}
+}
+
+func isIdleConnError(err error) bool {
+	if err == errIdleConn {
+		return true
+	}
+	var goAwayErr *GoAwayError
+	return errors.As(err, &goAwayErr) && goAwayErr.Code == GoAwayIdle
+}
@@ -0,0 +1,57 @@
+package fastrpc
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestControlFrameGoAway(t *testing.T) {
+	var buf bytes.Buffer
+	bw := bufio.NewWriter(&buf)
+	if err := writeGoAway(bw, GoAwayRejected, "too many connections"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := bw.Flush(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	br := bufio.NewReader(&buf)
+	var nonce [4]byte
+	if _, err := io.ReadFull(br, nonce[:]); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if n := bytes2Uint32(nonce); n != controlNonce {
+		t.Fatalf("unexpected nonce: %d; expecting %d", n, controlNonce)
+	}
+	typ, payload, err := readControlFrame(br)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if typ != controlGoAway {
+		t.Fatalf("unexpected frame type: %d; expecting %d", typ, controlGoAway)
+	}
+	e := parseGoAway(payload)
+	if e.Code != GoAwayRejected {
+		t.Fatalf("unexpected code: %s; expecting %s", e.Code, GoAwayRejected)
+	}
+	if e.Reason != "too many connections" {
+		t.Fatalf("unexpected reason: %q", e.Reason)
+	}
+}
+
+func TestControlFrameTooBig(t *testing.T) {
+	var buf bytes.Buffer
+	bw := bufio.NewWriter(&buf)
+	if err := writeControlFrame(bw, controlGoAway, make([]byte, maxControlPayloadSize+1)); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	bw.Flush()
+
+	br := bufio.NewReader(&buf)
+	br.Discard(4)
+	if _, _, err := readControlFrame(br); err == nil {
+		t.Fatalf("expecting non-nil error")
+	}
+}
@@ -0,0 +1,211 @@
+package fastrpc
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// DefaultDialTimeout is the default timeout for establishing
+	// connections via Dialer.
+	DefaultDialTimeout = 3 * time.Second
+
+	// DefaultDNSCacheDuration is the default duration for caching
+	// resolved addresses in Dialer.
+	DefaultDNSCacheDuration = time.Minute
+)
+
+// Dialer establishes connections to the given addresses.
+//
+// See Client.Addr for supported addr forms.
+//
+// Resolved host addresses are cached and connections are spread
+// among them in round-robin manner. If the connection cannot be
+// established, the next address is tried until Timeout.
+type Dialer struct {
+	// Timeout is the maximum duration for establishing the connection,
+	// including the time spent on resolving the host.
+	//
+	// DefaultDialTimeout is used by default.
+	Timeout time.Duration
+
+	// DNSCacheDuration is the duration for caching resolved host addresses.
+	//
+	// DefaultDNSCacheDuration is used by default.
+	DNSCacheDuration time.Duration
+
+	// DualStack enables connecting to IPv6 addresses.
+	//
+	// By default only IPv4 addresses are used for host names.
+	// IP literals are always dialed as is.
+	DualStack bool
+
+	cacheMu sync.Mutex
+	cache   map[string]*dnsCacheEntry
+}
+
+type dnsCacheEntry struct {
+	addrs       []string
+	resolveTime time.Time
+	n           uint32
+}
+
+var (
+	defaultDialer          = &Dialer{}
+	defaultDualStackDialer = &Dialer{DualStack: true}
+)
+
+// Dial establishes connection to the given addr.
+//
+// Host names are resolved to IPv4 addresses. IP literals, including
+// IPv6 literals such as [::1]:1234, are dialed as is.
+//
+// This function is used by Client by default.
+//
+// See Dialer for details.
+func Dial(addr string) (net.Conn, error) {
+	return defaultDialer.Dial(addr)
+}
+
+// DialDualStack establishes connection to the given addr.
+//
+// TCP connections are established over either IPv4 or IPv6.
+//
+// See Dialer for details.
+func DialDualStack(addr string) (net.Conn, error) {
+	return defaultDualStackDialer.Dial(addr)
+}
+
+// Dial establishes connection to the given addr.
+func (d *Dialer) Dial(addr string) (net.Conn, error) {
+	timeout := d.Timeout
+	if timeout <= 0 {
+		timeout = DefaultDialTimeout
+	}
+	deadline := time.Now().Add(timeout)
+	nd := &net.Dialer{
+		Deadline: deadline,
+	}
+
+	network, address, err := splitAddr(addr)
+	if err != nil {
+		return nil, err
+	}
+	if network == "unix" {
+		return nd.Dial(network, address)
+	}
+
+	addrs, idx, err := d.resolve(address, deadline)
+	if err != nil {
+		return nil, err
+	}
+
+	// Resolved host addresses are limited to IPv4 unless DualStack is set,
+	// while IP literals are dialed as is, so IPv6 literals are supported.
+	network = "tcp"
+	if !d.DualStack && !isIPLiteral(address) {
+		network = "tcp4"
+	}
+	for n := len(addrs); n > 0; n-- {
+		a := addrs[idx%uint32(len(addrs))]
+		idx++
+		var conn net.Conn
+		if conn, err = nd.Dial(network, a); err == nil {
+			return conn, nil
+		}
+		if time.Now().After(deadline) {
+			break
+		}
+	}
+	return nil, err
+}
+
+// resolve returns addresses for the given addr and the index
+// of the address to start dialing from.
+func (d *Dialer) resolve(addr string, deadline time.Time) ([]string, uint32, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, 0, err
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return []string{addr}, 0, nil
+	}
+
+	cacheDuration := d.DNSCacheDuration
+	if cacheDuration <= 0 {
+		cacheDuration = DefaultDNSCacheDuration
+	}
+
+	d.cacheMu.Lock()
+	e := d.cache[addr]
+	if e != nil && time.Since(e.resolveTime) > cacheDuration {
+		e = nil
+	}
+	d.cacheMu.Unlock()
+
+	if e == nil {
+		addrs, err := d.lookup(host, port, deadline)
+		if err != nil {
+			return nil, 0, err
+		}
+		e = &dnsCacheEntry{
+			addrs:       addrs,
+			resolveTime: time.Now(),
+		}
+
+		d.cacheMu.Lock()
+		if d.cache == nil {
+			d.cache = make(map[string]*dnsCacheEntry)
+		} else if _, ok := d.cache[addr]; !ok {
+			// Drop expired entries for addresses, which aren't dialed
+			// anymore, so the cache doesn't grow indefinitely.
+			for k, v := range d.cache {
+				if time.Since(v.resolveTime) > cacheDuration {
+					delete(d.cache, k)
+				}
+			}
+		}
+		d.cache[addr] = e
+		d.cacheMu.Unlock()
+	}
+
+	return e.addrs, atomic.AddUint32(&e.n, 1) - 1, nil
+}
+
+// isIPLiteral returns true if addr contains IP literal instead of host name.
+func isIPLiteral(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	return err == nil && net.ParseIP(host) != nil
+}
+
+func (d *Dialer) lookup(host, port string, deadline time.Time) ([]string, error) {
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+
+	portNum, err := net.DefaultResolver.LookupPort(ctx, "tcp", port)
+	if err != nil {
+		return nil, err
+	}
+	ips, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	portStr := strconv.Itoa(portNum)
+	var addrs []string
+	for _, ip := range ips {
+		if !d.DualStack && ip.IP.To4() == nil {
+			continue
+		}
+		addrs = append(addrs, net.JoinHostPort(ip.String(), portStr))
+	}
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("no suitable addresses found for %q", host)
+	}
+	return addrs, nil
+}
@@ -0,0 +1,102 @@
+package fastrpc
+
+import (
+	"net"
+	"strconv"
+	"testing"
+)
+
+func TestDialerDial(t *testing.T) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("cannot listen: %s", err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	port := strconv.Itoa(ln.Addr().(*net.TCPAddr).Port)
+
+	var d Dialer
+	for _, addr := range []string{"127.0.0.1:" + port, "localhost:" + port, "localhost:" + port} {
+		conn, err := d.Dial(addr)
+		if err != nil {
+			t.Fatalf("cannot dial %q: %s", addr, err)
+		}
+		conn.Close()
+	}
+
+	d.cacheMu.Lock()
+	e := d.cache["localhost:"+port]
+	d.cacheMu.Unlock()
+	if e == nil {
+		t.Fatalf("missing dns cache entry for localhost")
+	}
+	if e.n != 2 {
+		t.Fatalf("unexpected number of dials for the cache entry: %d. Expecting 2", e.n)
+	}
+	for _, addr := range e.addrs {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			t.Fatalf("unexpected address %q: %s", addr, err)
+		}
+		if net.ParseIP(host).To4() == nil {
+			t.Fatalf("unexpected IPv6 address %q for non-dual-stack dialer", addr)
+		}
+	}
+}
+
+func TestDialerDialIPv6Literal(t *testing.T) {
+	ln, err := net.Listen("tcp6", "[::1]:0")
+	if err != nil {
+		t.Skipf("IPv6 isn't available: %s", err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	addr := ln.Addr().String()
+
+	// IPv6 literals must be dialed without DualStack.
+	var d Dialer
+	conn, err := d.Dial(addr)
+	if err != nil {
+		t.Fatalf("cannot dial %q: %s", addr, err)
+	}
+	conn.Close()
+}
+
+func TestDialerInvalidAddr(t *testing.T) {
+	var d Dialer
+	if _, err := d.Dial("foobar"); err == nil {
+		t.Fatalf("expecting non-nil error for address without port")
+	}
+	if _, err := d.Dial("localhost:no-such-port"); err == nil {
+		t.Fatalf("expecting non-nil error for unknown port")
+	}
+}
+
+func TestErrTimeout(t *testing.T) {
+	netErr, ok := ErrTimeout.(net.Error)
+	if !ok {
+		t.Fatalf("ErrTimeout must implement net.Error")
+	}
+	if !netErr.Timeout() {
+		t.Fatalf("ErrTimeout.Timeout() must return true")
+	}
+	if !isTimeoutError(ErrTimeout) {
+		t.Fatalf("ErrTimeout must be detected as timeout error")
+	}
+}
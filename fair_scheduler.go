@@ -0,0 +1,294 @@
+package fastrpc
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// fairScheduler dispatches requests from per-key queues to a bounded pool
+// of worker goroutines via deficit round-robin.
+//
+// Each queue may dispatch up to its weight requests per round, so queues
+// share workers in proportion to their weights regardless of the number
+// of queued requests.
+type fairScheduler struct {
+	s *Server
+
+	// maxQueued is the maximum number of queued requests.
+	maxQueued  int
+	maxWorkers int
+
+	mu   sync.Mutex
+	cond *sync.Cond
+
+	queues map[string]*fairQueue
+
+	// active contains non-empty queues in round-robin order.
+	active []*fairQueue
+	cur    int
+
+	queued        int
+	workers       int
+	idleWorkers   int
+	reaperRunning bool
+}
+
+// fairQueue is a queue of requests with the same scheduling key.
+type fairQueue struct {
+	key    string
+	keyed  bool
+	weight int
+
+	deficit int
+	items   []serverTask
+	head    int
+
+	// refs is the number of connections using the queue.
+	refs int
+}
+
+func newFairScheduler(s *Server) *fairScheduler {
+	maxWorkers := s.MaxWorkers
+	if maxWorkers <= 0 {
+		maxWorkers = s.concurrency()
+	}
+	fs := &fairScheduler{
+		s:          s,
+		maxQueued:  s.concurrency(),
+		maxWorkers: maxWorkers,
+		queues:     make(map[string]*fairQueue),
+	}
+	fs.cond = sync.NewCond(&fs.mu)
+	return fs
+}
+
+func (s *Server) getFairScheduler() *fairScheduler {
+	s.fairSchedulerOnce.Do(func() {
+		s.fairScheduler = newFairScheduler(s)
+	})
+	return s.fairScheduler
+}
+
+// register returns the queue for requests from the given connection.
+//
+// unregister must be called when the connection is closed.
+func (fs *fairScheduler) register(conn net.Conn) *fairQueue {
+	s := fs.s
+	if s.SchedulingKey == nil {
+		return &fairQueue{
+			weight: 1,
+			refs:   1,
+		}
+	}
+
+	key := s.SchedulingKey(conn)
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	q := fs.queues[key]
+	if q == nil {
+		weight := 1
+		if s.SchedulingWeight != nil {
+			if w := s.SchedulingWeight(key); w > 0 {
+				weight = w
+			}
+		}
+		q = &fairQueue{
+			key:    key,
+			keyed:  true,
+			weight: weight,
+		}
+		fs.queues[key] = q
+	}
+	q.refs++
+	return q
+}
+
+func (fs *fairScheduler) unregister(q *fairQueue) {
+	fs.mu.Lock()
+	q.refs--
+	fs.maybeDeleteQueue(q)
+	fs.mu.Unlock()
+}
+
+// enqueue adds the item to the queue q.
+//
+// If the scheduler is full, the newest item from the longest queue
+// is evicted and returned, so heavy queues cannot push out requests
+// from light queues. The returned item must be rejected by the caller.
+func (fs *fairScheduler) enqueue(q *fairQueue, item serverTask) *serverTask {
+	fs.mu.Lock()
+
+	var victim *serverTask
+	if fs.queued >= fs.maxQueued {
+		longest := q
+		for _, aq := range fs.active {
+			if aq.len() > longest.len() {
+				longest = aq
+			}
+		}
+		if longest == q {
+			fs.mu.Unlock()
+			return &item
+		}
+		last := longest.items[len(longest.items)-1]
+		longest.items = longest.items[:len(longest.items)-1]
+		fs.queued--
+		if longest.len() == 0 {
+			fs.deactivate(longest)
+		}
+		victim = &last
+	}
+
+	if q.len() == 0 {
+		fs.activate(q)
+	}
+	q.items = append(q.items, item)
+	fs.queued++
+
+	if fs.idleWorkers > 0 {
+		fs.cond.Signal()
+	} else if fs.workers < fs.maxWorkers {
+		fs.workers++
+		go fs.worker()
+		if !fs.reaperRunning {
+			fs.reaperRunning = true
+			go fs.reaper()
+		}
+	}
+	fs.mu.Unlock()
+
+	return victim
+}
+
+func (fs *fairScheduler) worker() {
+	maxIdleWorkerDuration := fs.s.maxIdleWorkerDuration()
+	for {
+		fs.mu.Lock()
+		idleSince := time.Now()
+		for fs.queued == 0 {
+			if time.Since(idleSince) >= maxIdleWorkerDuration {
+				fs.workers--
+				fs.mu.Unlock()
+				return
+			}
+			fs.idleWorkers++
+			fs.cond.Wait()
+			fs.idleWorkers--
+		}
+		item := fs.next()
+		fs.mu.Unlock()
+
+		fs.s.handleRequest(item.wi, item.pendingResponses, item.stopCh)
+	}
+}
+
+// reaper periodically wakes up idle workers, so they stop after
+// Server.MaxIdleWorkerDuration.
+//
+// It exits when no workers are left.
+func (fs *fairScheduler) reaper() {
+	maxIdleWorkerDuration := fs.s.maxIdleWorkerDuration()
+	for {
+		time.Sleep(maxIdleWorkerDuration)
+
+		fs.mu.Lock()
+		if fs.workers == 0 {
+			fs.reaperRunning = false
+			fs.mu.Unlock()
+			return
+		}
+		fs.cond.Broadcast()
+		fs.mu.Unlock()
+	}
+}
+
+// next returns the next item to process.
+//
+// fs.mu must be locked and fs.queued must be positive.
+func (fs *fairScheduler) next() serverTask {
+	for {
+		q := fs.active[fs.cur]
+		if q.deficit > 0 {
+			q.deficit--
+			item := q.items[q.head]
+			q.items[q.head] = serverTask{}
+			q.head++
+			if q.head >= 64 && 2*q.head >= len(q.items) {
+				// Compact the queue, so it doesn't grow indefinitely.
+				n := copy(q.items, q.items[q.head:])
+				q.items = q.items[:n]
+				q.head = 0
+			}
+			fs.queued--
+			if q.len() == 0 {
+				fs.deactivate(q)
+			}
+			return item
+		}
+		fs.advance()
+	}
+}
+
+// activate appends the empty queue q to the round-robin list.
+func (fs *fairScheduler) activate(q *fairQueue) {
+	q.items = q.items[:0]
+	q.head = 0
+	q.deficit = 0
+	fs.active = append(fs.active, q)
+	if len(fs.active) == 1 {
+		fs.cur = 0
+		q.deficit = q.weight
+	}
+}
+
+// deactivate removes the empty queue q from the round-robin list.
+func (fs *fairScheduler) deactivate(q *fairQueue) {
+	for i, aq := range fs.active {
+		if aq != q {
+			continue
+		}
+		copy(fs.active[i:], fs.active[i+1:])
+		fs.active[len(fs.active)-1] = nil
+		fs.active = fs.active[:len(fs.active)-1]
+
+		switch {
+		case len(fs.active) == 0:
+			fs.cur = 0
+		case i < fs.cur:
+			fs.cur--
+		case i == fs.cur:
+			// The next queue becomes current.
+			if fs.cur >= len(fs.active) {
+				fs.cur = 0
+			}
+			fs.active[fs.cur].deficit += fs.active[fs.cur].weight
+		}
+		break
+	}
+	q.deficit = 0
+	fs.maybeDeleteQueue(q)
+}
+
+// advance moves to the next queue in the round-robin list and grants it
+// a quantum of requests.
+func (fs *fairScheduler) advance() {
+	fs.cur++
+	if fs.cur >= len(fs.active) {
+		fs.cur = 0
+	}
+	q := fs.active[fs.cur]
+	q.deficit += q.weight
+}
+
+func (fs *fairScheduler) maybeDeleteQueue(q *fairQueue) {
+	if q.keyed && q.refs == 0 && q.len() == 0 {
+		delete(fs.queues, q.key)
+	}
+}
+
+func (q *fairQueue) len() int {
+	return len(q.items) - q.head
+}
@@ -0,0 +1,192 @@
+package fastrpc
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/UladzimirTrehubenka/fastrpc/tlv"
+)
+
+func TestFairSchedulerRoundRobin(t *testing.T) {
+	s := &Server{}
+	fs := newFairScheduler(s)
+	fs.maxWorkers = 0
+
+	heavy := &fairQueue{weight: 2, refs: 1}
+	light := &fairQueue{weight: 1, refs: 1}
+	for i := 0; i < 6; i++ {
+		fs.enqueue(heavy, serverTask{wi: &serverWorkItem{nonce: [4]byte{'h', byte(i)}}})
+	}
+	for i := 0; i < 3; i++ {
+		fs.enqueue(light, serverTask{wi: &serverWorkItem{nonce: [4]byte{'l', byte(i)}}})
+	}
+
+	var order []byte
+	for fs.queued > 0 {
+		order = append(order, fs.next().wi.nonce[0])
+	}
+	if string(order) != "hhlhhlhhl" {
+		t.Fatalf("unexpected order: %q. Expecting %q", order, "hhlhhlhhl")
+	}
+}
+
+func TestFairSchedulerEvictLongestQueue(t *testing.T) {
+	s := &Server{
+		Concurrency: 3,
+	}
+	fs := newFairScheduler(s)
+	fs.maxWorkers = 0
+
+	heavy := &fairQueue{weight: 1, refs: 1}
+	light := &fairQueue{weight: 1, refs: 1}
+	for i := 0; i < 3; i++ {
+		if victim := fs.enqueue(heavy, serverTask{wi: &serverWorkItem{nonce: [4]byte{byte(i)}}}); victim != nil {
+			t.Fatalf("unexpected eviction on iteration %d", i)
+		}
+	}
+
+	// The heavy queue is the longest one, so its newest item must be evicted.
+	victim := fs.enqueue(light, serverTask{wi: &serverWorkItem{nonce: [4]byte{'l'}}})
+	if victim == nil || victim.wi.nonce[0] != 2 {
+		t.Fatalf("unexpected victim: %v", victim)
+	}
+
+	// New items for the longest queue are rejected.
+	item := serverTask{wi: &serverWorkItem{nonce: [4]byte{3}}}
+	if victim := fs.enqueue(heavy, item); victim == nil || victim.wi != item.wi {
+		t.Fatalf("unexpected victim: %v", victim)
+	}
+}
+
+func TestServerFairScheduling(t *testing.T) {
+	var mu sync.Mutex
+	var order []string
+	gate := make(chan struct{})
+	blocked := make(chan struct{})
+	s := &Server{
+		NewHandlerCtx: newTestHandlerCtx,
+		Handler: func(ctxv HandlerCtx) HandlerCtx {
+			ctx := ctxv.(*tlv.RequestCtx)
+			v := string(ctx.Request.Value())
+			if v == "block" {
+				close(blocked)
+				<-gate
+			}
+			mu.Lock()
+			order = append(order, v)
+			mu.Unlock()
+			ctx.Write(ctx.Request.Value())
+			return ctx
+		},
+		FairScheduling: true,
+		MaxWorkers:     1,
+	}
+	serverStop, ln := newTestServerExt(s)
+	cNoisy := newTestClient(ln)
+	cQuiet := newTestClient(ln)
+
+	resultCh := make(chan error, 100)
+	do := func(c *Client, v string) {
+		var req tlv.Request
+		var resp tlv.Response
+		req.SwapValue([]byte(v))
+		resultCh <- c.DoDeadline(&req, &resp, time.Now().Add(5*time.Second))
+	}
+
+	// Occupy the only worker.
+	go do(cNoisy, "block")
+	select {
+	case <-blocked:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timeout")
+	}
+
+	const noisyRequests = 20
+	for i := 0; i < noisyRequests; i++ {
+		go do(cNoisy, "noisy")
+	}
+	testWaitQueued(t, s, noisyRequests)
+	go do(cQuiet, "quiet")
+	testWaitQueued(t, s, noisyRequests+1)
+
+	close(gate)
+	for i := 0; i < noisyRequests+2; i++ {
+		select {
+		case err := <-resultCh:
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timeout")
+		}
+	}
+
+	mu.Lock()
+	pos := -1
+	for i, v := range order {
+		if v == "quiet" {
+			pos = i
+		}
+	}
+	mu.Unlock()
+	if pos < 0 || pos > 2 {
+		t.Fatalf("the quiet connection must be served right after the blocked request; got position %d", pos)
+	}
+
+	if err := serverStop(); err != nil {
+		t.Fatalf("cannot shutdown server: %s", err)
+	}
+}
+
+func testWaitQueued(t *testing.T, s *Server, n int) {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		fs := s.getFairScheduler()
+		fs.mu.Lock()
+		queued, idle := fs.queued, fs.idleWorkers
+		fs.mu.Unlock()
+		if queued == n && idle == 0 {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("timeout waiting for %d queued requests", n)
+}
+
+func TestServerRejectEvictedNonBlocking(t *testing.T) {
+	s := &Server{}
+	pendingResponses := make(chan *serverWorkItem)
+	stopCh := make(chan struct{})
+	task := &serverTask{
+		wi: &serverWorkItem{
+			nonce: [4]byte{1},
+			ctx:   newTestHandlerCtx(),
+		},
+		pendingResponses: pendingResponses,
+		stopCh:           stopCh,
+	}
+
+	// The rejection mustn't block while the victim's connection
+	// doesn't read pending responses.
+	doneCh := make(chan struct{})
+	go func() {
+		s.rejectEvicted(task)
+		close(doneCh)
+	}()
+	select {
+	case <-doneCh:
+	case <-time.After(time.Second):
+		t.Fatalf("rejectEvicted blocked on busy connection")
+	}
+
+	select {
+	case wi := <-pendingResponses:
+		if wi != task.wi {
+			t.Fatalf("unexpected work item pushed")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("the rejection hasn't been pushed to the connection")
+	}
+	close(stopCh)
+}
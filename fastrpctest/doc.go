@@ -0,0 +1,19 @@
+// Package fastrpctest provides utilities for testing code built
+// on top of fastrpc.
+//
+// Listener is an in-memory net.Listener, which may be used
+// in fastrpc.Server.Serve, while Listener.Dial may be used
+// as fastrpc.Client.Dial. Pair starts fastrpc.Server and connects
+// fastrpc.Client to it over Listener:
+//
+//	p := fastrpctest.NewPair(&fastrpc.Server{Handler: handler}, nil)
+//	defer p.Close()
+//
+//	var req tlv.Request
+//	var resp tlv.Response
+//	...
+//	err := p.Client.DoDeadline(&req, &resp, deadline)
+//
+// Listener.SetFaults injects faults described by faultconn.Faults
+// into connections. Goroutines detects goroutines leaked by the tested code.
+package fastrpctest
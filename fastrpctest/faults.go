@@ -0,0 +1,14 @@
+package fastrpctest
+
+import (
+	"github.com/UladzimirTrehubenka/fastrpc/faultconn"
+)
+
+// Faults describes faults injected into Listener connections.
+//
+// See faultconn.Faults for details.
+type Faults = faultconn.Faults
+
+// ErrConnReset is returned from Write calls on connections reset
+// by Faults.
+var ErrConnReset = faultconn.ErrConnReset
@@ -0,0 +1,138 @@
+package fastrpctest
+
+import (
+	"bytes"
+	"fmt"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Goroutines is a snapshot of running goroutines.
+//
+// It is used for detecting goroutines leaked by the tested code:
+//
+//	g := fastrpctest.SnapshotGoroutines()
+//	c := &fastrpc.Client{...}
+//	...
+//	c.Close()
+//	if err := g.CheckLeaks(time.Second); err != nil {
+//		t.Fatal(err)
+//	}
+type Goroutines struct {
+	ids map[uint64]struct{}
+}
+
+// SnapshotGoroutines returns the snapshot of currently running goroutines.
+func SnapshotGoroutines() *Goroutines {
+	stacks := goroutineStacks()
+	ids := make(map[uint64]struct{}, len(stacks))
+	for id := range stacks {
+		ids[id] = struct{}{}
+	}
+	return &Goroutines{
+		ids: ids,
+	}
+}
+
+// Leaked waits up to timeout until goroutines started after the snapshot
+// exit and returns stack traces of goroutines still running.
+//
+// Goroutines with stack traces containing any of ignore substrings
+// are skipped. For instance, pass "fastrpc.(*workerPool)" for skipping
+// idle Server workers, which are stopped after
+// fastrpc.Server.MaxIdleWorkerDuration.
+func (g *Goroutines) Leaked(timeout time.Duration, ignore ...string) []string {
+	deadline := time.Now().Add(timeout)
+	sleepDuration := time.Millisecond
+	for {
+		leaked := g.leaked(ignore)
+		if len(leaked) == 0 || time.Now().After(deadline) {
+			return leaked
+		}
+		time.Sleep(sleepDuration)
+		if sleepDuration < 100*time.Millisecond {
+			sleepDuration *= 2
+		}
+	}
+}
+
+// CheckLeaks is like Leaked, but returns an error with stack traces
+// of leaked goroutines.
+func (g *Goroutines) CheckLeaks(timeout time.Duration, ignore ...string) error {
+	leaked := g.Leaked(timeout, ignore...)
+	if len(leaked) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%d goroutines leaked:\n\n%s", len(leaked), strings.Join(leaked, "\n\n"))
+}
+
+func (g *Goroutines) leaked(ignore []string) []string {
+	var leaked []string
+	for id, stack := range goroutineStacks() {
+		if _, ok := g.ids[id]; ok {
+			continue
+		}
+		if containsAny(stack, ignore) {
+			continue
+		}
+		leaked = append(leaked, stack)
+	}
+	return leaked
+}
+
+func containsAny(s string, substrs []string) bool {
+	for _, substr := range substrs {
+		if strings.Contains(s, substr) {
+			return true
+		}
+	}
+	return false
+}
+
+// goroutineStacks returns stack traces of running goroutines
+// except the current one by goroutine ID.
+func goroutineStacks() map[uint64]string {
+	buf := make([]byte, 64*1024)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+
+	stacks := make(map[uint64]string)
+	for i, stack := range bytes.Split(buf, []byte("\n\n")) {
+		if i == 0 {
+			// The first stack belongs to the current goroutine.
+			continue
+		}
+		id, ok := parseGoroutineID(stack)
+		if !ok {
+			continue
+		}
+		stacks[id] = string(stack)
+	}
+	return stacks
+}
+
+// parseGoroutineID parses ID from "goroutine N [state]:" stack header.
+func parseGoroutineID(stack []byte) (uint64, bool) {
+	const prefix = "goroutine "
+	if !bytes.HasPrefix(stack, []byte(prefix)) {
+		return 0, false
+	}
+	stack = stack[len(prefix):]
+	n := bytes.IndexByte(stack, ' ')
+	if n < 0 {
+		return 0, false
+	}
+	id, err := strconv.ParseUint(string(stack[:n]), 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return id, true
+}
@@ -0,0 +1,50 @@
+package fastrpctest
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGoroutinesNoLeaks(t *testing.T) {
+	g := SnapshotGoroutines()
+
+	doneCh := make(chan struct{})
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		close(doneCh)
+	}()
+
+	// The goroutine must exit until timeout.
+	if err := g.CheckLeaks(time.Second); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	<-doneCh
+}
+
+func TestGoroutinesLeaked(t *testing.T) {
+	g := SnapshotGoroutines()
+
+	stopCh := make(chan struct{})
+	go testLeakedGoroutine(stopCh)
+	defer close(stopCh)
+
+	leaked := g.Leaked(50 * time.Millisecond)
+	if len(leaked) != 1 {
+		t.Fatalf("unexpected number of leaked goroutines: %d; expecting 1", len(leaked))
+	}
+	if !strings.Contains(leaked[0], "testLeakedGoroutine") {
+		t.Fatalf("unexpected stack trace of leaked goroutine:\n%s", leaked[0])
+	}
+	if err := g.CheckLeaks(0); err == nil {
+		t.Fatalf("expecting non-nil error")
+	}
+
+	if leaked := g.Leaked(0, "testLeakedGoroutine"); len(leaked) != 0 {
+		t.Fatalf("ignored goroutines mustn't be returned:\n%s", strings.Join(leaked, "\n\n"))
+	}
+}
+
+func testLeakedGoroutine(stopCh <-chan struct{}) {
+	<-stopCh
+}
@@ -0,0 +1,109 @@
+package fastrpctest
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrListenerClosed is returned from Accept and Dial calls
+// on the closed Listener.
+var ErrListenerClosed = errors.New("fastrpctest: use of closed network connection")
+
+// Listener is an in-memory net.Listener.
+//
+// Connections are created with Dial. Both ends of each connection
+// are created with net.Pipe, so writes block until the data is read
+// by the other end.
+type Listener struct {
+	conns chan net.Conn
+
+	// faults contains *Faults for new connections.
+	faults atomic.Value
+
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// NewListener returns new in-memory Listener.
+func NewListener() *Listener {
+	ln := &Listener{
+		conns:  make(chan net.Conn),
+		closed: make(chan struct{}),
+	}
+	ln.faults.Store((*Faults)(nil))
+	return ln
+}
+
+// SetFaults sets faults injected into both ends of connections
+// established after the call.
+//
+// Faults are disabled if f is nil.
+func (ln *Listener) SetFaults(f *Faults) {
+	ln.faults.Store(f)
+}
+
+// Dial connects to the Listener.
+//
+// It blocks until the connection is accepted or the Listener is closed.
+// Dial may be used as fastrpc.Client.Dial via DialFunc.
+func (ln *Listener) Dial() (net.Conn, error) {
+	clientConn, serverConn := net.Pipe()
+	if f := ln.faults.Load().(*Faults); f != nil {
+		clientConn = f.Conn(clientConn)
+		serverConn = f.Conn(serverConn)
+	}
+
+	select {
+	case ln.conns <- serverConn:
+		return clientConn, nil
+	case <-ln.closed:
+		clientConn.Close()
+		serverConn.Close()
+		return nil, ErrListenerClosed
+	}
+}
+
+// DialFunc returns the function for fastrpc.Client.Dial, which connects
+// to the Listener regardless of the address.
+func (ln *Listener) DialFunc() func(addr string) (net.Conn, error) {
+	return func(addr string) (net.Conn, error) {
+		return ln.Dial()
+	}
+}
+
+// Accept implements net.Listener.
+func (ln *Listener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-ln.conns:
+		return conn, nil
+	case <-ln.closed:
+		return nil, ErrListenerClosed
+	}
+}
+
+// Close implements net.Listener.
+//
+// Already established connections aren't closed.
+func (ln *Listener) Close() error {
+	ln.closeOnce.Do(func() {
+		close(ln.closed)
+	})
+	return nil
+}
+
+// Addr implements net.Listener.
+func (ln *Listener) Addr() net.Addr {
+	return pipeAddr{}
+}
+
+type pipeAddr struct{}
+
+func (pipeAddr) Network() string {
+	return "pipe"
+}
+
+func (pipeAddr) String() string {
+	return "pipe"
+}
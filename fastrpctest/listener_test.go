@@ -0,0 +1,67 @@
+package fastrpctest
+
+import (
+	"io"
+	"testing"
+)
+
+func TestListenerDialAccept(t *testing.T) {
+	ln := NewListener()
+	defer ln.Close()
+
+	acceptCh := make(chan error, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			acceptCh <- err
+			return
+		}
+		defer conn.Close()
+		_, err = io.Copy(conn, conn)
+		acceptCh <- err
+	}()
+
+	conn, err := ln.Dial()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, err := conn.Write([]byte("foobar")); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	var buf [6]byte
+	if _, err := io.ReadFull(conn, buf[:]); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if string(buf[:]) != "foobar" {
+		t.Fatalf("unexpected data read: %q; expecting %q", buf[:], "foobar")
+	}
+	conn.Close()
+	if err := <-acceptCh; err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+}
+
+func TestListenerClose(t *testing.T) {
+	ln := NewListener()
+
+	acceptCh := make(chan error, 1)
+	go func() {
+		_, err := ln.Accept()
+		acceptCh <- err
+	}()
+
+	if err := ln.Close(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := <-acceptCh; err != ErrListenerClosed {
+		t.Fatalf("unexpected error: %v; expecting %v", err, ErrListenerClosed)
+	}
+	if _, err := ln.Dial(); err != ErrListenerClosed {
+		t.Fatalf("unexpected error: %v; expecting %v", err, ErrListenerClosed)
+	}
+
+	// Close may be called multiple times.
+	if err := ln.Close(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+}
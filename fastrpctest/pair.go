@@ -0,0 +1,111 @@
+package fastrpctest
+
+import (
+	"errors"
+	"time"
+
+	"github.com/UladzimirTrehubenka/fastrpc"
+	"github.com/UladzimirTrehubenka/fastrpc/tlv"
+)
+
+// DefaultCloseTimeout is the default maximum duration Pair.Close waits
+// for the Server to stop.
+const DefaultCloseTimeout = 5 * time.Second
+
+// Pair is fastrpc.Server and fastrpc.Client connected over Listener.
+type Pair struct {
+	// Server serves connections accepted from Listener.
+	Server *fastrpc.Server
+
+	// Client is connected to Server.
+	Client *fastrpc.Client
+
+	// Listener is the in-memory listener for Server.
+	Listener *Listener
+
+	// CloseTimeout is the maximum duration Close waits for the Server
+	// to stop.
+	//
+	// DefaultCloseTimeout is used by default.
+	CloseTimeout time.Duration
+
+	serveResult chan error
+}
+
+// NewPair starts s on new Listener and returns Pair with c connected to it.
+//
+// s.NewHandlerCtx returns tlv.RequestCtx if it isn't set.
+// Client with tlv.Response is created if c is nil, otherwise
+// c.NewResponse returns tlv.Response if it isn't set. c.Dial is set
+// to Listener.Dial.
+//
+// Call Pair.Close when the Pair is no longer needed.
+func NewPair(s *fastrpc.Server, c *fastrpc.Client) *Pair {
+	if s.NewHandlerCtx == nil {
+		s.NewHandlerCtx = newHandlerCtx
+	}
+	if c == nil {
+		c = &fastrpc.Client{}
+	}
+	if c.NewResponse == nil {
+		c.NewResponse = newResponse
+	}
+
+	ln := NewListener()
+	c.Dial = ln.DialFunc()
+	if c.Addr == "" {
+		c.Addr = ln.Addr().String()
+	}
+
+	p := &Pair{
+		Server:      s,
+		Client:      c,
+		Listener:    ln,
+		serveResult: make(chan error, 1),
+	}
+	go func() {
+		p.serveResult <- s.Serve(ln)
+	}()
+	return p
+}
+
+// Close closes the Client and stops the Server.
+//
+// It waits until all the Server connections are closed.
+func (p *Pair) Close() error {
+	p.Client.Close()
+	p.Listener.Close()
+
+	closeTimeout := p.CloseTimeout
+	if closeTimeout <= 0 {
+		closeTimeout = DefaultCloseTimeout
+	}
+	t := time.NewTimer(closeTimeout)
+	defer t.Stop()
+
+	select {
+	case err := <-p.serveResult:
+		if err != nil {
+			return err
+		}
+	case <-t.C:
+		return errors.New("fastrpctest: timeout when stopping the Server")
+	}
+
+	for p.Server.Stats().ActiveConns > 0 {
+		select {
+		case <-t.C:
+			return errors.New("fastrpctest: timeout when closing the Server connections")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	return nil
+}
+
+func newHandlerCtx() fastrpc.HandlerCtx {
+	return &tlv.RequestCtx{}
+}
+
+func newResponse() fastrpc.ResponseReader {
+	return &tlv.Response{}
+}
@@ -0,0 +1,87 @@
+package fastrpctest
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/UladzimirTrehubenka/fastrpc"
+	"github.com/UladzimirTrehubenka/fastrpc/rpclog"
+	"github.com/UladzimirTrehubenka/fastrpc/tlv"
+)
+
+func TestPair(t *testing.T) {
+	g := SnapshotGoroutines()
+
+	p := NewPair(&fastrpc.Server{
+		Handler: testEchoHandler,
+		Logger:  rpclog.Discard,
+	}, nil)
+
+	for i := 0; i < 10; i++ {
+		v := fmt.Sprintf("value %d", i)
+		if err := testDoEcho(p.Client, v); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+
+	if err := p.Close(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := g.CheckLeaks(time.Second, "fastrpc.(*workerPool)"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+}
+
+func TestPairFaults(t *testing.T) {
+	p := NewPair(&fastrpc.Server{
+		Handler: testEchoHandler,
+		Logger:  rpclog.Discard,
+	}, &fastrpc.Client{
+		Logger: rpclog.Discard,
+	})
+	defer p.Close()
+
+	if err := testDoEcho(p.Client, "foo"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// Break the established connection, so the Client reconnects
+	// with faults injected.
+	p.Listener.SetFaults(&Faults{ResetRate: 1})
+	p.Client.Conn().Close()
+	if err := testDoEcho(p.Client, "bar"); err == nil {
+		t.Fatalf("expecting non-nil error")
+	}
+
+	p.Listener.SetFaults(nil)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		err := testDoEcho(p.Client, "baz")
+		if err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("the Client didn't recover after disabling faults: %s", err)
+		}
+	}
+}
+
+func testEchoHandler(ctxv fastrpc.HandlerCtx) fastrpc.HandlerCtx {
+	ctx := ctxv.(*tlv.RequestCtx)
+	ctx.Write(ctx.Request.Value())
+	return ctx
+}
+
+func testDoEcho(c *fastrpc.Client, v string) error {
+	var req tlv.Request
+	var resp tlv.Response
+	req.SwapValue([]byte(v))
+	if err := c.DoDeadline(&req, &resp, time.Now().Add(time.Second)); err != nil {
+		return err
+	}
+	if string(resp.Value()) != v {
+		return fmt.Errorf("unexpected response %q; expecting %q", resp.Value(), v)
+	}
+	return nil
+}
@@ -0,0 +1,228 @@
+package faultconn
+
+import (
+	"io"
+	"math/rand"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type fault int
+
+const (
+	faultNone fault = iota
+	faultDrop
+	faultReset
+	faultGarbage
+	faultCorrupt
+	faultPartialWrite
+	faultStall
+)
+
+type faultConn struct {
+	net.Conn
+
+	f *Faults
+
+	// rndMu serializes Write calls, so faults are reproducible.
+	rndMu sync.Mutex
+	rnd   *rand.Rand
+
+	// nextWriteTime is the time the next write may start at
+	// according to Faults.Bandwidth. It is protected by rndMu.
+	nextWriteTime time.Time
+
+	// writeDeadline contains time.Time for the write deadline.
+	writeDeadline atomic.Value
+
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// NetConn returns the original connection.
+func (c *faultConn) NetConn() net.Conn {
+	return c.Conn
+}
+
+func (c *faultConn) Write(p []byte) (int, error) {
+	c.rndMu.Lock()
+	defer c.rndMu.Unlock()
+
+	if err := c.wait(c.delay(len(p))); err != nil {
+		return 0, err
+	}
+
+	switch c.nextFault() {
+	case faultDrop:
+		return len(p), nil
+	case faultReset:
+		c.Close()
+		return 0, ErrConnReset
+	case faultGarbage:
+		garbage := make([]byte, len(p))
+		c.rnd.Read(garbage)
+		return c.Conn.Write(garbage)
+	case faultCorrupt:
+		if len(p) == 0 {
+			break
+		}
+		corrupted := append([]byte{}, p...)
+		corrupted[c.rnd.Intn(len(p))] ^= 1 << uint(c.rnd.Intn(8))
+		return c.Conn.Write(corrupted)
+	case faultPartialWrite:
+		n := 0
+		if len(p) > 0 {
+			n = c.rnd.Intn(len(p))
+		}
+		n, err := c.Conn.Write(p[:n])
+		c.Close()
+		if err != nil {
+			return n, err
+		}
+		return n, io.ErrShortWrite
+	case faultStall:
+		d := c.f.StallDuration
+		if d <= 0 {
+			d = -1
+		}
+		if err := c.wait(d); err != nil {
+			return 0, err
+		}
+	}
+	return c.Conn.Write(p)
+}
+
+func (c *faultConn) Close() error {
+	err := errClosed
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		err = c.Conn.Close()
+	})
+	return err
+}
+
+func (c *faultConn) SetDeadline(t time.Time) error {
+	c.writeDeadline.Store(t)
+	return c.Conn.SetDeadline(t)
+}
+
+func (c *faultConn) SetWriteDeadline(t time.Time) error {
+	c.writeDeadline.Store(t)
+	return c.Conn.SetWriteDeadline(t)
+}
+
+// delay returns the delay before writing n bytes according to Faults.Latency
+// and Faults.Bandwidth.
+//
+// rndMu must be locked.
+func (c *faultConn) delay(n int) time.Duration {
+	f := c.f
+	d := f.Latency
+	if f.LatencyJitter > 0 {
+		d += time.Duration(c.rnd.Int63n(int64(f.LatencyJitter)))
+	}
+	if f.Bandwidth > 0 {
+		now := time.Now()
+		if c.nextWriteTime.Before(now) {
+			c.nextWriteTime = now
+		}
+		if wait := c.nextWriteTime.Sub(now); wait > d {
+			d = wait
+		}
+		c.nextWriteTime = c.nextWriteTime.Add(time.Duration(n) * time.Second / time.Duration(f.Bandwidth))
+	}
+	return d
+}
+
+// wait waits for the given duration, until the connection is closed
+// or the write deadline is reached.
+//
+// It waits until the connection is closed or the write deadline
+// is reached if d is negative.
+func (c *faultConn) wait(d time.Duration) error {
+	timeout := false
+	if deadline := c.writeDeadline.Load().(time.Time); !deadline.IsZero() {
+		if dd := time.Until(deadline); d < 0 || dd <= d {
+			d, timeout = dd, true
+		}
+	}
+	if timeout && d <= 0 {
+		// The deadline has already passed.
+		return c.timeoutError()
+	}
+	if d == 0 {
+		return nil
+	}
+
+	var timerCh <-chan time.Time
+	if d >= 0 {
+		t := time.NewTimer(d)
+		defer t.Stop()
+		timerCh = t.C
+	}
+
+	select {
+	case <-timerCh:
+		if timeout {
+			return c.timeoutError()
+		}
+		return nil
+	case <-c.closed:
+		return errClosed
+	}
+}
+
+func (c *faultConn) timeoutError() error {
+	return &net.OpError{
+		Op:     "write",
+		Net:    c.LocalAddr().Network(),
+		Source: c.LocalAddr(),
+		Addr:   c.RemoteAddr(),
+		Err:    &timeoutError{},
+	}
+}
+
+// nextFault returns the fault for the next Write call.
+//
+// rndMu must be locked.
+func (c *faultConn) nextFault() fault {
+	f := c.f
+	x := c.rnd.Float64()
+	if x -= f.DropRate; x < 0 {
+		return faultDrop
+	}
+	if x -= f.ResetRate; x < 0 {
+		return faultReset
+	}
+	if x -= f.GarbageRate; x < 0 {
+		return faultGarbage
+	}
+	if x -= f.CorruptRate; x < 0 {
+		return faultCorrupt
+	}
+	if x -= f.PartialWriteRate; x < 0 {
+		return faultPartialWrite
+	}
+	if x -= f.StallRate; x < 0 {
+		return faultStall
+	}
+	return faultNone
+}
+
+type timeoutError struct{}
+
+func (e *timeoutError) Error() string {
+	return "i/o timeout"
+}
+
+// Timeout implements net.Error.
+func (e *timeoutError) Timeout() bool {
+	return true
+}
+
+// Temporary implements net.Error.
+func (e *timeoutError) Temporary() bool {
+	return true
+}
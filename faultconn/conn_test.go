@@ -0,0 +1,243 @@
+package faultconn
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestFaultsNone(t *testing.T) {
+	data := testFaultsWrite(t, &Faults{}, 100)
+	if len(data) != 100*len(testFaultsData) {
+		t.Fatalf("unexpected data length: %d; expecting %d", len(data), 100*len(testFaultsData))
+	}
+	if !bytes.Equal(data, bytes.Repeat(testFaultsData, 100)) {
+		t.Fatalf("unexpected data written")
+	}
+}
+
+func TestFaultsDrop(t *testing.T) {
+	data := testFaultsWrite(t, &Faults{DropRate: 0.5}, 100)
+	n := len(data) / len(testFaultsData)
+	if n == 0 || n == 100 {
+		t.Fatalf("unexpected number of writes passed: %d", n)
+	}
+	if !bytes.Equal(data, bytes.Repeat(testFaultsData, n)) {
+		t.Fatalf("unexpected data written")
+	}
+}
+
+func TestFaultsGarbage(t *testing.T) {
+	data := testFaultsWrite(t, &Faults{GarbageRate: 1}, 10)
+	if len(data) != 10*len(testFaultsData) {
+		t.Fatalf("unexpected data length: %d; expecting %d", len(data), 10*len(testFaultsData))
+	}
+	if bytes.Contains(data, testFaultsData) {
+		t.Fatalf("garbage must be written instead of data")
+	}
+}
+
+func TestFaultsReset(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+	conn := (&Faults{ResetRate: 1}).Conn(client)
+
+	if _, err := conn.Write(testFaultsData); err != ErrConnReset {
+		t.Fatalf("unexpected error: %v; expecting %v", err, ErrConnReset)
+	}
+	if _, err := server.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("unexpected error: %v; expecting %v", err, io.EOF)
+	}
+}
+
+func TestFaultsPartialWrite(t *testing.T) {
+	client, server := net.Pipe()
+	conn := (&Faults{PartialWriteRate: 1}).Conn(client)
+
+	readCh := make(chan []byte, 1)
+	go func() {
+		data, _ := ioutil.ReadAll(server)
+		readCh <- data
+	}()
+
+	n, err := conn.Write(testFaultsData)
+	if err != io.ErrShortWrite {
+		t.Fatalf("unexpected error: %v; expecting %v", err, io.ErrShortWrite)
+	}
+	data := <-readCh
+	if n != len(data) || n >= len(testFaultsData) {
+		t.Fatalf("unexpected number of bytes written: %d; read %d", n, len(data))
+	}
+	if !bytes.Equal(data, testFaultsData[:n]) {
+		t.Fatalf("unexpected data written: %q", data)
+	}
+}
+
+func TestFaultsLatency(t *testing.T) {
+	startTime := time.Now()
+	testFaultsWrite(t, &Faults{Latency: 10 * time.Millisecond}, 5)
+	if d := time.Since(startTime); d < 50*time.Millisecond {
+		t.Fatalf("too small duration for writes with latency: %s", d)
+	}
+}
+
+func TestFaultsCorrupt(t *testing.T) {
+	data := testFaultsWrite(t, &Faults{CorruptRate: 1}, 10)
+	if len(data) != 10*len(testFaultsData) {
+		t.Fatalf("unexpected data length: %d; expecting %d", len(data), 10*len(testFaultsData))
+	}
+	for i := 0; i < 10; i++ {
+		chunk := data[i*len(testFaultsData) : (i+1)*len(testFaultsData)]
+		diff := 0
+		for j := range chunk {
+			if chunk[j] != testFaultsData[j] {
+				diff++
+			}
+		}
+		if diff != 1 {
+			t.Fatalf("unexpected number of corrupted bytes in %q: %d; expecting 1", chunk, diff)
+		}
+	}
+}
+
+func TestFaultsBandwidth(t *testing.T) {
+	startTime := time.Now()
+	// 10 writes by 10 bytes at 500 bytes per second must take
+	// at least 180ms, since the first write isn't delayed.
+	testFaultsWrite(t, &Faults{Bandwidth: 500}, 10)
+	if d := time.Since(startTime); d < 180*time.Millisecond {
+		t.Fatalf("too small duration for writes with limited bandwidth: %s", d)
+	}
+}
+
+func TestFaultsStall(t *testing.T) {
+	startTime := time.Now()
+	data := testFaultsWrite(t, &Faults{StallRate: 1, StallDuration: 20 * time.Millisecond}, 3)
+	if d := time.Since(startTime); d < 60*time.Millisecond {
+		t.Fatalf("too small duration for stalled writes: %s", d)
+	}
+	if !bytes.Equal(data, bytes.Repeat(testFaultsData, 3)) {
+		t.Fatalf("stalled data must be written")
+	}
+}
+
+func TestFaultsStallWriteDeadline(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+	conn := (&Faults{StallRate: 1}).Conn(client)
+	defer conn.Close()
+
+	if err := conn.SetWriteDeadline(time.Now().Add(20 * time.Millisecond)); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	_, err := conn.Write(testFaultsData)
+	if netErr, ok := err.(net.Error); !ok || !netErr.Timeout() {
+		t.Fatalf("unexpected error: %v; expecting timeout error", err)
+	}
+}
+
+func TestFaultsPastWriteDeadline(t *testing.T) {
+	f := func(faults *Faults) {
+		t.Helper()
+		client, server := net.Pipe()
+		defer server.Close()
+		conn := faults.Conn(client)
+		defer conn.Close()
+
+		if err := conn.SetWriteDeadline(time.Now().Add(-time.Second)); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		writeCh := make(chan error, 1)
+		go func() {
+			_, err := conn.Write(testFaultsData)
+			writeCh <- err
+		}()
+		select {
+		case err := <-writeCh:
+			if netErr, ok := err.(net.Error); !ok || !netErr.Timeout() {
+				t.Fatalf("unexpected error: %v; expecting timeout error", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("write with past deadline must fail immediately")
+		}
+	}
+	f(&Faults{Latency: time.Hour})
+	f(&Faults{StallRate: 1})
+}
+
+func TestFaultsStallClose(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+	conn := (&Faults{StallRate: 1}).Conn(client)
+
+	writeCh := make(chan error, 1)
+	go func() {
+		_, err := conn.Write(testFaultsData)
+		writeCh <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	conn.Close()
+
+	select {
+	case err := <-writeCh:
+		if err != errClosed {
+			t.Fatalf("unexpected error: %v; expecting %v", err, errClosed)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("the stalled write must be interrupted by Close")
+	}
+}
+
+func TestFaultsSeed(t *testing.T) {
+	// Faults must be reproduced for equal seeds.
+	data1 := testFaultsWrite(t, &Faults{DropRate: 0.3, GarbageRate: 0.2, CorruptRate: 0.2, Seed: 42}, 100)
+	data2 := testFaultsWrite(t, &Faults{DropRate: 0.3, GarbageRate: 0.2, CorruptRate: 0.2, Seed: 42}, 100)
+	if !bytes.Equal(data1, data2) {
+		t.Fatalf("faults must be equal for equal seeds")
+	}
+	data3 := testFaultsWrite(t, &Faults{DropRate: 0.3, GarbageRate: 0.2, CorruptRate: 0.2, Seed: 43}, 100)
+	if bytes.Equal(data1, data3) {
+		t.Fatalf("faults must differ for distinct seeds")
+	}
+}
+
+func TestFaultsNetConn(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	conn := (&Faults{}).Conn(client)
+	nc, ok := conn.(interface{ NetConn() net.Conn })
+	if !ok {
+		t.Fatalf("the connection must implement NetConn")
+	}
+	if nc.NetConn() != client {
+		t.Fatalf("NetConn must return the original connection")
+	}
+}
+
+var testFaultsData = []byte("0123456789")
+
+// testFaultsWrite writes testFaultsData n times to the connection
+// with faults and returns the data read from the other end.
+func testFaultsWrite(t *testing.T, f *Faults, n int) []byte {
+	client, server := net.Pipe()
+	conn := f.Conn(client)
+
+	readCh := make(chan []byte, 1)
+	go func() {
+		data, _ := ioutil.ReadAll(server)
+		readCh <- data
+	}()
+
+	for i := 0; i < n; i++ {
+		if _, err := conn.Write(testFaultsData); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+	conn.Close()
+	return <-readCh
+}
@@ -0,0 +1,25 @@
+// Package faultconn injects network faults into net.Conn and net.Listener
+// for chaos testing.
+//
+// Faults describes latency, bandwidth caps, data drops, random disconnects,
+// byte corruption, partial writes and stalls. Faults are injected on writes,
+// so wrap both ends of connections for injecting faults in both directions.
+//
+// Use Faults.Dial result as fastrpc.Client.Dial and Faults.Listener
+// result in fastrpc.Server.Serve:
+//
+//	faults := &faultconn.Faults{
+//		Latency:   10 * time.Millisecond,
+//		ResetRate: 0.001,
+//		Seed:      42,
+//	}
+//	go s.Serve(faults.Listener(ln))
+//
+//	c := &fastrpc.Client{
+//		Dial: faults.Dial(fastrpc.Dial),
+//		...
+//	}
+//
+// Faults are driven by random number generators seeded with Faults.Seed,
+// so failures are reproduced for equal seeds.
+package faultconn
@@ -0,0 +1,149 @@
+package faultconn
+
+import (
+	"errors"
+	"math/rand"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// ErrConnReset is returned from Write calls on connections reset
+	// by Faults.
+	ErrConnReset = errors.New("faultconn: connection reset by fault injection")
+
+	errClosed = errors.New("faultconn: use of closed network connection")
+)
+
+// Faults describes faults injected into connections.
+//
+// Faults are injected on Write calls. Rates are probabilities
+// in the range [0..1] of the corresponding fault on each Write call.
+// At most a single fault is injected per call, so the sum of rates
+// mustn't exceed 1. Latency and Bandwidth are applied to all the calls.
+//
+// Faults mustn't be changed after the first Conn call.
+type Faults struct {
+	// Latency is added to each Write call.
+	Latency time.Duration
+
+	// LatencyJitter is the maximum random duration added to Latency.
+	LatencyJitter time.Duration
+
+	// Bandwidth is the maximum number of bytes per second written
+	// to each connection.
+	//
+	// Bandwidth isn't limited by default.
+	Bandwidth int
+
+	// DropRate is the probability of silently discarding the written data.
+	DropRate float64
+
+	// ResetRate is the probability of closing the connection
+	// instead of writing the data.
+	ResetRate float64
+
+	// GarbageRate is the probability of writing random bytes
+	// instead of the data.
+	GarbageRate float64
+
+	// CorruptRate is the probability of flipping a random bit
+	// in the written data.
+	CorruptRate float64
+
+	// PartialWriteRate is the probability of writing only a part
+	// of the data and closing the connection.
+	PartialWriteRate float64
+
+	// StallRate is the probability of stalling the Write call
+	// for StallDuration before writing the data.
+	StallRate float64
+
+	// StallDuration is the duration of stalls.
+	//
+	// Stalls last until the connection is closed or the write deadline
+	// is reached if StallDuration is zero.
+	StallDuration time.Duration
+
+	// Seed is the seed for random numbers generation.
+	//
+	// Each connection uses its own generator seeded with Seed
+	// and the connection number. Connections wrapped by Conn, Dial
+	// and Listener are numbered independently, so faults are reproduced
+	// for equal seeds and equal sequences of writes and connections
+	// on each side even if Faults is shared by the client and the server.
+	Seed int64
+
+	// conns, dials and accepts are the numbers of connections wrapped
+	// by Conn, Dial and Listener.
+	conns   uint32
+	dials   uint32
+	accepts uint32
+}
+
+// Connection sources, which are mixed into generator seeds.
+const (
+	sourceConn = iota
+	sourceDial
+	sourceAccept
+	sourceCount
+)
+
+// Conn returns conn with faults injected.
+//
+// The original connection is available via NetConn method
+// of the returned connection.
+func (f *Faults) Conn(conn net.Conn) net.Conn {
+	return f.newConn(conn, &f.conns, sourceConn)
+}
+
+// newConn returns conn with faults injected, which is numbered
+// with the given counter.
+func (f *Faults) newConn(conn net.Conn, counter *uint32, source int64) *faultConn {
+	n := int64(atomic.AddUint32(counter, 1))
+	c := &faultConn{
+		Conn:   conn,
+		f:      f,
+		rnd:    rand.New(rand.NewSource(f.Seed + n*sourceCount + source)),
+		closed: make(chan struct{}),
+	}
+	c.writeDeadline.Store(time.Time{})
+	return c
+}
+
+// Dial returns dial function, which injects faults into connections
+// established by the given dial.
+//
+// The returned function may be used as fastrpc.Client.Dial.
+func (f *Faults) Dial(dial func(addr string) (net.Conn, error)) func(addr string) (net.Conn, error) {
+	return func(addr string) (net.Conn, error) {
+		conn, err := dial(addr)
+		if err != nil {
+			return nil, err
+		}
+		return f.newConn(conn, &f.dials, sourceDial), nil
+	}
+}
+
+// Listener returns ln, which injects faults into accepted connections.
+func (f *Faults) Listener(ln net.Listener) net.Listener {
+	return &listener{
+		Listener: ln,
+		f:        f,
+	}
+}
+
+type listener struct {
+	net.Listener
+
+	f *Faults
+}
+
+func (ln *listener) Accept() (net.Conn, error) {
+	conn, err := ln.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return ln.f.newConn(conn, &ln.f.accepts, sourceAccept), nil
+}
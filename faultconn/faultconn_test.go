@@ -0,0 +1,126 @@
+package faultconn
+
+import (
+	"io"
+	"net"
+	"testing"
+)
+
+func TestFaultsListenerDial(t *testing.T) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("cannot listen: %s", err)
+	}
+	f := &Faults{}
+	fln := f.Listener(ln)
+	defer fln.Close()
+
+	acceptCh := make(chan error, 1)
+	go func() {
+		conn, err := fln.Accept()
+		if err != nil {
+			acceptCh <- err
+			return
+		}
+		defer conn.Close()
+		if _, ok := conn.(*faultConn); !ok {
+			acceptCh <- io.ErrUnexpectedEOF
+			return
+		}
+		_, err = io.Copy(conn, conn)
+		acceptCh <- err
+	}()
+
+	dial := f.Dial(func(addr string) (net.Conn, error) {
+		return net.Dial("tcp4", addr)
+	})
+	conn, err := dial(ln.Addr().String())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, ok := conn.(*faultConn); !ok {
+		t.Fatalf("dialed connection must inject faults")
+	}
+	if _, err := conn.Write([]byte("foobar")); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	var buf [6]byte
+	if _, err := io.ReadFull(conn, buf[:]); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if string(buf[:]) != "foobar" {
+		t.Fatalf("unexpected data read: %q; expecting %q", buf[:], "foobar")
+	}
+	conn.Close()
+	if err := <-acceptCh; err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if _, err := dial("127.0.0.1:1"); err == nil {
+		t.Fatalf("expecting dial error")
+	}
+}
+
+func TestFaultsSeedDialListener(t *testing.T) {
+	// The sequence of dialed connections must get equal faults
+	// regardless of connections accepted via the same Faults.
+	dialSeq := func(f *Faults, accepts int) []int64 {
+		t.Helper()
+		ln := f.Listener(&testPipeListener{})
+		for i := 0; i < accepts; i++ {
+			conn, err := ln.Accept()
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			conn.Close()
+		}
+		dial := f.Dial(func(addr string) (net.Conn, error) {
+			client, server := net.Pipe()
+			server.Close()
+			return client, nil
+		})
+		var seq []int64
+		for i := 0; i < 3; i++ {
+			conn, err := dial("foo")
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			seq = append(seq, conn.(*faultConn).rnd.Int63())
+			conn.Close()
+		}
+		return seq
+	}
+
+	seq1 := dialSeq(&Faults{Seed: 42}, 0)
+	seq2 := dialSeq(&Faults{Seed: 42}, 5)
+	for i := range seq1 {
+		if seq1[i] != seq2[i] {
+			t.Fatalf("unexpected random sequence for connection #%d: %d; expecting %d", i, seq2[i], seq1[i])
+		}
+	}
+
+	// Dialed and accepted connections must get distinct faults.
+	f := &Faults{Seed: 42}
+	dialed := f.newConn(nil, &f.dials, sourceDial)
+	accepted := f.newConn(nil, &f.accepts, sourceAccept)
+	if dialed.rnd.Int63() == accepted.rnd.Int63() {
+		t.Fatalf("dialed and accepted connections must have distinct random sequences")
+	}
+}
+
+// testPipeListener accepts in-memory connections.
+type testPipeListener struct{}
+
+func (ln *testPipeListener) Accept() (net.Conn, error) {
+	client, server := net.Pipe()
+	client.Close()
+	return server, nil
+}
+
+func (ln *testPipeListener) Close() error {
+	return nil
+}
+
+func (ln *testPipeListener) Addr() net.Addr {
+	return &net.UnixAddr{Name: "pipe", Net: "unix"}
+}
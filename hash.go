@@ -0,0 +1,40 @@
+package fastrpc
+
+// pickBackendByKey returns the backend for the given key hash using
+// rendezvous (highest random weight) hashing.
+//
+// Only keys owned by the removed backend are remapped when backends
+// are removed, and only keys taken by the new backend are remapped
+// when backends are added.
+func pickBackendByKey(backends []*multiClientBackend, keyHash uint64) *multiClientBackend {
+	var best *multiClientBackend
+	var bestScore uint64
+	for _, b := range backends {
+		score := mix64(keyHash ^ b.addrHash)
+		if best == nil || score > bestScore {
+			best, bestScore = b, score
+		}
+	}
+	return best
+}
+
+// hash64 returns FNV-1a hash for b.
+func hash64(b []byte) uint64 {
+	h := uint64(14695981039346656037)
+	for _, c := range b {
+		h ^= uint64(c)
+		h *= 1099511628211
+	}
+	return h
+}
+
+// mix64 is the splitmix64 finalizer. It spreads hash bits, so scores
+// for similar key and address hashes are independent.
+func mix64(h uint64) uint64 {
+	h ^= h >> 30
+	h *= 0xbf58476d1ce4e5b9
+	h ^= h >> 27
+	h *= 0x94d049bb133111eb
+	h ^= h >> 31
+	return h
+}
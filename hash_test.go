@@ -0,0 +1,41 @@
+package fastrpc
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestPickBackendByKeyMinimalRemapping(t *testing.T) {
+	var backends []*multiClientBackend
+	for i := 0; i < 10; i++ {
+		addr := fmt.Sprintf("10.0.0.%d:1234", i)
+		backends = append(backends, &multiClientBackend{
+			addr:     addr,
+			addrHash: hash64([]byte(addr)),
+		})
+	}
+
+	const keysCount = 10000
+	owners := make([]string, keysCount)
+	counts := make(map[string]int)
+	for i := 0; i < keysCount; i++ {
+		b := pickBackendByKey(backends, hash64([]byte(fmt.Sprintf("key %d", i))))
+		owners[i] = b.addr
+		counts[b.addr]++
+	}
+	for _, b := range backends {
+		if n := counts[b.addr]; n < keysCount/20 {
+			t.Fatalf("too few keys mapped to %q: %d", b.addr, n)
+		}
+	}
+
+	// Remove a backend. Only its keys must be remapped.
+	removed := backends[3]
+	backends = append(backends[:3:3], backends[4:]...)
+	for i := 0; i < keysCount; i++ {
+		b := pickBackendByKey(backends, hash64([]byte(fmt.Sprintf("key %d", i))))
+		if owners[i] != removed.addr && b.addr != owners[i] {
+			t.Fatalf("key %d moved from %q to %q", i, owners[i], b.addr)
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package fastrpc
+
+import (
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/UladzimirTrehubenka/fastrpc/tlv"
+	"github.com/valyala/fasthttp/fasthttputil"
+)
+
+func TestServerIdleTimeout(t *testing.T) {
+	s := &Server{
+		NewHandlerCtx: newTestHandlerCtx,
+		Handler:       testEchoHandler,
+		IdleTimeout:   100 * time.Millisecond,
+	}
+	serverStop, ln := newTestServerExt(s)
+	c, dials := newTestCountingClient(ln)
+
+	if err := testDoEcho(c, "foo"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	time.Sleep(300 * time.Millisecond)
+
+	// The client must transparently re-connect after the server closed
+	// the idle connection.
+	for i := 0; i < 3; i++ {
+		if err := testDoEcho(c, "bar"); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+	if n := atomic.LoadUint32(dials); n != 2 {
+		t.Fatalf("unexpected number of dials: %d; expecting 2", n)
+	}
+	if err := c.getError(nil); err != nil {
+		t.Fatalf("idle connection closing mustn't be reported as error: %s", err)
+	}
+
+	if err := serverStop(); err != nil {
+		t.Fatalf("cannot shutdown server: %s", err)
+	}
+}
+
+func TestServerIdleTimeoutPings(t *testing.T) {
+	s := &Server{
+		NewHandlerCtx: newTestHandlerCtx,
+		Handler:       testEchoHandler,
+		IdleTimeout:   100 * time.Millisecond,
+	}
+	serverStop, ln := newTestServerExt(s)
+	c, dials := newTestCountingClient(ln)
+	c.PingInterval = 20 * time.Millisecond
+
+	if err := testDoEcho(c, "foo"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	time.Sleep(300 * time.Millisecond)
+
+	// Pings mustn't keep the idle connection open.
+	if err := testDoEcho(c, "bar"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if n := atomic.LoadUint32(dials); n != 2 {
+		t.Fatalf("unexpected number of dials: %d; expecting 2", n)
+	}
+
+	if err := serverStop(); err != nil {
+		t.Fatalf("cannot shutdown server: %s", err)
+	}
+}
+
+func TestServerIdleTimeoutSlowHandler(t *testing.T) {
+	s := &Server{
+		NewHandlerCtx: newTestHandlerCtx,
+		Handler: func(ctxv HandlerCtx) HandlerCtx {
+			time.Sleep(300 * time.Millisecond)
+			return testEchoHandler(ctxv)
+		},
+		IdleTimeout: 100 * time.Millisecond,
+	}
+	serverStop, ln := newTestServerExt(s)
+	c, dials := newTestCountingClient(ln)
+
+	// Connections with requests being processed aren't idle.
+	if err := testDoEcho(c, "foo"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if n := atomic.LoadUint32(dials); n != 1 {
+		t.Fatalf("unexpected number of dials: %d; expecting 1", n)
+	}
+
+	if err := serverStop(); err != nil {
+		t.Fatalf("cannot shutdown server: %s", err)
+	}
+}
+
+func TestServerIdleTimeoutReadTimeout(t *testing.T) {
+	s := &Server{
+		NewHandlerCtx: newTestHandlerCtx,
+		Handler:       testEchoHandler,
+		ReadTimeout:   100 * time.Millisecond,
+		IdleTimeout:   time.Hour,
+	}
+	serverStop, ln := newTestServerExt(s)
+
+	conn, err := ln.Dial()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// Send incomplete request, so ReadTimeout must close the connection
+	// despite IdleTimeout.
+	if _, err := conn.Write([]byte{1, 0, 0, 0, 10}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var buf [1]byte
+	if _, err := conn.Read(buf[:]); err == nil {
+		t.Fatalf("expecting closed connection")
+	} else if isTimeoutError(err) {
+		t.Fatalf("the connection isn't closed on read timeout")
+	}
+	conn.Close()
+
+	if err := serverStop(); err != nil {
+		t.Fatalf("cannot shutdown server: %s", err)
+	}
+}
+
+func TestClientIdleTimeout(t *testing.T) {
+	serverStop, ln := newTestServer(testEchoHandler)
+	c, dials := newTestCountingClient(ln)
+	c.IdleTimeout = 100 * time.Millisecond
+
+	if err := testDoEcho(c, "foo"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	time.Sleep(300 * time.Millisecond)
+
+	// The idle connection must be closed, so the next request
+	// must establish new connection.
+	if err := testDoEcho(c, "bar"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if n := atomic.LoadUint32(dials); n != 2 {
+		t.Fatalf("unexpected number of dials: %d; expecting 2", n)
+	}
+	if n := c.Stats().Reconnects; n != 0 {
+		t.Fatalf("idle connection closing mustn't be counted as reconnect; got %d reconnects", n)
+	}
+
+	if err := serverStop(); err != nil {
+		t.Fatalf("cannot shutdown server: %s", err)
+	}
+}
+
+func TestClientIdleTimeoutReadTimeout(t *testing.T) {
+	serverStop, ln := newTestServer(testEchoHandler)
+	c, dials := newTestCountingClient(ln)
+	c.ReadTimeout = 50 * time.Millisecond
+	c.IdleTimeout = time.Hour
+
+	// ReadTimeout mustn't close idle connection if IdleTimeout is set.
+	for i := 0; i < 3; i++ {
+		if err := testDoEcho(c, "foo"); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+	if n := atomic.LoadUint32(dials); n != 1 {
+		t.Fatalf("unexpected number of dials: %d; expecting 1", n)
+	}
+
+	if err := serverStop(); err != nil {
+		t.Fatalf("cannot shutdown server: %s", err)
+	}
+}
+
+func TestClientIdleTimeoutSlowResponse(t *testing.T) {
+	serverStop, ln := newTestServer(func(ctxv HandlerCtx) HandlerCtx {
+		time.Sleep(300 * time.Millisecond)
+		return testEchoHandler(ctxv)
+	})
+	c, _ := newTestCountingClient(ln)
+	c.IdleTimeout = 100 * time.Millisecond
+
+	// Connections with pending responses aren't idle.
+	var req tlv.Request
+	var resp tlv.Response
+	req.SwapValue([]byte("foo"))
+	if err := c.DoDeadline(&req, &resp, time.Now().Add(5*time.Second)); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if err := serverStop(); err != nil {
+		t.Fatalf("cannot shutdown server: %s", err)
+	}
+}
+
+func newTestCountingClient(ln *fasthttputil.InmemoryListener) (*Client, *uint32) {
+	var dials uint32
+	c := newTestClient(ln)
+	c.Dial = func(addr string) (net.Conn, error) {
+		atomic.AddUint32(&dials, 1)
+		return ln.Dial()
+	}
+	return c, &dials
+}
@@ -0,0 +1,51 @@
+// Package netutil contains network helpers shared by fastrpc packages.
+package netutil
+
+import (
+	"net"
+)
+
+// LocalIP is the client IP for unix socket connections.
+var LocalIP = net.IPv4(127, 0, 0, 1)
+
+// RemoteIP returns the client IP for the given remote address.
+//
+// LocalIP is returned for unix socket addresses, since the client
+// is always local. So unix socket clients share per-IP limits
+// with each other and with TCP clients connected via 127.0.0.1.
+//
+// nil is returned for other non-TCP addresses.
+func RemoteIP(addr net.Addr) net.IP {
+	switch x := addr.(type) {
+	case *net.TCPAddr:
+		return x.IP
+	case *net.UnixAddr:
+		return LocalIP
+	default:
+		return nil
+	}
+}
+
+// ConnRemoteIP returns the client IP for the given connection.
+//
+// Connections with non-TCP and non-unix remote addresses are unwrapped
+// via NetConn method, so e.g. shared memory connections are attributed
+// to their control unix socket connections.
+//
+// nil is returned if no unwrapped connection has TCP or unix remote
+// address.
+func ConnRemoteIP(conn net.Conn) net.IP {
+	for conn != nil {
+		if ip := RemoteIP(conn.RemoteAddr()); ip != nil {
+			return ip
+		}
+		uc, ok := conn.(interface {
+			NetConn() net.Conn
+		})
+		if !ok {
+			break
+		}
+		conn = uc.NetConn()
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package netutil
+
+import (
+	"net"
+	"testing"
+)
+
+func TestRemoteIP(t *testing.T) {
+	f := func(addr net.Addr, expected net.IP) {
+		t.Helper()
+		ip := RemoteIP(addr)
+		if !ip.Equal(expected) {
+			t.Fatalf("unexpected ip for %v: %s. Expecting %s", addr, ip, expected)
+		}
+	}
+	f(&net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 1234}, net.IPv4(10, 0, 0, 1))
+	f(&net.TCPAddr{IP: net.IPv6loopback, Port: 1234}, net.IPv6loopback)
+	f(&net.UnixAddr{Name: "@", Net: "unix"}, LocalIP)
+	f(&net.UnixAddr{Name: "/tmp/sock", Net: "unix"}, LocalIP)
+	f(&net.UDPAddr{IP: net.IPv4(10, 0, 0, 1)}, nil)
+	f(nil, nil)
+}
+
+type testWrappedConn struct {
+	net.Conn
+	netConn net.Conn
+}
+
+func (c *testWrappedConn) RemoteAddr() net.Addr {
+	return nil
+}
+
+func (c *testWrappedConn) NetConn() net.Conn {
+	return c.netConn
+}
+
+func TestConnRemoteIP(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("cannot listen: %s", err)
+	}
+	defer ln.Close()
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("cannot dial: %s", err)
+	}
+	defer conn.Close()
+
+	f := func(conn net.Conn, expected net.IP) {
+		t.Helper()
+		ip := ConnRemoteIP(conn)
+		if !ip.Equal(expected) {
+			t.Fatalf("unexpected ip: %s. Expecting %s", ip, expected)
+		}
+	}
+	f(conn, net.IPv4(127, 0, 0, 1))
+	f(&testWrappedConn{netConn: conn}, net.IPv4(127, 0, 0, 1))
+	f(&testWrappedConn{}, nil)
+	f(nil, nil)
+}
@@ -0,0 +1,36 @@
+package netutil
+
+import (
+	"errors"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// RemoveStaleUnixSocket removes the unix socket file at path
+// if nobody listens on it, e.g. after the process crash.
+//
+// The listener is probed with the given dial timeout. The file is removed
+// only if the connection is refused, since other dial errors such as
+// EAGAIN for the full listen backlog may be returned for live listeners.
+func RemoveStaleUnixSocket(path string, timeout time.Duration) {
+	if strings.HasPrefix(path, "@") {
+		// Abstract sockets have no files.
+		return
+	}
+	fi, err := os.Lstat(path)
+	if err != nil || fi.Mode()&os.ModeSocket == 0 {
+		return
+	}
+	conn, err := net.DialTimeout("unix", path, timeout)
+	if err == nil {
+		// The socket is in use.
+		conn.Close()
+		return
+	}
+	if errors.Is(err, syscall.ECONNREFUSED) {
+		os.Remove(path)
+	}
+}
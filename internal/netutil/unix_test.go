@@ -0,0 +1,56 @@
+package netutil
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRemoveStaleUnixSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fastrpc-netutil")
+	if err != nil {
+		t.Fatalf("cannot create temporary dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The socket of the live listener must be left alone.
+	livePath := filepath.Join(dir, "live.sock")
+	ln, err := net.Listen("unix", livePath)
+	if err != nil {
+		t.Fatalf("cannot listen: %s", err)
+	}
+	defer ln.Close()
+	RemoveStaleUnixSocket(livePath, time.Second)
+	if _, err := os.Lstat(livePath); err != nil {
+		t.Fatalf("the socket of the live listener mustn't be removed: %s", err)
+	}
+
+	// The socket without a listener must be removed.
+	stalePath := filepath.Join(dir, "stale.sock")
+	staleLn, err := net.Listen("unix", stalePath)
+	if err != nil {
+		t.Fatalf("cannot listen: %s", err)
+	}
+	staleLn.(*net.UnixListener).SetUnlinkOnClose(false)
+	staleLn.Close()
+	if _, err := os.Lstat(stalePath); err != nil {
+		t.Fatalf("the socket file must exist after closing the listener: %s", err)
+	}
+	RemoveStaleUnixSocket(stalePath, time.Second)
+	if _, err := os.Lstat(stalePath); !os.IsNotExist(err) {
+		t.Fatalf("the stale socket must be removed; got %v", err)
+	}
+
+	// Regular files must be left alone.
+	filePath := filepath.Join(dir, "file")
+	if err := ioutil.WriteFile(filePath, []byte("foo"), 0600); err != nil {
+		t.Fatalf("cannot create file: %s", err)
+	}
+	RemoveStaleUnixSocket(filePath, time.Second)
+	if _, err := os.Lstat(filePath); err != nil {
+		t.Fatalf("regular file mustn't be removed: %s", err)
+	}
+}
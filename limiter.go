@@ -0,0 +1,222 @@
+package fastrpc
+
+import (
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// DefaultInitialConcurrencyLimit is the default initial limit
+	// for AIMDLimiter and GradientLimiter.
+	DefaultInitialConcurrencyLimit = 20
+
+	// DefaultMinConcurrencyLimit is the default minimum limit
+	// for AIMDLimiter and GradientLimiter.
+	DefaultMinConcurrencyLimit = 1
+)
+
+// ConcurrencyLimiter adaptively limits the number of pending requests
+// a Client may issue.
+//
+// Client returns ErrPendingRequestsOverflow if the number of pending
+// requests exceeds Limit.
+type ConcurrencyLimiter interface {
+	// Limit must return the current maximum number of pending requests.
+	//
+	// Limit is called for each request, so it must be fast.
+	Limit() int
+
+	// OnSample is called for each request sent to the server.
+	//
+	// rtt is the duration between sending the request to the server
+	// and receiving the response. inflight is the number of pending
+	// requests at the time the response is received. dropped is set
+	// if the request timed out before receiving the response.
+	OnSample(rtt time.Duration, inflight int, dropped bool)
+}
+
+// AIMDLimiter is additive-increase/multiplicative-decrease
+// ConcurrencyLimiter.
+//
+// The limit is increased by one for each successful sample if more than
+// half of the limit is in use. The limit is multiplied by BackoffRatio
+// for each dropped sample or each sample exceeding LatencyThreshold.
+type AIMDLimiter struct {
+	// InitialLimit is the initial limit.
+	//
+	// DefaultInitialConcurrencyLimit is used by default.
+	InitialLimit int
+
+	// MinLimit is the minimum limit.
+	//
+	// DefaultMinConcurrencyLimit is used by default.
+	MinLimit int
+
+	// MaxLimit is the maximum limit.
+	//
+	// DefaultMaxPendingRequests is used by default.
+	MaxLimit int
+
+	// BackoffRatio is the ratio the limit is multiplied by on overload.
+	//
+	// 0.9 is used by default.
+	BackoffRatio float64
+
+	// LatencyThreshold is the maximum rtt for successful samples.
+	//
+	// By default only dropped samples decrease the limit.
+	LatencyThreshold time.Duration
+
+	mu    sync.Mutex
+	limit int32
+}
+
+// Limit implements ConcurrencyLimiter.
+func (l *AIMDLimiter) Limit() int {
+	if limit := atomic.LoadInt32(&l.limit); limit > 0 {
+		return int(limit)
+	}
+	return limitOrDefault(l.InitialLimit, DefaultInitialConcurrencyLimit)
+}
+
+// OnSample implements ConcurrencyLimiter.
+func (l *AIMDLimiter) OnSample(rtt time.Duration, inflight int, dropped bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	limit := l.Limit()
+	if dropped || (l.LatencyThreshold > 0 && rtt > l.LatencyThreshold) {
+		backoffRatio := l.BackoffRatio
+		if backoffRatio <= 0 || backoffRatio >= 1 {
+			backoffRatio = 0.9
+		}
+		limit = int(float64(limit) * backoffRatio)
+	} else if 2*inflight >= limit {
+		limit++
+	}
+	limit = clampLimit(limit, l.MinLimit, l.MaxLimit)
+	atomic.StoreInt32(&l.limit, int32(limit))
+}
+
+// GradientLimiter is ConcurrencyLimiter adjusting the limit by the ratio
+// between long-term and short-term rtt.
+//
+// The limit decreases when the current rtt grows above the long-term rtt
+// multiplied by Tolerance, i.e. when requests start queueing on the server.
+// Otherwise the limit grows by the square root of the limit.
+type GradientLimiter struct {
+	// InitialLimit is the initial limit.
+	//
+	// DefaultInitialConcurrencyLimit is used by default.
+	InitialLimit int
+
+	// MinLimit is the minimum limit.
+	//
+	// DefaultMinConcurrencyLimit is used by default.
+	MinLimit int
+
+	// MaxLimit is the maximum limit.
+	//
+	// DefaultMaxPendingRequests is used by default.
+	MaxLimit int
+
+	// Tolerance is the ratio of the current rtt to the long-term rtt,
+	// which is tolerated before decreasing the limit.
+	//
+	// 1.5 is used by default.
+	Tolerance float64
+
+	// Smoothing is the weight of the new limit applied on each sample.
+	//
+	// 0.2 is used by default.
+	Smoothing float64
+
+	// LongWindow is the number of samples the long-term rtt is averaged
+	// over.
+	//
+	// 600 is used by default.
+	LongWindow int
+
+	mu      sync.Mutex
+	limit   int32
+	limitF  float64
+	longRTT float64
+}
+
+// Limit implements ConcurrencyLimiter.
+func (l *GradientLimiter) Limit() int {
+	if limit := atomic.LoadInt32(&l.limit); limit > 0 {
+		return int(limit)
+	}
+	return limitOrDefault(l.InitialLimit, DefaultInitialConcurrencyLimit)
+}
+
+// OnSample implements ConcurrencyLimiter.
+func (l *GradientLimiter) OnSample(rtt time.Duration, inflight int, dropped bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.limitF == 0 {
+		l.limitF = float64(l.Limit())
+	}
+
+	shortRTT := float64(rtt)
+	if l.longRTT == 0 {
+		l.longRTT = shortRTT
+	}
+	longWindow := l.LongWindow
+	if longWindow <= 0 {
+		longWindow = 600
+	}
+	factor := 2 / float64(longWindow+1)
+	l.longRTT = l.longRTT*(1-factor) + shortRTT*factor
+
+	// Do not increase the limit if it isn't in use.
+	if !dropped && 2*float64(inflight) < l.limitF {
+		return
+	}
+
+	tolerance := l.Tolerance
+	if tolerance <= 0 {
+		tolerance = 1.5
+	}
+	gradient := 0.5
+	if !dropped && shortRTT > 0 {
+		gradient = math.Max(0.5, math.Min(1, tolerance*l.longRTT/shortRTT))
+	}
+	newLimit := l.limitF*gradient + math.Sqrt(l.limitF)
+
+	smoothing := l.Smoothing
+	if smoothing <= 0 || smoothing > 1 {
+		smoothing = 0.2
+	}
+	l.limitF = l.limitF*(1-smoothing) + newLimit*smoothing
+
+	// limitF isn't rounded, so increments below 1 accumulate
+	// at small limits instead of being truncated on each sample.
+	minLimit := float64(limitOrDefault(l.MinLimit, DefaultMinConcurrencyLimit))
+	maxLimit := float64(limitOrDefault(l.MaxLimit, DefaultMaxPendingRequests))
+	l.limitF = math.Max(minLimit, math.Min(maxLimit, l.limitF))
+	atomic.StoreInt32(&l.limit, int32(math.Round(l.limitF)))
+}
+
+func limitOrDefault(limit, defaultLimit int) int {
+	if limit <= 0 {
+		return defaultLimit
+	}
+	return limit
+}
+
+func clampLimit(limit, minLimit, maxLimit int) int {
+	minLimit = limitOrDefault(minLimit, DefaultMinConcurrencyLimit)
+	maxLimit = limitOrDefault(maxLimit, DefaultMaxPendingRequests)
+	if limit < minLimit {
+		return minLimit
+	}
+	if limit > maxLimit {
+		return maxLimit
+	}
+	return limit
+}
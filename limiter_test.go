@@ -0,0 +1,193 @@
+package fastrpc
+
+import (
+	"testing"
+	"time"
+
+	"github.com/UladzimirTrehubenka/fastrpc/tlv"
+)
+
+func TestAIMDLimiter(t *testing.T) {
+	l := &AIMDLimiter{
+		InitialLimit:     10,
+		MaxLimit:         12,
+		LatencyThreshold: 100 * time.Millisecond,
+	}
+	if n := l.Limit(); n != 10 {
+		t.Fatalf("unexpected initial limit: %d. Expecting 10", n)
+	}
+
+	// The limit mustn't grow if it isn't in use.
+	l.OnSample(time.Millisecond, 1, false)
+	if n := l.Limit(); n != 10 {
+		t.Fatalf("unexpected limit: %d. Expecting 10", n)
+	}
+
+	for i := 0; i < 5; i++ {
+		l.OnSample(time.Millisecond, 10, false)
+	}
+	if n := l.Limit(); n != 12 {
+		t.Fatalf("unexpected limit: %d. Expecting 12", n)
+	}
+
+	l.OnSample(time.Second, 12, false)
+	if n := l.Limit(); n != 10 {
+		t.Fatalf("unexpected limit: %d. Expecting 10", n)
+	}
+
+	for i := 0; i < 100; i++ {
+		l.OnSample(time.Millisecond, 10, true)
+	}
+	if n := l.Limit(); n != DefaultMinConcurrencyLimit {
+		t.Fatalf("unexpected limit: %d. Expecting %d", n, DefaultMinConcurrencyLimit)
+	}
+}
+
+func TestGradientLimiter(t *testing.T) {
+	l := &GradientLimiter{
+		InitialLimit: 50,
+		LongWindow:   100,
+	}
+
+	// The limit grows while rtt is stable.
+	for i := 0; i < 100; i++ {
+		l.OnSample(10*time.Millisecond, l.Limit(), false)
+	}
+	grown := l.Limit()
+	if grown <= 50 {
+		t.Fatalf("the limit must grow with stable rtt; got %d", grown)
+	}
+
+	// The limit shrinks when rtt increases.
+	for i := 0; i < 20; i++ {
+		l.OnSample(100*time.Millisecond, l.Limit(), false)
+	}
+	if n := l.Limit(); n >= grown {
+		t.Fatalf("the limit must shrink with growing rtt; got %d, was %d", n, grown)
+	}
+}
+
+func TestGradientLimiterSmallLimits(t *testing.T) {
+	l := &GradientLimiter{}
+	initial := l.Limit()
+
+	// Increments below 1 per sample must accumulate at small limits.
+	for i := 0; i < 100; i++ {
+		l.OnSample(10*time.Millisecond, l.Limit(), false)
+	}
+	if n := l.Limit(); n <= initial {
+		t.Fatalf("the limit must grow under healthy samples; got %d, was %d", n, initial)
+	}
+
+	for i := 0; i < 1000 && l.Limit() > 5; i++ {
+		l.OnSample(10*time.Millisecond, l.Limit(), true)
+	}
+	if n := l.Limit(); n > 5 {
+		t.Fatalf("the limit must back off on drops; got %d", n)
+	}
+
+	// The limit must recover after the backoff.
+	for i := 0; i < 200; i++ {
+		l.OnSample(10*time.Millisecond, l.Limit(), false)
+	}
+	if n := l.Limit(); n <= initial {
+		t.Fatalf("the limit must recover after the backoff; got %d, expecting more than %d", n, initial)
+	}
+}
+
+func TestClientConcurrencyLimiter(t *testing.T) {
+	stopCh := make(chan struct{})
+	h := func(ctx HandlerCtx) HandlerCtx {
+		<-stopCh
+		return ctx
+	}
+	serverStop, c := newTestServerClient(h)
+	c.ConcurrencyLimiter = &AIMDLimiter{
+		InitialLimit: 5,
+	}
+
+	resultCh := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		go func() {
+			var req tlv.Request
+			var resp tlv.Response
+			resultCh <- c.DoDeadline(&req, &resp, time.Now().Add(time.Second))
+		}()
+	}
+
+	for i := 0; i < 5; i++ {
+		select {
+		case err := <-resultCh:
+			if err != ErrPendingRequestsOverflow {
+				t.Fatalf("unexpected error: %v. Expecting %s", err, ErrPendingRequestsOverflow)
+			}
+
+		case <-time.After(time.Second):
+			t.Fatalf("timeout")
+		}
+	}
+
+	close(stopCh)
+	for i := 0; i < 5; i++ {
+		select {
+		case err := <-resultCh:
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timeout")
+		}
+	}
+
+	if err := serverStop(); err != nil {
+		t.Fatalf("cannot shutdown server: %s", err)
+	}
+}
+
+func TestClientMaxPendingRequests(t *testing.T) {
+	stopCh := make(chan struct{})
+	h := func(ctx HandlerCtx) HandlerCtx {
+		<-stopCh
+		return ctx
+	}
+	serverStop, c := newTestServerClient(h)
+	c.MaxPendingRequests = 5
+
+	// Exactly MaxPendingRequests requests must be admitted.
+	resultCh := make(chan error, 5)
+	for i := 0; i < 5; i++ {
+		go func() {
+			var req tlv.Request
+			var resp tlv.Response
+			resultCh <- c.DoDeadline(&req, &resp, time.Now().Add(time.Second))
+		}()
+	}
+	for i := 0; i < 100 && c.PendingRequests() < 5; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := c.PendingRequests(); n != 5 {
+		t.Fatalf("unexpected number of pending requests: %d. Expecting 5", n)
+	}
+
+	var req tlv.Request
+	var resp tlv.Response
+	if err := c.DoDeadline(&req, &resp, time.Now().Add(time.Second)); err != ErrPendingRequestsOverflow {
+		t.Fatalf("unexpected error: %v. Expecting %s", err, ErrPendingRequestsOverflow)
+	}
+
+	close(stopCh)
+	for i := 0; i < 5; i++ {
+		select {
+		case err := <-resultCh:
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timeout")
+		}
+	}
+
+	if err := serverStop(); err != nil {
+		t.Fatalf("cannot shutdown server: %s", err)
+	}
+}
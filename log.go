@@ -0,0 +1,51 @@
+package fastrpc
+
+import (
+	"os"
+
+	"github.com/UladzimirTrehubenka/fastrpc/rpclog"
+)
+
+// Logger is a leveled structured logger used by Client and Server.
+//
+// *slog.Logger implements Logger. See rpclog package for adapters.
+type Logger = rpclog.Logger
+
+var defaultLogger = rpclog.New(os.Stderr, rpclog.LevelInfo)
+
+// newConnLogger returns logger adding connection fields to each message.
+func newConnLogger(logger Logger, connID uint64, laddr, raddr string) Logger {
+	return rpclog.With(logger, "conn_id", connID, "local_addr", laddr, "remote_addr", raddr)
+}
+
+// requestLogger adds the request nonce to each message logged
+// via the connection logger.
+//
+// It is embedded into serverWorkItem, so it doesn't allocate
+// per request.
+type requestLogger struct {
+	logger Logger
+	nonce  uint32
+}
+
+func (rl *requestLogger) Debug(msg string, keyvals ...interface{}) {
+	rl.logger.Debug(msg, rl.append(keyvals)...)
+}
+
+func (rl *requestLogger) Info(msg string, keyvals ...interface{}) {
+	rl.logger.Info(msg, rl.append(keyvals)...)
+}
+
+func (rl *requestLogger) Warn(msg string, keyvals ...interface{}) {
+	rl.logger.Warn(msg, rl.append(keyvals)...)
+}
+
+func (rl *requestLogger) Error(msg string, keyvals ...interface{}) {
+	rl.logger.Error(msg, rl.append(keyvals)...)
+}
+
+func (rl *requestLogger) append(keyvals []interface{}) []interface{} {
+	kvs := make([]interface{}, 0, len(keyvals)+2)
+	kvs = append(kvs, "nonce", rl.nonce)
+	return append(kvs, keyvals...)
+}
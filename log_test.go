@@ -0,0 +1,99 @@
+package fastrpc
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/UladzimirTrehubenka/fastrpc/rpclog"
+	"github.com/UladzimirTrehubenka/fastrpc/tlv"
+)
+
+type testLogLines struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (tl *testLogLines) Printf(format string, args ...interface{}) {
+	tl.mu.Lock()
+	tl.lines = append(tl.lines, fmt.Sprintf(format, args...))
+	tl.mu.Unlock()
+}
+
+func (tl *testLogLines) find(substr string) string {
+	tl.mu.Lock()
+	defer tl.mu.Unlock()
+	for _, line := range tl.lines {
+		if strings.Contains(line, substr) {
+			return line
+		}
+	}
+	return ""
+}
+
+func TestServerRequestLogger(t *testing.T) {
+	var tl testLogLines
+	s := &Server{
+		NewHandlerCtx: newTestHandlerCtx,
+		Handler: func(ctxv HandlerCtx) HandlerCtx {
+			ctx := ctxv.(*tlv.RequestCtx)
+			ctx.Logger().Info("handling request", "value", string(ctx.Request.Value()))
+			ctx.Write(ctx.Request.Value())
+			return ctx
+		},
+		Logger: rpclog.FromPrintfLogger(&tl, rpclog.LevelDebug),
+	}
+	serverStop, ln := newTestServerExt(s)
+	c := newTestClient(ln)
+
+	var req tlv.Request
+	var resp tlv.Response
+	req.SwapValue([]byte("foobar"))
+	if err := c.DoDeadline(&req, &resp, time.Now().Add(time.Second)); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	line := tl.find("handling request")
+	if line == "" {
+		t.Fatalf("missing handler log line in %q", tl.lines)
+	}
+	for _, field := range []string{"INFO ", " conn_id=1 ", " local_addr=", " remote_addr=", " nonce=1 ", " value=foobar"} {
+		if !strings.Contains(line, field) {
+			t.Fatalf("missing %q in log line %q", field, line)
+		}
+	}
+	if line := tl.find("accepted connection"); !strings.HasPrefix(line, "DEBUG ") || !strings.Contains(line, " conn_id=1 ") {
+		t.Fatalf("unexpected accepted connection log line: %q", line)
+	}
+
+	if err := serverStop(); err != nil {
+		t.Fatalf("cannot stop server: %s", err)
+	}
+}
+
+func TestClientReconnectLogLevel(t *testing.T) {
+	var tl testLogLines
+	c := &Client{
+		NewResponse: newTestResponse,
+		Dial: func(addr string) (net.Conn, error) {
+			return nil, fmt.Errorf("no dial")
+		},
+		Logger: rpclog.FromPrintfLogger(&tl, rpclog.LevelDebug),
+	}
+
+	var req tlv.Request
+	var resp tlv.Response
+	if err := c.DoDeadline(&req, &resp, time.Now().Add(50*time.Millisecond)); err == nil {
+		t.Fatalf("expecting non-nil error")
+	}
+	c.Close()
+
+	// Routine reconnects mustn't be logged above debug level.
+	line := tl.find("cannot connect")
+	if !strings.HasPrefix(line, "DEBUG ") {
+		t.Fatalf("unexpected dial error log line: %q", line)
+	}
+}
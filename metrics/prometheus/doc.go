@@ -0,0 +1,9 @@
+// Package prometheus exports fastrpc Client and Server metrics
+// to Prometheus.
+//
+// RegisterClient and RegisterServer must be called before the Client
+// or the Server is used, since they install fastrpc hooks.
+//
+// Request latencies are labeled by tlv opcode. Requests of other types
+// are labeled with empty opcode.
+package prometheus
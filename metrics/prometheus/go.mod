@@ -0,0 +1,25 @@
+module github.com/UladzimirTrehubenka/fastrpc/metrics/prometheus
+
+go 1.20
+
+require (
+	github.com/UladzimirTrehubenka/fastrpc v0.0.0-20261018133130-d5cede353604
+	github.com/prometheus/client_golang v1.11.1
+	github.com/prometheus/client_model v0.2.0
+	github.com/valyala/fasthttp v1.9.0
+)
+
+require (
+	github.com/beorn7/perks v1.0.1 // indirect
+	github.com/cespare/xxhash/v2 v2.1.1 // indirect
+	github.com/golang/protobuf v1.4.3 // indirect
+	github.com/matttproud/golang_protobuf_extensions v1.0.1 // indirect
+	github.com/prometheus/common v0.26.0 // indirect
+	github.com/prometheus/procfs v0.6.0 // indirect
+	golang.org/x/sys v0.0.0-20210603081109-ebe580a85c40 // indirect
+	google.golang.org/protobuf v1.26.0-rc.1 // indirect
+)
+
+// The replace directive builds the module against the fastrpc sources
+// in this repository. It is ignored by modules depending on this module.
+replace github.com/UladzimirTrehubenka/fastrpc => ../..
@@ -0,0 +1,385 @@
+package prometheus
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/UladzimirTrehubenka/fastrpc"
+	"github.com/UladzimirTrehubenka/fastrpc/tlv"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// DefaultBatchSizeBuckets are the default histogram buckets
+// for the number of messages per flush.
+var DefaultBatchSizeBuckets = prometheus.ExponentialBuckets(1, 2, 12)
+
+// Opts contains options for RegisterClient and RegisterServer.
+type Opts struct {
+	// ConstLabels are added to all the metrics.
+	//
+	// Use distinct labels for registering multiple Clients or Servers
+	// in the same registry.
+	ConstLabels prometheus.Labels
+
+	// DurationBuckets are histogram buckets for request durations
+	// in seconds.
+	//
+	// prometheus.DefBuckets are used by default.
+	DurationBuckets []float64
+
+	// BatchSizeBuckets are histogram buckets for the number of messages
+	// per flush.
+	//
+	// DefaultBatchSizeBuckets are used by default.
+	BatchSizeBuckets []float64
+}
+
+func (opts *Opts) durationBuckets() []float64 {
+	if opts == nil || len(opts.DurationBuckets) == 0 {
+		return prometheus.DefBuckets
+	}
+	return opts.DurationBuckets
+}
+
+func (opts *Opts) batchSizeBuckets() []float64 {
+	if opts == nil || len(opts.BatchSizeBuckets) == 0 {
+		return DefaultBatchSizeBuckets
+	}
+	return opts.BatchSizeBuckets
+}
+
+func (opts *Opts) constLabels() prometheus.Labels {
+	if opts == nil {
+		return nil
+	}
+	return opts.ConstLabels
+}
+
+// RegisterClient registers metrics for c in reg.
+//
+// c.OnRequestDone and c.OnFlush are wrapped, so previously set hooks
+// are still called. The hooks are replaced without synchronization,
+// so RegisterClient must be called before the first use of c.
+func RegisterClient(reg prometheus.Registerer, c *fastrpc.Client, opts *Opts) error {
+	labels := opts.constLabels()
+
+	requestDuration := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:        "fastrpc_client_request_duration_seconds",
+		Help:        "Duration of Client.DoDeadline calls.",
+		Buckets:     opts.durationBuckets(),
+		ConstLabels: labels,
+	}, []string{"opcode"})
+	requestErrors := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name:        "fastrpc_client_request_errors_total",
+		Help:        "Number of failed Client.DoDeadline calls.",
+		ConstLabels: labels,
+	}, []string{"opcode"})
+	batchSize := prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:        "fastrpc_client_flush_batch_size",
+		Help:        "Number of requests sent per flush.",
+		Buckets:     opts.batchSizeBuckets(),
+		ConstLabels: labels,
+	})
+
+	stats := &clientStatsCollector{
+		c: c,
+		metrics: []clientStatsMetric{
+			newClientCounter("fastrpc_client_requests_sent_total", "Number of requests sent to the server.", labels, func(cs *fastrpc.ClientStats) uint64 {
+				return cs.RequestsSent
+			}),
+			newClientCounter("fastrpc_client_responses_received_total", "Number of responses received from the server.", labels, func(cs *fastrpc.ClientStats) uint64 {
+				return cs.ResponsesReceived
+			}),
+			newClientCounter("fastrpc_client_written_bytes_total", "Number of bytes written to connections.", labels, func(cs *fastrpc.ClientStats) uint64 {
+				return cs.BytesWritten
+			}),
+			newClientCounter("fastrpc_client_read_bytes_total", "Number of bytes read from connections.", labels, func(cs *fastrpc.ClientStats) uint64 {
+				return cs.BytesRead
+			}),
+			newClientCounter("fastrpc_client_flushes_total", "Number of write buffer flushes.", labels, func(cs *fastrpc.ClientStats) uint64 {
+				return cs.Flushes
+			}),
+			newClientCounter("fastrpc_client_timeouts_total", "Number of timed out requests.", labels, func(cs *fastrpc.ClientStats) uint64 {
+				return cs.Timeouts
+			}),
+			newClientCounter("fastrpc_client_overflows_total", "Number of requests failed due to pending requests overflow.", labels, func(cs *fastrpc.ClientStats) uint64 {
+				return cs.Overflows
+			}),
+			newClientCounter("fastrpc_client_dials_total", "Number of established connections.", labels, func(cs *fastrpc.ClientStats) uint64 {
+				return cs.Dials
+			}),
+			newClientCounter("fastrpc_client_reconnects_total", "Number of connections established after losing the previous connection.", labels, func(cs *fastrpc.ClientStats) uint64 {
+				return cs.Reconnects
+			}),
+			newClientCounter("fastrpc_client_dial_errors_total", "Number of failed connection attempts.", labels, func(cs *fastrpc.ClientStats) uint64 {
+				return cs.DialErrors
+			}),
+			newClientCounter("fastrpc_client_handshake_errors_total", "Number of failed handshakes.", labels, func(cs *fastrpc.ClientStats) uint64 {
+				return cs.HandshakeErrors
+			}),
+			{
+				desc: prometheus.NewDesc("fastrpc_client_pending_requests", "Number of pending requests.", nil, labels),
+				typ:  prometheus.GaugeValue,
+				value: func(cs *fastrpc.ClientStats) float64 {
+					return float64(cs.PendingRequests)
+				},
+			},
+			{
+				desc: prometheus.NewDesc("fastrpc_client_ping_rtt_seconds", "Smoothed round-trip time of pings.", nil, labels),
+				typ:  prometheus.GaugeValue,
+				value: func(cs *fastrpc.ClientStats) float64 {
+					return cs.SmoothedRTT.Seconds()
+				},
+			},
+		},
+	}
+	collectors := []prometheus.Collector{
+		requestDuration,
+		requestErrors,
+		batchSize,
+		stats,
+	}
+	if err := register(reg, collectors); err != nil {
+		return err
+	}
+
+	onRequestDone := c.OnRequestDone
+	c.OnRequestDone = func(req fastrpc.RequestWriter, err error, duration time.Duration) {
+		opcode := requestOpcode(req)
+		requestDuration.WithLabelValues(opcode).Observe(duration.Seconds())
+		if err != nil {
+			requestErrors.WithLabelValues(opcode).Inc()
+		}
+		if onRequestDone != nil {
+			onRequestDone(req, err, duration)
+		}
+	}
+	c.OnFlush = observeFlush(batchSize, c.OnFlush)
+	return nil
+}
+
+// RegisterServer registers metrics for s in reg.
+//
+// s.Handler and s.OnFlush are wrapped, so RegisterServer must be called
+// after s.Handler is set and before serving requests.
+func RegisterServer(reg prometheus.Registerer, s *fastrpc.Server, opts *Opts) error {
+	if s.Handler == nil {
+		panic("BUG: Server.Handler must be set before RegisterServer call")
+	}
+	labels := opts.constLabels()
+
+	handlerDuration := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:        "fastrpc_server_handler_duration_seconds",
+		Help:        "Duration of Server.Handler calls.",
+		Buckets:     opts.durationBuckets(),
+		ConstLabels: labels,
+	}, []string{"opcode"})
+	batchSize := prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:        "fastrpc_server_flush_batch_size",
+		Help:        "Number of responses sent per flush.",
+		Buckets:     opts.batchSizeBuckets(),
+		ConstLabels: labels,
+	})
+
+	stats := &serverStatsCollector{
+		s: s,
+		metrics: []serverStatsMetric{
+			newServerCounter("fastrpc_server_requests_received_total", "Number of requests received from clients.", labels, func(ss *fastrpc.ServerStats) uint64 {
+				return ss.RequestsReceived
+			}),
+			newServerCounter("fastrpc_server_responses_sent_total", "Number of responses sent to clients.", labels, func(ss *fastrpc.ServerStats) uint64 {
+				return ss.ResponsesSent
+			}),
+			newServerCounter("fastrpc_server_read_bytes_total", "Number of bytes read from connections.", labels, func(ss *fastrpc.ServerStats) uint64 {
+				return ss.BytesRead
+			}),
+			newServerCounter("fastrpc_server_written_bytes_total", "Number of bytes written to connections.", labels, func(ss *fastrpc.ServerStats) uint64 {
+				return ss.BytesWritten
+			}),
+			newServerCounter("fastrpc_server_flushes_total", "Number of write buffer flushes.", labels, func(ss *fastrpc.ServerStats) uint64 {
+				return ss.Flushes
+			}),
+			newServerCounter("fastrpc_server_accepted_connections_total", "Number of accepted connections.", labels, func(ss *fastrpc.ServerStats) uint64 {
+				return ss.AcceptedConns
+			}),
+			newServerCounter("fastrpc_server_rejected_connections_total", "Number of connections rejected due to connection limits.", labels, func(ss *fastrpc.ServerStats) uint64 {
+				return ss.RejectedConns
+			}),
+			newServerCounter("fastrpc_server_handshake_errors_total", "Number of failed handshakes.", labels, func(ss *fastrpc.ServerStats) uint64 {
+				return ss.HandshakeErrors
+			}),
+			newServerCounter("fastrpc_server_concurrency_limit_rejections_total", "Number of requests rejected due to concurrency limit.", labels, func(ss *fastrpc.ServerStats) uint64 {
+				return ss.ConcurrencyLimitRejections
+			}),
+			newServerCounter("fastrpc_server_rejected_requests_total", "Number of requests rejected before calling the handler.", labels, func(ss *fastrpc.ServerStats) uint64 {
+				return ss.RejectedRequests
+			}),
+			newServerCounter("fastrpc_server_denied_requests_total", "Number of requests denied by the authorizer.", labels, func(ss *fastrpc.ServerStats) uint64 {
+				return ss.DeniedRequests
+			}),
+			{
+				desc: prometheus.NewDesc("fastrpc_server_active_connections", "Number of active connections.", nil, labels),
+				typ:  prometheus.GaugeValue,
+				value: func(ss *fastrpc.ServerStats) float64 {
+					return float64(ss.ActiveConns)
+				},
+			},
+			{
+				desc: prometheus.NewDesc("fastrpc_server_inflight_handlers", "Number of running Server.Handler calls.", nil, labels),
+				typ:  prometheus.GaugeValue,
+				value: func(ss *fastrpc.ServerStats) float64 {
+					return float64(ss.InflightHandlers)
+				},
+			},
+		},
+	}
+	collectors := []prometheus.Collector{
+		handlerDuration,
+		batchSize,
+		stats,
+	}
+	if err := register(reg, collectors); err != nil {
+		return err
+	}
+
+	handler := s.Handler
+	s.Handler = func(ctx fastrpc.HandlerCtx) fastrpc.HandlerCtx {
+		// Obtain the opcode before calling the handler, since ctx
+		// mustn't be accessed after the handler returns.
+		opcode := ctxOpcode(ctx)
+		startTime := time.Now()
+		ctxNew := handler(ctx)
+		handlerDuration.WithLabelValues(opcode).Observe(time.Since(startTime).Seconds())
+		return ctxNew
+	}
+	s.OnFlush = observeFlush(batchSize, s.OnFlush)
+	return nil
+}
+
+func register(reg prometheus.Registerer, collectors []prometheus.Collector) error {
+	for i, c := range collectors {
+		if err := reg.Register(c); err != nil {
+			// Unregister already registered collectors,
+			// so the registration may be retried.
+			for _, c := range collectors[:i] {
+				reg.Unregister(c)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// clientStatsCollector exports Client statistics.
+//
+// Statistics are obtained via a single Client.Stats call per Collect call.
+type clientStatsCollector struct {
+	c       *fastrpc.Client
+	metrics []clientStatsMetric
+}
+
+type clientStatsMetric struct {
+	desc  *prometheus.Desc
+	typ   prometheus.ValueType
+	value func(cs *fastrpc.ClientStats) float64
+}
+
+func newClientCounter(name, help string, labels prometheus.Labels, value func(cs *fastrpc.ClientStats) uint64) clientStatsMetric {
+	return clientStatsMetric{
+		desc: prometheus.NewDesc(name, help, nil, labels),
+		typ:  prometheus.CounterValue,
+		value: func(cs *fastrpc.ClientStats) float64 {
+			return float64(value(cs))
+		},
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (sc *clientStatsCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, m := range sc.metrics {
+		ch <- m.desc
+	}
+}
+
+// Collect implements prometheus.Collector.
+func (sc *clientStatsCollector) Collect(ch chan<- prometheus.Metric) {
+	cs := sc.c.Stats()
+	for _, m := range sc.metrics {
+		ch <- prometheus.MustNewConstMetric(m.desc, m.typ, m.value(&cs))
+	}
+}
+
+// serverStatsCollector exports Server statistics.
+//
+// Statistics are obtained via a single Server.Stats call per Collect call.
+type serverStatsCollector struct {
+	s       *fastrpc.Server
+	metrics []serverStatsMetric
+}
+
+type serverStatsMetric struct {
+	desc  *prometheus.Desc
+	typ   prometheus.ValueType
+	value func(ss *fastrpc.ServerStats) float64
+}
+
+func newServerCounter(name, help string, labels prometheus.Labels, value func(ss *fastrpc.ServerStats) uint64) serverStatsMetric {
+	return serverStatsMetric{
+		desc: prometheus.NewDesc(name, help, nil, labels),
+		typ:  prometheus.CounterValue,
+		value: func(ss *fastrpc.ServerStats) float64 {
+			return float64(value(ss))
+		},
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (sc *serverStatsCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, m := range sc.metrics {
+		ch <- m.desc
+	}
+}
+
+// Collect implements prometheus.Collector.
+func (sc *serverStatsCollector) Collect(ch chan<- prometheus.Metric) {
+	ss := sc.s.Stats()
+	for _, m := range sc.metrics {
+		ch <- prometheus.MustNewConstMetric(m.desc, m.typ, m.value(&ss))
+	}
+}
+
+func observeFlush(h prometheus.Histogram, onFlush func(batchSize int)) func(batchSize int) {
+	return func(batchSize int) {
+		h.Observe(float64(batchSize))
+		if onFlush != nil {
+			onFlush(batchSize)
+		}
+	}
+}
+
+func requestOpcode(req fastrpc.RequestWriter) string {
+	if r, ok := req.(*tlv.Request); ok {
+		return opcodeLabel(r.Opcode())
+	}
+	return ""
+}
+
+func ctxOpcode(ctx fastrpc.HandlerCtx) string {
+	if c, ok := ctx.(*tlv.RequestCtx); ok {
+		return opcodeLabel(c.Request.Opcode())
+	}
+	return ""
+}
+
+// opcodeLabels contains pre-formatted opcode labels.
+var opcodeLabels = func() [256]string {
+	var labels [256]string
+	for i := range labels {
+		labels[i] = strconv.Itoa(i)
+	}
+	return labels
+}()
+
+func opcodeLabel(opcode byte) string {
+	return opcodeLabels[opcode]
+}
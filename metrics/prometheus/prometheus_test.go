@@ -0,0 +1,124 @@
+package prometheus
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/UladzimirTrehubenka/fastrpc"
+	"github.com/UladzimirTrehubenka/fastrpc/tlv"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/valyala/fasthttp/fasthttputil"
+)
+
+func TestRegisterClientServer(t *testing.T) {
+	reg := prometheus.NewRegistry()
+
+	s := &fastrpc.Server{
+		NewHandlerCtx: func() fastrpc.HandlerCtx {
+			return &tlv.RequestCtx{}
+		},
+		Handler: func(ctxv fastrpc.HandlerCtx) fastrpc.HandlerCtx {
+			ctx := ctxv.(*tlv.RequestCtx)
+			ctx.Write(ctx.Request.Value())
+			return ctx
+		},
+	}
+	if err := RegisterServer(reg, s, nil); err != nil {
+		t.Fatalf("cannot register server: %s", err)
+	}
+	ln := fasthttputil.NewInmemoryListener()
+	serverDone := make(chan error, 1)
+	go func() {
+		serverDone <- s.Serve(ln)
+	}()
+
+	c := &fastrpc.Client{
+		NewResponse: func() fastrpc.ResponseReader {
+			return &tlv.Response{}
+		},
+		Dial: func(addr string) (net.Conn, error) {
+			return ln.Dial()
+		},
+	}
+	if err := RegisterClient(reg, c, nil); err != nil {
+		t.Fatalf("cannot register client: %s", err)
+	}
+
+	// Duplicate registration must fail.
+	if err := RegisterClient(reg, c, nil); err == nil {
+		t.Fatalf("expecting non-nil error on duplicate registration")
+	}
+
+	const requests = 5
+	for i := 0; i < requests; i++ {
+		var req tlv.Request
+		var resp tlv.Response
+		req.SetOpcode(42)
+		req.SwapValue([]byte("foobar"))
+		if err := c.DoDeadline(&req, &resp, time.Now().Add(5*time.Second)); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("cannot gather metrics: %s", err)
+	}
+	m := make(map[string]*dto.MetricFamily)
+	for _, mf := range mfs {
+		m[mf.GetName()] = mf
+	}
+
+	checkHistogramCount(t, m, "fastrpc_client_request_duration_seconds", "42", requests)
+	checkHistogramCount(t, m, "fastrpc_server_handler_duration_seconds", "42", requests)
+	checkCounter(t, m, "fastrpc_client_requests_sent_total", requests)
+	checkCounter(t, m, "fastrpc_server_requests_received_total", requests)
+	checkCounter(t, m, "fastrpc_server_accepted_connections_total", 1)
+	checkCounter(t, m, "fastrpc_client_reconnects_total", 0)
+	for _, name := range []string{"fastrpc_client_flush_batch_size", "fastrpc_server_flush_batch_size"} {
+		if mf := m[name]; mf == nil || mf.GetMetric()[0].GetHistogram().GetSampleCount() == 0 {
+			t.Fatalf("missing samples in %s", name)
+		}
+	}
+	if mf := m["fastrpc_server_active_connections"]; mf == nil || mf.GetMetric()[0].GetGauge().GetValue() != 1 {
+		t.Fatalf("unexpected fastrpc_server_active_connections")
+	}
+
+	c.Close()
+	ln.Close()
+	if err := <-serverDone; err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+}
+
+func checkHistogramCount(t *testing.T, m map[string]*dto.MetricFamily, name, opcode string, count uint64) {
+	t.Helper()
+	mf := m[name]
+	if mf == nil {
+		t.Fatalf("missing metric %s", name)
+	}
+	for _, metric := range mf.GetMetric() {
+		for _, lp := range metric.GetLabel() {
+			if lp.GetName() == "opcode" && lp.GetValue() == opcode {
+				if n := metric.GetHistogram().GetSampleCount(); n != count {
+					t.Fatalf("unexpected sample count in %s: %d; expecting %d", name, n, count)
+				}
+				return
+			}
+		}
+	}
+	t.Fatalf("missing opcode %s in %s", opcode, name)
+}
+
+func checkCounter(t *testing.T, m map[string]*dto.MetricFamily, name string, value float64) {
+	t.Helper()
+	mf := m[name]
+	if mf == nil {
+		t.Fatalf("missing metric %s", name)
+	}
+	if v := mf.GetMetric()[0].GetCounter().GetValue(); v != value {
+		t.Fatalf("unexpected %s value: %v; expecting %v", name, v, value)
+	}
+}
@@ -0,0 +1,410 @@
+package fastrpc
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultDrainTimeout is the default maximum duration MultiClient waits
+// for pending requests on a removed Client before closing it.
+const DefaultDrainTimeout = 10 * time.Second
+
+// DefaultResolveTimeout is the default maximum duration MultiClient.SendNowait
+// waits for the first list of Server addresses.
+const DefaultResolveTimeout = time.Second
+
+// ErrNoServers is returned by MultiClient when no Server addresses
+// are available.
+var ErrNoServers = errors.New("no servers available")
+
+// MultiClient sends rpc requests to multiple Servers.
+//
+// Server addresses are obtained from Resolver. MultiClient maintains
+// a Client per address, adds Clients for new addresses and drains Clients
+// for addresses that disappear.
+//
+// Each request is sent via the Client with the minimum number
+// of pending requests unless the request has a routing key.
+// Requests with equal routing keys are sent to the same Server
+// via consistent hashing, so only a small share of keys is remapped
+// when Servers are added or removed.
+//
+// Clients with open Client.CircuitBreaker are skipped for requests
+// without routing keys. Requests with routing keys fail with
+// ErrCircuitOpen if the circuit breaker of the key's Server is open
+// unless MultiClient.KeyFailover is set.
+type MultiClient struct {
+	// Resolver provides Server addresses.
+	Resolver Resolver
+
+	// NewClient must return new Client for the given addr.
+	//
+	// Client.Addr is set to addr if it is empty.
+	NewClient func(addr string) *Client
+
+	// DrainTimeout is the maximum duration to wait for pending requests
+	// on a Client for the removed address before closing the Client.
+	//
+	// DefaultDrainTimeout is used by default.
+	DrainTimeout time.Duration
+
+	// ResolveTimeout is the maximum duration SendNowait and ClientForKey
+	// wait for the first list of Server addresses from Resolver.
+	//
+	// DoDeadline waits for the first list of addresses until the request
+	// deadline.
+	//
+	// DefaultResolveTimeout is used by default.
+	ResolveTimeout time.Duration
+
+	// KeyFunc may return the routing key for the given request.
+	//
+	// Requests are routed by the returned key if it is non-nil.
+	// The key is used only during the call.
+	//
+	// By default only requests sent via DoDeadlineKey and SendNowaitKey
+	// have routing keys.
+	KeyFunc func(req RequestWriter) []byte
+
+	// KeyFailover routes requests with routing keys to another Server
+	// while Client.CircuitBreaker for the key's Server is open.
+	//
+	// By default such requests fail with ErrCircuitOpen, so requests
+	// with equal keys never go to distinct Servers.
+	KeyFailover bool
+
+	once sync.Once
+
+	// clients contains []*multiClientBackend snapshot for lock-free reads.
+	clients atomic.Value
+
+	clientsMap map[string]*multiClientBackend
+	clientsMu  sync.Mutex
+
+	ready     chan struct{}
+	readyOnce sync.Once
+
+	next uint32
+
+	stop     chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+type multiClientBackend struct {
+	addr     string
+	addrHash uint64
+	c        *Client
+}
+
+// DoDeadline sends the given request to the least loaded Server
+// or to the Server for the key returned by MultiClient.KeyFunc.
+//
+// ErrTimeout is returned if the server didn't return response until
+// the given deadline.
+func (mc *MultiClient) DoDeadline(req RequestWriter, resp ResponseReader, deadline time.Time) error {
+	return mc.DoDeadlineKey(mc.requestKey(req), req, resp, deadline)
+}
+
+// DoDeadlineKey sends the given request to the Server for the given key.
+//
+// The request is sent to the least loaded Server if key is nil.
+func (mc *MultiClient) DoDeadlineKey(key []byte, req RequestWriter, resp ResponseReader, deadline time.Time) error {
+	c, err := mc.pickClient(key, deadline)
+	if err != nil {
+		return err
+	}
+	return c.DoDeadline(req, resp, deadline)
+}
+
+// SendNowait schedules the given request for sending to the least loaded
+// Server or to the Server for the key returned by MultiClient.KeyFunc.
+//
+// See Client.SendNowait for details.
+func (mc *MultiClient) SendNowait(req RequestWriter, releaseReq func(req RequestWriter)) bool {
+	return mc.SendNowaitKey(mc.requestKey(req), req, releaseReq)
+}
+
+// SendNowaitKey schedules the given request for sending to the Server
+// for the given key.
+//
+// The request is sent to the least loaded Server if key is nil.
+//
+// SendNowaitKey waits for up to MultiClient.ResolveTimeout
+// if the first list of Server addresses isn't resolved yet.
+func (mc *MultiClient) SendNowaitKey(key []byte, req RequestWriter, releaseReq func(req RequestWriter)) bool {
+	c, err := mc.pickClient(key, mc.resolveDeadline())
+	if err != nil {
+		return false
+	}
+	return c.SendNowait(req, releaseReq)
+}
+
+// ClientForKey returns the Client requests with the given key
+// are routed to.
+func (mc *MultiClient) ClientForKey(key []byte) (*Client, error) {
+	return mc.pickClient(key, mc.resolveDeadline())
+}
+
+// resolveDeadline returns the deadline for waiting for the first list
+// of Server addresses in calls without deadlines.
+func (mc *MultiClient) resolveDeadline() time.Time {
+	d := mc.ResolveTimeout
+	if d <= 0 {
+		d = DefaultResolveTimeout
+	}
+	return time.Now().Add(d)
+}
+
+func (mc *MultiClient) requestKey(req RequestWriter) []byte {
+	if mc.KeyFunc == nil {
+		return nil
+	}
+	return mc.KeyFunc(req)
+}
+
+// PendingRequests returns the number of pending requests on all the Clients.
+func (mc *MultiClient) PendingRequests() int {
+	mc.once.Do(mc.init)
+
+	n := 0
+	for _, b := range mc.backends() {
+		n += b.c.PendingRequests()
+	}
+	return n
+}
+
+// Clients returns the Clients for the currently resolved addresses.
+func (mc *MultiClient) Clients() []*Client {
+	mc.once.Do(mc.init)
+
+	backends := mc.backends()
+	cs := make([]*Client, 0, len(backends))
+	for _, b := range backends {
+		cs = append(cs, b.c)
+	}
+	return cs
+}
+
+// Close stops address resolution and closes all the Clients.
+func (mc *MultiClient) Close() {
+	mc.once.Do(mc.init)
+
+	mc.stopOnce.Do(func() {
+		close(mc.stop)
+	})
+	mc.wg.Wait()
+
+	mc.clientsMu.Lock()
+	for addr, b := range mc.clientsMap {
+		b.c.Close()
+		delete(mc.clientsMap, addr)
+	}
+	mc.clients.Store([]*multiClientBackend(nil))
+	mc.clientsMu.Unlock()
+}
+
+func (mc *MultiClient) init() {
+	if mc.Resolver == nil {
+		panic("BUG: MultiClient.Resolver cannot be nil")
+	}
+	if mc.NewClient == nil {
+		panic("BUG: MultiClient.NewClient cannot be nil")
+	}
+
+	mc.clientsMap = make(map[string]*multiClientBackend)
+	mc.clients.Store([]*multiClientBackend(nil))
+	mc.ready = make(chan struct{})
+	mc.stop = make(chan struct{})
+
+	addrsCh := make(chan []string)
+	mc.wg.Add(2)
+	go func() {
+		defer mc.wg.Done()
+		mc.Resolver.Resolve(addrsCh, mc.stop)
+	}()
+	go func() {
+		defer mc.wg.Done()
+		mc.watchAddrs(addrsCh)
+	}()
+}
+
+func (mc *MultiClient) backends() []*multiClientBackend {
+	return mc.clients.Load().([]*multiClientBackend)
+}
+
+// pickClient returns the Client for the given key or the Client
+// with the minimum number of pending requests if key is nil.
+//
+// It waits until the first list of addresses is resolved or the deadline
+// is reached.
+func (mc *MultiClient) pickClient(key []byte, deadline time.Time) (*Client, error) {
+	mc.once.Do(mc.init)
+
+	if err := mc.waitReady(deadline); err != nil {
+		return nil, err
+	}
+
+	backends := mc.backends()
+	if len(backends) == 0 {
+		return nil, ErrNoServers
+	}
+	if key != nil && !mc.KeyFailover {
+		// Keys are hashed over all the backends, so they don't move
+		// to other backends when circuit breakers open.
+		b := pickBackendByKey(backends, hash64(key))
+		if b.isOpen() {
+			return nil, ErrCircuitOpen
+		}
+		return b.c, nil
+	}
+	backends = healthyBackends(backends)
+	n := len(backends)
+	if n == 0 {
+		return nil, ErrCircuitOpen
+	}
+
+	if key != nil {
+		return pickBackendByKey(backends, hash64(key)).c, nil
+	}
+
+	// Start from the next backend on each call, so backends with equal
+	// load are picked in round-robin fashion.
+	start := int(atomic.AddUint32(&mc.next, 1) % uint32(n))
+	var best *Client
+	minPending := 0
+	for i := 0; i < n; i++ {
+		c := backends[(start+i)%n].c
+		pending := c.PendingRequests()
+		if best == nil || pending < minPending {
+			best, minPending = c, pending
+		}
+	}
+	return best, nil
+}
+
+// healthyBackends returns backends without open circuit breakers.
+//
+// The original slice is returned if all the backends are healthy.
+func healthyBackends(backends []*multiClientBackend) []*multiClientBackend {
+	for i, b := range backends {
+		if b.isOpen() {
+			healthy := append([]*multiClientBackend{}, backends[:i]...)
+			for _, b := range backends[i+1:] {
+				if !b.isOpen() {
+					healthy = append(healthy, b)
+				}
+			}
+			return healthy
+		}
+	}
+	return backends
+}
+
+func (b *multiClientBackend) isOpen() bool {
+	cb := b.c.CircuitBreaker
+	return cb != nil && cb.State() == CircuitOpen
+}
+
+func (mc *MultiClient) waitReady(deadline time.Time) error {
+	select {
+	case <-mc.ready:
+		return nil
+	default:
+	}
+
+	if deadline.IsZero() {
+		return ErrNoServers
+	}
+
+	t := time.NewTimer(time.Until(deadline))
+	defer t.Stop()
+
+	select {
+	case <-mc.ready:
+		return nil
+	case <-mc.stop:
+		return ErrNoServers
+	case <-t.C:
+		return ErrTimeout
+	}
+}
+
+func (mc *MultiClient) watchAddrs(addrsCh <-chan []string) {
+	for {
+		select {
+		case <-mc.stop:
+			return
+		case addrs := <-addrsCh:
+			mc.setAddrs(addrs)
+			mc.readyOnce.Do(func() {
+				close(mc.ready)
+			})
+		}
+	}
+}
+
+func (mc *MultiClient) setAddrs(addrs []string) {
+	mc.clientsMu.Lock()
+	defer mc.clientsMu.Unlock()
+
+	m := make(map[string]struct{}, len(addrs))
+	backends := make([]*multiClientBackend, 0, len(addrs))
+	for _, addr := range addrs {
+		if _, ok := m[addr]; ok {
+			continue
+		}
+		m[addr] = struct{}{}
+
+		b := mc.clientsMap[addr]
+		if b == nil {
+			c := mc.NewClient(addr)
+			if c.Addr == "" {
+				c.Addr = addr
+			}
+			b = &multiClientBackend{
+				addr:     addr,
+				addrHash: hash64([]byte(addr)),
+				c:        c,
+			}
+			mc.clientsMap[addr] = b
+		}
+		backends = append(backends, b)
+	}
+
+	for addr, b := range mc.clientsMap {
+		if _, ok := m[addr]; ok {
+			continue
+		}
+		delete(mc.clientsMap, addr)
+
+		mc.wg.Add(1)
+		go func(c *Client) {
+			defer mc.wg.Done()
+			mc.drainClient(c)
+		}(b.c)
+	}
+
+	mc.clients.Store(backends)
+}
+
+// drainClient closes c after its pending requests are complete
+// or MultiClient.DrainTimeout elapses.
+func (mc *MultiClient) drainClient(c *Client) {
+	drainTimeout := mc.DrainTimeout
+	if drainTimeout <= 0 {
+		drainTimeout = DefaultDrainTimeout
+	}
+	deadline := time.Now().Add(drainTimeout)
+
+	for c.PendingRequests() > 0 && time.Now().Before(deadline) {
+		select {
+		case <-mc.stop:
+			deadline = zeroTime
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	c.Close()
+}
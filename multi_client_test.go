@@ -0,0 +1,339 @@
+package fastrpc
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/UladzimirTrehubenka/fastrpc/tlv"
+	"github.com/valyala/fasthttp/fasthttputil"
+)
+
+type testResolver chan []string
+
+func (r testResolver) Resolve(ch chan<- []string, stopCh <-chan struct{}) {
+	for {
+		select {
+		case addrs := <-r:
+			select {
+			case ch <- addrs:
+			case <-stopCh:
+				return
+			}
+		case <-stopCh:
+			return
+		}
+	}
+}
+
+func TestMultiClientAddRemoveServers(t *testing.T) {
+	lns := make(map[string]*fasthttputil.InmemoryListener)
+	var stops []func() error
+	for _, addr := range []string{"foo", "bar"} {
+		s := &Server{
+			NewHandlerCtx: newTestHandlerCtx,
+			Handler:       newTestAddrHandler(addr),
+		}
+		serverStop, ln := newTestServerExt(s)
+		lns[addr] = ln
+		stops = append(stops, serverStop)
+	}
+
+	r := make(testResolver, 1)
+	mc := &MultiClient{
+		Resolver: r,
+		NewClient: func(addr string) *Client {
+			return newTestClient(lns[addr])
+		},
+		DrainTimeout: 100 * time.Millisecond,
+	}
+
+	r <- []string{"foo"}
+	if err := testMultiClientAddrs(mc, "foo"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	r <- []string{"foo", "bar"}
+	if err := testMultiClientAddrs(mc, "foo", "bar"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	r <- []string{"bar"}
+	if err := testMultiClientAddrs(mc, "bar"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	mc.Close()
+
+	for _, serverStop := range stops {
+		if err := serverStop(); err != nil {
+			t.Fatalf("cannot shutdown server: %s", err)
+		}
+	}
+}
+
+func TestMultiClientNoServers(t *testing.T) {
+	mc := &MultiClient{
+		Resolver: StaticResolver(nil),
+		NewClient: func(addr string) *Client {
+			return &Client{
+				NewResponse: newTestResponse,
+			}
+		},
+	}
+	defer mc.Close()
+
+	var req tlv.Request
+	var resp tlv.Response
+	err := mc.DoDeadline(&req, &resp, time.Now().Add(100*time.Millisecond))
+	if err != ErrNoServers {
+		t.Fatalf("unexpected error: %v. Expecting %s", err, ErrNoServers)
+	}
+}
+
+// testMultiClientAddrs waits until mc sends requests only to the given
+// addrs and makes sure requests reach all of them.
+func testMultiClientAddrs(mc *MultiClient, addrs ...string) error {
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		seen := make(map[string]bool)
+		unexpected := false
+		for i := 0; i < 20*len(addrs); i++ {
+			var req tlv.Request
+			var resp tlv.Response
+			if err := mc.DoDeadline(&req, &resp, time.Now().Add(time.Second)); err != nil {
+				return fmt.Errorf("unexpected error on iteration %d: %s", i, err)
+			}
+			addr := string(resp.Value())
+			seen[addr] = true
+			if !containsAddr(addrs, addr) {
+				unexpected = true
+			}
+		}
+		if !unexpected && len(seen) == len(addrs) {
+			return nil
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return fmt.Errorf("timeout waiting for requests to %q", addrs)
+}
+
+func containsAddr(addrs []string, addr string) bool {
+	for _, a := range addrs {
+		if a == addr {
+			return true
+		}
+	}
+	return false
+}
+
+func newTestAddrHandler(addr string) func(HandlerCtx) HandlerCtx {
+	return func(ctxv HandlerCtx) HandlerCtx {
+		ctx := ctxv.(*tlv.RequestCtx)
+		ctx.Write([]byte(addr))
+		return ctx
+	}
+}
+
+func TestMultiClientKeyFunc(t *testing.T) {
+	lns := make(map[string]*fasthttputil.InmemoryListener)
+	var addrs []string
+	var stops []func() error
+	for i := 0; i < 5; i++ {
+		addr := fmt.Sprintf("server%d", i)
+		s := &Server{
+			NewHandlerCtx: newTestHandlerCtx,
+			Handler:       newTestAddrHandler(addr),
+		}
+		serverStop, ln := newTestServerExt(s)
+		lns[addr] = ln
+		addrs = append(addrs, addr)
+		stops = append(stops, serverStop)
+	}
+
+	mc := &MultiClient{
+		Resolver: StaticResolver(addrs),
+		NewClient: func(addr string) *Client {
+			return newTestClient(lns[addr])
+		},
+		KeyFunc: func(req RequestWriter) []byte {
+			return req.(*tlv.Request).Value()
+		},
+	}
+
+	owners := make(map[string]string)
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 50; j++ {
+			key := fmt.Sprintf("key %d", j)
+			var req tlv.Request
+			var resp tlv.Response
+			req.SwapValue([]byte(key))
+			if err := mc.DoDeadline(&req, &resp, time.Now().Add(time.Second)); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			addr := string(resp.Value())
+			if owner, ok := owners[key]; ok && owner != addr {
+				t.Fatalf("key %q routed to %q and %q", key, owner, addr)
+			}
+			owners[key] = addr
+
+			c, err := mc.ClientForKey([]byte(key))
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if c.Addr != addr {
+				t.Fatalf("unexpected client for key %q: %q. Expecting %q", key, c.Addr, addr)
+			}
+		}
+	}
+
+	mc.Close()
+
+	for _, serverStop := range stops {
+		if err := serverStop(); err != nil {
+			t.Fatalf("cannot shutdown server: %s", err)
+		}
+	}
+}
+
+func TestMultiClientKeyCircuitOpen(t *testing.T) {
+	f := func(keyFailover bool) {
+		t.Helper()
+
+		lns := make(map[string]*fasthttputil.InmemoryListener)
+		var addrs []string
+		var stops []func() error
+		for i := 0; i < 3; i++ {
+			addr := fmt.Sprintf("server%d", i)
+			s := &Server{
+				NewHandlerCtx: newTestHandlerCtx,
+				Handler:       newTestAddrHandler(addr),
+			}
+			serverStop, ln := newTestServerExt(s)
+			lns[addr] = ln
+			addrs = append(addrs, addr)
+			stops = append(stops, serverStop)
+		}
+
+		clients := make(map[string]*Client)
+		mc := &MultiClient{
+			Resolver: StaticResolver(addrs),
+			NewClient: func(addr string) *Client {
+				c := newTestClient(lns[addr])
+				c.CircuitBreaker = &CircuitBreaker{
+					MinRequests: 1,
+					OpenTimeout: time.Hour,
+				}
+				clients[addr] = c
+				return c
+			},
+			KeyFunc: func(req RequestWriter) []byte {
+				return req.(*tlv.Request).Value()
+			},
+			KeyFailover: keyFailover,
+		}
+
+		doKey := func(key string) (string, error) {
+			var req tlv.Request
+			var resp tlv.Response
+			req.SwapValue([]byte(key))
+			err := mc.DoDeadline(&req, &resp, time.Now().Add(time.Second))
+			return string(resp.Value()), err
+		}
+
+		owners := make(map[string]string)
+		for j := 0; j < 50; j++ {
+			key := fmt.Sprintf("key %d", j)
+			addr, err := doKey(key)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			owners[key] = addr
+		}
+
+		// Open the circuit breaker for the first server.
+		cb := clients[addrs[0]].CircuitBreaker
+		for i := 0; i < 100 && cb.State() != CircuitOpen; i++ {
+			testCircuitBreakerReport(t, cb, ErrTimeout)
+		}
+		if state := cb.State(); state != CircuitOpen {
+			t.Fatalf("unexpected state: %s. Expecting %s", state, CircuitOpen)
+		}
+
+		for key, owner := range owners {
+			addr, err := doKey(key)
+			if owner != addrs[0] {
+				// Keys of other servers mustn't move.
+				if err != nil {
+					t.Fatalf("unexpected error for key %q: %s", key, err)
+				}
+				if addr != owner {
+					t.Fatalf("key %q moved from %q to %q", key, owner, addr)
+				}
+				continue
+			}
+			if keyFailover {
+				if err != nil {
+					t.Fatalf("unexpected error for key %q: %s", key, err)
+				}
+				if addr == owner {
+					t.Fatalf("key %q must be routed to another server", key)
+				}
+				continue
+			}
+			if err != ErrCircuitOpen {
+				t.Fatalf("unexpected error for key %q: %v. Expecting %s", key, err, ErrCircuitOpen)
+			}
+		}
+
+		mc.Close()
+
+		for _, serverStop := range stops {
+			if err := serverStop(); err != nil {
+				t.Fatalf("cannot shutdown server: %s", err)
+			}
+		}
+	}
+	f(false)
+	f(true)
+}
+
+func TestMultiClientSendNowaitBeforeResolve(t *testing.T) {
+	sentCh := make(chan struct{}, 1)
+	s := &Server{
+		NewHandlerCtx: newTestHandlerCtx,
+		Handler: func(ctxv HandlerCtx) HandlerCtx {
+			sentCh <- struct{}{}
+			return ctxv
+		},
+	}
+	serverStop, ln := newTestServerExt(s)
+
+	r := make(testResolver, 1)
+	mc := &MultiClient{
+		Resolver: r,
+		NewClient: func(addr string) *Client {
+			return newTestClient(ln)
+		},
+	}
+
+	// SendNowait must wait for the first list of addresses.
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		r <- []string{"foo"}
+	}()
+	var req tlv.Request
+	if !mc.SendNowait(&req, nil) {
+		t.Fatalf("cannot send request before resolving addresses")
+	}
+	select {
+	case <-sentCh:
+	case <-time.After(time.Second):
+		t.Fatalf("timeout")
+	}
+
+	mc.Close()
+	if err := serverStop(); err != nil {
+		t.Fatalf("cannot shutdown server: %s", err)
+	}
+}
@@ -0,0 +1,6 @@
+// Package peercred obtains credentials of the process connected
+// via unix socket.
+//
+// Peer credentials are obtained via SO_PEERCRED on Linux.
+// Other platforms aren't supported yet.
+package peercred
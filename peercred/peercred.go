@@ -0,0 +1,47 @@
+package peercred
+
+import (
+	"errors"
+	"net"
+)
+
+// Cred contains credentials of the peer process.
+type Cred struct {
+	// PID is the process ID.
+	PID int
+
+	// UID is the user ID.
+	UID int
+
+	// GID is the group ID.
+	GID int
+}
+
+var (
+	// ErrUnsupported is returned on platforms without peer credentials
+	// support.
+	ErrUnsupported = errors.New("peer credentials aren't supported on this platform")
+
+	// ErrNotUnixConn is returned for connections other than unix sockets.
+	ErrNotUnixConn = errors.New("peer credentials are available only for unix socket connections")
+)
+
+// Get returns credentials of the peer process connected via conn.
+//
+// Connections wrapped by handshakes, e.g. TLS, are unwrapped via NetConn
+// method until the unix socket connection is found.
+func Get(conn net.Conn) (*Cred, error) {
+	for conn != nil {
+		if uc, ok := conn.(*net.UnixConn); ok {
+			return get(uc)
+		}
+		wc, ok := conn.(interface {
+			NetConn() net.Conn
+		})
+		if !ok {
+			break
+		}
+		conn = wc.NetConn()
+	}
+	return nil, ErrNotUnixConn
+}
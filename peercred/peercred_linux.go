@@ -0,0 +1,33 @@
+//go:build linux
+// +build linux
+
+package peercred
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+)
+
+func get(conn *net.UnixConn) (*Cred, error) {
+	rc, err := conn.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	var ucred *syscall.Ucred
+	var serr error
+	err = rc.Control(func(fd uintptr) {
+		ucred, serr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if serr != nil {
+		return nil, fmt.Errorf("cannot obtain SO_PEERCRED: %w", serr)
+	}
+	return &Cred{
+		PID: int(ucred.Pid),
+		UID: int(ucred.Uid),
+		GID: int(ucred.Gid),
+	}, nil
+}
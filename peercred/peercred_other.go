@@ -0,0 +1,12 @@
+//go:build !linux
+// +build !linux
+
+package peercred
+
+import (
+	"net"
+)
+
+func get(conn *net.UnixConn) (*Cred, error) {
+	return nil, ErrUnsupported
+}
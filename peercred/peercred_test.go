@@ -0,0 +1,67 @@
+package peercred
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestGet(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fastrpc-peercred")
+	if err != nil {
+		t.Fatalf("cannot create temporary dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	ln, err := net.Listen("unix", filepath.Join(dir, "sock"))
+	if err != nil {
+		t.Fatalf("cannot listen: %s", err)
+	}
+	defer ln.Close()
+
+	connCh := make(chan net.Conn, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			connCh <- nil
+			return
+		}
+		connCh <- conn
+	}()
+	clientConn, err := net.Dial("unix", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("cannot dial: %s", err)
+	}
+	defer clientConn.Close()
+	serverConn := <-connCh
+	if serverConn == nil {
+		t.Fatalf("cannot accept connection")
+	}
+	defer serverConn.Close()
+
+	cred, err := Get(serverConn)
+	if runtime.GOOS != "linux" {
+		if err != ErrUnsupported {
+			t.Fatalf("unexpected error: %v. Expecting %v", err, ErrUnsupported)
+		}
+		return
+	}
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if cred.PID != os.Getpid() || cred.UID != os.Getuid() || cred.GID != os.Getgid() {
+		t.Fatalf("unexpected credentials: %+v. Expecting pid=%d, uid=%d, gid=%d", cred, os.Getpid(), os.Getuid(), os.Getgid())
+	}
+}
+
+func TestGetNotUnixConn(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+	if _, err := Get(c1); err != ErrNotUnixConn {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, ErrNotUnixConn)
+	}
+}
@@ -0,0 +1,208 @@
+package fastrpc
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrRateLimited is passed to RejectHandlerCtx.RejectError for requests
+// exceeding rate limits.
+var ErrRateLimited = errors.New("rate limit exceeded")
+
+// rateLimitIdleTimeout is the duration after which rate limit state
+// for idle IPs and keys is removed.
+const rateLimitIdleTimeout = time.Minute
+
+// RateLimit limits the rate of requests with token buckets.
+//
+// Zero fields mean the corresponding rate isn't limited.
+type RateLimit struct {
+	// RequestsPerSecond is the maximum sustained rate of requests.
+	RequestsPerSecond float64
+
+	// RequestsBurst is the maximum number of requests exceeding
+	// RequestsPerSecond rate.
+	//
+	// RequestsPerSecond rounded up is used by default.
+	RequestsBurst int
+
+	// BytesPerSecond is the maximum sustained rate of request bytes.
+	BytesPerSecond float64
+
+	// BytesBurst is the maximum number of request bytes exceeding
+	// BytesPerSecond rate.
+	//
+	// BytesPerSecond rounded up is used by default.
+	BytesBurst int
+}
+
+func (rl *RateLimit) requestsBurst() float64 {
+	return burstOrDefault(rl.RequestsBurst, rl.RequestsPerSecond)
+}
+
+func (rl *RateLimit) bytesBurst() float64 {
+	return burstOrDefault(rl.BytesBurst, rl.BytesPerSecond)
+}
+
+func burstOrDefault(burst int, rate float64) float64 {
+	if burst > 0 {
+		return float64(burst)
+	}
+	if rate < 1 {
+		return 1
+	}
+	return float64(int(rate + 0.999999))
+}
+
+// rateLimitState contains token buckets for a single connection, IP or key.
+type rateLimitState struct {
+	mu       sync.Mutex
+	requests float64
+	bytes    float64
+	last     time.Time
+
+	// removed is set to 1 after the state is removed from rateLimitSet.
+	removed uint32
+}
+
+// allow returns true if the request with the given size doesn't exceed rl.
+func (st *rateLimitState) allow(rl *RateLimit, size int, now time.Time) bool {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if st.last.IsZero() {
+		st.requests = rl.requestsBurst()
+		st.bytes = rl.bytesBurst()
+	} else if elapsed := now.Sub(st.last).Seconds(); elapsed > 0 {
+		st.requests = refillTokens(st.requests, elapsed*rl.RequestsPerSecond, rl.requestsBurst())
+		st.bytes = refillTokens(st.bytes, elapsed*rl.BytesPerSecond, rl.bytesBurst())
+	}
+	st.last = now
+
+	if rl.RequestsPerSecond > 0 && st.requests < 1 {
+		return false
+	}
+	// Bytes may go into debt, so requests bigger than the burst
+	// are allowed after the bucket is refilled.
+	if rl.BytesPerSecond > 0 && st.bytes <= 0 {
+		return false
+	}
+	if rl.RequestsPerSecond > 0 {
+		st.requests--
+	}
+	if rl.BytesPerSecond > 0 {
+		st.bytes -= float64(size)
+	}
+	return true
+}
+
+func (st *rateLimitState) isIdle(now time.Time) bool {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return now.Sub(st.last) > rateLimitIdleTimeout
+}
+
+func refillTokens(tokens, delta, burst float64) float64 {
+	tokens += delta
+	if tokens > burst {
+		tokens = burst
+	}
+	return tokens
+}
+
+// rateLimitSet contains rate limit states for IPs or keys.
+type rateLimitSet struct {
+	mu          sync.Mutex
+	m           map[string]*rateLimitState
+	lastCleanup time.Time
+}
+
+// get returns the state for the given key.
+func (rs *rateLimitSet) get(rl *RateLimit, key string, now time.Time) *rateLimitState {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	if rs.m == nil {
+		rs.m = make(map[string]*rateLimitState)
+		rs.lastCleanup = now
+	}
+	if now.Sub(rs.lastCleanup) > rateLimitIdleTimeout {
+		for k, st := range rs.m {
+			if st.isIdle(now) {
+				atomic.StoreUint32(&st.removed, 1)
+				delete(rs.m, k)
+			}
+		}
+		rs.lastCleanup = now
+	}
+
+	st := rs.m[key]
+	if st == nil {
+		st = &rateLimitState{}
+		rs.m[key] = st
+	}
+	return st
+}
+
+// connRateLimiter applies Server rate limits to requests
+// from a single connection.
+type connRateLimiter struct {
+	s *Server
+
+	connState rateLimitState
+
+	ip      string
+	ipState *rateLimitState
+}
+
+func newConnRateLimiter(s *Server, conn net.Conn) *connRateLimiter {
+	if !s.hasRateLimits() {
+		return nil
+	}
+	crl := &connRateLimiter{
+		s: s,
+	}
+	if s.IPRateLimit != nil {
+		crl.ip = remoteIP(conn)
+	}
+	return crl
+}
+
+// allow returns true if the request with the given size doesn't exceed
+// Server rate limits.
+func (crl *connRateLimiter) allow(ctx HandlerCtx, size int) bool {
+	s := crl.s
+	now := time.Now()
+
+	if rl := s.ConnRateLimit; rl != nil && !crl.connState.allow(rl, size, now) {
+		return false
+	}
+
+	if rl := s.IPRateLimit; rl != nil && crl.ip != "" {
+		// The state is cached per connection in order to avoid lock
+		// contention on rateLimitSet. Obtain new state if the cached
+		// one has been removed due to inactivity.
+		if crl.ipState == nil || atomic.LoadUint32(&crl.ipState.removed) != 0 {
+			crl.ipState = s.ipRateLimits.get(rl, crl.ip, now)
+		}
+		if !crl.ipState.allow(rl, size, now) {
+			return false
+		}
+	}
+
+	if rl := s.KeyRateLimit; rl != nil && s.RateLimitKey != nil {
+		key := s.RateLimitKey(ctx)
+		if !s.keyRateLimits.get(rl, key, now).allow(rl, size, now) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (s *Server) hasRateLimits() bool {
+	return s.ConnRateLimit != nil || s.IPRateLimit != nil || s.KeyRateLimit != nil
+}
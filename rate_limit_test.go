@@ -0,0 +1,131 @@
+package fastrpc
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/UladzimirTrehubenka/fastrpc/tlv"
+)
+
+func TestRateLimitStateRequests(t *testing.T) {
+	rl := &RateLimit{
+		RequestsPerSecond: 10,
+		RequestsBurst:     3,
+	}
+	var st rateLimitState
+	now := time.Now()
+	for i := 0; i < 3; i++ {
+		if !st.allow(rl, 0, now) {
+			t.Fatalf("request %d must be allowed", i)
+		}
+	}
+	if st.allow(rl, 0, now) {
+		t.Fatalf("request exceeding burst must be rejected")
+	}
+
+	now = now.Add(100 * time.Millisecond)
+	if !st.allow(rl, 0, now) {
+		t.Fatalf("request must be allowed after refill")
+	}
+	if st.allow(rl, 0, now) {
+		t.Fatalf("request exceeding rate must be rejected")
+	}
+}
+
+func TestRateLimitStateBytes(t *testing.T) {
+	rl := &RateLimit{
+		BytesPerSecond: 100,
+	}
+	var st rateLimitState
+	now := time.Now()
+
+	// Requests bigger than the burst are allowed, but put the bucket into debt.
+	if !st.allow(rl, 250, now) {
+		t.Fatalf("the first request must be allowed")
+	}
+	now = now.Add(time.Second)
+	if st.allow(rl, 1, now) {
+		t.Fatalf("request must be rejected while in debt")
+	}
+	now = now.Add(time.Second)
+	if !st.allow(rl, 1, now) {
+		t.Fatalf("request must be allowed after the debt is repaid")
+	}
+}
+
+func TestServerConnRateLimit(t *testing.T) {
+	s := &Server{
+		NewHandlerCtx: newTestHandlerCtx,
+		Handler:       testEchoHandler,
+		ConnRateLimit: &RateLimit{
+			RequestsPerSecond: 0.001,
+			RequestsBurst:     5,
+		},
+	}
+	serverStop, c := newTestServerClientExt(s)
+
+	for i := 0; i < 10; i++ {
+		var req tlv.Request
+		var resp tlv.Response
+		req.SwapValue([]byte("foobar"))
+		if err := c.DoDeadline(&req, &resp, time.Now().Add(time.Second)); err != nil {
+			t.Fatalf("unexpected error on iteration %d: %s", i, err)
+		}
+		expected := "foobar"
+		if i >= 5 {
+			expected = ErrRateLimited.Error()
+		}
+		if string(resp.Value()) != expected {
+			t.Fatalf("unexpected response on iteration %d: %q. Expecting %q", i, resp.Value(), expected)
+		}
+		if rejected := errors.Is(resp.Err(), tlv.ErrRejected); rejected != (i >= 5) {
+			t.Fatalf("unexpected response status on iteration %d: %d", i, resp.Status())
+		}
+	}
+
+	if err := serverStop(); err != nil {
+		t.Fatalf("cannot shutdown server: %s", err)
+	}
+}
+
+func TestServerKeyRateLimit(t *testing.T) {
+	s := &Server{
+		NewHandlerCtx: newTestHandlerCtx,
+		Handler:       testEchoHandler,
+		KeyRateLimit: &RateLimit{
+			RequestsPerSecond: 0.001,
+			RequestsBurst:     2,
+		},
+		RateLimitKey: func(ctxv HandlerCtx) string {
+			ctx := ctxv.(*tlv.RequestCtx)
+			return string(ctx.Request.Value())
+		},
+	}
+	serverStop, ln := newTestServerExt(s)
+
+	// The key limit must be shared among connections.
+	for i := 0; i < 4; i++ {
+		c := newTestClient(ln)
+		for _, key := range []string{"foo", "bar"} {
+			var req tlv.Request
+			var resp tlv.Response
+			req.SwapValue([]byte(key))
+			if err := c.DoDeadline(&req, &resp, time.Now().Add(time.Second)); err != nil {
+				t.Fatalf("unexpected error on iteration %d: %s", i, err)
+			}
+			expected := key
+			if i >= 2 {
+				expected = ErrRateLimited.Error()
+			}
+			if string(resp.Value()) != expected {
+				t.Fatalf("unexpected response on iteration %d: %q. Expecting %q", i, resp.Value(), expected)
+			}
+		}
+		c.Close()
+	}
+
+	if err := serverStop(); err != nil {
+		t.Fatalf("cannot shutdown server: %s", err)
+	}
+}
@@ -0,0 +1,275 @@
+// Package replay re-issues requests from captures of tlv traffic
+// and compares responses with the captured ones.
+//
+// See capture package for creating captures.
+package replay
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/UladzimirTrehubenka/fastrpc"
+	"github.com/UladzimirTrehubenka/fastrpc/capture"
+	"github.com/UladzimirTrehubenka/fastrpc/tlv"
+)
+
+// DefaultTimeout is the default timeout for replayed requests.
+const DefaultTimeout = 5 * time.Second
+
+// DefaultConcurrency is the default maximum number of concurrently
+// replayed requests.
+const DefaultConcurrency = 100
+
+// Replayer re-issues requests from capture.
+type Replayer struct {
+	// Client sends replayed requests.
+	//
+	// Client.NewResponse must return *tlv.Response. It is set
+	// if it is nil.
+	Client *fastrpc.Client
+
+	// Rate is the replay speed relative to the captured traffic.
+	//
+	// Requests are replayed at the original rate if Rate is 1,
+	// twice faster if Rate is 2 and so on. Requests are replayed
+	// without delays if Rate is zero.
+	Rate float64
+
+	// Timeout is the timeout for each replayed request.
+	//
+	// DefaultTimeout is used by default.
+	Timeout time.Duration
+
+	// Concurrency is the maximum number of concurrently replayed requests.
+	//
+	// DefaultConcurrency is used by default.
+	Concurrency int
+
+	// OnMismatch is called for each replayed request with the response
+	// different from the captured one or with an error.
+	//
+	// OnMismatch may be called from concurrently running goroutines.
+	OnMismatch func(m *Mismatch)
+}
+
+// Mismatch describes the replayed request with unexpected response.
+type Mismatch struct {
+	// ConnID is the captured connection ID of the request.
+	ConnID uint64
+
+	// Nonce is the captured nonce of the request.
+	Nonce uint32
+
+	// Opcode is the request opcode.
+	Opcode byte
+
+	// Request is the request value.
+	Request []byte
+
+	// Expected is the captured response value.
+	Expected []byte
+
+	// Actual is the received response value.
+	Actual []byte
+
+	// Err is the error returned by the Client.
+	Err error
+}
+
+// Result contains replay statistics.
+type Result struct {
+	// Requests is the number of replayed requests.
+	Requests int
+
+	// Matched is the number of responses equal to the captured ones.
+	Matched int
+
+	// Mismatched is the number of responses different from
+	// the captured ones.
+	Mismatched int
+
+	// Errors is the number of requests failed with an error.
+	Errors int
+
+	// Unchecked is the number of requests without captured responses,
+	// including requests sent without waiting for responses.
+	Unchecked int
+
+	// Duration is the replay duration.
+	Duration time.Duration
+}
+
+type request struct {
+	time   time.Time
+	connID uint64
+	nonce  uint32
+	req    *tlv.Request
+
+	// resp is the captured response value. It is nil if the response
+	// isn't captured.
+	resp []byte
+}
+
+type requestKey struct {
+	connID uint64
+	nonce  uint32
+}
+
+// Replay re-issues requests from cr.
+func (r *Replayer) Replay(cr *capture.Reader) (*Result, error) {
+	reqs, err := readRequests(cr)
+	if err != nil {
+		return nil, err
+	}
+
+	c := r.Client
+	if c.NewResponse == nil {
+		c.NewResponse = newResponse
+	}
+	concurrency := r.Concurrency
+	if concurrency <= 0 {
+		concurrency = DefaultConcurrency
+	}
+
+	var (
+		res   Result
+		resMu sync.Mutex
+		wg    sync.WaitGroup
+	)
+	sem := make(chan struct{}, concurrency)
+	startTime := time.Now()
+	for i := range reqs {
+		rq := &reqs[i]
+		if r.Rate > 0 {
+			d := time.Duration(float64(rq.time.Sub(reqs[0].time)) / r.Rate)
+			if wait := time.Until(startTime.Add(d)); wait > 0 {
+				time.Sleep(wait)
+			}
+		}
+
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			m := r.replayRequest(rq)
+
+			resMu.Lock()
+			res.Requests++
+			switch {
+			case m == nil && rq.resp == nil:
+				res.Unchecked++
+			case m == nil:
+				res.Matched++
+			case m.Err != nil:
+				res.Errors++
+			default:
+				res.Mismatched++
+			}
+			resMu.Unlock()
+
+			if m != nil && r.OnMismatch != nil {
+				r.OnMismatch(m)
+			}
+		}()
+	}
+	wg.Wait()
+	res.Duration = time.Since(startTime)
+	return &res, nil
+}
+
+// replayRequest sends rq and returns non-nil Mismatch if the response
+// doesn't match the captured one.
+func (r *Replayer) replayRequest(rq *request) *Mismatch {
+	if rq.nonce == 0 {
+		if !r.Client.SendNowait(rq.req, nil) {
+			return rq.mismatch(nil, fastrpc.ErrPendingRequestsOverflow)
+		}
+		return nil
+	}
+
+	timeout := r.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	resp := &tlv.Response{}
+	if err := r.Client.DoDeadline(rq.req, resp, time.Now().Add(timeout)); err != nil {
+		return rq.mismatch(nil, err)
+	}
+	if rq.resp != nil && !bytes.Equal(resp.Value(), rq.resp) {
+		return rq.mismatch(resp.Value(), nil)
+	}
+	return nil
+}
+
+func (rq *request) mismatch(actual []byte, err error) *Mismatch {
+	return &Mismatch{
+		ConnID:   rq.connID,
+		Nonce:    rq.nonce,
+		Opcode:   rq.req.Opcode(),
+		Request:  rq.req.Value(),
+		Expected: rq.resp,
+		Actual:   actual,
+		Err:      err,
+	}
+}
+
+// readRequests reads requests with the corresponding responses
+// from cr.
+func readRequests(cr *capture.Reader) ([]request, error) {
+	var reqs []request
+	pending := make(map[requestKey]int)
+	var rec capture.Record
+	for {
+		if err := cr.Next(&rec); err != nil {
+			if err == io.EOF {
+				return reqs, nil
+			}
+			return nil, err
+		}
+
+		br := bufio.NewReader(bytes.NewReader(rec.Data))
+		key := requestKey{
+			connID: rec.ConnID,
+			nonce:  rec.Nonce,
+		}
+		switch rec.Type {
+		case capture.TypeRequest:
+			req := &tlv.Request{}
+			if err := req.ReadRequest(br); err != nil {
+				return nil, fmt.Errorf("cannot parse request with nonce %d on connection %d: %w", rec.Nonce, rec.ConnID, err)
+			}
+			if rec.Nonce != 0 {
+				pending[key] = len(reqs)
+			}
+			reqs = append(reqs, request{
+				time:   rec.Time,
+				connID: rec.ConnID,
+				nonce:  rec.Nonce,
+				req:    req,
+			})
+		case capture.TypeResponse:
+			i, ok := pending[key]
+			if !ok {
+				// The request isn't captured.
+				continue
+			}
+			delete(pending, key)
+			var resp tlv.Response
+			if err := resp.ReadResponse(br); err != nil {
+				return nil, fmt.Errorf("cannot parse response with nonce %d on connection %d: %w", rec.Nonce, rec.ConnID, err)
+			}
+			reqs[i].resp = append([]byte{}, resp.Value()...)
+		}
+	}
+}
+
+func newResponse() fastrpc.ResponseReader {
+	return &tlv.Response{}
+}
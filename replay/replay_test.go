@@ -0,0 +1,237 @@
+package replay
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/UladzimirTrehubenka/fastrpc"
+	"github.com/UladzimirTrehubenka/fastrpc/capture"
+	"github.com/UladzimirTrehubenka/fastrpc/fastrpctest"
+	"github.com/UladzimirTrehubenka/fastrpc/rpclog"
+	"github.com/UladzimirTrehubenka/fastrpc/tlv"
+)
+
+func TestReplayMatched(t *testing.T) {
+	data := testCapture(t, testEchoHandler, 10)
+
+	p := testNewPair(testEchoHandler)
+	defer p.Close()
+	r := &Replayer{
+		Client: p.Client,
+		OnMismatch: func(m *Mismatch) {
+			t.Errorf("unexpected mismatch: %+v", m)
+		},
+	}
+	res := testReplay(t, r, data)
+	if res.Requests != 10 || res.Matched != 10 {
+		t.Fatalf("unexpected result: %+v; expecting 10 matched requests", res)
+	}
+}
+
+func TestReplayMismatched(t *testing.T) {
+	data := testCapture(t, testEchoHandler, 10)
+
+	p := testNewPair(func(ctxv fastrpc.HandlerCtx) fastrpc.HandlerCtx {
+		ctx := ctxv.(*tlv.RequestCtx)
+		ctx.Write(bytes.ToUpper(ctx.Request.Value()))
+		return ctx
+	})
+	defer p.Close()
+
+	var (
+		mismatches   []*Mismatch
+		mismatchesMu sync.Mutex
+	)
+	r := &Replayer{
+		Client: p.Client,
+		OnMismatch: func(m *Mismatch) {
+			mismatchesMu.Lock()
+			mismatches = append(mismatches, m)
+			mismatchesMu.Unlock()
+		},
+	}
+	res := testReplay(t, r, data)
+	if res.Requests != 10 || res.Mismatched != 10 {
+		t.Fatalf("unexpected result: %+v; expecting 10 mismatched requests", res)
+	}
+	if len(mismatches) != 10 {
+		t.Fatalf("unexpected number of mismatches: %d; expecting 10", len(mismatches))
+	}
+	for _, m := range mismatches {
+		if m.Err != nil {
+			t.Fatalf("unexpected error: %s", m.Err)
+		}
+		if !bytes.Equal(m.Expected, m.Request) {
+			t.Fatalf("unexpected captured response %q; expecting %q", m.Expected, m.Request)
+		}
+		if !bytes.Equal(m.Actual, bytes.ToUpper(m.Request)) {
+			t.Fatalf("unexpected actual response %q; expecting %q", m.Actual, bytes.ToUpper(m.Request))
+		}
+	}
+}
+
+func TestReplayRate(t *testing.T) {
+	// Requests are captured with 100ms intervals.
+	var buf bytes.Buffer
+	w, err := capture.NewWriter(&buf)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	startTime := time.Now()
+	for i := 0; i < 3; i++ {
+		var req tlv.Request
+		req.SwapValue([]byte(fmt.Sprintf("value %d", i)))
+		rec := &capture.Record{
+			Type:   capture.TypeRequest,
+			Time:   startTime.Add(time.Duration(i) * 100 * time.Millisecond),
+			ConnID: 1,
+			Nonce:  uint32(i),
+			Data:   testRequestData(t, &req),
+		}
+		if err := w.Write(rec); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	p := testNewPair(testEchoHandler)
+	defer p.Close()
+
+	r := &Replayer{
+		Client: p.Client,
+		Rate:   2,
+	}
+	res := testReplay(t, r, buf.Bytes())
+	if res.Requests != 3 || res.Unchecked != 3 {
+		t.Fatalf("unexpected result: %+v; expecting 3 unchecked requests", res)
+	}
+	if res.Duration < 100*time.Millisecond || res.Duration > time.Second {
+		t.Fatalf("unexpected replay duration at rate 2: %s; expecting about 100ms", res.Duration)
+	}
+
+	r.Rate = 0
+	res = testReplay(t, r, buf.Bytes())
+	if res.Duration >= 100*time.Millisecond {
+		t.Fatalf("too long replay duration without delays: %s", res.Duration)
+	}
+}
+
+func TestReplayError(t *testing.T) {
+	data := testCapture(t, testEchoHandler, 3)
+
+	p := testNewPair(func(ctxv fastrpc.HandlerCtx) fastrpc.HandlerCtx {
+		time.Sleep(100 * time.Millisecond)
+		return testEchoHandler(ctxv)
+	})
+	defer p.Close()
+
+	r := &Replayer{
+		Client:  p.Client,
+		Timeout: 10 * time.Millisecond,
+	}
+	res := testReplay(t, r, data)
+	if res.Requests != 3 || res.Errors != 3 {
+		t.Fatalf("unexpected result: %+v; expecting 3 failed requests", res)
+	}
+}
+
+func TestReplayInvalidCapture(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := capture.NewWriter(&buf)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := w.Write(&capture.Record{Type: capture.TypeRequest, Nonce: 1, Data: []byte("garbage")}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	cr, err := capture.NewReader(&buf)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	r := &Replayer{
+		Client: &fastrpc.Client{},
+	}
+	if _, err := r.Replay(cr); err == nil {
+		t.Fatalf("expecting non-nil error")
+	}
+}
+
+// testCapture returns capture of n requests served by handler.
+func testCapture(t *testing.T, handler func(fastrpc.HandlerCtx) fastrpc.HandlerCtx, n int) []byte {
+	var buf bytes.Buffer
+	w, err := capture.NewWriter(&buf)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	p := fastrpctest.NewPair(&fastrpc.Server{
+		Handler: handler,
+		Logger:  rpclog.Discard,
+		Capture: w,
+	}, nil)
+	for i := 0; i < n; i++ {
+		var req tlv.Request
+		var resp tlv.Response
+		req.SetOpcode(byte(i))
+		req.SwapValue([]byte(fmt.Sprintf("value %d", i)))
+		if err := p.Client.DoDeadline(&req, &resp, time.Now().Add(time.Second)); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+	if err := p.Close(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	return buf.Bytes()
+}
+
+func testReplay(t *testing.T, r *Replayer, data []byte) *Result {
+	t.Helper()
+	cr, err := capture.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	res, err := r.Replay(cr)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	return res
+}
+
+func testRequestData(t *testing.T, req *tlv.Request) []byte {
+	var buf bytes.Buffer
+	bw := bufio.NewWriter(&buf)
+	if err := req.WriteRequest(bw); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := bw.Flush(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	return buf.Bytes()
+}
+
+func testNewPair(handler func(fastrpc.HandlerCtx) fastrpc.HandlerCtx) *fastrpctest.Pair {
+	return fastrpctest.NewPair(&fastrpc.Server{
+		Handler: handler,
+		Logger:  rpclog.Discard,
+	}, &fastrpc.Client{
+		Logger: rpclog.Discard,
+	})
+}
+
+func testEchoHandler(ctxv fastrpc.HandlerCtx) fastrpc.HandlerCtx {
+	ctx := ctxv.(*tlv.RequestCtx)
+	ctx.Write(ctx.Request.Value())
+	return ctx
+}
@@ -0,0 +1,194 @@
+package fastrpc
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultResolveInterval is the default interval between address
+// re-resolutions for DNSResolver and FileResolver.
+const DefaultResolveInterval = 30 * time.Second
+
+// Resolver streams Server addresses to MultiClient.
+type Resolver interface {
+	// Resolve must send the full up-to-date list of Server addresses
+	// to ch each time the list changes until stopCh is closed.
+	//
+	// Resolve may return before stopCh is closed if the list
+	// of addresses never changes.
+	Resolve(ch chan<- []string, stopCh <-chan struct{})
+}
+
+// StaticResolver is a Resolver returning a fixed list of addresses.
+type StaticResolver []string
+
+// Resolve implements Resolver.
+func (r StaticResolver) Resolve(ch chan<- []string, stopCh <-chan struct{}) {
+	addrs := append([]string(nil), r...)
+	select {
+	case ch <- addrs:
+	case <-stopCh:
+	}
+}
+
+// DNSResolver periodically resolves addresses via DNS.
+//
+// A and AAAA records for Host are resolved by default.
+// SRV records are resolved if Service is set.
+type DNSResolver struct {
+	// Host is the host name to resolve.
+	Host string
+
+	// Port is the port appended to addresses resolved from A and AAAA
+	// records.
+	//
+	// Port is ignored for SRV records, since they contain ports.
+	Port int
+
+	// Service and Proto are used for resolving SRV records
+	// for _Service._Proto.Host.
+	//
+	// Proto defaults to "tcp".
+	Service string
+	Proto   string
+
+	// Interval is the interval between re-resolutions.
+	//
+	// DefaultResolveInterval is used by default.
+	Interval time.Duration
+
+	// Resolver is used for DNS lookups.
+	//
+	// net.DefaultResolver is used by default.
+	Resolver *net.Resolver
+}
+
+// Resolve implements Resolver.
+func (r *DNSResolver) Resolve(ch chan<- []string, stopCh <-chan struct{}) {
+	resolveLoop(ch, stopCh, r.Interval, r.lookup)
+}
+
+func (r *DNSResolver) lookup() ([]string, error) {
+	resolver := r.Resolver
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	ctx := context.Background()
+
+	if r.Service != "" {
+		proto := r.Proto
+		if proto == "" {
+			proto = "tcp"
+		}
+		_, srvs, err := resolver.LookupSRV(ctx, r.Service, proto, r.Host)
+		if err != nil {
+			return nil, err
+		}
+		addrs := make([]string, 0, len(srvs))
+		for _, srv := range srvs {
+			host := strings.TrimSuffix(srv.Target, ".")
+			addrs = append(addrs, net.JoinHostPort(host, strconv.Itoa(int(srv.Port))))
+		}
+		return addrs, nil
+	}
+
+	ips, err := resolver.LookupHost(ctx, r.Host)
+	if err != nil {
+		return nil, err
+	}
+	port := strconv.Itoa(r.Port)
+	addrs := make([]string, 0, len(ips))
+	for _, ip := range ips {
+		addrs = append(addrs, net.JoinHostPort(ip, port))
+	}
+	return addrs, nil
+}
+
+// FileResolver periodically reads addresses from a file.
+//
+// The file must contain an address per line. Empty lines and lines
+// starting with '#' are ignored.
+type FileResolver struct {
+	// Path is the path to the file with addresses.
+	Path string
+
+	// Interval is the interval between file re-reads.
+	//
+	// DefaultResolveInterval is used by default.
+	Interval time.Duration
+}
+
+// Resolve implements Resolver.
+func (r *FileResolver) Resolve(ch chan<- []string, stopCh <-chan struct{}) {
+	resolveLoop(ch, stopCh, r.Interval, r.lookup)
+}
+
+func (r *FileResolver) lookup() ([]string, error) {
+	data, err := ioutil.ReadFile(r.Path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read addresses from %q: %w", r.Path, err)
+	}
+	var addrs []string
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		addrs = append(addrs, line)
+	}
+	return addrs, sc.Err()
+}
+
+// resolveLoop calls lookup every interval and sends the obtained addresses
+// to ch if they differ from the previously sent addresses.
+//
+// Lookup errors are ignored, so the previously sent addresses stay in use.
+func resolveLoop(ch chan<- []string, stopCh <-chan struct{}, interval time.Duration, lookup func() ([]string, error)) {
+	if interval <= 0 {
+		interval = DefaultResolveInterval
+	}
+
+	var lastAddrs []string
+	sent := false
+	for {
+		addrs, err := lookup()
+		if err == nil {
+			sort.Strings(addrs)
+			if !sent || !equalAddrs(addrs, lastAddrs) {
+				select {
+				case ch <- addrs:
+				case <-stopCh:
+					return
+				}
+				lastAddrs, sent = addrs, true
+			}
+		}
+
+		select {
+		case <-stopCh:
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+func equalAddrs(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,86 @@
+package fastrpc
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStaticResolver(t *testing.T) {
+	r := StaticResolver{"foo:1", "bar:2"}
+	addrs := testResolveOnce(t, r)
+	if len(addrs) != 2 || addrs[0] != "foo:1" || addrs[1] != "bar:2" {
+		t.Fatalf("unexpected addrs: %q", addrs)
+	}
+}
+
+func TestDNSResolverLocalhost(t *testing.T) {
+	r := &DNSResolver{
+		Host: "localhost",
+		Port: 1234,
+	}
+	addrs := testResolveOnce(t, r)
+	if len(addrs) == 0 {
+		t.Fatalf("expecting non-empty addrs")
+	}
+	for _, addr := range addrs {
+		if addr != "127.0.0.1:1234" && addr != "[::1]:1234" {
+			t.Fatalf("unexpected addr: %q", addr)
+		}
+	}
+}
+
+func TestFileResolver(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fastrpc-resolver")
+	if err != nil {
+		t.Fatalf("cannot create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "addrs")
+	if err := ioutil.WriteFile(path, []byte("# comment\nfoo:1\n\n  bar:2\n"), 0644); err != nil {
+		t.Fatalf("cannot write addrs file: %s", err)
+	}
+
+	r := &FileResolver{
+		Path:     path,
+		Interval: 10 * time.Millisecond,
+	}
+	ch := make(chan []string)
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	go r.Resolve(ch, stopCh)
+
+	addrs := testRecvAddrs(t, ch)
+	if len(addrs) != 2 || addrs[0] != "bar:2" || addrs[1] != "foo:1" {
+		t.Fatalf("unexpected addrs: %q", addrs)
+	}
+
+	if err := ioutil.WriteFile(path, []byte("baz:3\n"), 0644); err != nil {
+		t.Fatalf("cannot update addrs file: %s", err)
+	}
+	addrs = testRecvAddrs(t, ch)
+	if len(addrs) != 1 || addrs[0] != "baz:3" {
+		t.Fatalf("unexpected addrs: %q", addrs)
+	}
+}
+
+func testResolveOnce(t *testing.T, r Resolver) []string {
+	ch := make(chan []string)
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	go r.Resolve(ch, stopCh)
+	return testRecvAddrs(t, ch)
+}
+
+func testRecvAddrs(t *testing.T, ch <-chan []string) []string {
+	select {
+	case addrs := <-ch:
+		return addrs
+	case <-time.After(time.Second):
+		t.Fatalf("timeout")
+	}
+	return nil
+}
@@ -0,0 +1,6 @@
+// Package rpclog provides leveled structured logging for fastrpc.
+//
+// Logger accepts a message with alternating keys and values
+// in the same way as log/slog does, so *slog.Logger may be used
+// as Logger directly.
+package rpclog
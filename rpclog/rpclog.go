@@ -0,0 +1,200 @@
+package rpclog
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Logger is a leveled structured logger.
+//
+// keyvals must contain alternating keys and values, i.e.
+// "key1", value1, "key2", value2, ...
+//
+// *slog.Logger implements Logger.
+type Logger interface {
+	Debug(msg string, keyvals ...interface{})
+	Info(msg string, keyvals ...interface{})
+	Warn(msg string, keyvals ...interface{})
+	Error(msg string, keyvals ...interface{})
+}
+
+// Level is the logging level.
+//
+// Levels have the same values as log/slog levels.
+type Level int
+
+// Logging levels.
+const (
+	LevelDebug Level = -4
+	LevelInfo  Level = 0
+	LevelWarn  Level = 4
+	LevelError Level = 8
+)
+
+// String returns string representation of the level.
+func (l Level) String() string {
+	switch l {
+	case LevelDebug:
+		return "DEBUG"
+	case LevelInfo:
+		return "INFO"
+	case LevelWarn:
+		return "WARN"
+	case LevelError:
+		return "ERROR"
+	default:
+		return "LEVEL(" + strconv.Itoa(int(l)) + ")"
+	}
+}
+
+// PrintfLogger is an unstructured logger such as *log.Logger
+// or fasthttp.Logger.
+type PrintfLogger interface {
+	Printf(format string, args ...interface{})
+}
+
+// New returns Logger writing messages with at least the given level to w.
+//
+// Each message is written on a separate line in the form
+//
+//	2006/01/02 15:04:05 LEVEL msg key1=value1 key2=value2
+func New(w io.Writer, level Level) Logger {
+	return FromPrintfLogger(log.New(w, "", log.LstdFlags), level)
+}
+
+// FromPrintfLogger returns Logger writing messages with at least
+// the given level to l.
+//
+// The messages are formatted in the same way as by New.
+func FromPrintfLogger(l PrintfLogger, level Level) Logger {
+	return &printfLogger{
+		l:     l,
+		level: level,
+	}
+}
+
+type printfLogger struct {
+	l     PrintfLogger
+	level Level
+}
+
+func (pl *printfLogger) Debug(msg string, keyvals ...interface{}) {
+	pl.log(LevelDebug, msg, keyvals)
+}
+
+func (pl *printfLogger) Info(msg string, keyvals ...interface{}) {
+	pl.log(LevelInfo, msg, keyvals)
+}
+
+func (pl *printfLogger) Warn(msg string, keyvals ...interface{}) {
+	pl.log(LevelWarn, msg, keyvals)
+}
+
+func (pl *printfLogger) Error(msg string, keyvals ...interface{}) {
+	pl.log(LevelError, msg, keyvals)
+}
+
+func (pl *printfLogger) log(level Level, msg string, keyvals []interface{}) {
+	if level < pl.level {
+		return
+	}
+	pl.l.Printf("%s", format(level, msg, keyvals))
+}
+
+func format(level Level, msg string, keyvals []interface{}) string {
+	var sb strings.Builder
+	sb.WriteString(level.String())
+	sb.WriteByte(' ')
+	sb.WriteString(msg)
+	for i := 0; i < len(keyvals); i += 2 {
+		sb.WriteByte(' ')
+		if i+1 == len(keyvals) {
+			// The value without a key is logged with !BADKEY key
+			// like log/slog does.
+			sb.WriteString("!BADKEY=")
+			sb.WriteString(formatValue(keyvals[i]))
+			break
+		}
+		sb.WriteString(fmt.Sprint(keyvals[i]))
+		sb.WriteByte('=')
+		sb.WriteString(formatValue(keyvals[i+1]))
+	}
+	return sb.String()
+}
+
+func formatValue(v interface{}) string {
+	s := fmt.Sprint(v)
+	if s == "" || strings.IndexFunc(s, needsQuoting) >= 0 {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+func needsQuoting(r rune) bool {
+	return r == '=' || r == '"' || unicode.IsSpace(r) || !unicode.IsPrint(r)
+}
+
+// With returns Logger adding the given keyvals to each message
+// logged via l.
+func With(l Logger, keyvals ...interface{}) Logger {
+	if len(keyvals) == 0 {
+		return l
+	}
+	if wl, ok := l.(*withLogger); ok {
+		kvs := make([]interface{}, 0, len(wl.keyvals)+len(keyvals))
+		kvs = append(kvs, wl.keyvals...)
+		kvs = append(kvs, keyvals...)
+		return &withLogger{
+			l:       wl.l,
+			keyvals: kvs,
+		}
+	}
+	return &withLogger{
+		l:       l,
+		keyvals: append([]interface{}(nil), keyvals...),
+	}
+}
+
+type withLogger struct {
+	l       Logger
+	keyvals []interface{}
+}
+
+func (wl *withLogger) Debug(msg string, keyvals ...interface{}) {
+	wl.l.Debug(msg, wl.append(keyvals)...)
+}
+
+func (wl *withLogger) Info(msg string, keyvals ...interface{}) {
+	wl.l.Info(msg, wl.append(keyvals)...)
+}
+
+func (wl *withLogger) Warn(msg string, keyvals ...interface{}) {
+	wl.l.Warn(msg, wl.append(keyvals)...)
+}
+
+func (wl *withLogger) Error(msg string, keyvals ...interface{}) {
+	wl.l.Error(msg, wl.append(keyvals)...)
+}
+
+func (wl *withLogger) append(keyvals []interface{}) []interface{} {
+	if len(keyvals) == 0 {
+		return wl.keyvals
+	}
+	kvs := make([]interface{}, 0, len(wl.keyvals)+len(keyvals))
+	kvs = append(kvs, wl.keyvals...)
+	return append(kvs, keyvals...)
+}
+
+// Discard is Logger, which discards all the messages.
+var Discard Logger = discardLogger{}
+
+type discardLogger struct{}
+
+func (discardLogger) Debug(msg string, keyvals ...interface{}) {}
+func (discardLogger) Info(msg string, keyvals ...interface{})  {}
+func (discardLogger) Warn(msg string, keyvals ...interface{})  {}
+func (discardLogger) Error(msg string, keyvals ...interface{}) {}
@@ -0,0 +1,71 @@
+package rpclog
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+type testPrintfLogger struct {
+	lines []string
+}
+
+func (tl *testPrintfLogger) Printf(format string, args ...interface{}) {
+	tl.lines = append(tl.lines, fmt.Sprintf(format, args...))
+}
+
+func TestFromPrintfLoggerLevel(t *testing.T) {
+	var tl testPrintfLogger
+	l := FromPrintfLogger(&tl, LevelWarn)
+	l.Debug("debug")
+	l.Info("info")
+	l.Warn("warn")
+	l.Error("error")
+	expected := []string{"WARN warn", "ERROR error"}
+	if strings.Join(tl.lines, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("unexpected lines: %q. Expecting %q", tl.lines, expected)
+	}
+}
+
+func TestFromPrintfLoggerFormat(t *testing.T) {
+	testFormat(t, "foo", nil, "INFO foo")
+	testFormat(t, "foo bar", []interface{}{"a", 1, "b", "x y"}, `INFO foo bar a=1 b="x y"`)
+	testFormat(t, "foo", []interface{}{"a", "", "b", "c=d"}, `INFO foo a="" b="c=d"`)
+	testFormat(t, "foo", []interface{}{"a", 1, "b"}, `INFO foo a=1 !BADKEY=b`)
+	testFormat(t, "foo", []interface{}{"err", fmt.Errorf("x\ny")}, `INFO foo err="x\ny"`)
+}
+
+func testFormat(t *testing.T, msg string, keyvals []interface{}, expected string) {
+	t.Helper()
+	var tl testPrintfLogger
+	FromPrintfLogger(&tl, LevelDebug).Info(msg, keyvals...)
+	if len(tl.lines) != 1 || tl.lines[0] != expected {
+		t.Fatalf("unexpected lines: %q. Expecting %q", tl.lines, expected)
+	}
+}
+
+func TestWith(t *testing.T) {
+	var tl testPrintfLogger
+	l := With(FromPrintfLogger(&tl, LevelDebug), "a", 1)
+	l = With(l, "b", 2)
+	l.Debug("foo", "c", 3)
+	l.Error("bar")
+	expected := []string{"DEBUG foo a=1 b=2 c=3", "ERROR bar a=1 b=2"}
+	if strings.Join(tl.lines, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("unexpected lines: %q. Expecting %q", tl.lines, expected)
+	}
+}
+
+func TestLevelString(t *testing.T) {
+	for level, expected := range map[Level]string{
+		LevelDebug: "DEBUG",
+		LevelInfo:  "INFO",
+		LevelWarn:  "WARN",
+		LevelError: "ERROR",
+		Level(2):   "LEVEL(2)",
+	} {
+		if s := level.String(); s != expected {
+			t.Fatalf("unexpected level string: %q. Expecting %q", s, expected)
+		}
+	}
+}
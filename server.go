@@ -2,17 +2,17 @@ package fastrpc
 
 import (
 	"bufio"
+	"crypto/tls"
 	"fmt"
 	"io"
-	"log"
 	"net"
-	"os"
 	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
-	"github.com/valyala/fasthttp"
+	"github.com/UladzimirTrehubenka/fastrpc/auth"
+	"github.com/UladzimirTrehubenka/fastrpc/capture"
 )
 
 // HandlerCtx is an interface implementing context passed to Server.Handler
@@ -22,7 +22,9 @@ type HandlerCtx interface {
 	ConcurrencyLimitError(concurrency int)
 
 	// Init must prepare ctx for reading the next request.
-	Init(conn net.Conn, logger fasthttp.Logger)
+	//
+	// logger adds connection ID and request nonce to each message.
+	Init(conn net.Conn, logger Logger)
 
 	// ReadRequest must read request from br.
 	ReadRequest(br *bufio.Reader) error
@@ -31,6 +33,29 @@ type HandlerCtx interface {
 	WriteResponse(bw *bufio.Writer) error
 }
 
+// RejectHandlerCtx may be implemented by HandlerCtx for sending responses
+// to requests rejected before calling Server.Handler, e.g. due to
+// rate limits or overload.
+//
+// ConcurrencyLimitError is called for rejected requests if HandlerCtx
+// doesn't implement RejectHandlerCtx.
+type RejectHandlerCtx interface {
+	// RejectError must set the response to the given error, so the client
+	// may distinguish it from responses returned by Server.Handler.
+	RejectError(err error)
+}
+
+// PermissionDeniedHandlerCtx may be implemented by HandlerCtx for sending
+// responses to requests denied by Server.Authorizer.
+//
+// RejectHandlerCtx is used for denied requests if HandlerCtx doesn't
+// implement PermissionDeniedHandlerCtx.
+type PermissionDeniedHandlerCtx interface {
+	// PermissionDeniedError must set the response to the given error,
+	// so the client may distinguish it from other rejections.
+	PermissionDeniedError(err error)
+}
+
 // Server accepts rpc requests from Client.
 type Server struct {
 	// NewHandlerCtx must return new HandlerCtx
@@ -46,6 +71,40 @@ type Server struct {
 	// Otherwise new ctx must be returned.
 	Handler func(ctx HandlerCtx) HandlerCtx
 
+	// TLSConfig enables TLS for accepted connections if set.
+	//
+	// Set TLSConfig.ClientAuth to tls.RequireAndVerifyClientCert
+	// for mutual TLS. Verified client certificates are available
+	// in the handler, e.g. via tlv.RequestCtx.PeerCertificates.
+	//
+	// TLS handshake is performed before Handshake
+	// and is limited by HandshakeTimeout.
+	TLSConfig *tls.Config
+
+	// Authenticator authenticates clients once per connection if set.
+	//
+	// Clients must set Client.Credentials. The authenticated principal
+	// is available in the handler, e.g. via tlv.RequestCtx.Principal.
+	//
+	// Authentication is performed after TLS handshake and before
+	// Handshake. It is limited by HandshakeTimeout.
+	Authenticator auth.Authenticator
+
+	// Authorizer decides whether requests are allowed to reach Handler
+	// if set.
+	//
+	// Denied requests are rejected before rate limiting and admission
+	// control with the error returned by Authorizer.
+	Authorizer Authorizer
+
+	// Capture records all the requests and responses sent and received
+	// over connections if set.
+	//
+	// Frames are recorded with the connection IDs used in log messages.
+	// Capture isn't closed by the Server. See capture package
+	// for the file format.
+	Capture *capture.Writer
+
 	Handshake        func(conn net.Conn) (net.Conn, error)
 	HandshakeTimeout time.Duration
 
@@ -55,6 +114,66 @@ type Server struct {
 	// DefaultConcurrency is used by default.
 	Concurrency int
 
+	// AdmissionController decides whether requests are passed
+	// to Server.Handler.
+	//
+	// All the requests are admitted by default.
+	AdmissionController AdmissionController
+
+	// CoDel drops requests waiting too long before Server.Handler
+	// picks them up if set.
+	//
+	// See CoDel for how the queue delay is measured.
+	//
+	// Dropped requests are rejected with ErrOverloaded.
+	CoDel *CoDel
+
+	// ConnRateLimit limits the rate of requests per connection if set.
+	//
+	// Requests exceeding rate limits are rejected with ErrRateLimited.
+	ConnRateLimit *RateLimit
+
+	// IPRateLimit limits the rate of requests per client IP if set.
+	//
+	// Unix socket clients are limited as clients with 127.0.0.1 IP.
+	IPRateLimit *RateLimit
+
+	// KeyRateLimit limits the rate of requests per key returned
+	// by RateLimitKey if set.
+	KeyRateLimit *RateLimit
+
+	// RateLimitKey must return the key for KeyRateLimit.
+	//
+	// The key may be obtained from the request, e.g. a tenant name.
+	RateLimitKey func(ctx HandlerCtx) string
+
+	// MaxConns is the maximum number of concurrent connections
+	// the server may serve.
+	//
+	// The number of connections is also limited by Concurrency
+	// if PipelineRequests is enabled.
+	//
+	// By default the number of connections is unlimited.
+	MaxConns int
+
+	// MaxConnsPerIP is the maximum number of concurrent connections
+	// from a single client IP.
+	//
+	// Unix socket clients are limited as clients with 127.0.0.1 IP.
+	//
+	// By default the number of connections per IP is unlimited.
+	MaxConnsPerIP int
+
+	// NotifyRejectedConns enables sending GOAWAY frame to connections
+	// rejected due to connection limits before closing them.
+	//
+	// Client returns GoAwayError with the rejection reason
+	// after receiving the frame. The frame isn't sent if either Handshake,
+	// TLSConfig or Authenticator is set.
+	//
+	// By default rejected connections are closed without notification.
+	NotifyRejectedConns bool
+
 	// MaxBatchDelay is the maximum duration before ready responses
 	// are sent to the client.
 	//
@@ -65,11 +184,27 @@ type Server struct {
 
 	// Maximum duration for reading the full request (including body).
 	//
-	// This also limits the maximum lifetime for idle connections.
+	// This also limits the maximum lifetime for idle connections
+	// if IdleTimeout isn't set.
 	//
 	// By default request read timeout is unlimited.
 	ReadTimeout time.Duration
 
+	// IdleTimeout is the maximum duration the connection may stay idle,
+	// i.e. without requests being read or processed.
+	//
+	// Ping frames sent by Client.PingInterval don't prevent closing
+	// idle connections.
+	//
+	// Idle connections are closed after sending GOAWAY frame, so Client
+	// transparently re-sends requests the server didn't read
+	// on a new connection.
+	//
+	// ReadTimeout limits only reading requests if IdleTimeout is set.
+	//
+	// By default ReadTimeout limits idle connections' lifetime.
+	IdleTimeout time.Duration
+
 	// Maximum duration for writing the full response (including body).
 	//
 	// By default response write timeout is unlimited.
@@ -85,10 +220,15 @@ type Server struct {
 	// DefaultWriteBufferSize is used by default.
 	WriteBufferSize int
 
+	// OnFlush is called after each flush of responses to a connection
+	// with the number of responses written since the previous flush.
+	OnFlush func(batchSize int)
+
 	// Logger, which is used by the Server.
 	//
-	// Standard logger from log package is used by default.
-	Logger fasthttp.Logger
+	// Messages with at least rpclog.LevelInfo level are written
+	// to os.Stderr by default.
+	Logger Logger
 
 	// PipelineRequests enables requests' pipelining.
 	//
@@ -104,8 +244,82 @@ type Server struct {
 	// By default requests from a single client are processed concurrently.
 	PipelineRequests bool
 
+	// FairScheduling enables fair scheduling of requests among connections
+	// if PipelineRequests is disabled.
+	//
+	// Requests are queued per scheduling key and are dispatched
+	// to at most MaxWorkers goroutines in weighted round-robin order,
+	// so a connection sending bursts of requests cannot starve other
+	// connections. Up to Concurrency requests may be queued. The newest
+	// request from the longest queue is rejected via
+	// HandlerCtx.ConcurrencyLimitError when the limit is exceeded.
+	//
+	// By default each request is processed in a separate goroutine.
+	FairScheduling bool
+
+	// SchedulingKey must return the scheduling key for the given
+	// connection if set.
+	//
+	// Connections with equal keys share a queue, e.g. connections
+	// from the same tenant.
+	//
+	// By default each connection has its own queue.
+	SchedulingKey func(conn net.Conn) string
+
+	// SchedulingWeight must return the weight for the given scheduling key
+	// if set.
+	//
+	// Queues receive a share of workers proportional to their weights.
+	//
+	// By default all the queues have weight 1.
+	SchedulingWeight func(key string) int
+
+	// WorkerPool enables processing requests in a pool of reusable
+	// goroutines if PipelineRequests is disabled.
+	//
+	// This reduces scheduler and stack growth overhead comparing
+	// to starting a goroutine per request. Requests are rejected
+	// via HandlerCtx.ConcurrencyLimitError if all the MaxWorkers
+	// goroutines are busy.
+	//
+	// By default each request is processed in a separate goroutine.
+	WorkerPool bool
+
+	// MaxWorkers is the maximum number of goroutines processing requests
+	// if FairScheduling or WorkerPool is enabled.
+	//
+	// Concurrency is used by default.
+	MaxWorkers int
+
+	// MaxIdleWorkerDuration is the duration after which idle goroutines
+	// are stopped if FairScheduling or WorkerPool is enabled.
+	//
+	// DefaultMaxIdleWorkerDuration is used by default.
+	MaxIdleWorkerDuration time.Duration
+
 	workItemPool sync.Pool
 
+	ipRateLimits  rateLimitSet
+	keyRateLimits rateLimitSet
+
+	fairSchedulerOnce sync.Once
+	fairScheduler     *fairScheduler
+
+	workerPoolOnce sync.Once
+	workerPool     *workerPool
+
+	handshakeOnce sync.Once
+	handshakeFunc func(conn net.Conn) (net.Conn, error)
+
+	connsCount   int32
+	connsPerIPMu sync.Mutex
+	connsPerIP   map[string]int
+
+	// connID is the ID of the last accepted connection.
+	connID uint64
+
+	counters serverCounters
+
 	concurrencyCount uint32
 }
 
@@ -122,8 +336,6 @@ func (s *Server) Serve(ln net.Listener) error {
 	if s.Handler == nil {
 		panic("BUG: Server.Handler must be set")
 	}
-	concurrency := s.concurrency()
-	pipelineRequests := s.PipelineRequests
 	for {
 		conn, err := ln.Accept()
 		if err != nil {
@@ -131,12 +343,12 @@ func (s *Server) Serve(ln net.Listener) error {
 				panic("BUG: net.Listener returned non-nil conn and non-nil error")
 			}
 			if netErr, ok := err.(net.Error); ok && netErr.Temporary() {
-				s.logger().Printf("fastrpc.Server: temporary error when accepting new connections: %s", netErr)
+				s.logger().Warn("fastrpc.Server: temporary error when accepting new connections", "error", netErr)
 				time.Sleep(time.Second)
 				continue
 			}
 			if err != io.EOF && !strings.Contains(err.Error(), "use of closed network connection") {
-				s.logger().Printf("fastrpc.Server: permanent error when accepting new connections: %s", err)
+				s.logger().Error("fastrpc.Server: permanent error when accepting new connections", "error", err)
 				return err
 			}
 			return nil
@@ -145,52 +357,85 @@ func (s *Server) Serve(ln net.Listener) error {
 			panic("BUG: net.Listener returned (nil, nil)")
 		}
 
-		if pipelineRequests {
-			n := int(atomic.AddUint32(&s.concurrencyCount, 1))
-			if n > concurrency {
-				atomic.AddUint32(&s.concurrencyCount, ^uint32(0))
-				s.logger().Printf("fastrpc.Server: concurrency limit exceeded: %d", concurrency)
-				continue
-			}
+		ip, reason := s.acquireConn(conn)
+		if reason != "" {
+			s.rejectConn(conn, reason)
+			continue
 		}
 
+		atomic.AddUint64(&s.counters.acceptedConns, 1)
+		atomic.AddInt64(&s.counters.activeConns, 1)
+		connID := atomic.AddUint64(&s.connID, 1)
 		go func() {
-			laddr := conn.LocalAddr().String()
-			raddr := conn.RemoteAddr().String()
-			if err := s.serveConn(conn); err != nil {
-				s.logger().Printf("fastrpc.Server: error on connection %q<->%q: %s", laddr, raddr, err)
-			}
-			if pipelineRequests {
-				atomic.AddUint32(&s.concurrencyCount, ^uint32(0))
+			logger := newConnLogger(s.logger(), connID, conn.LocalAddr().String(), conn.RemoteAddr().String())
+			logger.Debug("fastrpc.Server: accepted connection")
+			if err := s.serveConn(conn, connID, logger); err != nil {
+				logger.Warn("fastrpc.Server: error on connection", "error", err)
+			} else {
+				logger.Debug("fastrpc.Server: connection closed")
 			}
+			s.releaseConn(ip)
+			atomic.AddInt64(&s.counters.activeConns, -1)
 		}()
 	}
 }
 
-func (s *Server) serveConn(conn net.Conn) error {
-	realConn, br, bw, err := newBufioConn(conn, s.ReadBufferSize, s.WriteBufferSize, s.Handshake, s.HandshakeTimeout)
+func (s *Server) serveConn(conn net.Conn, connID uint64, logger Logger) error {
+	realConn, br, bw, err := newBufioConn(conn, s.ReadBufferSize, s.WriteBufferSize, s.handshake(), s.HandshakeTimeout)
 	if err != nil {
+		atomic.AddUint64(&s.counters.handshakeErrors, 1)
 		conn.Close()
 		return err
 	}
 
 	conn = realConn
 
+	var r io.Reader = conn
+	fr := newFrameRecorder(s.Capture, connID, logger)
+	if fr != nil {
+		r = fr.reader(conn)
+	}
+	cr := &countingReader{
+		r:          r,
+		total:      &s.counters.bytesRead,
+		trackReads: s.CoDel != nil,
+	}
+	br.Reset(cr)
+	bw.Reset(&countingWriter{
+		w:     conn,
+		total: &s.counters.bytesWritten,
+	})
+
 	stopCh := make(chan struct{})
 
+	// inflight is the number of requests waiting for responses.
+	// It is tracked only if IdleTimeout is set.
+	var inflight *int32
+	if s.IdleTimeout > 0 {
+		inflight = new(int32)
+	}
+
 	pendingResponses := make(chan *serverWorkItem, s.concurrency())
 	readerDone := make(chan error, 1)
 	go func() {
-		readerDone <- s.connReader(br, conn, pendingResponses, stopCh)
+		readerDone <- s.connReader(br, cr, fr, conn, logger, pendingResponses, stopCh, inflight)
 	}()
 
 	writerDone := make(chan error, 1)
 	go func() {
-		writerDone <- s.connWriter(bw, conn, pendingResponses, stopCh)
+		writerDone <- s.connWriter(bw, fr, conn, pendingResponses, stopCh, inflight)
 	}()
 
 	select {
 	case err = <-readerDone:
+		if err == errIdleConn {
+			close(stopCh)
+			if err = <-writerDone; err == nil {
+				writeIdleGoAway(bw, conn)
+			}
+			conn.Close()
+			return err
+		}
 		conn.Close()
 		close(stopCh)
 		<-writerDone
@@ -202,15 +447,64 @@ func (s *Server) serveConn(conn net.Conn) error {
 	return err
 }
 
-func (s *Server) connReader(br *bufio.Reader, conn net.Conn, pendingResponses chan<- *serverWorkItem, stopCh <-chan struct{}) error {
-	logger := s.logger()
+// writeIdleGoAway sends GOAWAY frame to the idle connection.
+//
+// The connection writer must be stopped.
+func writeIdleGoAway(bw *bufio.Writer, conn net.Conn) {
+	if err := conn.SetWriteDeadline(time.Now().Add(goAwayWriteTimeout)); err != nil {
+		return
+	}
+	if err := writeGoAway(bw, GoAwayIdle, "idle timeout"); err != nil {
+		return
+	}
+	// Ignore the error, since the client may close the idle connection
+	// at the same time.
+	bw.Flush()
+}
+
+func (s *Server) connReader(br *bufio.Reader, cr *countingReader, fr *frameRecorder, conn net.Conn, logger Logger, pendingResponses chan<- *serverWorkItem, stopCh <-chan struct{}, inflight *int32) error {
 	concurrency := s.concurrency()
 	pipelineRequests := s.PipelineRequests
 	readTimeout := s.ReadTimeout
+	idleTimeout := s.IdleTimeout
+	crl := newConnRateLimiter(s, conn)
+
+	var fs *fairScheduler
+	var fq *fairQueue
+	if s.FairScheduling && !pipelineRequests {
+		fs = s.getFairScheduler()
+		fq = fs.register(conn)
+		defer fs.unregister(fq)
+	}
+
+	var wp *workerPool
+	if s.WorkerPool && fs == nil && !pipelineRequests {
+		wp = s.getWorkerPool()
+	}
 
 	var lastReadDeadline time.Time
 
+	// idleSince is the time of reading the last request. Control frames
+	// such as pings don't update it.
+	idleSince := time.Now()
+
 	for {
+		if idleTimeout > 0 && br.Buffered() == 0 {
+			if err := waitForRequest(br, conn, idleTimeout, &idleSince, inflight); err != nil {
+				if err == io.EOF {
+					return nil
+				}
+				return err
+			}
+			// The read deadline must be updated for reading the request.
+			lastReadDeadline = zeroTime
+			if readTimeout <= 0 {
+				if err := conn.SetReadDeadline(zeroTime); err != nil {
+					return fmt.Errorf("cannot reset read deadline: %s", err)
+				}
+			}
+		}
+
 		wi := s.acquireWorkItem()
 
 		if readTimeout > 0 {
@@ -228,6 +522,11 @@ func (s *Server) connReader(br *bufio.Reader, conn net.Conn, pendingResponses ch
 			}
 		}
 
+		var startPos int64
+		if cr != nil {
+			startPos = cr.n - int64(br.Buffered())
+		}
+
 		if n, err := io.ReadFull(br, wi.nonce[:]); err != nil {
 			if n == 0 {
 				// Ignore error if no bytes are read, since
@@ -236,18 +535,102 @@ func (s *Server) connReader(br *bufio.Reader, conn net.Conn, pendingResponses ch
 			}
 			return fmt.Errorf("cannot read request ID: %s", err)
 		}
+		if s.CoDel != nil {
+			// The request may wait in the read buffer behind other requests,
+			// so the queue delay is measured since reading its header
+			// from the connection.
+			wi.readTime = cr.readTime(startPos)
+		}
+		if bytes2Uint32(wi.nonce) == controlNonce {
+			ok, err := s.handleControlFrame(br, wi, pendingResponses, stopCh)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return nil
+			}
+			continue
+		}
+		if idleTimeout > 0 {
+			idleSince = time.Now()
+		}
+		if inflight != nil && !isZeroNonce(wi.nonce) {
+			atomic.AddInt32(inflight, 1)
+		}
 
-		wi.ctx.Init(conn, logger)
+		wi.logger.logger = logger
+		wi.logger.nonce = bytes2Uint32(wi.nonce)
+		wi.ctx.Init(conn, &wi.logger)
+		if fr != nil {
+			fr.beginRead(br)
+		}
 		if err := wi.ctx.ReadRequest(br); err != nil {
 			return fmt.Errorf("cannot read request: %s", err)
 		}
+		if fr != nil {
+			fr.endRead(br, capture.TypeRequest, bytes2Uint32(wi.nonce))
+		}
+		atomic.AddUint64(&s.counters.requestsReceived, 1)
+
+		if az := s.Authorizer; az != nil {
+			if err := az.Authorize(conn, wi.ctx); err != nil {
+				if !s.denyRequest(wi, err, pendingResponses, stopCh) {
+					return nil
+				}
+				continue
+			}
+		}
+
+		if crl != nil {
+			size := int(cr.n - int64(br.Buffered()) - startPos)
+			if !crl.allow(wi.ctx, size) {
+				if !s.rejectRequest(wi, ErrRateLimited, pendingResponses, stopCh) {
+					return nil
+				}
+				continue
+			}
+		}
+
+		if ac := s.AdmissionController; ac != nil {
+			if err := ac.Admit(conn, wi.ctx); err != nil {
+				if !s.rejectRequest(wi, err, pendingResponses, stopCh) {
+					return nil
+				}
+				continue
+			}
+		}
 
 		if pipelineRequests {
 			s.handleRequest(wi, pendingResponses, stopCh)
+		} else if fs != nil {
+			victim := fs.enqueue(fq, serverTask{
+				wi:               wi,
+				pendingResponses: pendingResponses,
+				stopCh:           stopCh,
+			})
+			if victim != nil {
+				if victim.wi == wi {
+					if !s.rejectConcurrencyLimit(wi, pendingResponses, stopCh) {
+						return nil
+					}
+				} else {
+					s.rejectEvicted(victim)
+				}
+			}
+		} else if wp != nil {
+			task := serverTask{
+				wi:               wi,
+				pendingResponses: pendingResponses,
+				stopCh:           stopCh,
+			}
+			if !wp.serve(task) && !s.rejectConcurrencyLimit(wi, pendingResponses, stopCh) {
+				return nil
+			}
 		} else {
 			n := int(atomic.AddUint32(&s.concurrencyCount, 1))
 			if n > concurrency {
 				atomic.AddUint32(&s.concurrencyCount, ^uint32(0))
+				atomic.AddUint64(&s.counters.concurrencyLimitRejections, 1)
 				wi.ctx.ConcurrencyLimitError(concurrency)
 				if !pushPendingResponse(pendingResponses, wi, stopCh) {
 					return nil
@@ -262,8 +645,61 @@ func (s *Server) connReader(br *bufio.Reader, conn net.Conn, pendingResponses ch
 	}
 }
 
+// handleControlFrame handles control frame sent by the client.
+//
+// Pings are answered without calling Server.Handler.
+// Returns false if the connection is closing.
+func (s *Server) handleControlFrame(br *bufio.Reader, wi *serverWorkItem, pendingResponses chan<- *serverWorkItem, stopCh <-chan struct{}) (bool, error) {
+	typ, payload, err := readControlFrame(br)
+	if err != nil {
+		return false, err
+	}
+	if typ != controlPing {
+		// Ignore unknown control frames for forward compatibility.
+		s.releaseWorkItem(wi)
+		return true, nil
+	}
+	wi.pong = payload
+	return pushPendingResponse(pendingResponses, wi, stopCh), nil
+}
+
+// waitForRequest waits for the next request from the client.
+//
+// errIdleConn is returned if the connection has no inflight requests
+// and the client sends no requests during idleTimeout since idleSince.
+// idleSince is updated while requests are processed.
+func waitForRequest(br *bufio.Reader, conn net.Conn, idleTimeout time.Duration, idleSince *time.Time, inflight *int32) error {
+	for {
+		if err := conn.SetReadDeadline(idleSince.Add(idleTimeout)); err != nil {
+			return fmt.Errorf("cannot update read deadline: %s", err)
+		}
+		_, err := br.Peek(1)
+		if err == nil {
+			return nil
+		}
+		if !isTimeoutError(err) {
+			return err
+		}
+		// The connection isn't idle while requests are processed.
+		if atomic.LoadInt32(inflight) == 0 {
+			return errIdleConn
+		}
+		*idleSince = time.Now()
+	}
+}
+
 func (s *Server) handleRequest(wi *serverWorkItem, pendingResponses chan<- *serverWorkItem, stopCh <-chan struct{}) {
+	if cd := s.CoDel; cd != nil {
+		t := time.Now()
+		if cd.drop(t.Sub(wi.readTime), t) {
+			s.rejectRequest(wi, ErrOverloaded, pendingResponses, stopCh)
+			return
+		}
+	}
+
+	atomic.AddInt64(&s.counters.inflightHandlers, 1)
 	nonce, ctxNew := wi.nonce, s.Handler(wi.ctx)
+	atomic.AddInt64(&s.counters.inflightHandlers, -1)
 
 	if isZeroNonce(nonce) {
 		if ctxNew == wi.ctx {
@@ -284,6 +720,72 @@ func (s *Server) handleRequest(wi *serverWorkItem, pendingResponses chan<- *serv
 	pushPendingResponse(pendingResponses, wi, stopCh)
 }
 
+// rejectRequest sends the response with the given error for the request
+// rejected before calling Server.Handler.
+//
+// Returns false if the connection is closed.
+func (s *Server) rejectRequest(wi *serverWorkItem, err error, pendingResponses chan<- *serverWorkItem, stopCh <-chan struct{}) bool {
+	atomic.AddUint64(&s.counters.rejectedRequests, 1)
+	if isZeroNonce(wi.nonce) {
+		s.releaseWorkItem(wi)
+		return true
+	}
+	if ctx, ok := wi.ctx.(RejectHandlerCtx); ok {
+		ctx.RejectError(err)
+	} else {
+		wi.ctx.ConcurrencyLimitError(s.concurrency())
+	}
+	return pushPendingResponse(pendingResponses, wi, stopCh)
+}
+
+// denyRequest sends the response with the given error for the request
+// denied by Server.Authorizer.
+//
+// Returns false if the connection is closed.
+func (s *Server) denyRequest(wi *serverWorkItem, err error, pendingResponses chan<- *serverWorkItem, stopCh <-chan struct{}) bool {
+	atomic.AddUint64(&s.counters.deniedRequests, 1)
+	ctx, ok := wi.ctx.(PermissionDeniedHandlerCtx)
+	if !ok || isZeroNonce(wi.nonce) {
+		return s.rejectRequest(wi, err, pendingResponses, stopCh)
+	}
+	atomic.AddUint64(&s.counters.rejectedRequests, 1)
+	ctx.PermissionDeniedError(err)
+	return pushPendingResponse(pendingResponses, wi, stopCh)
+}
+
+// rejectConcurrencyLimit sends 'concurrency limit exceeded' response
+// for the given request.
+//
+// Returns false if the connection is closed.
+func (s *Server) rejectConcurrencyLimit(wi *serverWorkItem, pendingResponses chan<- *serverWorkItem, stopCh <-chan struct{}) bool {
+	atomic.AddUint64(&s.counters.concurrencyLimitRejections, 1)
+	if isZeroNonce(wi.nonce) {
+		s.releaseWorkItem(wi)
+		return true
+	}
+	wi.ctx.ConcurrencyLimitError(s.concurrency())
+	return pushPendingResponse(pendingResponses, wi, stopCh)
+}
+
+// rejectEvicted sends 'concurrency limit exceeded' response for the request
+// evicted from another connection's queue by the fair scheduler.
+//
+// The response is pushed asynchronously if the connection's writer is busy,
+// so the current connection's reader isn't blocked by another connection.
+func (s *Server) rejectEvicted(t *serverTask) {
+	atomic.AddUint64(&s.counters.concurrencyLimitRejections, 1)
+	if isZeroNonce(t.wi.nonce) {
+		s.releaseWorkItem(t.wi)
+		return
+	}
+	t.wi.ctx.ConcurrencyLimitError(s.concurrency())
+	select {
+	case t.pendingResponses <- t.wi:
+	default:
+		go pushPendingResponse(t.pendingResponses, t.wi, t.stopCh)
+	}
+}
+
 func pushPendingResponse(pendingResponses chan<- *serverWorkItem, wi *serverWorkItem, stopCh <-chan struct{}) bool {
 	select {
 	case pendingResponses <- wi:
@@ -297,7 +799,7 @@ func pushPendingResponse(pendingResponses chan<- *serverWorkItem, wi *serverWork
 	return true
 }
 
-func (s *Server) connWriter(bw *bufio.Writer, conn net.Conn, pendingResponses <-chan *serverWorkItem, stopCh <-chan struct{}) error {
+func (s *Server) connWriter(bw *bufio.Writer, fr *frameRecorder, conn net.Conn, pendingResponses <-chan *serverWorkItem, stopCh <-chan struct{}, inflight *int32) error {
 	var wi *serverWorkItem
 
 	var (
@@ -316,6 +818,7 @@ func (s *Server) connWriter(bw *bufio.Writer, conn net.Conn, pendingResponses <-
 	writeTimeout := s.WriteTimeout
 
 	var lastWriteDeadline time.Time
+	var batchSize int
 	for {
 		select {
 		case wi = <-pendingResponses:
@@ -328,6 +831,11 @@ func (s *Server) connWriter(bw *bufio.Writer, conn net.Conn, pendingResponses <-
 				if err := bw.Flush(); err != nil {
 					return fmt.Errorf("cannot flush response data to client: %s", err)
 				}
+				atomic.AddUint64(&s.counters.flushes, 1)
+				if s.OnFlush != nil {
+					s.OnFlush(batchSize)
+				}
+				batchSize = 0
 				flushCh = nil
 				continue
 			}
@@ -348,11 +856,28 @@ func (s *Server) connWriter(bw *bufio.Writer, conn net.Conn, pendingResponses <-
 			}
 		}
 
-		if _, err := bw.Write(wi.nonce[:]); err != nil {
-			return fmt.Errorf("cannot write response ID: %s", err)
-		}
-		if err := wi.ctx.WriteResponse(bw); err != nil {
-			return fmt.Errorf("cannot write response: %s", err)
+		if wi.pong != nil {
+			if err := writeControlFrame(bw, controlPong, wi.pong); err != nil {
+				return fmt.Errorf("cannot write pong: %s", err)
+			}
+		} else {
+			if _, err := bw.Write(wi.nonce[:]); err != nil {
+				return fmt.Errorf("cannot write response ID: %s", err)
+			}
+			var err error
+			if fr != nil {
+				err = fr.writeFrame(bw, capture.TypeResponse, bytes2Uint32(wi.nonce), wi.ctx.WriteResponse)
+			} else {
+				err = wi.ctx.WriteResponse(bw)
+			}
+			if err != nil {
+				return fmt.Errorf("cannot write response: %s", err)
+			}
+			atomic.AddUint64(&s.counters.responsesSent, 1)
+			batchSize++
+			if inflight != nil && !isZeroNonce(wi.nonce) {
+				atomic.AddInt32(inflight, -1)
+			}
 		}
 
 		s.releaseWorkItem(wi)
@@ -370,8 +895,24 @@ func (s *Server) connWriter(bw *bufio.Writer, conn net.Conn, pendingResponses <-
 }
 
 type serverWorkItem struct {
-	ctx   HandlerCtx
-	nonce [4]byte
+	ctx      HandlerCtx
+	nonce    [4]byte
+	readTime time.Time
+
+	// pong is the payload of pong frame to send instead of the response.
+	pong []byte
+
+	// logger is passed to ctx.Init. It is embedded here, since ctx
+	// keeps it until the work item is released.
+	logger requestLogger
+}
+
+// serverTask is a request dispatched to a worker goroutine together
+// with the connection's response queue.
+type serverTask struct {
+	wi               *serverWorkItem
+	pendingResponses chan<- *serverWorkItem
+	stopCh           <-chan struct{}
 }
 
 func (s *Server) acquireWorkItem() *serverWorkItem {
@@ -385,12 +926,19 @@ func (s *Server) acquireWorkItem() *serverWorkItem {
 }
 
 func (s *Server) releaseWorkItem(wi *serverWorkItem) {
+	wi.pong = nil
+	wi.logger.logger = nil
 	s.workItemPool.Put(wi)
 }
 
-var defaultLogger = log.New(os.Stderr, "", log.LstdFlags)
+func (s *Server) handshake() func(conn net.Conn) (net.Conn, error) {
+	s.handshakeOnce.Do(func() {
+		s.handshakeFunc = newHandshake(s.TLSConfig, true, "", newServerAuthHandshake(s.Authenticator, s.Handshake))
+	})
+	return s.handshakeFunc
+}
 
-func (s *Server) logger() fasthttp.Logger {
+func (s *Server) logger() Logger {
 	if s.Logger != nil {
 		return s.Logger
 	}
@@ -10,6 +10,8 @@ import (
 	"testing"
 	"time"
 
+	"github.com/UladzimirTrehubenka/fastrpc/faultconn"
+	"github.com/UladzimirTrehubenka/fastrpc/rpclog"
 	"github.com/UladzimirTrehubenka/fastrpc/tlv"
 	"github.com/valyala/fasthttp/fasthttputil"
 )
@@ -54,10 +56,6 @@ func TestServerBrokenClientSendRequestAndCloseConn(t *testing.T) {
 	})
 }
 
-type nilLogger struct{}
-
-func (nl *nilLogger) Printf(fmt string, args ...interface{}) {}
-
 func newTestHandlerCtx() HandlerCtx {
 	return &tlv.RequestCtx{
 		ConcurrencyLimitErrorHandler: concurrencyLimitErrorHandler,
@@ -72,7 +70,7 @@ func testServerBrokenClient(t *testing.T, clientConnFunc func(net.Conn) error) {
 	s := &Server{
 		NewHandlerCtx: newTestHandlerCtx,
 		Handler:       testEchoHandler,
-		Logger:        &nilLogger{},
+		Logger:        rpclog.Discard,
 	}
 	serverStop, ln := newTestServerExt(s)
 
@@ -100,6 +98,52 @@ func testServerBrokenClient(t *testing.T, clientConnFunc func(net.Conn) error) {
 	}
 }
 
+func TestServerFaultyClient(t *testing.T) {
+	for _, f := range []*faultconn.Faults{
+		{DropRate: 0.5, Seed: 1},
+		{ResetRate: 0.5, Seed: 2},
+		{GarbageRate: 0.5, Seed: 3},
+		{CorruptRate: 0.5, Seed: 4},
+		{PartialWriteRate: 0.5, Seed: 5},
+		{StallRate: 0.5, StallDuration: 10 * time.Millisecond, Seed: 6},
+	} {
+		testServerFaultyClient(t, f)
+	}
+}
+
+func testServerFaultyClient(t *testing.T, f *faultconn.Faults) {
+	s := &Server{
+		NewHandlerCtx: newTestHandlerCtx,
+		Handler:       testEchoHandler,
+		Logger:        rpclog.Discard,
+	}
+	serverStop, ln := newTestServerExt(s)
+
+	c := newTestClient(ln)
+	c.Logger = rpclog.Discard
+	c.Dial = f.Dial(func(addr string) (net.Conn, error) {
+		return ln.Dial()
+	})
+	for i := 0; i < 10; i++ {
+		var req tlv.Request
+		var resp tlv.Response
+		req.SwapValue([]byte("foobar"))
+		c.DoDeadline(&req, &resp, time.Now().Add(20*time.Millisecond))
+	}
+	c.Close()
+
+	// The server must serve other clients.
+	c = newTestClient(ln)
+	if err := testDoEcho(c, "foobar"); err != nil {
+		t.Fatalf("unexpected error for faults %+v: %s", f, err)
+	}
+	c.Close()
+
+	if err := serverStop(); err != nil {
+		t.Fatalf("cannot shutdown server: %s", err)
+	}
+}
+
 func TestServerTLSUnencryptedConn(t *testing.T) {
 	s := &Server{
 		NewHandlerCtx: newTestHandlerCtx,
@@ -70,6 +70,32 @@ func BenchmarkEndToEndPipeline1000(b *testing.B) {
 	benchmarkEndToEnd(b, 1000, 0, true)
 }
 
+func BenchmarkEndToEndWorkerPool1(b *testing.B) {
+	benchmarkEndToEndWorkerPool(b, 1)
+}
+
+func BenchmarkEndToEndWorkerPool10(b *testing.B) {
+	benchmarkEndToEndWorkerPool(b, 10)
+}
+
+func BenchmarkEndToEndWorkerPool100(b *testing.B) {
+	benchmarkEndToEndWorkerPool(b, 100)
+}
+
+func BenchmarkEndToEndWorkerPool1000(b *testing.B) {
+	benchmarkEndToEndWorkerPool(b, 1000)
+}
+
+func BenchmarkEndToEndWorkerPool10K(b *testing.B) {
+	benchmarkEndToEndWorkerPool(b, 10000)
+}
+
+func benchmarkEndToEndWorkerPool(b *testing.B, parallelism int) {
+	benchmarkEndToEndExt(b, parallelism, 0, func(s *Server) {
+		s.WorkerPool = true
+	})
+}
+
 func BenchmarkSendNowait(b *testing.B) {
 	bN := uint64(b.N)
 	var n uint64
@@ -131,6 +157,12 @@ func BenchmarkSendNowait(b *testing.B) {
 }
 
 func benchmarkEndToEnd(b *testing.B, parallelism int, batchDelay time.Duration, pipelineRequests bool) {
+	benchmarkEndToEndExt(b, parallelism, batchDelay, func(s *Server) {
+		s.PipelineRequests = pipelineRequests
+	})
+}
+
+func benchmarkEndToEndExt(b *testing.B, parallelism int, batchDelay time.Duration, configure func(s *Server)) {
 	var serverBatchDelay time.Duration
 	if batchDelay > 0 {
 		serverBatchDelay = 100 * time.Microsecond
@@ -146,10 +178,10 @@ func benchmarkEndToEnd(b *testing.B, parallelism int, batchDelay time.Duration,
 			ctx.Response.Append(expectedBody)
 			return ctx
 		},
-		Concurrency:      parallelism * runtime.NumCPU(),
-		MaxBatchDelay:    serverBatchDelay,
-		PipelineRequests: pipelineRequests,
+		Concurrency:   parallelism * runtime.NumCPU(),
+		MaxBatchDelay: serverBatchDelay,
 	}
+	configure(s)
 	serverStop, ln := newTestServerExt(s)
 
 	var cc []*Client
@@ -0,0 +1,295 @@
+//go:build linux
+// +build linux
+
+package shmconn
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"os"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+const (
+	efdCloexec  = syscall.O_CLOEXEC
+	efdNonblock = syscall.O_NONBLOCK
+
+	// spinCount is the number of scheduler yields before waiting
+	// on eventfd, since the peer is likely to make progress soon
+	// on the hot path.
+	spinCount = 32
+)
+
+// Eventfd indexes in the handshake message.
+const (
+	efdClientToServerData = iota
+	efdClientToServerSpace
+	efdServerToClientData
+	efdServerToClientSpace
+	efdCount
+)
+
+// conn is net.Conn over shared memory rings.
+type conn struct {
+	ctrl *net.UnixConn
+	mem  []byte
+
+	rx ring
+	tx ring
+
+	// rxData and txSpace are waited on by the conn.
+	rxData  *os.File
+	txSpace *os.File
+
+	// rxSpace and txData are signaled by the conn.
+	rxSpace *os.File
+	txData  *os.File
+
+	readMu  sync.Mutex
+	writeMu sync.Mutex
+
+	closed     uint32
+	peerClosed uint32
+
+	laddr Addr
+	raddr Addr
+}
+
+func newConn(ctrl *net.UnixConn, mem []byte, size int, efds []*os.File, isServer bool, laddr, raddr Addr) *conn {
+	c := &conn{
+		ctrl:  ctrl,
+		mem:   mem,
+		laddr: laddr,
+		raddr: raddr,
+	}
+	c2s := newRing(mem, 0, size)
+	s2c := newRing(mem, 1, size)
+	if isServer {
+		c.rx, c.tx = c2s, s2c
+		c.rxData, c.rxSpace = efds[efdClientToServerData], efds[efdClientToServerSpace]
+		c.txData, c.txSpace = efds[efdServerToClientData], efds[efdServerToClientSpace]
+	} else {
+		c.rx, c.tx = s2c, c2s
+		c.rxData, c.rxSpace = efds[efdServerToClientData], efds[efdServerToClientSpace]
+		c.txData, c.txSpace = efds[efdClientToServerData], efds[efdClientToServerSpace]
+	}
+	go c.watchPeer()
+	return c
+}
+
+// watchPeer waits until the peer closes the control connection
+// and wakes up the conn waiters.
+func (c *conn) watchPeer() {
+	var buf [1]byte
+	for {
+		if _, err := c.ctrl.Read(buf[:]); err != nil {
+			break
+		}
+	}
+	atomic.StoreUint32(&c.peerClosed, 1)
+	signal(c.rxData)
+	signal(c.txSpace)
+}
+
+// Read implements net.Conn.
+func (c *conn) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+
+	for i := 0; ; i++ {
+		if atomic.LoadUint32(&c.closed) != 0 {
+			return 0, errClosed
+		}
+		// peerClosed must be loaded before reading the ring, so the data
+		// written by the peer before closing isn't lost.
+		peerClosed := atomic.LoadUint32(&c.peerClosed) != 0
+		n, err := c.rx.read(p)
+		if err != nil {
+			return 0, err
+		}
+		if n > 0 {
+			if atomic.LoadUint32(c.rx.producerWaiting) != 0 {
+				signal(c.rxSpace)
+			}
+			return n, nil
+		}
+		if peerClosed {
+			return 0, io.EOF
+		}
+		if i < spinCount {
+			runtime.Gosched()
+			continue
+		}
+
+		atomic.StoreUint32(c.rx.consumerWaiting, 1)
+		if c.rx.readable() || atomic.LoadUint32(&c.peerClosed) != 0 {
+			atomic.StoreUint32(c.rx.consumerWaiting, 0)
+			continue
+		}
+		err = wait(c.rxData)
+		atomic.StoreUint32(c.rx.consumerWaiting, 0)
+		if err != nil {
+			return 0, c.waitError("read", err)
+		}
+	}
+}
+
+// Write implements net.Conn.
+func (c *conn) Write(p []byte) (int, error) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	written := 0
+	for i := 0; written < len(p); i++ {
+		if atomic.LoadUint32(&c.closed) != 0 {
+			return written, errClosed
+		}
+		if atomic.LoadUint32(&c.peerClosed) != 0 {
+			return written, &net.OpError{Op: "write", Net: "shm", Addr: c.raddr, Err: syscall.EPIPE}
+		}
+		n, err := c.tx.write(p[written:])
+		if err != nil {
+			return written, err
+		}
+		if n > 0 {
+			written += n
+			i = 0
+			if atomic.LoadUint32(c.tx.consumerWaiting) != 0 {
+				signal(c.txData)
+			}
+			continue
+		}
+		if i < spinCount {
+			runtime.Gosched()
+			continue
+		}
+
+		atomic.StoreUint32(c.tx.producerWaiting, 1)
+		if c.tx.writable() || atomic.LoadUint32(&c.peerClosed) != 0 {
+			atomic.StoreUint32(c.tx.producerWaiting, 0)
+			continue
+		}
+		err = wait(c.txSpace)
+		atomic.StoreUint32(c.tx.producerWaiting, 0)
+		if err != nil {
+			return written, c.waitError("write", err)
+		}
+	}
+	return written, nil
+}
+
+func (c *conn) waitError(op string, err error) error {
+	if atomic.LoadUint32(&c.closed) != 0 {
+		return errClosed
+	}
+	if te, ok := err.(interface {
+		Timeout() bool
+	}); ok && te.Timeout() {
+		err = timeoutError{}
+	}
+	return &net.OpError{
+		Op:     op,
+		Net:    "shm",
+		Source: c.laddr,
+		Addr:   c.raddr,
+		Err:    err,
+	}
+}
+
+// timeoutError is returned from Read and Write after the deadline.
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+// Close implements net.Conn.
+func (c *conn) Close() error {
+	if !atomic.CompareAndSwapUint32(&c.closed, 0, 1) {
+		return errClosed
+	}
+	// Closing the control connection notifies the peer.
+	c.ctrl.Close()
+
+	// Unblock the conn waiters before unmapping the memory they may access.
+	c.rxData.Close()
+	c.txSpace.Close()
+	c.readMu.Lock()
+	c.writeMu.Lock()
+	c.rxSpace.Close()
+	c.txData.Close()
+	err := syscall.Munmap(c.mem)
+	c.mem = nil
+	c.writeMu.Unlock()
+	c.readMu.Unlock()
+	return err
+}
+
+// LocalAddr implements net.Conn.
+func (c *conn) LocalAddr() net.Addr {
+	return c.laddr
+}
+
+// RemoteAddr implements net.Conn.
+func (c *conn) RemoteAddr() net.Addr {
+	return c.raddr
+}
+
+// NetConn returns the control unix socket connection.
+//
+// This allows peercred.Get, per-IP limits and tlv.RequestCtx.RemoteIP
+// to treat shared memory connections as unix socket connections.
+func (c *conn) NetConn() net.Conn {
+	return c.ctrl
+}
+
+// SetDeadline implements net.Conn.
+func (c *conn) SetDeadline(t time.Time) error {
+	if err := c.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return c.SetWriteDeadline(t)
+}
+
+// SetReadDeadline implements net.Conn.
+func (c *conn) SetReadDeadline(t time.Time) error {
+	return c.rxData.SetReadDeadline(t)
+}
+
+// SetWriteDeadline implements net.Conn.
+//
+// The deadline limits waiting for free space in the ring.
+func (c *conn) SetWriteDeadline(t time.Time) error {
+	return c.txSpace.SetReadDeadline(t)
+}
+
+func newEventfd() (*os.File, error) {
+	fd, _, errno := syscall.Syscall(syscall.SYS_EVENTFD2, 0, efdCloexec|efdNonblock, 0)
+	if errno != 0 {
+		return nil, os.NewSyscallError("eventfd2", errno)
+	}
+	return os.NewFile(fd, "eventfd"), nil
+}
+
+// signal wakes up the waiter on the given eventfd.
+func signal(f *os.File) {
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], 1)
+	// Ignore the error, since it may be returned only for closed f.
+	f.Write(buf[:])
+}
+
+// wait waits until the given eventfd is signaled.
+func wait(f *os.File) error {
+	var buf [8]byte
+	_, err := f.Read(buf[:])
+	return err
+}
@@ -0,0 +1,263 @@
+//go:build linux
+// +build linux
+
+package shmconn
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/UladzimirTrehubenka/fastrpc"
+	"github.com/UladzimirTrehubenka/fastrpc/peercred"
+	"github.com/UladzimirTrehubenka/fastrpc/tlv"
+)
+
+func newTestListener(t *testing.T) (net.Listener, func()) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "fastrpc-shmconn")
+	if err != nil {
+		t.Fatalf("cannot create temporary dir: %s", err)
+	}
+	ln, err := Listen(filepath.Join(dir, "sock"))
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("cannot listen: %s", err)
+	}
+	return ln, func() {
+		ln.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func newTestConnPair(t *testing.T) (net.Conn, net.Conn, func()) {
+	t.Helper()
+	ln, cleanup := newTestListener(t)
+	d := &Dialer{RingSize: minRingSize}
+	clientConn, err := d.Dial(ln.Addr().String())
+	if err != nil {
+		cleanup()
+		t.Fatalf("cannot dial: %s", err)
+	}
+	serverConn, err := ln.Accept()
+	if err != nil {
+		cleanup()
+		t.Fatalf("cannot accept: %s", err)
+	}
+	return clientConn, serverConn, func() {
+		clientConn.Close()
+		serverConn.Close()
+		cleanup()
+	}
+}
+
+func TestConnReadWrite(t *testing.T) {
+	clientConn, serverConn, cleanup := newTestConnPair(t)
+	defer cleanup()
+
+	// The data exceeds the ring size, so the writer must wait for the reader.
+	data := make([]byte, 10*minRingSize+123)
+	for i := range data {
+		data[i] = byte(i * 13)
+	}
+
+	// Echo the data back to the client.
+	go io.Copy(serverConn, serverConn)
+
+	writeErr := make(chan error, 1)
+	go func() {
+		_, err := clientConn.Write(data)
+		writeErr <- err
+	}()
+	result := make([]byte, len(data))
+	if _, err := io.ReadFull(clientConn, result); err != nil {
+		t.Fatalf("cannot read echoed data: %s", err)
+	}
+	if err := <-writeErr; err != nil {
+		t.Fatalf("unexpected write error: %s", err)
+	}
+	if !bytes.Equal(result, data) {
+		t.Fatalf("unexpected echoed data")
+	}
+}
+
+func TestConnReadDeadline(t *testing.T) {
+	clientConn, _, cleanup := newTestConnPair(t)
+	defer cleanup()
+
+	if err := clientConn.SetReadDeadline(time.Now().Add(50 * time.Millisecond)); err != nil {
+		t.Fatalf("cannot set read deadline: %s", err)
+	}
+	var buf [1]byte
+	_, err := clientConn.Read(buf[:])
+	ne, ok := err.(net.Error)
+	if !ok || !ne.Timeout() {
+		t.Fatalf("unexpected error: %v. Expecting timeout error", err)
+	}
+}
+
+func TestConnPeerClose(t *testing.T) {
+	clientConn, serverConn, cleanup := newTestConnPair(t)
+	defer cleanup()
+
+	if _, err := serverConn.Write([]byte("foobar")); err != nil {
+		t.Fatalf("unexpected write error: %s", err)
+	}
+	if err := serverConn.Close(); err != nil {
+		t.Fatalf("cannot close conn: %s", err)
+	}
+
+	// The data written before closing must be readable.
+	data, err := ioutil.ReadAll(clientConn)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if string(data) != "foobar" {
+		t.Fatalf("unexpected data: %q. Expecting %q", data, "foobar")
+	}
+	if _, err := clientConn.Write([]byte("foo")); err == nil {
+		t.Fatalf("expecting non-nil error when writing to closed peer")
+	}
+	if _, err := serverConn.Read(data[:1]); err != errClosed {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, errClosed)
+	}
+}
+
+func TestServerClient(t *testing.T) {
+	ln, cleanup := newTestListener(t)
+	defer cleanup()
+
+	s := &fastrpc.Server{
+		NewHandlerCtx: func() fastrpc.HandlerCtx {
+			return &tlv.RequestCtx{}
+		},
+		Handler: func(ctxv fastrpc.HandlerCtx) fastrpc.HandlerCtx {
+			ctx := ctxv.(*tlv.RequestCtx)
+			ctx.Write(ctx.Request.Value())
+			return ctx
+		},
+	}
+	serverDone := make(chan error, 1)
+	go func() {
+		serverDone <- s.Serve(ln)
+	}()
+
+	c := &fastrpc.Client{
+		NewResponse: func() fastrpc.ResponseReader {
+			return &tlv.Response{}
+		},
+		Addr: ln.Addr().String(),
+		Dial: Dial,
+	}
+	for i := 0; i < 100; i++ {
+		var req tlv.Request
+		var resp tlv.Response
+		value := bytes.Repeat([]byte("x"), i*100)
+		req.SwapValue(value)
+		if err := c.DoDeadline(&req, &resp, time.Now().Add(time.Second)); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if !bytes.Equal(resp.Value(), value) {
+			t.Fatalf("unexpected response size: %d. Expecting %d", len(resp.Value()), len(value))
+		}
+	}
+	c.Close()
+
+	if err := ln.Close(); err != nil {
+		t.Fatalf("cannot close listener: %s", err)
+	}
+	select {
+	case err := <-serverDone:
+		if err != nil {
+			t.Fatalf("unexpected server error: %s", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("timeout")
+	}
+}
+
+func TestMapSegmentSeals(t *testing.T) {
+	f, mem, err := newSegment(minRingSize)
+	if err != nil {
+		t.Fatalf("cannot create segment: %s", err)
+	}
+	defer f.Close()
+	syscall.Munmap(mem)
+
+	// Sealed segment cannot be resized.
+	if err := f.Truncate(0); err == nil {
+		t.Fatalf("expecting non-nil error when shrinking sealed segment")
+	}
+	mem, size, err := mapSegment(f)
+	if err != nil {
+		t.Fatalf("cannot map sealed segment: %s", err)
+	}
+	syscall.Munmap(mem)
+	if size != minRingSize {
+		t.Fatalf("unexpected ring size: %d. Expecting %d", size, minRingSize)
+	}
+
+	// Unsealed segment must be rejected, since the client may shrink it
+	// after it is mapped by the server.
+	uf, err := memfdCreate("fastrpc-shm-test")
+	if err != nil {
+		t.Fatalf("cannot create memfd: %s", err)
+	}
+	defer uf.Close()
+	if err := uf.Truncate(int64(segmentSize(minRingSize))); err != nil {
+		t.Fatalf("cannot resize memfd: %s", err)
+	}
+	if _, _, err := mapSegment(uf); err == nil {
+		t.Fatalf("expecting non-nil error for unsealed segment")
+	}
+}
+
+func TestListenerAcceptError(t *testing.T) {
+	ln, cleanup := newTestListener(t)
+	defer cleanup()
+
+	// Break the underlying listener, so acceptLoop stops on permanent error.
+	ln.(*listener).ln.Close()
+
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := ln.Accept()
+		errCh <- err
+	}()
+	select {
+	case err := <-errCh:
+		if err == nil {
+			t.Fatalf("expecting non-nil error")
+		}
+		if err == errClosed {
+			t.Fatalf("expecting accept error instead of %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("timeout")
+	}
+}
+
+func TestConnNetConn(t *testing.T) {
+	_, serverConn, cleanup := newTestConnPair(t)
+	defer cleanup()
+
+	cred, err := peercred.Get(serverConn)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if cred.PID != os.Getpid() {
+		t.Fatalf("unexpected pid: %d. Expecting %d", cred.PID, os.Getpid())
+	}
+
+	var ctx tlv.RequestCtx
+	ctx.Init(serverConn, nil)
+	if ip := ctx.RemoteIP(); !ip.Equal(net.IPv4(127, 0, 0, 1)) {
+		t.Fatalf("unexpected remote ip: %s. Expecting 127.0.0.1", ip)
+	}
+}
@@ -0,0 +1,29 @@
+// Package shmconn provides net.Conn and net.Listener over shared memory
+// for communication between processes on the same host.
+//
+// Data is transferred via a pair of single-producer single-consumer
+// ring buffers in memory shared by the client and the server, so
+// no syscalls are needed while both sides are busy. The sides
+// are woken up via eventfd only when they wait for data or space.
+//
+// The connection is established over unix socket, which is used
+// for passing shared memory and eventfd descriptors and for detecting
+// the peer close.
+//
+// Connections returned by Listener expose the control unix socket
+// connection via NetConn method, so peercred.Get and per-IP limits
+// in fastrpc.Server apply to them like to unix socket connections.
+//
+// Only Linux is supported. Use Listen result in fastrpc.Server.Serve
+// and Dial as fastrpc.Client.Dial:
+//
+//	ln, err := shmconn.Listen("/var/run/service.shm.sock")
+//	...
+//	go s.Serve(ln)
+//
+//	c := &fastrpc.Client{
+//		Addr: "/var/run/service.shm.sock",
+//		Dial: shmconn.Dial,
+//		...
+//	}
+package shmconn
@@ -0,0 +1,367 @@
+//go:build linux
+// +build linux
+
+package shmconn
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/UladzimirTrehubenka/fastrpc/internal/netutil"
+)
+
+const (
+	handshakeVersion = 1
+	handshakeAck     = 1
+
+	// handshakeTimeout limits the server side of the handshake.
+	handshakeTimeout = 3 * time.Second
+)
+
+// memfd_create flags and file seals from linux/memfd.h and linux/fcntl.h.
+const (
+	mfdCloexec      = 0x1
+	mfdAllowSealing = 0x2
+
+	fAddSeals = 1033
+	fGetSeals = 1034
+
+	fSealSeal   = 0x1
+	fSealShrink = 0x2
+	fSealGrow   = 0x4
+
+	// segmentSeals must be set on shared memory segments, so the client
+	// cannot resize the segment mapped by the server. Accessing
+	// the mapping beyond the truncated file results in SIGBUS.
+	segmentSeals = fSealShrink | fSealGrow
+)
+
+func dial(addr string, size int, deadline time.Time) (net.Conn, error) {
+	nd := &net.Dialer{
+		Deadline: deadline,
+	}
+	c, err := nd.Dial("unix", addr)
+	if err != nil {
+		return nil, err
+	}
+	ctrl := c.(*net.UnixConn)
+	conn, err := clientHandshake(ctrl, addr, size, deadline)
+	if err != nil {
+		ctrl.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+func clientHandshake(ctrl *net.UnixConn, addr string, size int, deadline time.Time) (*conn, error) {
+	if err := ctrl.SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+
+	f, mem, err := newSegment(size)
+	if err != nil {
+		return nil, err
+	}
+	// The memory stays mapped after closing the file.
+	defer f.Close()
+
+	efds, err := newEventfds()
+	if err != nil {
+		syscall.Munmap(mem)
+		return nil, err
+	}
+	fail := func(err error) (*conn, error) {
+		closeFiles(efds)
+		syscall.Munmap(mem)
+		return nil, err
+	}
+
+	fds := []int{int(f.Fd())}
+	for _, efd := range efds {
+		fds = append(fds, int(efd.Fd()))
+	}
+	if _, _, err := ctrl.WriteMsgUnix([]byte{handshakeVersion}, syscall.UnixRights(fds...), nil); err != nil {
+		return fail(fmt.Errorf("cannot send shared memory descriptors: %w", err))
+	}
+	var ack [1]byte
+	if _, err := ctrl.Read(ack[:]); err != nil {
+		return fail(fmt.Errorf("cannot read handshake ack: %w", err))
+	}
+	if ack[0] != handshakeAck {
+		return fail(fmt.Errorf("unexpected handshake ack: %d", ack[0]))
+	}
+	if err := ctrl.SetDeadline(time.Time{}); err != nil {
+		return fail(err)
+	}
+	return newConn(ctrl, mem, size, efds, false, "", Addr(addr)), nil
+}
+
+func serverHandshake(ctrl *net.UnixConn, addr Addr) (*conn, error) {
+	if err := ctrl.SetDeadline(time.Now().Add(handshakeTimeout)); err != nil {
+		return nil, err
+	}
+
+	var buf [1]byte
+	oob := make([]byte, syscall.CmsgSpace(4*(1+efdCount)))
+	n, oobn, _, _, err := ctrl.ReadMsgUnix(buf[:], oob)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read handshake: %w", err)
+	}
+	fds, err := parseUnixRights(oob[:oobn])
+	if err != nil {
+		return nil, err
+	}
+	files := make([]*os.File, len(fds))
+	for i, fd := range fds {
+		syscall.CloseOnExec(fd)
+		files[i] = os.NewFile(uintptr(fd), "shmconn")
+	}
+	if n != 1 || buf[0] != handshakeVersion {
+		closeFiles(files)
+		return nil, fmt.Errorf("unsupported handshake version: %d", buf[0])
+	}
+	if len(files) != 1+efdCount {
+		closeFiles(files)
+		return nil, fmt.Errorf("unexpected number of descriptors in handshake: %d. Expecting %d", len(files), 1+efdCount)
+	}
+
+	shmFile, efds := files[0], files[1:]
+	mem, size, err := mapSegment(shmFile)
+	shmFile.Close()
+	if err != nil {
+		closeFiles(efds)
+		return nil, err
+	}
+	if _, err := ctrl.Write([]byte{handshakeAck}); err != nil {
+		closeFiles(efds)
+		syscall.Munmap(mem)
+		return nil, fmt.Errorf("cannot write handshake ack: %w", err)
+	}
+	if err := ctrl.SetDeadline(time.Time{}); err != nil {
+		closeFiles(efds)
+		syscall.Munmap(mem)
+		return nil, err
+	}
+	return newConn(ctrl, mem, size, efds, true, addr, ""), nil
+}
+
+func parseUnixRights(oob []byte) ([]int, error) {
+	msgs, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse handshake control message: %w", err)
+	}
+	var fds []int
+	for i := range msgs {
+		msgFds, err := syscall.ParseUnixRights(&msgs[i])
+		if err != nil {
+			for _, fd := range fds {
+				syscall.Close(fd)
+			}
+			return nil, fmt.Errorf("cannot parse handshake descriptors: %w", err)
+		}
+		fds = append(fds, msgFds...)
+	}
+	return fds, nil
+}
+
+// newSegment creates shared memory segment for rings with the given size.
+//
+// The segment is memfd sealed against resizing.
+func newSegment(size int) (*os.File, []byte, error) {
+	f, err := memfdCreate("fastrpc-shm")
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot create shared memory file: %w", err)
+	}
+
+	total := segmentSize(size)
+	if err := f.Truncate(int64(total)); err != nil {
+		f.Close()
+		return nil, nil, fmt.Errorf("cannot resize shared memory file: %w", err)
+	}
+	if _, err := fcntl(f, fAddSeals, segmentSeals|fSealSeal); err != nil {
+		f.Close()
+		return nil, nil, fmt.Errorf("cannot seal shared memory file: %w", err)
+	}
+	mem, err := syscall.Mmap(int(f.Fd()), 0, total, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
+	if err != nil {
+		f.Close()
+		return nil, nil, fmt.Errorf("cannot map shared memory: %w", err)
+	}
+	initSegment(mem, size)
+	return f, mem, nil
+}
+
+// mapSegment maps the shared memory segment created by the client.
+//
+// The segment must be sealed against resizing, so the client
+// cannot crash the server by truncating the mapped segment.
+func mapSegment(f *os.File) ([]byte, int, error) {
+	seals, err := fcntl(f, fGetSeals, 0)
+	if err != nil {
+		return nil, 0, fmt.Errorf("cannot obtain shared memory seals: %w", err)
+	}
+	if seals&segmentSeals != segmentSeals {
+		return nil, 0, fmt.Errorf("shared memory isn't sealed against resizing; seals: 0x%x", seals)
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, 0, fmt.Errorf("cannot stat shared memory file: %w", err)
+	}
+	total := fi.Size()
+	if total < ringDataOffset || total > int64(segmentSize(maxRingSize)) {
+		return nil, 0, fmt.Errorf("unexpected shared memory size: %d bytes", total)
+	}
+	mem, err := syscall.Mmap(int(f.Fd()), 0, int(total), syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, 0, fmt.Errorf("cannot map shared memory: %w", err)
+	}
+	size, err := checkSegment(mem)
+	if err != nil {
+		syscall.Munmap(mem)
+		return nil, 0, err
+	}
+	return mem, size, nil
+}
+
+func memfdCreate(name string) (*os.File, error) {
+	p, err := syscall.BytePtrFromString(name)
+	if err != nil {
+		return nil, err
+	}
+	fd, _, errno := syscall.Syscall(sysMemfdCreate, uintptr(unsafe.Pointer(p)), mfdCloexec|mfdAllowSealing, 0)
+	if errno != 0 {
+		return nil, os.NewSyscallError("memfd_create", errno)
+	}
+	return os.NewFile(fd, name), nil
+}
+
+func fcntl(f *os.File, cmd, arg int) (int, error) {
+	r, _, errno := syscall.Syscall(syscall.SYS_FCNTL, f.Fd(), uintptr(cmd), uintptr(arg))
+	if errno != 0 {
+		return 0, os.NewSyscallError("fcntl", errno)
+	}
+	return int(r), nil
+}
+
+func newEventfds() ([]*os.File, error) {
+	efds := make([]*os.File, 0, efdCount)
+	for i := 0; i < efdCount; i++ {
+		f, err := newEventfd()
+		if err != nil {
+			closeFiles(efds)
+			return nil, err
+		}
+		efds = append(efds, f)
+	}
+	return efds, nil
+}
+
+func closeFiles(files []*os.File) {
+	for _, f := range files {
+		f.Close()
+	}
+}
+
+// listener accepts shared memory connections.
+type listener struct {
+	ln   *net.UnixListener
+	addr Addr
+
+	conns  chan *conn
+	stopCh chan struct{}
+
+	// errCh is closed when acceptLoop stops on acceptErr.
+	errCh     chan struct{}
+	acceptErr error
+
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+}
+
+func listen(addr string) (net.Listener, error) {
+	netutil.RemoveStaleUnixSocket(addr, DefaultDialTimeout)
+	ln, err := net.Listen("unix", addr)
+	if err != nil {
+		return nil, err
+	}
+	l := &listener{
+		ln:     ln.(*net.UnixListener),
+		addr:   Addr(addr),
+		conns:  make(chan *conn),
+		stopCh: make(chan struct{}),
+		errCh:  make(chan struct{}),
+	}
+	l.wg.Add(1)
+	go l.acceptLoop()
+	return l, nil
+}
+
+// acceptLoop accepts control connections and performs handshakes
+// in separate goroutines, so slow clients don't block Accept.
+func (l *listener) acceptLoop() {
+	defer l.wg.Done()
+	for {
+		ctrl, err := l.ln.AcceptUnix()
+		if err != nil {
+			select {
+			case <-l.stopCh:
+				return
+			default:
+			}
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				time.Sleep(10 * time.Millisecond)
+				continue
+			}
+			l.acceptErr = err
+			close(l.errCh)
+			return
+		}
+		l.wg.Add(1)
+		go func() {
+			defer l.wg.Done()
+			c, err := serverHandshake(ctrl, l.addr)
+			if err != nil {
+				ctrl.Close()
+				return
+			}
+			select {
+			case l.conns <- c:
+			case <-l.stopCh:
+				c.Close()
+			}
+		}()
+	}
+}
+
+// Accept implements net.Listener.
+func (l *listener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.conns:
+		return c, nil
+	case <-l.stopCh:
+		return nil, errClosed
+	case <-l.errCh:
+		return nil, l.acceptErr
+	}
+}
+
+// Close implements net.Listener.
+func (l *listener) Close() error {
+	err := errClosed
+	l.closeOnce.Do(func() {
+		close(l.stopCh)
+		err = l.ln.Close()
+		l.wg.Wait()
+	})
+	return err
+}
+
+// Addr implements net.Listener.
+func (l *listener) Addr() net.Addr {
+	return l.addr
+}
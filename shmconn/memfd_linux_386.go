@@ -0,0 +1,8 @@
+//go:build linux
+// +build linux
+
+package shmconn
+
+// sysMemfdCreate is memfd_create syscall number, which is missing
+// in syscall package for this architecture.
+const sysMemfdCreate = 356
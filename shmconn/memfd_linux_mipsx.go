@@ -0,0 +1,9 @@
+//go:build linux && (mips || mipsle)
+// +build linux
+// +build mips mipsle
+
+package shmconn
+
+// sysMemfdCreate is memfd_create syscall number, which is missing
+// in syscall package for this architecture.
+const sysMemfdCreate = 4354
@@ -0,0 +1,9 @@
+//go:build linux && (arm64 || loong64 || mips64 || mips64le || riscv64 || s390x)
+// +build linux
+// +build arm64 loong64 mips64 mips64le riscv64 s390x
+
+package shmconn
+
+import "syscall"
+
+const sysMemfdCreate = syscall.SYS_MEMFD_CREATE
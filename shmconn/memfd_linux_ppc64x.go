@@ -0,0 +1,9 @@
+//go:build linux && (ppc64 || ppc64le)
+// +build linux
+// +build ppc64 ppc64le
+
+package shmconn
+
+// sysMemfdCreate is memfd_create syscall number, which is missing
+// in syscall package for this architecture.
+const sysMemfdCreate = 360
@@ -0,0 +1,147 @@
+package shmconn
+
+import (
+	"encoding/binary"
+	"errors"
+	"sync/atomic"
+	"unsafe"
+)
+
+// Shared memory layout:
+//
+//	[0:8]       magic
+//	[8:12]      version
+//	[12:16]     ring size
+//	[64:320]    client->server ring header
+//	[320:576]   server->client ring header
+//	[4096:...]  client->server ring data followed by server->client ring data
+//
+// Ring header fields are placed on separate cache lines, so the producer
+// and the consumer don't contend on them.
+const (
+	segmentMagic   = "FRPCSHM1"
+	segmentVersion = 1
+
+	ringHeaderOffset = 64
+	ringHeaderSize   = 256
+	ringDataOffset   = 4096
+
+	ringHeadOffset            = 0
+	ringTailOffset            = 64
+	ringConsumerWaitingOffset = 128
+	ringProducerWaitingOffset = 192
+)
+
+var errCorruptedRing = errors.New("shmconn: corrupted ring buffer")
+
+func segmentSize(ringSize int) int {
+	return ringDataOffset + 2*ringSize
+}
+
+func initSegment(mem []byte, ringSize int) {
+	copy(mem, segmentMagic)
+	binary.LittleEndian.PutUint32(mem[8:], segmentVersion)
+	binary.LittleEndian.PutUint32(mem[12:], uint32(ringSize))
+}
+
+// checkSegment validates the segment header and returns the ring size.
+func checkSegment(mem []byte) (int, error) {
+	if len(mem) < ringDataOffset {
+		return 0, errors.New("shmconn: too small shared memory segment")
+	}
+	if string(mem[:8]) != segmentMagic {
+		return 0, errors.New("shmconn: invalid shared memory segment magic")
+	}
+	if v := binary.LittleEndian.Uint32(mem[8:]); v != segmentVersion {
+		return 0, errors.New("shmconn: unsupported shared memory segment version")
+	}
+	size := int(binary.LittleEndian.Uint32(mem[12:]))
+	if size < minRingSize || size > maxRingSize || size&(size-1) != 0 {
+		return 0, errors.New("shmconn: invalid ring size")
+	}
+	if len(mem) < segmentSize(size) {
+		return 0, errors.New("shmconn: shared memory segment is smaller than rings")
+	}
+	return size, nil
+}
+
+// ring is a single-producer single-consumer ring buffer in shared memory.
+//
+// head and tail are free-running positions of the consumer
+// and the producer.
+type ring struct {
+	head            *uint64
+	tail            *uint64
+	consumerWaiting *uint32
+	producerWaiting *uint32
+	data            []byte
+	size            uint64
+}
+
+// newRing returns the ring with the given index in mem.
+func newRing(mem []byte, idx, size int) ring {
+	hdr := mem[ringHeaderOffset+idx*ringHeaderSize:]
+	dataOffset := ringDataOffset + idx*size
+	return ring{
+		head:            (*uint64)(unsafe.Pointer(&hdr[ringHeadOffset])),
+		tail:            (*uint64)(unsafe.Pointer(&hdr[ringTailOffset])),
+		consumerWaiting: (*uint32)(unsafe.Pointer(&hdr[ringConsumerWaitingOffset])),
+		producerWaiting: (*uint32)(unsafe.Pointer(&hdr[ringProducerWaitingOffset])),
+		data:            mem[dataOffset : dataOffset+size],
+		size:            uint64(size),
+	}
+}
+
+// write copies p to the ring and returns the number of copied bytes.
+//
+// It must be called only by the producer.
+func (r *ring) write(p []byte) (int, error) {
+	tail := atomic.LoadUint64(r.tail)
+	used := tail - atomic.LoadUint64(r.head)
+	if used > r.size {
+		return 0, errCorruptedRing
+	}
+	n := int(r.size - used)
+	if n > len(p) {
+		n = len(p)
+	}
+	if n == 0 {
+		return 0, nil
+	}
+	start := tail & (r.size - 1)
+	m := copy(r.data[start:], p[:n])
+	copy(r.data, p[m:n])
+	atomic.StoreUint64(r.tail, tail+uint64(n))
+	return n, nil
+}
+
+// read copies data from the ring to p and returns the number of copied bytes.
+//
+// It must be called only by the consumer.
+func (r *ring) read(p []byte) (int, error) {
+	head := atomic.LoadUint64(r.head)
+	avail := atomic.LoadUint64(r.tail) - head
+	if avail > r.size {
+		return 0, errCorruptedRing
+	}
+	n := int(avail)
+	if n > len(p) {
+		n = len(p)
+	}
+	if n == 0 {
+		return 0, nil
+	}
+	start := head & (r.size - 1)
+	m := copy(p[:n], r.data[start:])
+	copy(p[m:n], r.data)
+	atomic.StoreUint64(r.head, head+uint64(n))
+	return n, nil
+}
+
+func (r *ring) readable() bool {
+	return atomic.LoadUint64(r.tail) != atomic.LoadUint64(r.head)
+}
+
+func (r *ring) writable() bool {
+	return atomic.LoadUint64(r.tail)-atomic.LoadUint64(r.head) < r.size
+}
@@ -0,0 +1,87 @@
+package shmconn
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestRingSize(t *testing.T) {
+	f := func(n, expected int) {
+		t.Helper()
+		if size := ringSize(n); size != expected {
+			t.Fatalf("unexpected ring size for %d: %d. Expecting %d", n, size, expected)
+		}
+	}
+	f(0, DefaultRingSize)
+	f(1, minRingSize)
+	f(minRingSize+1, 2*minRingSize)
+	f(1<<20, 1<<20)
+	f(1<<40, maxRingSize)
+}
+
+func TestRingReadWrite(t *testing.T) {
+	const size = minRingSize
+	mem := make([]byte, segmentSize(size))
+	initSegment(mem, size)
+	if n, err := checkSegment(mem); err != nil || n != size {
+		t.Fatalf("unexpected checkSegment result: %d, %v. Expecting %d, nil", n, err, size)
+	}
+	r := newRing(mem, 0, size)
+	other := newRing(mem, 1, size)
+
+	var data []byte
+	for i := 0; i < 3*size; i++ {
+		data = append(data, byte(i*7))
+	}
+
+	// Write and read in chunks, so the ring wraps around.
+	var result []byte
+	buf := make([]byte, 1000)
+	for written := 0; written < len(data); {
+		n, err := r.write(data[written:])
+		if err != nil {
+			t.Fatalf("unexpected write error: %s", err)
+		}
+		written += n
+		if r.writable() {
+			t.Fatalf("the ring must be full after writing %d bytes", n)
+		}
+		for r.readable() {
+			n, err := r.read(buf)
+			if err != nil {
+				t.Fatalf("unexpected read error: %s", err)
+			}
+			result = append(result, buf[:n]...)
+		}
+	}
+	if !bytes.Equal(result, data) {
+		t.Fatalf("unexpected data read from the ring")
+	}
+	if other.readable() {
+		t.Fatalf("the other ring must be empty")
+	}
+
+	// Corrupted positions must be detected.
+	*r.tail += 2 * size
+	if _, err := r.read(buf); err != errCorruptedRing {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, errCorruptedRing)
+	}
+	if _, err := r.write(buf); err != errCorruptedRing {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, errCorruptedRing)
+	}
+}
+
+func TestCheckSegmentError(t *testing.T) {
+	mem := make([]byte, segmentSize(minRingSize))
+	if _, err := checkSegment(mem); err == nil {
+		t.Fatalf("expecting non-nil error for zero segment")
+	}
+	initSegment(mem, 2*minRingSize)
+	if _, err := checkSegment(mem); err == nil {
+		t.Fatalf("expecting non-nil error for segment smaller than rings")
+	}
+	initSegment(mem, minRingSize+1)
+	if _, err := checkSegment(mem); err == nil {
+		t.Fatalf("expecting non-nil error for invalid ring size")
+	}
+}
@@ -0,0 +1,101 @@
+package shmconn
+
+import (
+	"errors"
+	"net"
+	"time"
+)
+
+const (
+	// DefaultRingSize is the default size of each ring buffer
+	// in the connection.
+	DefaultRingSize = 1 << 20
+
+	// DefaultDialTimeout is the default timeout for establishing
+	// connections via Dialer.
+	DefaultDialTimeout = 3 * time.Second
+
+	minRingSize = 4096
+	maxRingSize = 1 << 30
+)
+
+var (
+	// ErrUnsupported is returned on platforms without shared memory
+	// transport support.
+	ErrUnsupported = errors.New("shared memory transport isn't supported on this platform")
+
+	errClosed = errors.New("shmconn: use of closed network connection")
+)
+
+// Dialer establishes shared memory connections to Listener.
+type Dialer struct {
+	// RingSize is the size of each ring buffer in the connection.
+	//
+	// The size is rounded up to the power of two.
+	//
+	// DefaultRingSize is used by default.
+	RingSize int
+
+	// Timeout is the maximum duration for establishing the connection.
+	//
+	// DefaultDialTimeout is used by default.
+	Timeout time.Duration
+}
+
+var defaultDialer = &Dialer{}
+
+// Dial establishes shared memory connection to Listener at the given
+// unix socket path.
+//
+// Dial may be used as fastrpc.Client.Dial.
+func Dial(addr string) (net.Conn, error) {
+	return defaultDialer.Dial(addr)
+}
+
+// Dial establishes shared memory connection to Listener at the given
+// unix socket path.
+func (d *Dialer) Dial(addr string) (net.Conn, error) {
+	timeout := d.Timeout
+	if timeout <= 0 {
+		timeout = DefaultDialTimeout
+	}
+	return dial(addr, ringSize(d.RingSize), time.Now().Add(timeout))
+}
+
+// Listen announces on the given unix socket path for shared memory
+// connections.
+//
+// Stale socket file without a listener is removed before listening.
+func Listen(addr string) (net.Listener, error) {
+	return listen(addr)
+}
+
+func ringSize(n int) int {
+	if n <= 0 {
+		return DefaultRingSize
+	}
+	if n < minRingSize {
+		return minRingSize
+	}
+	if n > maxRingSize {
+		return maxRingSize
+	}
+	size := minRingSize
+	for size < n {
+		size <<= 1
+	}
+	return size
+}
+
+// Addr is the unix socket path of the shared memory connection.
+type Addr string
+
+// Network implements net.Addr.
+func (a Addr) Network() string {
+	return "shm"
+}
+
+// String implements net.Addr.
+func (a Addr) String() string {
+	return string(a)
+}
@@ -0,0 +1,17 @@
+//go:build !linux
+// +build !linux
+
+package shmconn
+
+import (
+	"net"
+	"time"
+)
+
+func dial(addr string, size int, deadline time.Time) (net.Conn, error) {
+	return nil, ErrUnsupported
+}
+
+func listen(addr string) (net.Listener, error) {
+	return nil, ErrUnsupported
+}
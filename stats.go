@@ -0,0 +1,273 @@
+package fastrpc
+
+import (
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// pingEpoch is the base for monotonic timestamps sent in ping frames.
+var pingEpoch = time.Now()
+
+// ClientStats contains Client statistics.
+//
+// Counters are cumulative since the Client creation.
+type ClientStats struct {
+	// RequestsSent is the number of requests written to connections,
+	// including requests sent via SendNowait.
+	RequestsSent uint64
+
+	// ResponsesReceived is the number of responses read from connections.
+	ResponsesReceived uint64
+
+	// BytesWritten is the number of bytes written to connections.
+	BytesWritten uint64
+
+	// BytesRead is the number of bytes read from connections.
+	BytesRead uint64
+
+	// Flushes is the number of write buffer flushes.
+	Flushes uint64
+
+	// Timeouts is the number of requests failed with ErrTimeout.
+	Timeouts uint64
+
+	// Overflows is the number of requests failed with
+	// ErrPendingRequestsOverflow.
+	Overflows uint64
+
+	// Dials is the number of established connections.
+	Dials uint64
+
+	// Reconnects is the number of connections established after losing
+	// the previous connection.
+	//
+	// Connections closed due to IdleTimeout aren't counted as lost.
+	Reconnects uint64
+
+	// DialErrors is the number of failed connection attempts.
+	DialErrors uint64
+
+	// HandshakeErrors is the number of failed handshakes.
+	HandshakeErrors uint64
+
+	// PendingRequests is the number of pending requests at the moment.
+	PendingRequests int
+
+	// RTT is the round-trip time measured by the last ping.
+	//
+	// RTT is zero if Client.PingInterval isn't set or no pong
+	// has been received yet.
+	RTT time.Duration
+
+	// SmoothedRTT is exponentially weighted moving average of ping
+	// round-trip times.
+	SmoothedRTT time.Duration
+}
+
+// AvgBatchSize returns the average number of requests sent per flush.
+func (cs *ClientStats) AvgBatchSize() float64 {
+	return avgBatchSize(cs.RequestsSent, cs.Flushes)
+}
+
+// clientCounters contains Client counters updated atomically.
+type clientCounters struct {
+	requestsSent      uint64
+	responsesReceived uint64
+	bytesWritten      uint64
+	bytesRead         uint64
+	flushes           uint64
+	timeouts          uint64
+	overflows         uint64
+	dials             uint64
+	reconnects        uint64
+	dialErrors        uint64
+	handshakeErrors   uint64
+}
+
+// Stats returns Client statistics.
+func (c *Client) Stats() ClientStats {
+	cc := &c.counters
+	return ClientStats{
+		RequestsSent:      atomic.LoadUint64(&cc.requestsSent),
+		ResponsesReceived: atomic.LoadUint64(&cc.responsesReceived),
+		BytesWritten:      atomic.LoadUint64(&cc.bytesWritten),
+		BytesRead:         atomic.LoadUint64(&cc.bytesRead),
+		Flushes:           atomic.LoadUint64(&cc.flushes),
+		Timeouts:          atomic.LoadUint64(&cc.timeouts),
+		Overflows:         atomic.LoadUint64(&cc.overflows),
+		Dials:             atomic.LoadUint64(&cc.dials),
+		Reconnects:        atomic.LoadUint64(&cc.reconnects),
+		DialErrors:        atomic.LoadUint64(&cc.dialErrors),
+		HandshakeErrors:   atomic.LoadUint64(&cc.handshakeErrors),
+		PendingRequests:   c.PendingRequests(),
+		RTT:               time.Duration(atomic.LoadInt64(&c.rtt)),
+		SmoothedRTT:       time.Duration(atomic.LoadInt64(&c.smoothedRTT)),
+	}
+}
+
+// ServerStats contains Server statistics.
+//
+// Counters are cumulative since the Server creation.
+type ServerStats struct {
+	// RequestsReceived is the number of requests read from connections.
+	RequestsReceived uint64
+
+	// ResponsesSent is the number of responses written to connections.
+	ResponsesSent uint64
+
+	// BytesRead is the number of bytes read from connections.
+	BytesRead uint64
+
+	// BytesWritten is the number of bytes written to connections.
+	BytesWritten uint64
+
+	// Flushes is the number of write buffer flushes.
+	Flushes uint64
+
+	// AcceptedConns is the number of accepted connections.
+	AcceptedConns uint64
+
+	// RejectedConns is the number of connections rejected due to
+	// connection limits.
+	RejectedConns uint64
+
+	// HandshakeErrors is the number of failed handshakes.
+	HandshakeErrors uint64
+
+	// ConcurrencyLimitRejections is the number of requests rejected
+	// via HandlerCtx.ConcurrencyLimitError.
+	ConcurrencyLimitRejections uint64
+
+	// RejectedRequests is the number of requests rejected
+	// via RejectHandlerCtx.RejectError, e.g. due to rate limits
+	// or admission control.
+	RejectedRequests uint64
+
+	// DeniedRequests is the number of requests denied by Server.Authorizer.
+	//
+	// Denied requests are also counted in RejectedRequests.
+	DeniedRequests uint64
+
+	// ActiveConns is the number of connections served at the moment.
+	ActiveConns int
+
+	// InflightHandlers is the number of running Server.Handler calls
+	// at the moment.
+	InflightHandlers int
+}
+
+// AvgBatchSize returns the average number of responses sent per flush.
+func (ss *ServerStats) AvgBatchSize() float64 {
+	return avgBatchSize(ss.ResponsesSent, ss.Flushes)
+}
+
+// serverCounters contains Server counters updated atomically.
+type serverCounters struct {
+	requestsReceived           uint64
+	responsesSent              uint64
+	bytesRead                  uint64
+	bytesWritten               uint64
+	flushes                    uint64
+	acceptedConns              uint64
+	rejectedConns              uint64
+	handshakeErrors            uint64
+	concurrencyLimitRejections uint64
+	rejectedRequests           uint64
+	deniedRequests             uint64
+	activeConns                int64
+	inflightHandlers           int64
+}
+
+// Stats returns Server statistics.
+func (s *Server) Stats() ServerStats {
+	sc := &s.counters
+	return ServerStats{
+		RequestsReceived:           atomic.LoadUint64(&sc.requestsReceived),
+		ResponsesSent:              atomic.LoadUint64(&sc.responsesSent),
+		BytesRead:                  atomic.LoadUint64(&sc.bytesRead),
+		BytesWritten:               atomic.LoadUint64(&sc.bytesWritten),
+		Flushes:                    atomic.LoadUint64(&sc.flushes),
+		AcceptedConns:              atomic.LoadUint64(&sc.acceptedConns),
+		RejectedConns:              atomic.LoadUint64(&sc.rejectedConns),
+		HandshakeErrors:            atomic.LoadUint64(&sc.handshakeErrors),
+		ConcurrencyLimitRejections: atomic.LoadUint64(&sc.concurrencyLimitRejections),
+		RejectedRequests:           atomic.LoadUint64(&sc.rejectedRequests),
+		DeniedRequests:             atomic.LoadUint64(&sc.deniedRequests),
+		ActiveConns:                int(atomic.LoadInt64(&sc.activeConns)),
+		InflightHandlers:           int(atomic.LoadInt64(&sc.inflightHandlers)),
+	}
+}
+
+func avgBatchSize(messages, flushes uint64) float64 {
+	if flushes == 0 {
+		return 0
+	}
+	return float64(messages) / float64(flushes)
+}
+
+// countingReader counts bytes read from r.
+type countingReader struct {
+	r io.Reader
+
+	// n is the number of bytes read from r.
+	n int64
+
+	// total is atomically incremented by the number of bytes read from r.
+	total *uint64
+
+	// trackReads enables recording reads for readTime.
+	trackReads bool
+
+	// reads contains recorded reads, which may still be buffered.
+	reads []countedRead
+}
+
+// countedRead is a read recorded by countingReader.
+type countedRead struct {
+	// end is the number of bytes read from r after the read.
+	end int64
+	t   time.Time
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	atomic.AddUint64(cr.total, uint64(n))
+	if cr.trackReads && n > 0 {
+		cr.reads = append(cr.reads, countedRead{
+			end: cr.n,
+			t:   time.Now(),
+		})
+	}
+	return n, err
+}
+
+// readTime returns the time the byte at the given position
+// has been read from r.
+//
+// Reads before pos are forgotten, so pos mustn't decrease
+// on subsequent calls. trackReads must be set.
+func (cr *countingReader) readTime(pos int64) time.Time {
+	i := 0
+	for i < len(cr.reads) && cr.reads[i].end <= pos {
+		i++
+	}
+	cr.reads = cr.reads[:copy(cr.reads, cr.reads[i:])]
+	if len(cr.reads) == 0 {
+		return time.Now()
+	}
+	return cr.reads[0].t
+}
+
+// countingWriter counts bytes written to w.
+type countingWriter struct {
+	w     io.Writer
+	total *uint64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	atomic.AddUint64(cw.total, uint64(n))
+	return n, err
+}
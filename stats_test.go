@@ -0,0 +1,224 @@
+package fastrpc
+
+import (
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/UladzimirTrehubenka/fastrpc/tlv"
+	"github.com/valyala/fasthttp/fasthttputil"
+)
+
+func TestClientPing(t *testing.T) {
+	var calls uint32
+	serverStop, ln := newTestServer(func(ctxv HandlerCtx) HandlerCtx {
+		atomic.AddUint32(&calls, 1)
+		return testEchoHandler(ctxv)
+	})
+	c := newTestClient(ln)
+	c.PingInterval = 20 * time.Millisecond
+
+	if err := testDoEcho(c, "foo"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for c.Stats().RTT == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for pong")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	stats := c.Stats()
+	if stats.SmoothedRTT <= 0 {
+		t.Fatalf("unexpected SmoothedRTT: %s", stats.SmoothedRTT)
+	}
+	// Pings are flushed as separate batches.
+	if stats.Flushes < 2 {
+		t.Fatalf("unexpected number of flushes: %d; expecting at least 2", stats.Flushes)
+	}
+
+	// Pings mustn't be passed to Server.Handler.
+	if n := atomic.LoadUint32(&calls); n != 1 {
+		t.Fatalf("unexpected number of handler calls: %d; expecting 1", n)
+	}
+	if err := testDoEcho(c, "bar"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if err := serverStop(); err != nil {
+		t.Fatalf("cannot shutdown server: %s", err)
+	}
+}
+
+func TestClientMissedPongs(t *testing.T) {
+	// The server reads requests, but never responds,
+	// like a half-open connection.
+	ln := fasthttputil.NewInmemoryListener()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go io.Copy(ioutil.Discard, conn)
+		}
+	}()
+
+	c := newTestClient(ln)
+	c.PingInterval = 20 * time.Millisecond
+	c.MaxMissedPongs = 2
+
+	var req tlv.Request
+	var resp tlv.Response
+	req.SwapValue([]byte("foo"))
+	startTime := time.Now()
+	err := c.DoDeadline(&req, &resp, time.Now().Add(10*time.Second))
+	if err == nil {
+		t.Fatalf("expecting non-nil error")
+	}
+	if !strings.Contains(err.Error(), "didn't answer") {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if d := time.Since(startTime); d > 5*time.Second {
+		t.Fatalf("too long dead connection detection: %s", d)
+	}
+
+	c.Close()
+	ln.Close()
+}
+
+func TestClientServerStats(t *testing.T) {
+	s := &Server{
+		NewHandlerCtx: newTestHandlerCtx,
+		Handler:       testEchoHandler,
+	}
+	serverStop, ln := newTestServerExt(s)
+	c := newTestClient(ln)
+
+	const requests = 10
+	for i := 0; i < requests; i++ {
+		if err := testDoEcho(c, "foobar"); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+
+	cs := c.Stats()
+	if cs.RequestsSent != requests {
+		t.Fatalf("unexpected RequestsSent: %d; expecting %d", cs.RequestsSent, requests)
+	}
+	if cs.ResponsesReceived != requests {
+		t.Fatalf("unexpected ResponsesReceived: %d; expecting %d", cs.ResponsesReceived, requests)
+	}
+	if cs.Dials != 1 {
+		t.Fatalf("unexpected Dials: %d; expecting 1", cs.Dials)
+	}
+	if cs.BytesWritten == 0 || cs.BytesRead == 0 {
+		t.Fatalf("unexpected bytes stats: written=%d, read=%d", cs.BytesWritten, cs.BytesRead)
+	}
+	if cs.Flushes == 0 || cs.AvgBatchSize() <= 0 {
+		t.Fatalf("unexpected flushes stats: flushes=%d, avgBatchSize=%f", cs.Flushes, cs.AvgBatchSize())
+	}
+	if cs.PendingRequests != 0 {
+		t.Fatalf("unexpected PendingRequests: %d; expecting 0", cs.PendingRequests)
+	}
+
+	ss := s.Stats()
+	if ss.RequestsReceived != requests {
+		t.Fatalf("unexpected RequestsReceived: %d; expecting %d", ss.RequestsReceived, requests)
+	}
+	if ss.ResponsesSent != requests {
+		t.Fatalf("unexpected ResponsesSent: %d; expecting %d", ss.ResponsesSent, requests)
+	}
+	if ss.AcceptedConns != 1 || ss.ActiveConns != 1 {
+		t.Fatalf("unexpected conns stats: accepted=%d, active=%d", ss.AcceptedConns, ss.ActiveConns)
+	}
+	if ss.BytesRead != cs.BytesWritten {
+		t.Fatalf("unexpected BytesRead: %d; expecting %d", ss.BytesRead, cs.BytesWritten)
+	}
+	if ss.BytesWritten == 0 {
+		t.Fatalf("unexpected zero BytesWritten")
+	}
+	if ss.InflightHandlers != 0 {
+		t.Fatalf("unexpected InflightHandlers: %d; expecting 0", ss.InflightHandlers)
+	}
+
+	if err := serverStop(); err != nil {
+		t.Fatalf("cannot shutdown server: %s", err)
+	}
+}
+
+func TestClientStatsReconnects(t *testing.T) {
+	serverStop, c := newTestServerClient(testEchoHandler)
+
+	if err := testDoEcho(c, "foo"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if cs := c.Stats(); cs.Dials != 1 || cs.Reconnects != 0 {
+		t.Fatalf("unexpected stats: dials=%d, reconnects=%d; expecting 1, 0", cs.Dials, cs.Reconnects)
+	}
+
+	// Break the connection, so the next request must reconnect.
+	c.Conn().Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for testDoEcho(c, "bar") != nil {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if cs := c.Stats(); cs.Dials != 2 || cs.Reconnects != 1 {
+		t.Fatalf("unexpected stats: dials=%d, reconnects=%d; expecting 2, 1", cs.Dials, cs.Reconnects)
+	}
+
+	if err := serverStop(); err != nil {
+		t.Fatalf("cannot shutdown server: %s", err)
+	}
+}
+
+func TestClientStatsErrors(t *testing.T) {
+	gate := make(chan struct{})
+	serverStop, ln := newTestServer(func(ctxv HandlerCtx) HandlerCtx {
+		<-gate
+		return testEchoHandler(ctxv)
+	})
+	c := newTestClient(ln)
+	c.MaxPendingRequests = 1
+
+	resultCh := make(chan error, 1)
+	go func() {
+		var req tlv.Request
+		var resp tlv.Response
+		req.SwapValue([]byte("foo"))
+		resultCh <- c.DoDeadline(&req, &resp, time.Now().Add(50*time.Millisecond))
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for c.PendingRequests() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if err := testDoEcho(c, "bar"); err != ErrPendingRequestsOverflow {
+		t.Fatalf("unexpected error: %v; expecting %s", err, ErrPendingRequestsOverflow)
+	}
+	if err := <-resultCh; err != ErrTimeout {
+		t.Fatalf("unexpected error: %v; expecting %s", err, ErrTimeout)
+	}
+	close(gate)
+
+	cs := c.Stats()
+	if cs.Overflows != 1 {
+		t.Fatalf("unexpected Overflows: %d; expecting 1", cs.Overflows)
+	}
+	if cs.Timeouts != 1 {
+		t.Fatalf("unexpected Timeouts: %d; expecting 1", cs.Timeouts)
+	}
+
+	if err := serverStop(); err != nil {
+		t.Fatalf("cannot shutdown server: %s", err)
+	}
+}
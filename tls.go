@@ -0,0 +1,153 @@
+package fastrpc
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// DefaultCertCheckInterval is the default interval between checks
+// for certificate files' modification in CertReloader.
+const DefaultCertCheckInterval = 10 * time.Second
+
+// newHandshake returns handshake func performing TLS handshake
+// if tlsConfig is set and then calling handshake if it is set.
+//
+// Returns nil if neither tlsConfig nor handshake is set.
+func newHandshake(tlsConfig *tls.Config, isServer bool, addr string, handshake func(conn net.Conn) (net.Conn, error)) func(conn net.Conn) (net.Conn, error) {
+	if tlsConfig == nil {
+		return handshake
+	}
+	if !isServer && tlsConfig.ServerName == "" && !tlsConfig.InsecureSkipVerify {
+		tlsConfig = tlsConfig.Clone()
+		tlsConfig.ServerName = addrHost(addr)
+	}
+	return func(conn net.Conn) (net.Conn, error) {
+		var tlsConn *tls.Conn
+		if isServer {
+			tlsConn = tls.Server(conn, tlsConfig)
+		} else {
+			tlsConn = tls.Client(conn, tlsConfig)
+		}
+		if err := tlsConn.Handshake(); err != nil {
+			return nil, fmt.Errorf("TLS handshake error: %w", err)
+		}
+		if handshake == nil {
+			return tlsConn, nil
+		}
+		return handshake(tlsConn)
+	}
+}
+
+// CertReloader loads TLS certificate from files and reloads it
+// when the files are modified, so certificates may be rotated
+// without restarting Server or Client.
+//
+// Use CertReloader.GetCertificate for tls.Config.GetCertificate
+// on Server and CertReloader.GetClientCertificate
+// for tls.Config.GetClientCertificate on Client.
+type CertReloader struct {
+	certFile      string
+	keyFile       string
+	checkInterval time.Duration
+
+	mu          sync.Mutex
+	cert        *tls.Certificate
+	certModTime time.Time
+	keyModTime  time.Time
+	lastCheck   time.Time
+}
+
+// NewCertReloader returns CertReloader for the given PEM-encoded
+// certificate and key files.
+//
+// The files are checked for modification at most once per checkInterval.
+// DefaultCertCheckInterval is used if checkInterval isn't positive.
+func NewCertReloader(certFile, keyFile string, checkInterval time.Duration) (*CertReloader, error) {
+	if checkInterval <= 0 {
+		checkInterval = DefaultCertCheckInterval
+	}
+	cr := &CertReloader{
+		certFile:      certFile,
+		keyFile:       keyFile,
+		checkInterval: checkInterval,
+	}
+	if err := cr.Reload(); err != nil {
+		return nil, err
+	}
+	return cr, nil
+}
+
+// Reload unconditionally reloads the certificate from files.
+//
+// The previously loaded certificate is kept on error.
+func (cr *CertReloader) Reload() error {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+	return cr.reload()
+}
+
+func (cr *CertReloader) reload() error {
+	certModTime, keyModTime, err := cr.modTimes()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(cr.certFile, cr.keyFile)
+	if err != nil {
+		return fmt.Errorf("cannot load certificate from %q and %q: %w", cr.certFile, cr.keyFile, err)
+	}
+	cr.cert = &cert
+	cr.certModTime = certModTime
+	cr.keyModTime = keyModTime
+	cr.lastCheck = time.Now()
+	return nil
+}
+
+func (cr *CertReloader) modTimes() (time.Time, time.Time, error) {
+	certInfo, err := os.Stat(cr.certFile)
+	if err != nil {
+		return zeroTime, zeroTime, fmt.Errorf("cannot stat certificate file: %w", err)
+	}
+	keyInfo, err := os.Stat(cr.keyFile)
+	if err != nil {
+		return zeroTime, zeroTime, fmt.Errorf("cannot stat key file: %w", err)
+	}
+	return certInfo.ModTime(), keyInfo.ModTime(), nil
+}
+
+// Certificate returns the current certificate.
+//
+// The certificate is reloaded if the files have been modified.
+// The previously loaded certificate is returned if the files cannot
+// be loaded, since they may be in the middle of rotation.
+func (cr *CertReloader) Certificate() *tls.Certificate {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+
+	if time.Since(cr.lastCheck) < cr.checkInterval {
+		return cr.cert
+	}
+	cr.lastCheck = time.Now()
+	certModTime, keyModTime, err := cr.modTimes()
+	if err != nil {
+		return cr.cert
+	}
+	if !certModTime.Equal(cr.certModTime) || !keyModTime.Equal(cr.keyModTime) {
+		// Ignore the error, since the old certificate is kept.
+		_ = cr.reload()
+	}
+	return cr.cert
+}
+
+// GetCertificate may be used as tls.Config.GetCertificate.
+func (cr *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return cr.Certificate(), nil
+}
+
+// GetClientCertificate may be used as tls.Config.GetClientCertificate.
+func (cr *CertReloader) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	return cr.Certificate(), nil
+}
@@ -0,0 +1,276 @@
+package fastrpc
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/UladzimirTrehubenka/fastrpc/tlv"
+)
+
+type testCert struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPEM []byte
+	keyPEM  []byte
+}
+
+func (tc *testCert) tlsCertificate(t *testing.T) tls.Certificate {
+	cert, err := tls.X509KeyPair(tc.certPEM, tc.keyPEM)
+	if err != nil {
+		t.Fatalf("cannot create key pair: %s", err)
+	}
+	return cert
+}
+
+func newTestCert(t *testing.T, ca *testCert, cn string, spiffeID string) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("cannot generate key: %s", err)
+	}
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		t.Fatalf("cannot generate serial number: %s", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		DNSNames:     []string{cn},
+	}
+	if spiffeID != "" {
+		u, err := url.Parse(spiffeID)
+		if err != nil {
+			t.Fatalf("cannot parse SPIFFE ID: %s", err)
+		}
+		tmpl.URIs = []*url.URL{u}
+	}
+	parent, parentKey := tmpl, key
+	if ca == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		tmpl.KeyUsage |= x509.KeyUsageCertSign
+	} else {
+		parent, parentKey = ca.cert, ca.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatalf("cannot create certificate: %s", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("cannot parse certificate: %s", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("cannot marshal key: %s", err)
+	}
+	return &testCert{
+		cert:    cert,
+		key:     key,
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		keyPEM:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}
+}
+
+func newTestTLSServer(t *testing.T, ca, serverCert *testCert, handler func(HandlerCtx) HandlerCtx) *Server {
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	return &Server{
+		NewHandlerCtx: newTestHandlerCtx,
+		Handler:       handler,
+		TLSConfig: &tls.Config{
+			Certificates: []tls.Certificate{serverCert.tlsCertificate(t)},
+			ClientCAs:    pool,
+			ClientAuth:   tls.RequireAndVerifyClientCert,
+		},
+	}
+}
+
+func newTestTLSClientConfig(t *testing.T, ca *testCert, clientCert *testCert) *tls.Config {
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	cfg := &tls.Config{
+		RootCAs: pool,
+	}
+	if clientCert != nil {
+		cfg.Certificates = []tls.Certificate{clientCert.tlsCertificate(t)}
+	}
+	return cfg
+}
+
+func TestServerClientMutualTLS(t *testing.T) {
+	ca := newTestCert(t, nil, "test CA", "")
+	serverCert := newTestCert(t, ca, "localhost", "")
+	clientCert := newTestCert(t, ca, "client", "spiffe://example.org/client")
+
+	s := newTestTLSServer(t, ca, serverCert, func(ctxv HandlerCtx) HandlerCtx {
+		ctx := ctxv.(*tlv.RequestCtx)
+		certs := ctx.PeerCertificates()
+		if len(certs) == 0 {
+			ctx.Write([]byte("missing peer certificates"))
+			return ctx
+		}
+		ctx.Write([]byte(certs[0].Subject.CommonName + " " + ctx.SPIFFEID()))
+		return ctx
+	})
+	serverStop, ln := newTestServerExt(s)
+
+	c := newTestClient(ln)
+	c.Addr = "localhost:1234"
+	c.TLSConfig = newTestTLSClientConfig(t, ca, clientCert)
+
+	var req tlv.Request
+	var resp tlv.Response
+	req.SwapValue([]byte("foobar"))
+	if err := c.DoDeadline(&req, &resp, time.Now().Add(time.Second)); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected := "client spiffe://example.org/client"
+	if string(resp.Value()) != expected {
+		t.Fatalf("unexpected response: %q. Expecting %q", resp.Value(), expected)
+	}
+
+	if err := serverStop(); err != nil {
+		t.Fatalf("cannot shutdown server: %s", err)
+	}
+}
+
+func TestServerTLSRejectsClientWithoutCert(t *testing.T) {
+	ca := newTestCert(t, nil, "test CA", "")
+	serverCert := newTestCert(t, ca, "localhost", "")
+
+	s := newTestTLSServer(t, ca, serverCert, testEchoHandler)
+	serverStop, ln := newTestServerExt(s)
+
+	c := newTestClient(ln)
+	c.Addr = "localhost:1234"
+	c.TLSConfig = newTestTLSClientConfig(t, ca, nil)
+
+	var req tlv.Request
+	var resp tlv.Response
+	if err := c.DoDeadline(&req, &resp, time.Now().Add(200*time.Millisecond)); err == nil {
+		t.Fatalf("expecting non-nil error")
+	}
+	// The server may count the handshake error after the client fails.
+	deadline := time.Now().Add(time.Second)
+	for s.Stats().HandshakeErrors == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expecting non-zero handshake errors")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err := serverStop(); err != nil {
+		t.Fatalf("cannot shutdown server: %s", err)
+	}
+}
+
+func TestClientTLSServerNameMismatch(t *testing.T) {
+	ca := newTestCert(t, nil, "test CA", "")
+	serverCert := newTestCert(t, ca, "localhost", "")
+	clientCert := newTestCert(t, ca, "client", "")
+
+	s := newTestTLSServer(t, ca, serverCert, testEchoHandler)
+	serverStop, ln := newTestServerExt(s)
+
+	c := newTestClient(ln)
+	c.Addr = "example.org:1234"
+	c.TLSConfig = newTestTLSClientConfig(t, ca, clientCert)
+
+	var req tlv.Request
+	var resp tlv.Response
+	if err := c.DoDeadline(&req, &resp, time.Now().Add(200*time.Millisecond)); err == nil {
+		t.Fatalf("expecting non-nil error")
+	}
+	if n := c.Stats().HandshakeErrors; n == 0 {
+		t.Fatalf("expecting non-zero handshake errors")
+	}
+
+	if err := serverStop(); err != nil {
+		t.Fatalf("cannot shutdown server: %s", err)
+	}
+}
+
+func TestCertReloader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fastrpc-cert-reloader")
+	if err != nil {
+		t.Fatalf("cannot create temporary dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+
+	writeCert := func(tc *testCert, modTime time.Time) {
+		if err := ioutil.WriteFile(certFile, tc.certPEM, 0600); err != nil {
+			t.Fatalf("cannot write certificate: %s", err)
+		}
+		if err := ioutil.WriteFile(keyFile, tc.keyPEM, 0600); err != nil {
+			t.Fatalf("cannot write key: %s", err)
+		}
+		for _, path := range []string{certFile, keyFile} {
+			if err := os.Chtimes(path, modTime, modTime); err != nil {
+				t.Fatalf("cannot change modification time: %s", err)
+			}
+		}
+	}
+
+	ca := newTestCert(t, nil, "test CA", "")
+	cert1 := newTestCert(t, ca, "cert1", "")
+	cert2 := newTestCert(t, ca, "cert2", "")
+
+	if _, err := NewCertReloader(certFile, keyFile, 0); err == nil {
+		t.Fatalf("expecting non-nil error for missing files")
+	}
+
+	now := time.Now()
+	writeCert(cert1, now.Add(-time.Minute))
+	cr, err := NewCertReloader(certFile, keyFile, time.Nanosecond)
+	if err != nil {
+		t.Fatalf("cannot create cert reloader: %s", err)
+	}
+	checkCN := func(expected string) {
+		t.Helper()
+		cert, err := cr.GetCertificate(nil)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		x, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			t.Fatalf("cannot parse certificate: %s", err)
+		}
+		if x.Subject.CommonName != expected {
+			t.Fatalf("unexpected certificate: %q. Expecting %q", x.Subject.CommonName, expected)
+		}
+	}
+	checkCN("cert1")
+
+	writeCert(cert2, now)
+	time.Sleep(time.Millisecond)
+	checkCN("cert2")
+
+	// Broken files must not replace the loaded certificate.
+	if err := ioutil.WriteFile(certFile, []byte("broken"), 0600); err != nil {
+		t.Fatalf("cannot write certificate: %s", err)
+	}
+	time.Sleep(time.Millisecond)
+	checkCN("cert2")
+	if err := cr.Reload(); err == nil {
+		t.Fatalf("expecting non-nil error when reloading broken certificate")
+	}
+	checkCN("cert2")
+}
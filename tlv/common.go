@@ -8,18 +8,55 @@ import (
 
 const maxBytesSize = 1024 * 1024
 
-func writeBytes(bw *bufio.Writer, b, header []byte) error {
+// extFlag is set in the size header if the value is preceded
+// by an extension block.
+//
+// The extension block consists of 4-byte size followed by the block data.
+const extFlag = 1 << 31
+
+// maxExtSize is the maximum size of the extension block.
+const maxExtSize = 64 * 1024
+
+// statusShift is the position of the response status in the size header.
+//
+// Sizes never exceed maxBytesSize, so the bits are always zero
+// for requests and for responses with StatusOK.
+const statusShift = 24
+
+// statusMask masks the response status in the size header.
+const statusMask = 0x7f << statusShift
+
+// writeBytes writes the value b with the extension block ext to bw.
+//
+// flags are set in the size header.
+func writeBytes(bw *bufio.Writer, b, header, ext []byte, flags uint32) error {
 	size := len(b)
 	if size > maxBytesSize {
 		return fmt.Errorf("too big size=%d. Must not exceed %d", size, maxBytesSize)
 	}
+	if len(ext) > maxExtSize {
+		return fmt.Errorf("too big extension size=%d. Must not exceed %d", len(ext), maxExtSize)
+	}
 
-	appendUint32(header[:0], uint32(size))
+	n := uint32(size) | flags
+	if len(ext) > 0 {
+		n |= extFlag
+	}
+	appendUint32(header[:0], n)
 
 	_, err := bw.Write(header)
 	if err != nil {
 		return fmt.Errorf("cannot write header: %s", err)
 	}
+	if len(ext) > 0 {
+		var extHeader [4]byte
+		if _, err = bw.Write(appendUint32(extHeader[:0], uint32(len(ext)))); err != nil {
+			return fmt.Errorf("cannot write extension header: %s", err)
+		}
+		if _, err = bw.Write(ext); err != nil {
+			return fmt.Errorf("cannot write extension with size %d: %s", len(ext), err)
+		}
+	}
 	_, err = bw.Write(b)
 	if err != nil {
 		return fmt.Errorf("cannot write body with size %d: %s", size, err)
@@ -27,14 +64,44 @@ func writeBytes(bw *bufio.Writer, b, header []byte) error {
 	return nil
 }
 
-func readBytes(br *bufio.Reader, b, header []byte) ([]byte, error) {
+// readBytes reads the value into b and the extension block into ext.
+//
+// The extension block is rejected if ext is nil. Size header bits
+// from flagsMask are returned in flags.
+func readBytes(br *bufio.Reader, b, header, ext []byte, flagsMask uint32) ([]byte, []byte, uint32, error) {
 	_, err := io.ReadFull(br, header)
 	if err != nil {
-		return b, fmt.Errorf("cannot read header: %s", err)
+		return b, ext, 0, fmt.Errorf("cannot read header: %s", err)
+	}
+	n := bytes2Uint32(header)
+	flags := n & flagsMask
+	n &^= flagsMask
+	if n&extFlag != 0 {
+		if ext == nil {
+			return b, ext, flags, fmt.Errorf("unexpected extension block")
+		}
+		var extHeader [4]byte
+		if _, err = io.ReadFull(br, extHeader[:]); err != nil {
+			return b, ext, flags, fmt.Errorf("cannot read extension header: %s", err)
+		}
+		extSize := int(bytes2Uint32(extHeader[:]))
+		if extSize > maxExtSize {
+			return b, ext, flags, fmt.Errorf("too big extension size=%d. Must not exceed %d", extSize, maxExtSize)
+		}
+		if cap(ext) < extSize {
+			ext = make([]byte, extSize)
+		}
+		ext = ext[:extSize]
+		if _, err = io.ReadFull(br, ext); err != nil {
+			return b, ext, flags, fmt.Errorf("cannot read extension with size %d: %s", extSize, err)
+		}
+		n &^= extFlag
+	} else if ext != nil {
+		ext = ext[:0]
 	}
-	size := int(bytes2Uint32(header))
+	size := int(n)
 	if size > maxBytesSize {
-		return b, fmt.Errorf("too big size=%d. Must not exceed %d", size, maxBytesSize)
+		return b, ext, flags, fmt.Errorf("too big size=%d. Must not exceed %d", size, maxBytesSize)
 	}
 	if cap(b) < size {
 		b = make([]byte, size)
@@ -42,9 +109,9 @@ func readBytes(br *bufio.Reader, b, header []byte) ([]byte, error) {
 	b = b[:size]
 	_, err = io.ReadFull(br, b)
 	if err != nil {
-		return b, fmt.Errorf("cannot read body with size %d: %s", size, err)
+		return b, ext, flags, fmt.Errorf("cannot read body with size %d: %s", size, err)
 	}
-	return b, nil
+	return b, ext, flags, nil
 }
 
 func appendUint32(b []byte, n uint32) []byte {
@@ -10,11 +10,16 @@ import (
 type Request struct {
 	value  []byte
 	header [5]byte
+
+	// metadata contains encoded key-value pairs. Each pair consists of
+	// 1-byte key size, key, 2-byte value size and value.
+	metadata []byte
 }
 
 // Reset resets the given request.
 func (req *Request) Reset() {
 	req.value = req.value[:0]
+	req.metadata = req.metadata[:0]
 }
 
 // SetOpcode sets request opcode.
@@ -66,11 +71,97 @@ func (req *Request) Value() []byte {
 	return req.value
 }
 
+// SetMetadata sets metadata value for the given key.
+//
+// Metadata is sent together with the request value, e.g. for propagating
+// trace context. The server must support request metadata.
+//
+// The key length mustn't exceed 255 bytes and the value length mustn't
+// exceed 65535 bytes.
+func (req *Request) SetMetadata(key string, value []byte) {
+	if len(key) > 0xff {
+		panic("BUG: too long metadata key")
+	}
+	if len(value) > 0xffff {
+		panic("BUG: too long metadata value")
+	}
+	req.DelMetadata(key)
+	req.metadata = append(req.metadata, byte(len(key)))
+	req.metadata = append(req.metadata, key...)
+	req.metadata = append(req.metadata, byte(len(value)), byte(len(value)>>8))
+	req.metadata = append(req.metadata, value...)
+}
+
+// Metadata returns metadata value for the given key.
+//
+// nil is returned if the request has no metadata with the given key.
+// The returned value is valid until the next Request method call
+// or until ReleaseRequest is called.
+func (req *Request) Metadata(key string) []byte {
+	var result []byte
+	req.VisitMetadata(func(k, v []byte) {
+		if result == nil && string(k) == key {
+			result = v
+		}
+	})
+	return result
+}
+
+// DelMetadata deletes metadata with the given key.
+func (req *Request) DelMetadata(key string) {
+	start := 0
+	for start < len(req.metadata) {
+		k, _, tail, ok := nextMetadata(req.metadata[start:])
+		if !ok {
+			return
+		}
+		if string(k) == key {
+			req.metadata = append(req.metadata[:start], tail...)
+			continue
+		}
+		start = len(req.metadata) - len(tail)
+	}
+}
+
+// VisitMetadata calls f for each metadata key-value pair.
+//
+// f mustn't retain references to key and value after returning.
+func (req *Request) VisitMetadata(f func(key, value []byte)) {
+	b := req.metadata
+	for len(b) > 0 {
+		k, v, tail, ok := nextMetadata(b)
+		if !ok {
+			return
+		}
+		f(k, v)
+		b = tail
+	}
+}
+
+func nextMetadata(b []byte) ([]byte, []byte, []byte, bool) {
+	if len(b) < 1 {
+		return nil, nil, nil, false
+	}
+	n := int(b[0])
+	b = b[1:]
+	if len(b) < n+2 {
+		return nil, nil, nil, false
+	}
+	k := b[:n]
+	b = b[n:]
+	n = int(b[0]) | int(b[1])<<8
+	b = b[2:]
+	if len(b) < n {
+		return nil, nil, nil, false
+	}
+	return k, b[:n], b[n:], true
+}
+
 // WriteRequest writes the request to bw.
 //
 // It implements fastrpc.RequestWriter
 func (req *Request) WriteRequest(bw *bufio.Writer) error {
-	if err := writeBytes(bw, req.value, req.header[:]); err != nil {
+	if err := writeBytes(bw, req.value, req.header[:], req.metadata, 0); err != nil {
 		return fmt.Errorf("cannot write request value: %s", err)
 	}
 	return nil
@@ -79,7 +170,12 @@ func (req *Request) WriteRequest(bw *bufio.Writer) error {
 // ReadRequest reads the request from br.
 func (req *Request) ReadRequest(br *bufio.Reader) error {
 	var err error
-	req.value, err = readBytes(br, req.value[:0], req.header[:])
+	metadata := req.metadata[:0]
+	if metadata == nil {
+		// Non-nil buffer enables reading metadata.
+		metadata = emptyMetadata
+	}
+	req.value, req.metadata, _, err = readBytes(br, req.value[:0], req.header[:], metadata, 0)
 	if err != nil {
 		return fmt.Errorf("cannot read request value: %s", err)
 	}
@@ -103,3 +199,5 @@ func ReleaseRequest(req *Request) {
 }
 
 var requestPool sync.Pool
+
+var emptyMetadata = []byte{}
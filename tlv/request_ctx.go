@@ -2,11 +2,15 @@ package tlv
 
 import (
 	"bufio"
-	"fmt"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"net"
-	"sync"
 
-	"github.com/valyala/fasthttp"
+	"github.com/UladzimirTrehubenka/fastrpc/auth"
+	"github.com/UladzimirTrehubenka/fastrpc/internal/netutil"
+	"github.com/UladzimirTrehubenka/fastrpc/peercred"
+	"github.com/UladzimirTrehubenka/fastrpc/rpclog"
 )
 
 // RequestCtx implements fastrpc.HandlerCtx
@@ -15,11 +19,20 @@ type RequestCtx struct {
 	// is reached on the fastrpc.Server.
 	ConcurrencyLimitErrorHandler func(ctx *RequestCtx, concurrency int)
 
+	// RejectErrorHandler is called each time fastrpc.Server rejects
+	// the request before passing it to the handler.
+	//
+	// The response status is set to StatusRejected or StatusPermissionDenied
+	// before the call. The response value is set to the error message
+	// by default.
+	RejectErrorHandler func(ctx *RequestCtx, err error)
+
 	Request  Request
 	Response Response
 
 	conn   net.Conn
-	logger ctxLogger
+	logger rpclog.Logger
+	ctx    context.Context
 }
 
 // ConcurrencyLimitError implements the corresponding method
@@ -30,14 +43,38 @@ func (ctx *RequestCtx) ConcurrencyLimitError(concurrency int) {
 	}
 }
 
+// RejectError implements fastrpc.RejectHandlerCtx.
+//
+// The response is sent with StatusRejected, so the client may distinguish
+// it from responses returned by the handler via Response.Err.
+func (ctx *RequestCtx) RejectError(err error) {
+	ctx.rejectError(StatusRejected, err)
+}
+
+// PermissionDeniedError implements fastrpc.PermissionDeniedHandlerCtx.
+//
+// The response is sent with StatusPermissionDenied, so the client may
+// distinguish it from responses returned by the handler via Response.Err.
+func (ctx *RequestCtx) PermissionDeniedError(err error) {
+	ctx.rejectError(StatusPermissionDenied, err)
+}
+
+func (ctx *RequestCtx) rejectError(status Status, err error) {
+	ctx.Response.SetStatus(status)
+	if ctx.RejectErrorHandler != nil {
+		ctx.RejectErrorHandler(ctx, err)
+		return
+	}
+	ctx.Response.value = append(ctx.Response.value[:0], err.Error()...)
+}
+
 // Init implements the corresponding method of fastrpc.HandlerCtx.
-func (ctx *RequestCtx) Init(conn net.Conn, logger fasthttp.Logger) {
+func (ctx *RequestCtx) Init(conn net.Conn, logger rpclog.Logger) {
 	ctx.Request.Reset()
 	ctx.Response.Reset()
 	ctx.conn = conn
-
-	ctx.logger.ctx = ctx
-	ctx.logger.logger = logger
+	ctx.ctx = nil
+	ctx.logger = logger
 }
 
 // ReadRequest implements the corresponding method of fastrpc.HandlerCtx.
@@ -55,9 +92,29 @@ func (ctx *RequestCtx) Conn() net.Conn {
 	return ctx.conn
 }
 
+// Context returns context.Context associated with the current request.
+//
+// context.Background() is returned if the context isn't set
+// via SetContext.
+func (ctx *RequestCtx) Context() context.Context {
+	if ctx.ctx == nil {
+		return context.Background()
+	}
+	return ctx.ctx
+}
+
+// SetContext associates the given context.Context with the current request,
+// e.g. context with the trace span extracted from the request metadata.
+func (ctx *RequestCtx) SetContext(c context.Context) {
+	ctx.ctx = c
+}
+
 // Logger returns logger associated with the current RequestCtx.
-func (ctx *RequestCtx) Logger() fasthttp.Logger {
-	return &ctx.logger
+//
+// The logger adds connection ID, connection addresses and request nonce
+// to each message.
+func (ctx *RequestCtx) Logger() rpclog.Logger {
+	return ctx.logger
 }
 
 // Write appends p to ctx.Response's value.
@@ -91,31 +148,81 @@ func (ctx *RequestCtx) LocalAddr() net.Addr {
 
 // RemoteIP returns client ip for the given request.
 //
+// net.IPv4(127, 0, 0, 1) is returned for unix socket connections,
+// since the client is always local. Other connections, such as shared
+// memory connections, are unwrapped via NetConn method until TCP or unix
+// socket connection is found.
+//
 // Always returns non-nil result.
 func (ctx *RequestCtx) RemoteIP() net.IP {
-	x, ok := ctx.RemoteAddr().(*net.TCPAddr)
-	if !ok {
-		return net.IPv4zero
+	if ip := netutil.ConnRemoteIP(ctx.conn); ip != nil {
+		return ip
 	}
-	return x.IP
+	return net.IPv4zero
 }
 
-var zeroTCPAddr = &net.TCPAddr{
-	IP: net.IPv4zero,
+// PeerCredentials returns credentials of the client process connected
+// via unix socket.
+//
+// See peercred.Get for details.
+func (ctx *RequestCtx) PeerCredentials() (*peercred.Cred, error) {
+	return peercred.Get(ctx.conn)
 }
 
-type ctxLogger struct {
-	sync.Mutex
-
-	ctx    *RequestCtx
-	logger fasthttp.Logger
+// PeerCertificates returns certificates presented by the client
+// over TLS connection.
+//
+// The certificates are verified if fastrpc.Server.TLSConfig requires
+// client certificates' verification, e.g. via tls.RequireAndVerifyClientCert.
+//
+// Returns nil for non-TLS connections. Connections wrapped by
+// fastrpc.Server.Handshake are unwrapped via NetConn method
+// until TLS connection is found.
+func (ctx *RequestCtx) PeerCertificates() []*x509.Certificate {
+	conn := ctx.conn
+	for conn != nil {
+		if tc, ok := conn.(interface {
+			ConnectionState() tls.ConnectionState
+		}); ok {
+			return tc.ConnectionState().PeerCertificates
+		}
+		uc, ok := conn.(interface {
+			NetConn() net.Conn
+		})
+		if !ok {
+			break
+		}
+		conn = uc.NetConn()
+	}
+	return nil
 }
 
-func (cl *ctxLogger) Printf(format string, args ...interface{}) {
-	cl.Lock()
-	defer cl.Unlock()
+// SPIFFEID returns SPIFFE ID from the client certificate,
+// i.e. spiffe:// URI SAN of the leaf certificate.
+//
+// Returns empty string if the client didn't present certificate
+// with SPIFFE ID.
+func (ctx *RequestCtx) SPIFFEID() string {
+	certs := ctx.PeerCertificates()
+	if len(certs) == 0 {
+		return ""
+	}
+	for _, uri := range certs[0].URIs {
+		if uri.Scheme == "spiffe" {
+			return uri.String()
+		}
+	}
+	return ""
+}
 
-	ctx, msg := cl.ctx, fmt.Sprintf(format, args...)
+// Principal returns the client principal authenticated
+// by fastrpc.Server.Authenticator.
+//
+// Returns nil if the connection isn't authenticated.
+func (ctx *RequestCtx) Principal() *auth.Principal {
+	return auth.PrincipalFromConn(ctx.conn)
+}
 
-	cl.logger.Printf("%s<->%s - %s", ctx.LocalAddr(), ctx.RemoteAddr(), msg)
+var zeroTCPAddr = &net.TCPAddr{
+	IP: net.IPv4zero,
 }
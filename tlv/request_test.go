@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"bytes"
 	"fmt"
+	"strings"
 	"testing"
 )
 
@@ -42,3 +43,61 @@ func TestRequestMarshalUnmarshal(t *testing.T) {
 	}
 	ReleaseRequest(req1)
 }
+
+func TestRequestMetadata(t *testing.T) {
+	var req Request
+	req.SetMetadata("traceparent", []byte("00-abc-def-01"))
+	req.SetMetadata("tracestate", []byte("foo=bar"))
+	req.SetMetadata("empty", nil)
+	req.SetMetadata("traceparent", []byte("00-123-456-01"))
+
+	if v := req.Metadata("traceparent"); string(v) != "00-123-456-01" {
+		t.Fatalf("unexpected traceparent: %q", v)
+	}
+	if v := req.Metadata("missing"); v != nil {
+		t.Fatalf("unexpected value for missing key: %q", v)
+	}
+	req.DelMetadata("empty")
+
+	var buf bytes.Buffer
+	bw := bufio.NewWriter(&buf)
+	req.SetOpcode(7)
+	req.SetValue([]byte("value"))
+	if err := req.WriteRequest(bw); err != nil {
+		t.Fatalf("unexpected error when writing request: %s", err)
+	}
+	if err := bw.Flush(); err != nil {
+		t.Fatalf("unexpected error when flushing request: %s", err)
+	}
+
+	var req1 Request
+	br := bufio.NewReader(&buf)
+	if err := req1.ReadRequest(br); err != nil {
+		t.Fatalf("unexpected error when reading request: %s", err)
+	}
+	if req1.Opcode() != 7 {
+		t.Fatalf("unexpected opcode: %d. Expecting 7", req1.Opcode())
+	}
+	if string(req1.Value()) != "value" {
+		t.Fatalf("unexpected value: %q. Expecting %q", req1.Value(), "value")
+	}
+	var keys []string
+	req1.VisitMetadata(func(k, v []byte) {
+		keys = append(keys, fmt.Sprintf("%s=%s", k, v))
+	})
+	expectedKeys := "tracestate=foo=bar,traceparent=00-123-456-01"
+	if s := strings.Join(keys, ","); s != expectedKeys {
+		t.Fatalf("unexpected metadata: %q. Expecting %q", s, expectedKeys)
+	}
+
+	// Responses mustn't contain metadata.
+	buf.Reset()
+	if err := req.WriteRequest(bw); err != nil {
+		t.Fatalf("unexpected error when writing request: %s", err)
+	}
+	bw.Flush()
+	var resp Response
+	if err := resp.ReadResponse(bufio.NewReader(&buf)); err == nil {
+		t.Fatalf("expecting error when reading response with metadata")
+	}
+}
@@ -2,19 +2,81 @@ package tlv
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"sync"
 )
 
+// Status is the status of the response.
+type Status byte
+
+const (
+	// StatusOK is the status of responses returned by the handler.
+	StatusOK Status = iota
+
+	// StatusRejected is the status of responses for requests rejected
+	// by fastrpc.Server before calling the handler, e.g. due to rate limits
+	// or overload. The response value contains the error message.
+	StatusRejected
+
+	// StatusPermissionDenied is the status of responses for requests
+	// denied by fastrpc.Server.Authorizer. The response value contains
+	// the error message.
+	StatusPermissionDenied
+)
+
+var (
+	// ErrRejected is wrapped by Response.Err for responses
+	// with StatusRejected.
+	ErrRejected = errors.New("request rejected by server")
+
+	// ErrPermissionDenied is wrapped by Response.Err for responses
+	// with StatusPermissionDenied.
+	ErrPermissionDenied = errors.New("permission denied by server")
+)
+
 // Response is a TLV response.
 type Response struct {
 	value  []byte
 	header [4]byte
+	status Status
 }
 
 // Reset resets the given response.
 func (r *Response) Reset() {
 	r.value = r.value[:0]
+	r.status = StatusOK
+}
+
+// SetStatus sets the response status.
+func (r *Response) SetStatus(status Status) {
+	if status > statusMask>>statusShift {
+		panic("BUG: too big response status")
+	}
+	r.status = status
+}
+
+// Status returns the response status.
+func (r *Response) Status() Status {
+	return r.status
+}
+
+// Err returns the error for responses with non-StatusOK status.
+//
+// The returned error wraps ErrRejected for StatusRejected
+// and ErrPermissionDenied for StatusPermissionDenied, so it may be
+// checked with errors.Is. nil is returned for StatusOK.
+func (r *Response) Err() error {
+	switch r.status {
+	case StatusOK:
+		return nil
+	case StatusRejected:
+		return fmt.Errorf("%w: %s", ErrRejected, r.value)
+	case StatusPermissionDenied:
+		return fmt.Errorf("%w: %s", ErrPermissionDenied, r.value)
+	default:
+		return fmt.Errorf("unexpected response status %d: %s", r.status, r.value)
+	}
 }
 
 func (r *Response) Value() []byte {
@@ -45,7 +107,7 @@ func (r *Response) Swap(value []byte) []byte {
 
 // WriteResponse writes the response to bw.
 func (r *Response) WriteResponse(bw *bufio.Writer) error {
-	if err := writeBytes(bw, r.value, r.header[:]); err != nil {
+	if err := writeBytes(bw, r.value, r.header[:], nil, uint32(r.status)<<statusShift); err != nil {
 		return fmt.Errorf("cannot write response value: %s", err)
 	}
 	return nil
@@ -56,10 +118,12 @@ func (r *Response) WriteResponse(bw *bufio.Writer) error {
 // It implements fastrpc.ReadResponse.
 func (r *Response) ReadResponse(br *bufio.Reader) error {
 	var err error
-	r.value, err = readBytes(br, r.value[:0], r.header[:])
+	var flags uint32
+	r.value, _, flags, err = readBytes(br, r.value[:0], r.header[:], nil, statusMask)
 	if err != nil {
 		return fmt.Errorf("cannot read request value: %s", err)
 	}
+	r.status = Status(flags >> statusShift)
 	return nil
 }
 
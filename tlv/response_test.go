@@ -3,6 +3,7 @@ package tlv
 import (
 	"bufio"
 	"bytes"
+	"errors"
 	"fmt"
 	"testing"
 )
@@ -37,3 +38,52 @@ func TestResponseMarshalUnmarshal(t *testing.T) {
 	}
 	ReleaseResponse(resp1)
 }
+
+func TestResponseStatus(t *testing.T) {
+	var buf bytes.Buffer
+
+	bw := bufio.NewWriter(&buf)
+	var resp Response
+	resp.SetStatus(StatusRejected)
+	resp.Swap([]byte("rate limit exceeded"))
+	if err := resp.WriteResponse(bw); err != nil {
+		t.Fatalf("unexpected error when writing response: %s", err)
+	}
+	resp.Reset()
+	resp.Swap([]byte("foobar"))
+	if err := resp.WriteResponse(bw); err != nil {
+		t.Fatalf("unexpected error when writing response: %s", err)
+	}
+	if err := bw.Flush(); err != nil {
+		t.Fatalf("unexpected error when flushing response: %s", err)
+	}
+
+	var resp1 Response
+	br := bufio.NewReader(&buf)
+	if err := resp1.ReadResponse(br); err != nil {
+		t.Fatalf("unexpected error when reading response: %s", err)
+	}
+	if resp1.Status() != StatusRejected {
+		t.Fatalf("unexpected response status: %d. Expecting %d", resp1.Status(), StatusRejected)
+	}
+	err := resp1.Err()
+	if !errors.Is(err, ErrRejected) {
+		t.Fatalf("unexpected error: %v. Expecting %s", err, ErrRejected)
+	}
+	if err.Error() != "request rejected by server: rate limit exceeded" {
+		t.Fatalf("unexpected error message: %q", err)
+	}
+
+	if err := resp1.ReadResponse(br); err != nil {
+		t.Fatalf("unexpected error when reading response: %s", err)
+	}
+	if resp1.Status() != StatusOK {
+		t.Fatalf("unexpected response status: %d. Expecting %d", resp1.Status(), StatusOK)
+	}
+	if err := resp1.Err(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if string(resp1.Value()) != "foobar" {
+		t.Fatalf("unexpected response value: %q. Expecting %q", resp1.Value(), "foobar")
+	}
+}
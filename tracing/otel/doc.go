@@ -0,0 +1,9 @@
+// Package otel provides OpenTelemetry tracing for fastrpc with tlv
+// requests.
+//
+// Client creates client spans around fastrpc.Client.DoDeadline calls
+// and propagates trace context to the server in tlv.Request metadata.
+// InstrumentServer creates server spans around fastrpc.Server.Handler
+// calls. The span context is available in the handler
+// via tlv.RequestCtx.Context.
+package otel
@@ -0,0 +1,22 @@
+module github.com/UladzimirTrehubenka/fastrpc/tracing/otel
+
+go 1.20
+
+require (
+	github.com/UladzimirTrehubenka/fastrpc v0.0.0-20261018133214-50813efd3985
+	github.com/valyala/fasthttp v1.9.0
+	go.opentelemetry.io/otel v1.19.0
+	go.opentelemetry.io/otel/sdk v1.19.0
+	go.opentelemetry.io/otel/trace v1.19.0
+)
+
+require (
+	github.com/go-logr/logr v1.2.4 // indirect
+	github.com/go-logr/stdr v1.2.2 // indirect
+	go.opentelemetry.io/otel/metric v1.19.0 // indirect
+	golang.org/x/sys v0.12.0 // indirect
+)
+
+// The replace directive builds the module against the fastrpc sources
+// in this repository. It is ignored by modules depending on this module.
+replace github.com/UladzimirTrehubenka/fastrpc => ../..
@@ -0,0 +1,205 @@
+package otel
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/UladzimirTrehubenka/fastrpc"
+	"github.com/UladzimirTrehubenka/fastrpc/tlv"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentationName is the name of the tracer.
+const instrumentationName = "github.com/UladzimirTrehubenka/fastrpc/tracing/otel"
+
+// Span attribute keys.
+const (
+	rpcSystemKey        = attribute.Key("rpc.system")
+	opcodeKey           = attribute.Key("fastrpc.opcode")
+	requestSizeKey      = attribute.Key("fastrpc.request.size")
+	responseSizeKey     = attribute.Key("fastrpc.response.size")
+	peerAddrKey         = attribute.Key("net.peer.name")
+	remoteAddrKey       = attribute.Key("net.sock.peer.addr")
+	rpcSystemFastrpc    = "fastrpc"
+	clientSpanName      = "fastrpc.client"
+	serverSpanName      = "fastrpc.server"
+	spanNameOpcodeDelim = "/"
+)
+
+// Options contains tracing options.
+type Options struct {
+	// TracerProvider is used for creating spans.
+	//
+	// The global TracerProvider is used by default.
+	TracerProvider trace.TracerProvider
+
+	// Propagator is used for propagating trace context in request
+	// metadata.
+	//
+	// The global TextMapPropagator is used by default.
+	Propagator propagation.TextMapPropagator
+}
+
+func (opts *Options) tracer() trace.Tracer {
+	var tp trace.TracerProvider
+	if opts != nil {
+		tp = opts.TracerProvider
+	}
+	if tp == nil {
+		tp = otel.GetTracerProvider()
+	}
+	return tp.Tracer(instrumentationName)
+}
+
+func (opts *Options) propagator() propagation.TextMapPropagator {
+	if opts == nil || opts.Propagator == nil {
+		return otel.GetTextMapPropagator()
+	}
+	return opts.Propagator
+}
+
+// Client sends traced requests via fastrpc.Client.
+type Client struct {
+	c          *fastrpc.Client
+	tracer     trace.Tracer
+	propagator propagation.TextMapPropagator
+}
+
+// NewClient returns Client sending requests via c.
+func NewClient(c *fastrpc.Client, opts *Options) *Client {
+	return &Client{
+		c:          c,
+		tracer:     opts.tracer(),
+		propagator: opts.propagator(),
+	}
+}
+
+// DoDeadline sends the given request via fastrpc.Client.DoDeadline
+// in a client span, which is a child of the span from ctx.
+//
+// Trace context is propagated to the server in req metadata.
+// The span gets error status if the request fails or the response
+// has non-OK status.
+func (c *Client) DoDeadline(ctx context.Context, req *tlv.Request, resp *tlv.Response, deadline time.Time) error {
+	opcode := req.Opcode()
+	ctx, span := c.tracer.Start(ctx, spanName(clientSpanName, opcode),
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			rpcSystemKey.String(rpcSystemFastrpc),
+			opcodeKey.Int(int(opcode)),
+			requestSizeKey.Int(len(req.Value())),
+			peerAddrKey.String(c.c.Addr),
+		),
+	)
+	defer span.End()
+
+	c.propagator.Inject(ctx, requestCarrier{req: req})
+
+	err := c.c.DoDeadline(req, resp, deadline)
+	if err != nil {
+		setSpanError(span, err)
+		return err
+	}
+	span.SetAttributes(responseSizeKey.Int(len(resp.Value())))
+	if err := resp.Err(); err != nil {
+		// The request has been rejected by the server.
+		setSpanError(span, err)
+	}
+	return nil
+}
+
+// InstrumentServer wraps s.Handler, so it is called in a server span
+// with the trace context extracted from request metadata.
+//
+// The server span is available in the handler via
+// trace.SpanFromContext(ctx.Context()) for *tlv.RequestCtx.
+// Handlers may record errors in the span. The span gets error status
+// if the handler panics or returns the response with non-OK status,
+// e.g. after tlv.RequestCtx.RejectError call.
+//
+// InstrumentServer must be called after s.Handler is set and before
+// s.Serve is called.
+func InstrumentServer(s *fastrpc.Server, opts *Options) {
+	if s.Handler == nil {
+		panic("BUG: Server.Handler must be set before InstrumentServer call")
+	}
+	tracer := opts.tracer()
+	propagator := opts.propagator()
+	handler := s.Handler
+	s.Handler = func(ctxv fastrpc.HandlerCtx) fastrpc.HandlerCtx {
+		ctx, ok := ctxv.(*tlv.RequestCtx)
+		if !ok {
+			return handler(ctxv)
+		}
+
+		opcode := ctx.Request.Opcode()
+		parent := propagator.Extract(ctx.Context(), requestCarrier{req: &ctx.Request})
+		spanCtx, span := tracer.Start(parent, spanName(serverSpanName, opcode),
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				rpcSystemKey.String(rpcSystemFastrpc),
+				opcodeKey.Int(int(opcode)),
+				requestSizeKey.Int(len(ctx.Request.Value())),
+				remoteAddrKey.String(ctx.RemoteAddr().String()),
+			),
+		)
+		defer span.End()
+		defer func() {
+			if r := recover(); r != nil {
+				span.SetStatus(codes.Error, fmt.Sprintf("panic in handler: %v", r))
+				panic(r)
+			}
+		}()
+
+		ctx.SetContext(spanCtx)
+		ctxNew := handler(ctx)
+		if ctxNew == ctxv {
+			// ctx may be accessed only if the handler returned it.
+			span.SetAttributes(responseSizeKey.Int(len(ctx.Response.Value())))
+			if err := ctx.Response.Err(); err != nil {
+				setSpanError(span, err)
+			}
+		}
+		return ctxNew
+	}
+}
+
+// setSpanError records err in span and sets the span error status.
+func setSpanError(span trace.Span, err error) {
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
+
+func spanName(prefix string, opcode byte) string {
+	return prefix + spanNameOpcodeDelim + strconv.Itoa(int(opcode))
+}
+
+// requestCarrier adapts tlv.Request metadata to propagation.TextMapCarrier.
+type requestCarrier struct {
+	req *tlv.Request
+}
+
+// Get implements propagation.TextMapCarrier.
+func (rc requestCarrier) Get(key string) string {
+	return string(rc.req.Metadata(key))
+}
+
+// Set implements propagation.TextMapCarrier.
+func (rc requestCarrier) Set(key, value string) {
+	rc.req.SetMetadata(key, []byte(value))
+}
+
+// Keys implements propagation.TextMapCarrier.
+func (rc requestCarrier) Keys() []string {
+	var keys []string
+	rc.req.VisitMetadata(func(k, v []byte) {
+		keys = append(keys, string(k))
+	})
+	return keys
+}
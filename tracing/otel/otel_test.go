@@ -0,0 +1,214 @@
+package otel
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/UladzimirTrehubenka/fastrpc"
+	"github.com/UladzimirTrehubenka/fastrpc/tlv"
+	"github.com/valyala/fasthttp/fasthttputil"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestClientServerTracing(t *testing.T) {
+	sr := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(sr))
+	opts := &Options{
+		TracerProvider: tp,
+		Propagator:     propagation.TraceContext{},
+	}
+
+	handlerTraceIDs := make(chan trace.TraceID, 1)
+	s := &fastrpc.Server{
+		NewHandlerCtx: func() fastrpc.HandlerCtx {
+			return &tlv.RequestCtx{}
+		},
+		Handler: func(ctxv fastrpc.HandlerCtx) fastrpc.HandlerCtx {
+			ctx := ctxv.(*tlv.RequestCtx)
+			handlerTraceIDs <- trace.SpanContextFromContext(ctx.Context()).TraceID()
+			ctx.Write(ctx.Request.Value())
+			return ctx
+		},
+	}
+	InstrumentServer(s, opts)
+	ln := fasthttputil.NewInmemoryListener()
+	serverDone := make(chan error, 1)
+	go func() {
+		serverDone <- s.Serve(ln)
+	}()
+
+	c := NewClient(&fastrpc.Client{
+		Addr: "foobar",
+		NewResponse: func() fastrpc.ResponseReader {
+			return &tlv.Response{}
+		},
+		Dial: func(addr string) (net.Conn, error) {
+			return ln.Dial()
+		},
+	}, opts)
+
+	ctx, parent := tp.Tracer("test").Start(context.Background(), "parent")
+	var req tlv.Request
+	var resp tlv.Response
+	req.SetOpcode(42)
+	req.SwapValue([]byte("foobar"))
+	if err := c.DoDeadline(ctx, &req, &resp, time.Now().Add(5*time.Second)); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	parent.End()
+
+	traceID := parent.SpanContext().TraceID()
+	if id := <-handlerTraceIDs; id != traceID {
+		t.Fatalf("unexpected trace id in handler: %s. Expecting %s", id, traceID)
+	}
+	if string(resp.Value()) != "foobar" {
+		t.Fatalf("unexpected response: %q. Expecting %q", resp.Value(), "foobar")
+	}
+
+	spans := sr.Ended()
+	if len(spans) != 3 {
+		t.Fatalf("unexpected number of spans: %d. Expecting 3", len(spans))
+	}
+	kinds := make(map[trace.SpanKind]sdktrace.ReadOnlySpan)
+	for _, span := range spans {
+		if span.SpanContext().TraceID() != traceID {
+			t.Fatalf("unexpected trace id in span %q: %s. Expecting %s", span.Name(), span.SpanContext().TraceID(), traceID)
+		}
+		kinds[span.SpanKind()] = span
+	}
+	clientSpan := kinds[trace.SpanKindClient]
+	serverSpan := kinds[trace.SpanKindServer]
+	if clientSpan == nil || serverSpan == nil {
+		t.Fatalf("missing client or server span")
+	}
+	if serverSpan.Parent().SpanID() != clientSpan.SpanContext().SpanID() {
+		t.Fatalf("server span must be a child of client span")
+	}
+	if clientSpan.Name() != "fastrpc.client/42" {
+		t.Fatalf("unexpected client span name: %q", clientSpan.Name())
+	}
+	checkAttr(t, clientSpan, opcodeKey, "42")
+	checkAttr(t, clientSpan, responseSizeKey, "6")
+	checkAttr(t, clientSpan, peerAddrKey, "foobar")
+	checkAttr(t, serverSpan, requestSizeKey, "6")
+	checkAttr(t, serverSpan, responseSizeKey, "6")
+
+	if err := ln.Close(); err != nil {
+		t.Fatalf("cannot close listener: %s", err)
+	}
+	select {
+	case <-serverDone:
+	case <-time.After(time.Second):
+		t.Fatalf("timeout")
+	}
+}
+
+func TestClientTracingError(t *testing.T) {
+	sr := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(sr))
+
+	c := NewClient(&fastrpc.Client{
+		NewResponse: func() fastrpc.ResponseReader {
+			return &tlv.Response{}
+		},
+		Dial: func(addr string) (net.Conn, error) {
+			return nil, net.UnknownNetworkError("test")
+		},
+	}, &Options{TracerProvider: tp})
+
+	var req tlv.Request
+	var resp tlv.Response
+	if err := c.DoDeadline(context.Background(), &req, &resp, time.Now().Add(50*time.Millisecond)); err == nil {
+		t.Fatalf("expecting non-nil error")
+	}
+	spans := sr.Ended()
+	if len(spans) != 1 {
+		t.Fatalf("unexpected number of spans: %d. Expecting 1", len(spans))
+	}
+	if code := spans[0].Status().Code; code != codes.Error {
+		t.Fatalf("unexpected span status: %s. Expecting %s", code, codes.Error)
+	}
+}
+
+func TestServerTracingError(t *testing.T) {
+	sr := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(sr))
+	opts := &Options{
+		TracerProvider: tp,
+	}
+
+	s := &fastrpc.Server{
+		NewHandlerCtx: func() fastrpc.HandlerCtx {
+			return &tlv.RequestCtx{}
+		},
+		Handler: func(ctxv fastrpc.HandlerCtx) fastrpc.HandlerCtx {
+			ctx := ctxv.(*tlv.RequestCtx)
+			ctx.RejectError(errors.New("foobar"))
+			return ctx
+		},
+	}
+	InstrumentServer(s, opts)
+	ln := fasthttputil.NewInmemoryListener()
+	serverDone := make(chan error, 1)
+	go func() {
+		serverDone <- s.Serve(ln)
+	}()
+
+	c := NewClient(&fastrpc.Client{
+		NewResponse: func() fastrpc.ResponseReader {
+			return &tlv.Response{}
+		},
+		Dial: func(addr string) (net.Conn, error) {
+			return ln.Dial()
+		},
+	}, opts)
+
+	var req tlv.Request
+	var resp tlv.Response
+	if err := c.DoDeadline(context.Background(), &req, &resp, time.Now().Add(5*time.Second)); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !errors.Is(resp.Err(), tlv.ErrRejected) {
+		t.Fatalf("unexpected response error: %v. Expecting %s", resp.Err(), tlv.ErrRejected)
+	}
+
+	spans := sr.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("unexpected number of spans: %d. Expecting 2", len(spans))
+	}
+	for _, span := range spans {
+		if code := span.Status().Code; code != codes.Error {
+			t.Fatalf("unexpected status of %s span: %s. Expecting %s", span.SpanKind(), code, codes.Error)
+		}
+	}
+
+	if err := ln.Close(); err != nil {
+		t.Fatalf("cannot close listener: %s", err)
+	}
+	select {
+	case <-serverDone:
+	case <-time.After(time.Second):
+		t.Fatalf("timeout")
+	}
+}
+
+func checkAttr(t *testing.T, span sdktrace.ReadOnlySpan, key attribute.Key, expected string) {
+	t.Helper()
+	for _, kv := range span.Attributes() {
+		if kv.Key == key {
+			if v := kv.Value.Emit(); v != expected {
+				t.Fatalf("unexpected %q attribute in span %q: %q. Expecting %q", key, span.Name(), v, expected)
+			}
+			return
+		}
+	}
+	t.Fatalf("missing %q attribute in span %q", key, span.Name())
+}
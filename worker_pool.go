@@ -0,0 +1,150 @@
+package fastrpc
+
+import (
+	"sync"
+	"time"
+)
+
+// DefaultMaxIdleWorkerDuration is the default duration after which idle
+// worker goroutines are stopped.
+const DefaultMaxIdleWorkerDuration = 10 * time.Second
+
+// workerPool processes requests in reusable goroutines.
+//
+// Ready workers are kept in LIFO order, so the most recently used worker
+// with the hot stack and CPU caches picks up the next request, while
+// the least recently used workers become idle and are stopped.
+type workerPool struct {
+	s *Server
+
+	maxWorkers            int
+	maxIdleWorkerDuration time.Duration
+
+	mu             sync.Mutex
+	workers        int
+	ready          []*poolWorker
+	cleanerRunning bool
+}
+
+type poolWorker struct {
+	ch          chan serverTask
+	lastUseTime time.Time
+}
+
+func newWorkerPool(s *Server) *workerPool {
+	maxWorkers := s.MaxWorkers
+	if maxWorkers <= 0 {
+		maxWorkers = s.concurrency()
+	}
+	return &workerPool{
+		s:                     s,
+		maxWorkers:            maxWorkers,
+		maxIdleWorkerDuration: s.maxIdleWorkerDuration(),
+	}
+}
+
+func (s *Server) getWorkerPool() *workerPool {
+	s.workerPoolOnce.Do(func() {
+		s.workerPool = newWorkerPool(s)
+	})
+	return s.workerPool
+}
+
+func (s *Server) maxIdleWorkerDuration() time.Duration {
+	if s.MaxIdleWorkerDuration <= 0 {
+		return DefaultMaxIdleWorkerDuration
+	}
+	return s.MaxIdleWorkerDuration
+}
+
+// serve passes the task to a worker.
+//
+// Returns false if all the workers are busy and no more workers
+// may be started.
+func (wp *workerPool) serve(task serverTask) bool {
+	w := wp.getWorker()
+	if w == nil {
+		return false
+	}
+	w.ch <- task
+	return true
+}
+
+func (wp *workerPool) getWorker() *poolWorker {
+	wp.mu.Lock()
+	defer wp.mu.Unlock()
+
+	if n := len(wp.ready); n > 0 {
+		w := wp.ready[n-1]
+		wp.ready[n-1] = nil
+		wp.ready = wp.ready[:n-1]
+		return w
+	}
+	if wp.workers >= wp.maxWorkers {
+		return nil
+	}
+
+	wp.workers++
+	w := &poolWorker{
+		ch: make(chan serverTask, 1),
+	}
+	go wp.worker(w)
+
+	if !wp.cleanerRunning {
+		wp.cleanerRunning = true
+		go wp.cleaner()
+	}
+	return w
+}
+
+func (wp *workerPool) worker(w *poolWorker) {
+	for task := range w.ch {
+		wp.s.handleRequest(task.wi, task.pendingResponses, task.stopCh)
+
+		w.lastUseTime = coarseTimeNow()
+		wp.mu.Lock()
+		wp.ready = append(wp.ready, w)
+		wp.mu.Unlock()
+	}
+}
+
+// cleaner stops workers idle for more than maxIdleWorkerDuration.
+//
+// It exits when no workers are left.
+func (wp *workerPool) cleaner() {
+	var idle []*poolWorker
+	for {
+		time.Sleep(wp.maxIdleWorkerDuration)
+
+		criticalTime := coarseTimeNow().Add(-wp.maxIdleWorkerDuration)
+
+		wp.mu.Lock()
+		// ready is sorted by lastUseTime, since workers are appended
+		// to it after each request.
+		n := 0
+		for n < len(wp.ready) && wp.ready[n].lastUseTime.Before(criticalTime) {
+			n++
+		}
+		idle = append(idle[:0], wp.ready[:n]...)
+		m := copy(wp.ready, wp.ready[n:])
+		for i := m; i < len(wp.ready); i++ {
+			wp.ready[i] = nil
+		}
+		wp.ready = wp.ready[:m]
+		wp.workers -= n
+		done := wp.workers == 0
+		if done {
+			wp.cleanerRunning = false
+		}
+		wp.mu.Unlock()
+
+		for i, w := range idle {
+			close(w.ch)
+			idle[i] = nil
+		}
+
+		if done {
+			return
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package fastrpc
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/UladzimirTrehubenka/fastrpc/tlv"
+)
+
+func TestWorkerPoolReuseWorkers(t *testing.T) {
+	s := &Server{
+		Handler: func(ctxv HandlerCtx) HandlerCtx {
+			return ctxv
+		},
+		MaxWorkers: 4,
+	}
+	wp := newWorkerPool(s)
+
+	done := make(chan *serverWorkItem, 100)
+	for i := 0; i < 100; i++ {
+		wi := &serverWorkItem{
+			nonce: [4]byte{1},
+			ctx:   newTestHandlerCtx(),
+		}
+		if !wp.serve(serverTask{wi: wi, pendingResponses: done}) {
+			t.Fatalf("cannot serve request #%d", i)
+		}
+		<-done
+
+		// Wait until the worker becomes ready again.
+		for {
+			wp.mu.Lock()
+			n := len(wp.ready)
+			wp.mu.Unlock()
+			if n > 0 {
+				break
+			}
+			runtime.Gosched()
+		}
+	}
+
+	wp.mu.Lock()
+	workers := wp.workers
+	wp.mu.Unlock()
+	if workers != 1 {
+		t.Fatalf("unexpected number of workers: %d; expecting 1", workers)
+	}
+}
+
+func TestWorkerPoolMaxWorkers(t *testing.T) {
+	gate := make(chan struct{})
+	s := &Server{
+		Handler: func(ctxv HandlerCtx) HandlerCtx {
+			<-gate
+			return ctxv
+		},
+		MaxWorkers: 2,
+	}
+	wp := newWorkerPool(s)
+
+	done := make(chan *serverWorkItem, 2)
+	for i := 0; i < 2; i++ {
+		wi := &serverWorkItem{
+			nonce: [4]byte{1},
+			ctx:   newTestHandlerCtx(),
+		}
+		if !wp.serve(serverTask{wi: wi, pendingResponses: done}) {
+			t.Fatalf("cannot serve request #%d", i)
+		}
+	}
+	wi := &serverWorkItem{
+		nonce: [4]byte{1},
+		ctx:   newTestHandlerCtx(),
+	}
+	if wp.serve(serverTask{wi: wi, pendingResponses: done}) {
+		t.Fatalf("expecting rejected request when all the workers are busy")
+	}
+
+	close(gate)
+	for i := 0; i < 2; i++ {
+		<-done
+	}
+}
+
+func TestWorkerPoolIdleWorkers(t *testing.T) {
+	s := &Server{
+		Handler: func(ctxv HandlerCtx) HandlerCtx {
+			return ctxv
+		},
+		MaxIdleWorkerDuration: 50 * time.Millisecond,
+	}
+	wp := newWorkerPool(s)
+
+	done := make(chan *serverWorkItem, 1)
+	wi := &serverWorkItem{
+		nonce: [4]byte{1},
+		ctx:   newTestHandlerCtx(),
+	}
+	if !wp.serve(serverTask{wi: wi, pendingResponses: done}) {
+		t.Fatalf("cannot serve request")
+	}
+	<-done
+
+	// coarseTimeNow has one second resolution.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		wp.mu.Lock()
+		workers, cleanerRunning := wp.workers, wp.cleanerRunning
+		wp.mu.Unlock()
+		if workers == 0 && !cleanerRunning {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("idle workers weren't stopped: workers=%d, cleanerRunning=%v", workers, cleanerRunning)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestServerWorkerPool(t *testing.T) {
+	s := &Server{
+		NewHandlerCtx: newTestHandlerCtx,
+		Handler: func(ctxv HandlerCtx) HandlerCtx {
+			ctx := ctxv.(*tlv.RequestCtx)
+			ctx.Write(ctx.Request.Value())
+			return ctx
+		},
+		WorkerPool: true,
+		MaxWorkers: 16,
+	}
+	serverStop, ln := newTestServerExt(s)
+	c := newTestClient(ln)
+
+	var wg sync.WaitGroup
+	errCh := make(chan error, 100)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			var req tlv.Request
+			var resp tlv.Response
+			for j := 0; j < 10; j++ {
+				v := fmt.Sprintf("request %d.%d", i, j)
+				req.SwapValue([]byte(v))
+				if err := c.DoDeadline(&req, &resp, time.Now().Add(5*time.Second)); err != nil {
+					errCh <- err
+					return
+				}
+				if string(resp.Value()) != v {
+					errCh <- fmt.Errorf("unexpected response %q; expecting %q", resp.Value(), v)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errCh)
+	for err := range errCh {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if err := serverStop(); err != nil {
+		t.Fatalf("cannot shutdown server: %s", err)
+	}
+}